@@ -3,46 +3,155 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/peano88/fizzbuzz-rest/pkg/auth"
+	"github.com/peano88/fizzbuzz-rest/pkg/cache"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/jobs"
+	"github.com/peano88/fizzbuzz-rest/pkg/metrics"
+	"github.com/peano88/fizzbuzz-rest/pkg/ratelimit"
 	"github.com/peano88/fizzbuzz-rest/pkg/server"
 	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
-	"github.com/peano88/fizzbuzz-rest/pkg/utils"
-)
-
-const (
-	certPathEnvVar = "FIZZBUZZ_TLS_CERT"
-	keyPathEnvVar  = "FIZZBUZZ_TLS_KEY"
+	"github.com/peano88/fizzbuzz-rest/pkg/tracing"
+	"github.com/peano88/fizzbuzz-rest/pkg/webhooks"
 )
 
 func main() {
+	cfg, options, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%s", err.Error())
+	}
+
+	if options.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	ctx, cancelMain := signal.NotifyContext(context.TODO(), os.Interrupt, os.Kill)
 	defer cancelMain()
 
-	fizzBuzzStats, err := statistics.NewFizzBuzzStatsRedis()
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		log.Fatalf("error instantiating tracing: %s", err.Error())
+	}
+	defer func() {
+		if err := shutdownTracing(context.TODO()); err != nil {
+			log.Printf("error flushing traces: %s", err.Error())
+		}
+	}()
+
+	fizzBuzzStats, err := statistics.NewFizzBuzzStatsRedis(cfg.Redis)
 	if err != nil {
 		log.Fatalf("error instantiating fizzbuzz statistics component: %s", err.Error())
 	}
 
+	fizzBuzzMetrics := metrics.New()
+	if err := fizzBuzzMetrics.Register(metrics.NewRedisPoolCollector(fizzBuzzStats.Client())); err != nil {
+		log.Fatalf("error registering redis metrics: %s", err.Error())
+	}
+	instrumentedStats := metrics.NewInstrumentedStats(fizzBuzzStats, fizzBuzzMetrics)
+
+	dispatcher := webhooks.NewDispatcher(webhooks.NewRedisStore(fizzBuzzStats.Client()), cfg.Webhooks)
+	go dispatcher.Run(ctx)
+
+	var apiKeys auth.KeyRegistry
+	if cfg.Auth.APIKeys == "redis" {
+		apiKeys = auth.NewRedisKeyStore(fizzBuzzStats.Client())
+	}
+	var rateLimiter ratelimit.Limiter
+	if cfg.Limits.RateLimit.Backend == "redis" {
+		rateLimiter = ratelimit.NewRedisLimiter(fizzBuzzStats.Client())
+	}
+	var sharedCache cache.Store
+	if cfg.Cache.Backend == "redis" {
+		sharedCache = cache.NewRedisStore(fizzBuzzStats.Client())
+	}
+
+	var jobQueue server.JobQueue
+	if cfg.Jobs.Enable {
+		manager, err := jobs.NewManager(cfg.Jobs)
+		if err != nil {
+			log.Fatalf("error initializing the jobs: %s", err.Error())
+		}
+		go manager.Run(ctx)
+		jobQueue = manager
+	}
+
 	fizzbuzzServer := server.FizzBuzzServer{
-		Stats: fizzBuzzStats,
+		Stats:       webhooks.NewNotifyingStats(instrumentedStats, dispatcher),
+		Webhooks:    dispatcher,
+		Metrics:     fizzBuzzMetrics,
+		APIKeys:     apiKeys,
+		RateLimiter: rateLimiter,
+		SharedCache: sharedCache,
+		Jobs:        jobQueue,
+		LoadConfig: func() (config.Config, error) {
+			cfg, _, err := config.Load(os.Args[1:])
+			return cfg, err
+		},
 	}
 
-	s, err := fizzbuzzServer.Configure()
+	s, err := fizzbuzzServer.Configure(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if fizzbuzzServer.Certificates != nil {
+		go fizzbuzzServer.Certificates.Watch(ctx)
+	}
+
+	admin, err := fizzbuzzServer.ConfigureAdmin(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// SIGHUP reloads the configuration, same as POST /config/reload on the admin server
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				restartRequired, err := fizzbuzzServer.ReloadConfig()
+				if err != nil {
+					log.Printf("configuration not reloaded:\n%s", err.Error())
+					continue
+				}
+				log.Println("configuration reloaded")
+				if len(restartRequired) > 0 {
+					log.Printf("changed settings requiring a restart, ignored: %v", restartRequired)
+				}
+			}
+		}
+	}()
+
 	errChan := make(chan error)
 
-	if utils.IsTLSEnabled(server.TLSEnvVar) {
+	go func() {
+		if err := admin.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errChan <- err
+		}
+	}()
+
+	if cfg.TLS.Enable {
 		go func() {
 			log.Println("Serving TLS connections")
-			if err := s.ListenAndServeTLS(os.Getenv(certPathEnvVar), os.Getenv(keyPathEnvVar)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			// the key pair is provided by s.TLSConfig, so that it can be reloaded
+			if err := s.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
 				errChan <- err
 			}
 		}()
@@ -57,12 +166,18 @@ func main() {
 
 	select {
 	case <-ctx.Done():
-		// graceful shutdown
-		ctxShutdown, cancelShutdown := context.WithTimeout(context.TODO(), 10*time.Second)
+		// graceful shutdown: readiness is withdrawn first, and the server keeps serving for the shutdown delay, so
+		// that the load balancers observe it and stop routing new requests to the server
+		fizzbuzzServer.SetShuttingDown()
+		time.Sleep(cfg.Server.ShutdownDelay)
+		ctxShutdown, cancelShutdown := context.WithTimeout(context.TODO(), cfg.Server.ShutdownTimeout)
 		defer cancelShutdown()
 		if err := s.Shutdown(ctxShutdown); err != nil {
 			log.Fatalf("could not shutdown gracefully: %s", err.Error())
 		}
+		if err := admin.Shutdown(ctxShutdown); err != nil {
+			log.Fatalf("could not shutdown admin server gracefully: %s", err.Error())
+		}
 	case err := <-errChan:
 		log.Fatalf("fatal error: %s", err.Error())
 	}
@@ -0,0 +1,11 @@
+// Package fizzbuzzrest holds the resources of the fizzbuzz rest server shared by its packages
+package fizzbuzzrest
+
+import (
+	_ "embed"
+)
+
+// OpenAPI is the OpenAPI document of the api, in YAML: the source of truth of the requests and responses
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+)
+
+const (
+	// APIKeyHeader is the request header carrying the API key
+	APIKeyHeader = "X-Api-Key"
+
+	// separates the identifier and the secret of an API key
+	keySeparator = "."
+)
+
+// APIKeyNotFound indicates that no API key is stored with the requested identifier
+type APIKeyNotFound struct{}
+
+// Error is the error interface implementation
+func (a APIKeyNotFound) Error() string {
+	return "api key not found"
+}
+
+// BootstrapClosed indicates that a key can't be bootstrapped, as a key has already been stored
+type BootstrapClosed struct{}
+
+// Error is the error interface implementation
+func (b BootstrapClosed) Error() string {
+	return "api keys already bootstrapped"
+}
+
+// InvalidCredentials indicates that the credentials of a request are malformed, unknown or expired
+type InvalidCredentials struct {
+	reason string
+}
+
+// Error is the error interface implementation
+func (ic InvalidCredentials) Error() string {
+	return "invalid credentials: " + ic.reason
+}
+
+// Reason returns why the credentials are invalid
+func (ic InvalidCredentials) Reason() string {
+	return ic.reason
+}
+
+// Is allows errors.Is(err, InvalidCredentials{}) regardless of the reason
+func (ic InvalidCredentials) Is(target error) bool {
+	_, ok := target.(InvalidCredentials)
+	return ok
+}
+
+// KeyStore provides the API keys
+type KeyStore interface {
+	// APIKey returns the key with the provided id, APIKeyNotFound otherwise
+	APIKey(ctx context.Context, id string) (model.APIKey, error)
+}
+
+// KeyRegistry is a KeyStore whose keys can be managed
+type KeyRegistry interface {
+	KeyStore
+	// SaveAPIKey stores or replaces a key
+	SaveAPIKey(ctx context.Context, key model.APIKey) error
+	// BootstrapAPIKey atomically stores key if no key has ever been stored, BootstrapClosed otherwise; it succeeds
+	// only once, even if the stored keys are deleted afterwards
+	BootstrapAPIKey(ctx context.Context, key model.APIKey) error
+	// APIKeys returns all stored keys
+	APIKeys(ctx context.Context) ([]model.APIKey, error)
+	// DeleteAPIKey removes the key with the provided id, APIKeyNotFound if not stored
+	DeleteAPIKey(ctx context.Context, id string) error
+}
+
+// Hash returns the hex encoded SHA-256 digest of secret, as stored in model.APIKey Hash. API keys secrets are
+// random and long enough for a fast hash to be safe
+func Hash(secret string) string {
+	digest := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(digest[:])
+}
+
+// GenerateAPIKey returns a new random key granting scopes. Its Key is the only occurrence of the secret: it has to
+// be handed to the holder and blanked before storing the key
+func GenerateAPIKey(scopes []string) (model.APIKey, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return model.APIKey{}, fmt.Errorf("error generating api key: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return model.APIKey{}, fmt.Errorf("error generating api key: %w", err)
+	}
+
+	key := model.APIKey{
+		ID:     hex.EncodeToString(id),
+		Scopes: scopes,
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = Hash(encodedSecret)
+	key.Key = key.ID + keySeparator + encodedSecret
+	return key, nil
+}
+
+// APIKeyAuthenticator authenticates the requests carrying an API key in the APIKeyHeader header
+type APIKeyAuthenticator struct {
+	store KeyStore
+}
+
+// NewAPIKeyAuthenticator returns an APIKeyAuthenticator checking the keys against store
+func NewAPIKeyAuthenticator(store KeyStore) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{
+		store: store,
+	}
+}
+
+// Authenticate returns the principal holding the API key of r. The returned boolean is false if r carries no
+// API key; an error of type InvalidCredentials is returned if the key is malformed or unknown
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (model.Principal, bool, error) {
+	raw := r.Header.Get(APIKeyHeader)
+	if raw == "" {
+		return model.Principal{}, false, nil
+	}
+
+	id, secret, ok := strings.Cut(raw, keySeparator)
+	if !ok || id == "" || secret == "" {
+		return model.Principal{}, true, InvalidCredentials{reason: "malformed api key"}
+	}
+
+	key, err := a.store.APIKey(r.Context(), id)
+	if errors.Is(err, APIKeyNotFound{}) {
+		return model.Principal{}, true, InvalidCredentials{reason: "unknown api key"}
+	}
+	if err != nil {
+		return model.Principal{}, true, fmt.Errorf("error retrieving api key: %w", err)
+	}
+
+	expected, err := hex.DecodeString(key.Hash)
+	if err != nil {
+		return model.Principal{}, true, fmt.Errorf("error decoding hash of api key %s: %w", id, err)
+	}
+	actual := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(expected, actual[:]) != 1 {
+		return model.Principal{}, true, InvalidCredentials{reason: "unknown api key"}
+	}
+
+	return model.Principal{
+		ID:     key.ID,
+		Method: model.AuthMethodAPIKey,
+		Scopes: key.Scopes,
+	}, true, nil
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requestWithKey(key string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz", nil)
+	if key != "" {
+		req.Header.Set(APIKeyHeader, key)
+	}
+	return req
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, err := GenerateAPIKey([]string{model.ScopeFizzBuzzRead})
+	require.NoError(t, err)
+
+	id, secret, ok := strings.Cut(key.Key, ".")
+	require.True(t, ok)
+	assert.Equal(t, key.ID, id)
+	assert.Equal(t, Hash(secret), key.Hash)
+	assert.Equal(t, []string{model.ScopeFizzBuzzRead}, key.Scopes)
+
+	other, err := GenerateAPIKey([]string{model.ScopeFizzBuzzRead})
+	require.NoError(t, err)
+	assert.NotEqual(t, key.ID, other.ID)
+	assert.NotEqual(t, key.Key, other.Key)
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	store := NewMemoryKeyStore()
+	key, err := GenerateAPIKey([]string{model.ScopeStatisticsRead})
+	require.NoError(t, err)
+	require.NoError(t, store.SaveAPIKey(context.TODO(), key))
+	a := NewAPIKeyAuthenticator(store)
+
+	principal, ok, err := a.Authenticate(requestWithKey(key.Key))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, model.Principal{ID: key.ID, Method: model.AuthMethodAPIKey, Scopes: key.Scopes}, principal)
+
+	_, ok, err = a.Authenticate(requestWithKey(""))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	for _, invalid := range []string{"boh", key.ID + ".", "." + key.ID, "unknown.secret", key.ID + ".wrong"} {
+		_, ok, err = a.Authenticate(requestWithKey(invalid))
+		assert.True(t, ok, invalid)
+		assert.True(t, errors.Is(err, InvalidCredentials{}), invalid)
+	}
+}
+
+func TestMemoryKeyStore(t *testing.T) {
+	ctx := context.TODO()
+	store := NewMemoryKeyStore()
+
+	key, err := GenerateAPIKey([]string{model.ScopeAdmin})
+	require.NoError(t, err)
+	require.NoError(t, store.SaveAPIKey(ctx, key))
+
+	stored, err := store.APIKey(ctx, key.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Key)
+	assert.Equal(t, key.Hash, stored.Hash)
+
+	keys, err := store.APIKeys(ctx)
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	require.NoError(t, store.DeleteAPIKey(ctx, key.ID))
+	_, err = store.APIKey(ctx, key.ID)
+	assert.True(t, errors.Is(err, APIKeyNotFound{}))
+	assert.True(t, errors.Is(store.DeleteAPIKey(ctx, key.ID), APIKeyNotFound{}))
+
+	// the bootstrap is closed once a key has been stored, even if deleted
+	assert.True(t, errors.Is(store.BootstrapAPIKey(ctx, key), BootstrapClosed{}))
+}
+
+func TestMemoryKeyStore_Bootstrap(t *testing.T) {
+	ctx := context.TODO()
+	store := NewMemoryKeyStore()
+
+	first, err := GenerateAPIKey([]string{model.ScopeAdmin})
+	require.NoError(t, err)
+	require.NoError(t, store.BootstrapAPIKey(ctx, first))
+	stored, err := store.APIKey(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Key)
+
+	second, err := GenerateAPIKey([]string{model.ScopeAdmin})
+	require.NoError(t, err)
+	assert.True(t, errors.Is(store.BootstrapAPIKey(ctx, second), BootstrapClosed{}))
+	require.NoError(t, store.DeleteAPIKey(ctx, first.ID))
+	assert.True(t, errors.Is(store.BootstrapAPIKey(ctx, second), BootstrapClosed{}))
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "keys.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	store, err := LoadKeyFile(write(`
+keys:
+  - id: reporting
+    hash: ` + Hash("s3cr3t") + `
+    scopes: [statistics:read]
+`))
+	require.NoError(t, err)
+	_, ok, err := NewAPIKeyAuthenticator(store).Authenticate(requestWithKey("reporting.s3cr3t"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	invalid := map[string]string{
+		"no id":         "keys:\n  - hash: " + Hash("s") + "\n    scopes: [admin]\n",
+		"duplicated id": "keys:\n  - {id: a, hash: " + Hash("s") + ", scopes: [admin]}\n  - {id: a, hash: " + Hash("t") + ", scopes: [admin]}\n",
+		"plain secret":  "keys:\n  - {id: a, hash: s3cr3t, scopes: [admin]}\n",
+		"no scopes":     "keys:\n  - {id: a, hash: " + Hash("s") + "}\n",
+		"unknown scope": "keys:\n  - {id: a, hash: " + Hash("s") + ", scopes: [write]}\n",
+		"not yaml":      "keys: [",
+	}
+	for label, content := range invalid {
+		_, err := LoadKeyFile(write(content))
+		assert.Error(t, err, label)
+	}
+
+	_, err = LoadKeyFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
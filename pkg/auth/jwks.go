@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minimum delay between two refreshes of a key set triggered by unknown keys, so that tokens with forged key
+	// ids can't flood the key set provider
+	jwksMinRefresh = 30 * time.Second
+	// maximum size of a key set document
+	jwksMaxSize = 1 << 20
+	// minimum size of the RSA keys
+	rsaMinBits = 2048
+)
+
+// verificationKey is a public key of a key set
+type verificationKey struct {
+	kid string
+	// algorithm the key is restricted to, empty if not restricted
+	alg string
+	key crypto.PublicKey
+}
+
+// jwk is a JSON Web Key (RFC 7517), restricted to the members of the supported key types
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA modulus and exponent
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP curve and coordinates
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS is a JSON Web Key Set read from a local file or an http(s) URL. The keys are cached and read again once they
+// are older than the refresh interval, or when a token is signed by an unknown key (at most every 30 seconds). If a
+// refresh fails, the cached keys are kept
+type JWKS struct {
+	location string
+	refresh  time.Duration
+	client   *http.Client
+	now      func() time.Time
+
+	// guards keys, fetched and inflight; it's never held while the key set is read
+	mu      sync.Mutex
+	keys    []verificationKey
+	fetched time.Time
+	// refresh in progress, joined by the concurrent refreshes; nil if none
+	inflight *jwksRefresh
+}
+
+// jwksRefresh is a refresh of a key set, done is closed once err is set
+type jwksRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWKS returns a JWKS read from location, a file path or an http(s) URL, and cached for refresh. No key is
+// available until Load succeeds
+func NewJWKS(location string, refresh time.Duration) *JWKS {
+	return &JWKS{
+		location: location,
+		refresh:  refresh,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		now:      time.Now,
+	}
+}
+
+// Load reads the key set, which must contain at least one supported signature key
+func (j *JWKS) Load(ctx context.Context) error {
+	return j.update(ctx, 0)
+}
+
+// candidates returns the keys which may have signed a token with key id kid (any key if kid is empty), refreshing
+// the key set if needed. An error is returned only if no key set was ever read; a failed refresh is logged and the
+// cached keys are matched
+func (j *JWKS) candidates(ctx context.Context, kid string) ([]verificationKey, error) {
+	if err := j.update(ctx, j.refresh); err != nil {
+		log.Printf("error refreshing JWKS %s: %s", j.location, err.Error())
+		if j.cached() == nil {
+			return nil, err
+		}
+	}
+
+	res := j.match(kid)
+	if len(res) == 0 {
+		if err := j.update(ctx, jwksMinRefresh); err != nil {
+			log.Printf("error refreshing JWKS %s for key %q: %s", j.location, kid, err.Error())
+		}
+		res = j.match(kid)
+	}
+	return res, nil
+}
+
+func (j *JWKS) cached() []verificationKey {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.keys
+}
+
+func (j *JWKS) match(kid string) []verificationKey {
+	res := []verificationKey{}
+	for _, key := range j.cached() {
+		if kid == "" || key.kid == kid {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+// update reads the key set again if it was read at least maxAge ago. Concurrent updates join the one in progress,
+// so that the key set is read once. The fetch time is updated even on failure, so that an unavailable provider is
+// not queried at each request
+func (j *JWKS) update(ctx context.Context, maxAge time.Duration) error {
+	j.mu.Lock()
+	if call := j.inflight; call != nil {
+		j.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if !j.fetched.IsZero() && j.now().Sub(j.fetched) < maxAge {
+		j.mu.Unlock()
+		return nil
+	}
+	call := &jwksRefresh{done: make(chan struct{})}
+	j.inflight = call
+	j.fetched = j.now()
+	j.mu.Unlock()
+
+	keys, err := j.fetch(ctx)
+
+	j.mu.Lock()
+	if err == nil {
+		j.keys = keys
+	}
+	j.inflight = nil
+	j.mu.Unlock()
+	call.err = err
+	close(call.done)
+	return err
+}
+
+// fetch reads and parses the key set
+func (j *JWKS) fetch(ctx context.Context) ([]verificationKey, error) {
+	content, err := j.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading JWKS: %w", err)
+	}
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JWKS: %w", err)
+	}
+	return keys, nil
+}
+
+func (j *JWKS) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(j.location, "http://") && !strings.HasPrefix(j.location, "https://") {
+		return os.ReadFile(j.location)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.location, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, jwksMaxSize))
+}
+
+// parseJWKS returns the signature keys of a key set document; keys of unsupported types are ignored
+func parseJWKS(content []byte) ([]verificationKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, err
+	}
+
+	res := []verificationKey{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key #%d %s: %w", i+1, k.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		res = append(res, verificationKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	if len(res) == 0 {
+		return nil, errors.New("no supported signature key found")
+	}
+	return res, nil
+}
+
+// publicKey returns the public key of k, nil if its type is not supported
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		if n.BitLen() < rsaMinBits {
+			return nil, fmt.Errorf("modulus shorter than %d bits", rsaMinBits)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeFixed(k.X, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeFixed(k.Y, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		// refuses the points which are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := decodeFixed(k.X, ed25519.PublicKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeInt(encoded string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func decodeFixed(encoded string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("expected %d bytes, got %d", size, len(b))
+	}
+	return b, nil
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+)
+
+const (
+	// supported signature algorithms (RFC 7518 and RFC 8037)
+	algRS256 = "RS256"
+	algES256 = "ES256"
+	algEdDSA = "EdDSA"
+
+	bearerPrefix = "Bearer "
+)
+
+// jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// JWTAuthenticator authenticates the requests carrying a JWT bearer token in the Authorization header. The token
+// signature is verified against a JWKS; the token must be issued by the configured issuer for the configured
+// audience, and not be expired
+type JWTAuthenticator struct {
+	keys *JWKS
+	cfg  config.JWTConfig
+	now  func() time.Time
+}
+
+// NewJWTAuthenticator returns a JWTAuthenticator verifying the tokens with keys and checking their claims against
+// cfg
+func NewJWTAuthenticator(keys *JWKS, cfg config.JWTConfig) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		keys: keys,
+		cfg:  cfg,
+		now:  time.Now,
+	}
+}
+
+// Authenticate returns the principal identified by the subject of the bearer token of r, granted the scopes mapped
+// from the scopes claim. The returned boolean is false if r carries no bearer token; an error of type
+// InvalidCredentials is returned if the token is malformed, its signature is not valid or its claims are not
+// accepted
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (model.Principal, bool, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return model.Principal{}, false, nil
+	}
+	token := strings.TrimSpace(header[len(bearerPrefix):])
+
+	claims, err := a.verify(r.Context(), token)
+	if err != nil {
+		return model.Principal{}, true, err
+	}
+	if err := a.checkClaims(claims); err != nil {
+		return model.Principal{}, true, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	return model.Principal{
+		ID:     subject,
+		Method: model.AuthMethodJWT,
+		Scopes: a.scopes(claims[a.cfg.ScopesClaim]),
+	}, true, nil
+}
+
+// verify checks the signature of token and returns its claims
+func (a *JWTAuthenticator) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, InvalidCredentials{reason: "malformed token"}
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, InvalidCredentials{reason: "malformed token header"}
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, InvalidCredentials{reason: "malformed token signature"}
+	}
+	switch header.Alg {
+	case algRS256, algES256, algEdDSA:
+	default:
+		return nil, InvalidCredentials{reason: fmt.Sprintf("unsupported algorithm %q", header.Alg)}
+	}
+
+	candidates, err := a.keys.candidates(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range candidates {
+		if key.alg != "" && key.alg != header.Alg {
+			continue
+		}
+		if verifySignature(header.Alg, key.key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, InvalidCredentials{reason: "invalid token signature"}
+	}
+
+	claims := map[string]any{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, InvalidCredentials{reason: "malformed token claims"}
+	}
+	return claims, nil
+}
+
+// checkClaims checks the registered claims of a verified token
+func (a *JWTAuthenticator) checkClaims(claims map[string]any) error {
+	now := a.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return InvalidCredentials{reason: "missing exp claim"}
+	}
+	if now.After(numericDate(exp).Add(a.cfg.Leeway)) {
+		return InvalidCredentials{reason: "token expired"}
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(numericDate(nbf).Add(-a.cfg.Leeway)) {
+		return InvalidCredentials{reason: "token not yet valid"}
+	}
+
+	if iss, _ := claims["iss"].(string); iss != a.cfg.Issuer {
+		return InvalidCredentials{reason: "unexpected issuer"}
+	}
+	if !contains(stringValues(claims["aud"]), a.cfg.Audience) {
+		return InvalidCredentials{reason: "unexpected audience"}
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return InvalidCredentials{reason: "missing sub claim"}
+	}
+	return nil
+}
+
+// scopes returns the scopes granted by the value of the scopes claim
+func (a *JWTAuthenticator) scopes(claim any) []string {
+	values := stringValues(claim)
+	if s, ok := claim.(string); ok {
+		values = strings.Fields(s)
+	}
+	if len(a.cfg.Scopes) == 0 {
+		return values
+	}
+
+	res := []string{}
+	for _, value := range values {
+		for _, scope := range a.cfg.Scopes[value] {
+			if !contains(res, scope) {
+				res = append(res, scope)
+			}
+		}
+	}
+	return res
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case algRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case algES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(signature) != 64 {
+			return false
+		}
+		// the signature is the concatenation of r and s, not ASN.1 encoded
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case algEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, signed, signature)
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+// numericDate converts a JWT NumericDate, i.e. seconds since the epoch
+func numericDate(seconds float64) time.Time {
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
+
+// stringValues returns the values of a claim which is either a string or an array of strings
+func stringValues(claim any) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []any:
+		res := make([]string, 0, len(c))
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
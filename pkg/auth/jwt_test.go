@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signingKey is a locally generated key signing test tokens
+type signingKey struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+func newSigningKeys(t *testing.T) []signingKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return []signingKey{
+		{kid: "rsa", alg: algRS256, key: rsaKey},
+		{kid: "ec", alg: algES256, key: ecKey},
+		{kid: "ed", alg: algEdDSA, key: edKey},
+	}
+}
+
+// jwksDocument returns the key set of the public keys of keys
+func jwksDocument(t *testing.T, keys ...signingKey) []byte {
+	encode := base64.RawURLEncoding.EncodeToString
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for _, k := range keys {
+		entry := jwk{Kid: k.kid, Use: "sig", Alg: k.alg}
+		switch pub := k.key.Public().(type) {
+		case *rsa.PublicKey:
+			entry.Kty, entry.N, entry.E = "RSA", encode(pub.N.Bytes()), encode(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			entry.Kty, entry.Crv = "EC", "P-256"
+			entry.X, entry.Y = encode(pub.X.FillBytes(make([]byte, 32))), encode(pub.Y.FillBytes(make([]byte, 32)))
+		case ed25519.PublicKey:
+			entry.Kty, entry.Crv, entry.X = "OKP", "Ed25519", encode(pub)
+		}
+		set.Keys = append(set.Keys, entry)
+	}
+	content, err := json.Marshal(set)
+	require.NoError(t, err)
+	return content
+}
+
+// signToken returns a compact JWT of claims signed by k
+func signToken(t *testing.T, k signingKey, claims map[string]any) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	header, err := json.Marshal(jwtHeader{Alg: k.alg, Kid: k.kid})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := encode(header) + "." + encode(payload)
+
+	var signature []byte
+	digest := sha256.Sum256([]byte(signed))
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	}
+	require.NoError(t, err)
+	return signed + "." + encode(signature)
+}
+
+func requestWithToken(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func testJWTConfig() config.JWTConfig {
+	cfg := config.Default().Auth.JWT
+	cfg.Issuer = "https://idp.example.com"
+	cfg.Audience = "fizzbuzz"
+	return cfg
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":   "https://idp.example.com",
+		"aud":   []string{"fizzbuzz", "other"},
+		"sub":   "reporting",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "statistics:read fizzbuzz:read",
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	keys := newSigningKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwksDocument(t, keys...), 0o600))
+	jwks := NewJWKS(path, time.Hour)
+	require.NoError(t, jwks.Load(context.Background()))
+	authenticator := NewJWTAuthenticator(jwks, testJWTConfig())
+
+	for _, k := range keys {
+		principal, ok, err := authenticator.Authenticate(requestWithToken(signToken(t, k, validClaims())))
+		require.NoError(t, err, k.alg)
+		assert.True(t, ok)
+		assert.Equal(t, model.Principal{
+			ID:     "reporting",
+			Method: model.AuthMethodJWT,
+			Scopes: []string{model.ScopeStatisticsRead, model.ScopeFizzBuzzRead},
+		}, principal, k.alg)
+	}
+
+	_, ok, err := authenticator.Authenticate(requestWithToken(""))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	other := newSigningKeys(t)[2]
+	invalid := map[string]string{
+		"malformed":          "boh",
+		"unknown key":        signToken(t, other, validClaims()),
+		"forged signature":   signToken(t, signingKey{kid: "ed", alg: algEdDSA, key: other.key}, validClaims()),
+		"algorithm mismatch": signToken(t, signingKey{kid: "ec", alg: algEdDSA, key: other.key}, validClaims()),
+		"none algorithm":     base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"a"}`)) + ".",
+	}
+	for claim, value := range map[string]any{
+		"exp": time.Now().Add(-time.Minute).Unix(),
+		"nbf": time.Now().Add(time.Minute).Unix(),
+		"iss": "https://evil.example.com",
+		"aud": "other",
+		"sub": "",
+	} {
+		claims := validClaims()
+		claims[claim] = value
+		invalid[claim] = signToken(t, keys[0], claims)
+	}
+	claims := validClaims()
+	delete(claims, "exp")
+	invalid["missing exp"] = signToken(t, keys[0], claims)
+
+	for name, token := range invalid {
+		_, ok, err := authenticator.Authenticate(requestWithToken(token))
+		assert.True(t, ok, name)
+		assert.True(t, errors.Is(err, InvalidCredentials{}), name)
+	}
+
+	// the leeway tolerates a small clock skew
+	claims = validClaims()
+	claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+	_, _, err = authenticator.Authenticate(requestWithToken(signToken(t, keys[1], claims)))
+	assert.NoError(t, err)
+}
+
+func TestJWTAuthenticator_ScopesMapping(t *testing.T) {
+	keys := newSigningKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwksDocument(t, keys...), 0o600))
+
+	cfg := testJWTConfig()
+	cfg.ScopesClaim = "groups"
+	cfg.Scopes = map[string][]string{
+		"analysts":  {model.ScopeStatisticsRead, model.ScopeFizzBuzzRead},
+		"operators": {model.ScopeAdmin, model.ScopeFizzBuzzRead},
+	}
+	authenticator := NewJWTAuthenticator(NewJWKS(path, time.Hour), cfg)
+
+	claims := validClaims()
+	claims["groups"] = []string{"analysts", "operators", "unknown"}
+	principal, _, err := authenticator.Authenticate(requestWithToken(signToken(t, keys[0], claims)))
+	require.NoError(t, err)
+	assert.Equal(t, []string{model.ScopeStatisticsRead, model.ScopeFizzBuzzRead, model.ScopeAdmin}, principal.Scopes)
+
+	// the scope claim is ignored
+	principal, _, err = authenticator.Authenticate(requestWithToken(signToken(t, keys[0], validClaims())))
+	require.NoError(t, err)
+	assert.Empty(t, principal.Scopes)
+}
+
+func TestJWKS_Refresh(t *testing.T) {
+	keys := newSigningKeys(t)
+	document := atomic.Pointer[[]byte]{}
+	initial := jwksDocument(t, keys[0])
+	document.Store(&initial)
+	fetches := atomic.Int32{}
+	provider := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		rw.Write(*document.Load())
+	}))
+	defer provider.Close()
+
+	now := time.Now()
+	jwks := NewJWKS(provider.URL, time.Hour)
+	jwks.now = func() time.Time { return now }
+	authenticator := NewJWTAuthenticator(jwks, testJWTConfig())
+	authenticate := func(k signingKey) error {
+		_, _, err := authenticator.Authenticate(requestWithToken(signToken(t, k, validClaims())))
+		return err
+	}
+
+	// the key set is fetched once and cached
+	assert.NoError(t, authenticate(keys[0]))
+	assert.NoError(t, authenticate(keys[0]))
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// an unknown key triggers a refresh, at most every jwksMinRefresh
+	rotated := jwksDocument(t, keys[0], keys[1])
+	document.Store(&rotated)
+	assert.Error(t, authenticate(keys[1]))
+	assert.Equal(t, int32(1), fetches.Load())
+	now = now.Add(jwksMinRefresh)
+	assert.NoError(t, authenticate(keys[1]))
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// the key set is refreshed once expired, the cached keys are kept if the provider fails
+	now = now.Add(time.Hour)
+	broken := []byte("{")
+	document.Store(&broken)
+	assert.NoError(t, authenticate(keys[1]))
+	assert.Equal(t, int32(3), fetches.Load())
+
+	removed := jwksDocument(t, keys[1])
+	document.Store(&removed)
+	now = now.Add(time.Hour)
+	assert.True(t, errors.Is(authenticate(keys[0]), InvalidCredentials{}))
+	assert.NoError(t, authenticate(keys[1]))
+
+	// a token signed by an unknown key is invalid even if the provider is unavailable
+	document.Store(&broken)
+	now = now.Add(jwksMinRefresh)
+	assert.True(t, errors.Is(authenticate(keys[0]), InvalidCredentials{}))
+	assert.NoError(t, authenticate(keys[1]))
+}
+
+func TestJWKS_ConcurrentRefresh(t *testing.T) {
+	keys := newSigningKeys(t)
+	fetches := atomic.Int32{}
+	document := jwksDocument(t, keys[0])
+	provider := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		rw.Write(document)
+	}))
+	defer provider.Close()
+
+	jwks := NewJWKS(provider.URL, time.Hour)
+	require.NoError(t, jwks.Load(context.Background()))
+	jwks.mu.Lock()
+	jwks.fetched = time.Now().Add(-2 * time.Hour)
+	jwks.mu.Unlock()
+	authenticator := NewJWTAuthenticator(jwks, testJWTConfig())
+	token := signToken(t, keys[0], validClaims())
+
+	// the expired key set is read once by the concurrent requests
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := authenticator.Authenticate(requestWithToken(token))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestParseJWKS(t *testing.T) {
+	keys := newSigningKeys(t)
+	parsed, err := parseJWKS(jwksDocument(t, keys...))
+	require.NoError(t, err)
+	assert.Len(t, parsed, 3)
+
+	// unsupported keys are ignored
+	parsed, err = parseJWKS([]byte(`{"keys": [{"kty": "oct", "k": "c2VjcmV0"}, {"kty": "EC", "crv": "P-384"}]}`))
+	assert.Error(t, err)
+	assert.Nil(t, parsed)
+
+	for name, document := range map[string]string{
+		"not json":        `{`,
+		"short rsa key":   `{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`,
+		"point off curve": `{"keys": [{"kty": "EC", "crv": "P-256", "x": "` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `", "y": "` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `"}]}`,
+		"short ed25519":   `{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AQAB"}]}`,
+	} {
+		_, err := parseJWKS([]byte(document))
+		assert.Error(t, err, name)
+	}
+}
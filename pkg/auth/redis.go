@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	apiKeysHash = "fizzbuzz:apikeys"
+	// set once a key has been stored, closing the bootstrap
+	bootstrappedKey = "fizzbuzz:apikeys:bootstrapped"
+)
+
+// bootstrapScript atomically stores a key, unless a key is stored or has ever been
+var bootstrapScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 1 or redis.call('HLEN', KEYS[1]) > 0 then
+  return 0
+end
+redis.call('SET', KEYS[2], 1)
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// RedisKeyStore is a KeyRegistry based on redis DB: keys, without their secret, are JSON encoded in a redis hash
+type RedisKeyStore struct {
+	rdb *redis.Client
+}
+
+// NewRedisKeyStore returns a RedisKeyStore using the provided client
+func NewRedisKeyStore(rdb *redis.Client) *RedisKeyStore {
+	return &RedisKeyStore{
+		rdb: rdb,
+	}
+}
+
+// APIKey is the KeyStore interface implementation
+func (rs *RedisKeyStore) APIKey(ctx context.Context, id string) (model.APIKey, error) {
+	payload, err := rs.rdb.HGet(ctx, apiKeysHash, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return model.APIKey{}, APIKeyNotFound{}
+	}
+	if err != nil {
+		return model.APIKey{}, fmt.Errorf("error retrieving api key: %w", err)
+	}
+
+	var key model.APIKey
+	if err := json.Unmarshal(payload, &key); err != nil {
+		return model.APIKey{}, fmt.Errorf("error unmarshaling api key: %w", err)
+	}
+	return key, nil
+}
+
+// SaveAPIKey is the KeyRegistry interface implementation; the whole key is never stored
+func (rs *RedisKeyStore) SaveAPIKey(ctx context.Context, key model.APIKey) error {
+	key.Key = ""
+	payload, err := json.Marshal(&key)
+	if err != nil {
+		return fmt.Errorf("error marshaling api key: %w", err)
+	}
+
+	if _, err := rs.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, apiKeysHash, key.ID, payload)
+		pipe.Set(ctx, bootstrappedKey, 1, 0)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error saving api key: %w", err)
+	}
+	return nil
+}
+
+// BootstrapAPIKey is the KeyRegistry interface implementation; the whole key is never stored
+func (rs *RedisKeyStore) BootstrapAPIKey(ctx context.Context, key model.APIKey) error {
+	key.Key = ""
+	payload, err := json.Marshal(&key)
+	if err != nil {
+		return fmt.Errorf("error marshaling api key: %w", err)
+	}
+
+	stored, err := bootstrapScript.Run(ctx, rs.rdb, []string{apiKeysHash, bootstrappedKey}, key.ID, payload).Int()
+	if err != nil {
+		return fmt.Errorf("error bootstrapping api key: %w", err)
+	}
+	if stored == 0 {
+		return BootstrapClosed{}
+	}
+	return nil
+}
+
+// APIKeys is the KeyRegistry interface implementation, keys are sorted by ID
+func (rs *RedisKeyStore) APIKeys(ctx context.Context) ([]model.APIKey, error) {
+	payloads, err := rs.rdb.HGetAll(ctx, apiKeysHash).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving api keys: %w", err)
+	}
+
+	res := make([]model.APIKey, 0, len(payloads))
+	for _, payload := range payloads {
+		var key model.APIKey
+		if err := json.Unmarshal([]byte(payload), &key); err != nil {
+			return nil, fmt.Errorf("error unmarshaling api key: %w", err)
+		}
+		res = append(res, key)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// DeleteAPIKey is the KeyRegistry interface implementation
+func (rs *RedisKeyStore) DeleteAPIKey(ctx context.Context, id string) error {
+	deleted, err := rs.rdb.HDel(ctx, apiKeysHash, id).Result()
+	if err != nil {
+		return fmt.Errorf("error deleting api key: %w", err)
+	}
+	if deleted == 0 {
+		return APIKeyNotFound{}
+	}
+	return nil
+}
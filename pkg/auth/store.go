@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+	"gopkg.in/yaml.v3"
+)
+
+// MemoryKeyStore is a KeyRegistry keeping the keys in memory; useful for tests or keys loaded from a file
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]model.APIKey
+	// a key has been stored, closing the bootstrap
+	bootstrapped bool
+}
+
+// NewMemoryKeyStore returns an empty MemoryKeyStore
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{
+		keys: map[string]model.APIKey{},
+	}
+}
+
+// keyFile is the format of an API keys file
+type keyFile struct {
+	Keys []struct {
+		ID     string   `yaml:"id"`
+		Hash   string   `yaml:"hash"`
+		Scopes []string `yaml:"scopes"`
+	} `yaml:"keys"`
+}
+
+// LoadKeyFile returns a MemoryKeyStore with the keys of the YAML file at path, e.g.
+//
+//	keys:
+//	  - id: reporting
+//	    hash: <hex encoded SHA-256 digest of the secret>
+//	    scopes: [statistics:read]
+//
+// The key of the holder is the concatenation of the id, a dot and the secret.
+func LoadKeyFile(path string) (*MemoryKeyStore, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading api keys file: %w", err)
+	}
+
+	var file keyFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("error decoding api keys file: %w", err)
+	}
+
+	ms := NewMemoryKeyStore()
+	for i, k := range file.Keys {
+		key := model.APIKey{ID: k.ID, Hash: k.Hash, Scopes: k.Scopes}
+		if key.ID == "" {
+			return nil, fmt.Errorf("api key #%d: missing id", i+1)
+		}
+		if _, ok := ms.keys[key.ID]; ok {
+			return nil, fmt.Errorf("api key %s: duplicated id", key.ID)
+		}
+		if digest, err := hex.DecodeString(key.Hash); err != nil || len(digest) != 32 {
+			return nil, fmt.Errorf("api key %s: hash should be a hex encoded SHA-256 digest", key.ID)
+		}
+		if err := validation.ValidateAPIKey(key); err != nil {
+			return nil, fmt.Errorf("api key %s: %w", key.ID, err)
+		}
+		ms.keys[key.ID] = key
+	}
+	return ms, nil
+}
+
+// APIKey is the KeyStore interface implementation
+func (ms *MemoryKeyStore) APIKey(ctx context.Context, id string) (model.APIKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	key, ok := ms.keys[id]
+	if !ok {
+		return model.APIKey{}, APIKeyNotFound{}
+	}
+	return key, nil
+}
+
+// SaveAPIKey is the KeyRegistry interface implementation; the whole key is never stored
+func (ms *MemoryKeyStore) SaveAPIKey(ctx context.Context, key model.APIKey) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	key.Key = ""
+	ms.keys[key.ID] = key
+	ms.bootstrapped = true
+	return nil
+}
+
+// BootstrapAPIKey is the KeyRegistry interface implementation
+func (ms *MemoryKeyStore) BootstrapAPIKey(ctx context.Context, key model.APIKey) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.bootstrapped || len(ms.keys) > 0 {
+		return BootstrapClosed{}
+	}
+	key.Key = ""
+	ms.keys[key.ID] = key
+	ms.bootstrapped = true
+	return nil
+}
+
+// APIKeys is the KeyRegistry interface implementation, keys are sorted by ID
+func (ms *MemoryKeyStore) APIKeys(ctx context.Context) ([]model.APIKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	res := make([]model.APIKey, 0, len(ms.keys))
+	for _, key := range ms.keys {
+		res = append(res, key)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// DeleteAPIKey is the KeyRegistry interface implementation
+func (ms *MemoryKeyStore) DeleteAPIKey(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.keys[id]; !ok {
+		return APIKeyNotFound{}
+	}
+	delete(ms.keys, id)
+	return nil
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/metrics"
+)
+
+const (
+	// tiers of a cache hit
+	TierMemory = "memory"
+	TierShared = "shared"
+	// the value was filled by a concurrent request
+	TierInflight = "inflight"
+)
+
+// Store is a cache tier shared by the server instances
+type Store interface {
+	// Get returns the value of key, false if it is not stored
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// Cache is a read-through cache of encoded responses: the values are looked up in an in-memory LRU, then in the
+// optional shared Store, and are filled otherwise. The concurrent fills of the same key are collapsed into a single
+// one. The errors of the shared Store are counted and handled as misses, so that it is never required
+type Cache struct {
+	lru     *LRU
+	shared  Store
+	metrics *metrics.Metrics
+	flights flights
+
+	// ttl of the shared entries, as a time.Duration
+	ttl atomic.Int64
+}
+
+// New returns a Cache holding at most maxBytes in memory, backed by shared if not nil, whose entries expire after
+// ttl. Hits and misses are recorded in m
+func New(maxBytes int, shared Store, ttl time.Duration, m *metrics.Metrics) *Cache {
+	c := &Cache{
+		lru:     NewLRU(maxBytes),
+		shared:  shared,
+		metrics: m,
+	}
+	c.ttl.Store(int64(ttl))
+	return c
+}
+
+// Update bounds the in-memory cache to maxBytes and sets the ttl of the new shared entries
+func (c *Cache) Update(maxBytes int, ttl time.Duration) {
+	c.lru.Resize(maxBytes)
+	c.ttl.Store(int64(ttl))
+	c.metrics.CacheSize(c.lru.Size())
+}
+
+// Get returns the value of key, calling fill to compute it if it is not cached. The error of fill is returned as is
+// and its value is not cached
+func (c *Cache) Get(ctx context.Context, key string, fill func() ([]byte, error)) ([]byte, error) {
+	if value, ok := c.lru.Get(key); ok {
+		c.metrics.CacheHit(TierMemory)
+		return value, nil
+	}
+
+	tier := TierShared
+	value, shared, err := c.flights.do(key, func() ([]byte, error) {
+		if c.shared != nil {
+			value, ok, err := c.shared.Get(ctx, key)
+			if err != nil {
+				c.metrics.CacheError("get")
+			}
+			if ok {
+				c.lru.Add(key, value)
+				c.metrics.CacheSize(c.lru.Size())
+				return value, nil
+			}
+		}
+
+		tier = ""
+		value, err := fill()
+		if err != nil {
+			return nil, err
+		}
+		c.lru.Add(key, value)
+		c.metrics.CacheSize(c.lru.Size())
+		if c.shared != nil {
+			if err := c.shared.Set(ctx, key, value, time.Duration(c.ttl.Load())); err != nil {
+				c.metrics.CacheError("set")
+			}
+		}
+		return value, nil
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case shared:
+		c.metrics.CacheHit(TierInflight)
+	case tier == "":
+		c.metrics.CacheMiss()
+	default:
+		c.metrics.CacheHit(tier)
+	}
+	return value, nil
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore is a Store in memory, failing if err is set
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+	ttls    map[string]time.Duration
+	err     error
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		entries: map[string][]byte{},
+		ttls:    map[string]time.Duration{},
+	}
+}
+
+func (ms *memoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.err != nil {
+		return nil, false, ms.err
+	}
+	value, ok := ms.entries[key]
+	return value, ok, nil
+}
+
+func (ms *memoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.err != nil {
+		return ms.err
+	}
+	ms.entries[key] = value
+	ms.ttls[key] = ttl
+	return nil
+}
+
+func TestLRU(t *testing.T) {
+	c := NewLRU(10)
+
+	c.Add("a", []byte("1234"))
+	c.Add("b", []byte("1234"))
+	assert.Equal(t, 10, c.Size())
+
+	// a is now the most recently used
+	value, ok := c.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, []byte("1234"), value)
+
+	c.Add("c", []byte("12"))
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 8, c.Size())
+
+	// replacing an entry updates the size
+	c.Add("c", []byte("1"))
+	assert.Equal(t, 7, c.Size())
+
+	// a value bigger than the whole cache is not cached
+	c.Add("d", []byte("1234567890"))
+	_, ok = c.Get("d")
+	assert.False(t, ok)
+	assert.Equal(t, 7, c.Size())
+
+	c.Resize(3)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	_, ok = c.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 2, c.Size())
+}
+
+func TestCache_Tiers(t *testing.T) {
+	shared := newMemoryStore()
+	c := New(1024, shared, time.Minute, nil)
+
+	fills := 0
+	fill := func() ([]byte, error) {
+		fills++
+		return []byte("value"), nil
+	}
+
+	value, err := c.Get(context.Background(), "k", fill)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Equal(t, []byte("value"), shared.entries["k"])
+	assert.Equal(t, time.Minute, shared.ttls["k"])
+
+	_, err = c.Get(context.Background(), "k", fill)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fills)
+
+	// another instance finds the value in the shared tier
+	other := New(1024, shared, time.Minute, nil)
+	value, err = other.Get(context.Background(), "k", fill)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Equal(t, 1, fills)
+	_, ok := other.lru.Get("k")
+	assert.True(t, ok)
+
+	// the shared tier is not required
+	shared.err = errors.New("boom")
+	value, err = other.Get(context.Background(), "k2", fill)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Equal(t, 2, fills)
+
+	// errors are not cached
+	_, err = c.Get(context.Background(), "k3", func() ([]byte, error) {
+		return nil, errors.New("dummy")
+	})
+	assert.Error(t, err)
+	_, ok = c.lru.Get("k3")
+	assert.False(t, ok)
+}
+
+func TestCache_Collapse(t *testing.T) {
+	c := New(1024, nil, time.Minute, nil)
+
+	var fills atomic.Int32
+	release := make(chan struct{})
+	fill := func() ([]byte, error) {
+		fills.Add(1)
+		<-release
+		return []byte("value"), nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := c.Get(context.Background(), "k", fill)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("value"), value)
+		}()
+	}
+	// wait for the first fill to be in progress
+	require.Eventually(t, func() bool { return fills.Load() == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), fills.Load())
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+// errAborted is the result of a fill which panicked
+var errAborted = errors.New("cache fill aborted")
+
+// call is a fill in progress, or completed, for a key
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// flights collapses the concurrent fills of the same key: the first caller runs the fill, the others wait for its
+// result
+type flights struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// do runs fill for key unless a fill of key is already in progress, in which case its result is returned. shared
+// reports whether the result comes from the fill of another caller
+func (f *flights) do(key string, fill func() ([]byte, error)) (value []byte, shared bool, err error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = map[string]*call{}
+	}
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		<-c.done
+		return c.value, true, c.err
+	}
+	c := &call{done: make(chan struct{}), err: errAborted}
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(c.done)
+	}()
+	c.value, c.err = fill()
+	return c.value, false, c.err
+}
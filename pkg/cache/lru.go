@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// entry is an element of the LRU list
+type entry struct {
+	key   string
+	value []byte
+}
+
+// LRU is an in-memory cache bounded by the size of its keys and values: the least recently used entries are evicted
+// to make room for the new ones. It is safe for concurrent use
+type LRU struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	// most recently used first
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewLRU returns an empty LRU holding at most maxBytes
+func NewLRU(maxBytes int) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Get returns the value of key, false if it is not cached
+func (c *LRU) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*entry).value, true
+}
+
+// Add caches value under key, evicting the least recently used entries if needed. A value bigger than the whole
+// cache is not cached. The value must not be modified afterwards
+func (c *LRU) Add(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	if entrySize(key, value) > c.maxBytes {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, value: value})
+	c.size += entrySize(key, value)
+	c.evict()
+}
+
+// Resize bounds the cache to maxBytes, evicting the least recently used entries if needed
+func (c *LRU) Resize(maxBytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// Size returns the number of bytes currently cached
+func (c *LRU) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+func (c *LRU) evict() {
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRU) remove(e *list.Element) {
+	ent := c.order.Remove(e).(*entry)
+	delete(c.entries, ent.key)
+	c.size -= entrySize(ent.key, ent.value)
+}
+
+func entrySize(key string, value []byte) int {
+	return len(key) + len(value)
+}
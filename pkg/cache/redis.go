@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	cachePrefix = "fizzbuzz:cache:"
+)
+
+// RedisStore is a Store keeping the entries in the redis DB, so that they are shared by the server instances
+type RedisStore struct {
+	rdb *redis.Client
+}
+
+// NewRedisStore returns a RedisStore using the provided client
+func NewRedisStore(rdb *redis.Client) *RedisStore {
+	return &RedisStore{
+		rdb: rdb,
+	}
+}
+
+// Get is the Store interface implementation
+func (rs *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := rs.rdb.Get(ctx, cachePrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting cache entry: %w", err)
+	}
+	return value, true, nil
+}
+
+// Set is the Store interface implementation
+func (rs *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := rs.rdb.Set(ctx, cachePrefix+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("error setting cache entry: %w", err)
+	}
+	return nil
+}
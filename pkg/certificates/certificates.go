@@ -0,0 +1,255 @@
+package certificates
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/metrics"
+)
+
+const (
+	// CertificateServer labels the expiry of the server certificate
+	CertificateServer = "server"
+	// CertificateClientCA labels the expiry of the client CA bundle, i.e. of its first expiring CA
+	CertificateClientCA = "client_ca"
+)
+
+// material is a consistent set of TLS credentials, loaded at the same time
+type material struct {
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	// expiry of the first expiring client CA, zero if no client CA bundle is configured
+	clientCAExpiry time.Time
+	// digest of the content of the files the material was loaded from
+	digest [sha256.Size]byte
+}
+
+// Reloader holds the TLS key pair of the server and the bundle of CAs trusted to verify the client certificates.
+// Both are read from the files of a config.TLSConfig and can be reloaded while serving: a reload is atomic and a
+// key pair which doesn't match, or whose certificate is expired, is refused so that the current credentials are kept.
+// Watch reloads the credentials whenever the content of the files changes
+type Reloader struct {
+	metrics *metrics.Metrics
+
+	// serializes the reloads
+	mu  sync.Mutex
+	cfg config.TLSConfig
+	// digest of the files whose loading failed last, so that the failure is reported once
+	failed  [sha256.Size]byte
+	current atomic.Pointer[material]
+}
+
+// New returns a Reloader recording the loadings and the certificates expiry in m. No credential is available until
+// Update succeeds
+func New(m *metrics.Metrics) *Reloader {
+	return &Reloader{metrics: m}
+}
+
+// Update reads the credentials from the files of cfg, which become the watched ones. If cfg.ClientCA is empty, no
+// CA is trusted to verify the client certificates. On error, the current credentials and files are kept
+func (r *Reloader) Update(cfg config.TLSConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, err := r.load(cfg)
+	if err != nil {
+		return err
+	}
+	r.cfg = cfg
+	r.current.Store(m)
+	return nil
+}
+
+// Watch checks the watched files every cfg.WatchInterval until ctx is done, and reloads the credentials if their
+// content changed. Nothing is watched if the interval is not positive
+func (r *Reloader) Watch(ctx context.Context) {
+	r.mu.Lock()
+	interval := r.cfg.WatchInterval
+	r.mu.Unlock()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.reloadChanged()
+			if err != nil {
+				log.Printf("error reloading TLS credentials, keeping the current ones: %s", err.Error())
+				continue
+			}
+			if changed {
+				log.Println("TLS credentials reloaded")
+			}
+		}
+	}
+}
+
+// reloadChanged reloads the credentials if the content of the watched files is neither the current one nor the one
+// which failed last
+func (r *Reloader) reloadChanged() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, digest, err := readFiles(r.cfg)
+	if err != nil {
+		return false, err
+	}
+	if current := r.current.Load(); (current != nil && digest == current.digest) || digest == r.failed {
+		return false, nil
+	}
+
+	m, err := r.load(r.cfg)
+	if err != nil {
+		return false, err
+	}
+	r.current.Store(m)
+	return true, nil
+}
+
+// GetCertificate is the tls.Config GetCertificate callback, returning the current certificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	current := r.current.Load()
+	if current == nil {
+		return nil, errors.New("no TLS credentials loaded")
+	}
+	return current.certificate, nil
+}
+
+// ClientCAs returns the current pool of CAs trusted to verify the client certificates, nil if no credentials are
+// loaded or no client CA bundle is configured
+func (r *Reloader) ClientCAs() *x509.CertPool {
+	current := r.current.Load()
+	if current == nil {
+		return nil
+	}
+	return current.clientCAs
+}
+
+// GetConfigForClient returns a tls.Config GetConfigForClient callback: each handshake uses a copy of base with
+// the current client CAs and certificate
+func (r *Reloader) GetConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.GetCertificate = r.GetCertificate
+		cfg.ClientCAs = r.ClientCAs()
+		return cfg, nil
+	}
+}
+
+// load reads and checks the credentials from the files of cfg; the outcome is recorded in the metrics
+func (r *Reloader) load(cfg config.TLSConfig) (*material, error) {
+	m, err := r.parse(cfg)
+	r.metrics.CertificateReload(err)
+	if err != nil {
+		return nil, err
+	}
+
+	r.metrics.CertificateExpiry(CertificateServer, m.certificate.Leaf.NotAfter)
+	if !m.clientCAExpiry.IsZero() {
+		r.metrics.CertificateExpiry(CertificateClientCA, m.clientCAExpiry)
+	}
+	return m, nil
+}
+
+func (r *Reloader) parse(cfg config.TLSConfig) (*material, error) {
+	contents, digest, err := readFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// from here on, a failure is caused by the content of the files
+	r.failed = digest
+
+	certificate, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return nil, fmt.Errorf("error loading key pair: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate: %w", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired on %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	certificate.Leaf = leaf
+
+	m := &material{
+		certificate: &certificate,
+		digest:      digest,
+	}
+	if cfg.ClientCA != "" {
+		cas, err := parseCertificates(contents[2])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing client CA bundle: %w", err)
+		}
+		m.clientCAs = x509.NewCertPool()
+		m.clientCAExpiry = cas[0].NotAfter
+		for _, ca := range cas {
+			m.clientCAs.AddCert(ca)
+			if ca.NotAfter.Before(m.clientCAExpiry) {
+				m.clientCAExpiry = ca.NotAfter
+			}
+		}
+	}
+
+	r.failed = [sha256.Size]byte{}
+	return m, nil
+}
+
+// readFiles returns the content of the certificate, key and client CA files of cfg, together with their digest
+func readFiles(cfg config.TLSConfig) ([3][]byte, [sha256.Size]byte, error) {
+	contents := [3][]byte{}
+	for i, path := range []string{cfg.Cert, cfg.Key, cfg.ClientCA} {
+		if path == "" {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return contents, [sha256.Size]byte{}, fmt.Errorf("error reading TLS file: %w", err)
+		}
+		contents[i] = content
+	}
+	return contents, sha256.Sum256(bytes.Join(contents[:], []byte{0})), nil
+}
+
+// parseCertificates returns the certificates of a PEM bundle, which must contain at least one
+func parseCertificates(bundle []byte) ([]*x509.Certificate, error) {
+	res := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, certificate)
+	}
+	if len(res) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return res, nil
+}
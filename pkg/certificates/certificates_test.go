@@ -0,0 +1,201 @@
+package certificates
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type keyPair struct {
+	certificate []byte
+	key         []byte
+}
+
+func newKeyPair(t *testing.T, commonName string, notAfter time.Time) keyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-48 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return keyPair{
+		certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:         pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func writeFiles(t *testing.T, cfg config.TLSConfig, certificate, key, clientCA []byte) {
+	require.NoError(t, os.WriteFile(cfg.Cert, certificate, 0o600))
+	require.NoError(t, os.WriteFile(cfg.Key, key, 0o600))
+	if cfg.ClientCA != "" {
+		require.NoError(t, os.WriteFile(cfg.ClientCA, clientCA, 0o600))
+	}
+}
+
+func tlsConfig(t *testing.T, clientCA bool) config.TLSConfig {
+	dir := t.TempDir()
+	cfg := config.Default().TLS
+	cfg.Enable = true
+	cfg.Cert = filepath.Join(dir, "cert.pem")
+	cfg.Key = filepath.Join(dir, "key.pem")
+	if clientCA {
+		cfg.ClientCA = filepath.Join(dir, "ca.pem")
+	}
+	return cfg
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	certificate, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	return certificate.Leaf.Subject.CommonName
+}
+
+func TestUpdate(t *testing.T) {
+	cfg := tlsConfig(t, true)
+	server := newKeyPair(t, "server", time.Now().Add(24*time.Hour))
+	caExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	ca := newKeyPair(t, "ca", caExpiry)
+	writeFiles(t, cfg, server.certificate, server.key, ca.certificate)
+
+	m := metrics.New()
+	r := New(m)
+	_, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	assert.Error(t, err)
+	assert.Nil(t, r.ClientCAs())
+
+	require.NoError(t, r.Update(cfg))
+	assert.Equal(t, "server", commonName(t, r))
+	expected := x509.NewCertPool()
+	expected.AppendCertsFromPEM(ca.certificate)
+	assert.True(t, expected.Equal(r.ClientCAs()))
+
+	resp := httptest.NewRecorder()
+	m.Handler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := resp.Body.String()
+	assert.Contains(t, body, `fizzbuzz_tls_certificate_reloads_total{result="success"} 1`)
+	assert.Contains(t, body, `fizzbuzz_tls_certificate_expiry_timestamp_seconds{certificate="client_ca"}`)
+	assert.Contains(t, body, `fizzbuzz_tls_certificate_expiry_timestamp_seconds{certificate="server"}`)
+
+	// the handshake configuration carries the current credentials
+	base := &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}
+	handshake, err := r.GetConfigForClient(base)(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, handshake.ClientAuth)
+	assert.Same(t, r.ClientCAs(), handshake.ClientCAs)
+	assert.Nil(t, handshake.GetConfigForClient)
+	assert.Nil(t, base.ClientCAs)
+}
+
+func TestUpdate_Refused(t *testing.T) {
+	cfg := tlsConfig(t, true)
+	server := newKeyPair(t, "server", time.Now().Add(24*time.Hour))
+	ca := newKeyPair(t, "ca", time.Now().Add(24*time.Hour))
+	writeFiles(t, cfg, server.certificate, server.key, ca.certificate)
+
+	r := New(nil)
+	require.NoError(t, r.Update(cfg))
+
+	other := newKeyPair(t, "other", time.Now().Add(24*time.Hour))
+	expired := newKeyPair(t, "expired", time.Now().Add(-time.Hour))
+	tests := map[string][3][]byte{
+		"mismatched pair":  {other.certificate, server.key, ca.certificate},
+		"expired":          {expired.certificate, expired.key, ca.certificate},
+		"empty CA bundle":  {other.certificate, other.key, []byte("no certificate here")},
+		"invalid key file": {other.certificate, []byte("boh"), ca.certificate},
+	}
+	for name, files := range tests {
+		t.Run(name, func(t *testing.T) {
+			writeFiles(t, cfg, files[0], files[1], files[2])
+			assert.Error(t, r.Update(cfg))
+			assert.Equal(t, "server", commonName(t, r))
+		})
+	}
+
+	missing := cfg
+	missing.Cert = filepath.Join(t.TempDir(), "missing.pem")
+	assert.Error(t, r.Update(missing))
+	assert.Equal(t, "server", commonName(t, r))
+}
+
+func TestReloadChanged(t *testing.T) {
+	cfg := tlsConfig(t, false)
+	before := newKeyPair(t, "before", time.Now().Add(24*time.Hour))
+	writeFiles(t, cfg, before.certificate, before.key, nil)
+
+	r := New(nil)
+	require.NoError(t, r.Update(cfg))
+
+	changed, err := r.reloadChanged()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// a rotation writing the certificate before the key is refused until the key is written
+	after := newKeyPair(t, "after", time.Now().Add(24*time.Hour))
+	writeFiles(t, cfg, after.certificate, before.key, nil)
+	_, err = r.reloadChanged()
+	assert.Error(t, err)
+	assert.Equal(t, "before", commonName(t, r))
+	// the failure is reported once
+	changed, err = r.reloadChanged()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	writeFiles(t, cfg, after.certificate, after.key, nil)
+	changed, err = r.reloadChanged()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "after", commonName(t, r))
+}
+
+func TestWatch(t *testing.T) {
+	cfg := tlsConfig(t, false)
+	cfg.WatchInterval = 10 * time.Millisecond
+	before := newKeyPair(t, "before", time.Now().Add(24*time.Hour))
+	writeFiles(t, cfg, before.certificate, before.key, nil)
+
+	r := New(nil)
+	require.NoError(t, r.Update(cfg))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Watch(ctx)
+		close(done)
+	}()
+
+	after := newKeyPair(t, "after", time.Now().Add(24*time.Hour))
+	writeFiles(t, cfg, after.certificate, after.key, nil)
+	assert.Eventually(t, func() bool {
+		return commonName(t, r) == "after"
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
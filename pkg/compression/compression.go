@@ -0,0 +1,166 @@
+package compression
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+)
+
+const (
+	// content codings supported, in order of preference of the server
+	Zstd    = "zstd"
+	Brotli  = "br"
+	Gzip    = "gzip"
+	Deflate = "deflate"
+)
+
+// Encoder is a compressing writer which can be reused once closed
+type Encoder interface {
+	io.WriteCloser
+	// Flush writes the pending compressed data to the underlying writer
+	Flush() error
+	// Reset discards the state of the Encoder and makes it write to w
+	Reset(w io.Writer)
+}
+
+// codec is a content coding whose encoders are pooled
+type codec struct {
+	name string
+	pool sync.Pool
+}
+
+// Codecs are the content codings available to compress the responses, each with its own compression level. The
+// encoders are pooled, so that they are not allocated for each response. It is safe for concurrent use
+type Codecs struct {
+	// in order of preference of the server
+	codecs []*codec
+}
+
+// New returns the Codecs zstd, br, gzip and deflate, each compressing at its level of cfg
+func New(cfg config.CompressionConfig) (*Codecs, error) {
+	if cfg.ZstdLevel < 1 || cfg.ZstdLevel > 22 {
+		return nil, fmt.Errorf("invalid zstd level %d", cfg.ZstdLevel)
+	}
+	if cfg.BrotliLevel < brotli.BestSpeed || cfg.BrotliLevel > brotli.BestCompression {
+		return nil, fmt.Errorf("invalid brotli level %d", cfg.BrotliLevel)
+	}
+	if cfg.GzipLevel < gzip.BestSpeed || cfg.GzipLevel > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid gzip level %d", cfg.GzipLevel)
+	}
+	if cfg.DeflateLevel < flate.BestSpeed || cfg.DeflateLevel > flate.BestCompression {
+		return nil, fmt.Errorf("invalid deflate level %d", cfg.DeflateLevel)
+	}
+
+	zs := &codec{name: Zstd}
+	zs.pool.New = func() any {
+		// a single goroutine per encoder, as each response is compressed by its own one
+		w, _ := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(cfg.ZstdLevel)),
+			zstd.WithEncoderConcurrency(1))
+		return w
+	}
+	br := &codec{name: Brotli}
+	br.pool.New = func() any {
+		return brotli.NewWriterLevel(io.Discard, cfg.BrotliLevel)
+	}
+	gz := &codec{name: Gzip}
+	gz.pool.New = func() any {
+		// the level is already checked
+		w, _ := gzip.NewWriterLevel(io.Discard, cfg.GzipLevel)
+		return w
+	}
+	fl := &codec{name: Deflate}
+	fl.pool.New = func() any {
+		w, _ := flate.NewWriter(io.Discard, cfg.DeflateLevel)
+		return w
+	}
+
+	return &Codecs{
+		codecs: []*codec{zs, br, gz, fl},
+	}, nil
+}
+
+// Negotiate returns the content coding preferred by the client, according to its Accept-Encoding header, among the
+// available ones; it is empty if none is acceptable, the response being sent as is. The client preference (q value)
+// prevails, then the server one
+func (c *Codecs) Negotiate(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	wildcard := -1.0
+	for _, element := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(element, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		qualities[coding] = q
+	}
+
+	type candidate struct {
+		name string
+		q    float64
+	}
+	var candidates []candidate
+	for _, cd := range c.codecs {
+		q, ok := qualities[cd.name]
+		if !ok {
+			q = wildcard
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{name: cd.name, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	// stable, so that the order of preference of the server is kept among the same q values
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].name
+}
+
+// Get returns an Encoder of the coding name writing to w, nil if the coding is not available. The Encoder must be
+// closed and given back with Put
+func (c *Codecs) Get(name string, w io.Writer) Encoder {
+	for _, cd := range c.codecs {
+		if cd.name == name {
+			enc := cd.pool.Get().(Encoder)
+			enc.Reset(w)
+			return enc
+		}
+	}
+	return nil
+}
+
+// Put gives back enc, an Encoder of the coding name returned by Get, once closed
+func (c *Codecs) Put(name string, enc Encoder) {
+	for _, cd := range c.codecs {
+		if cd.name == name {
+			// the encoder shouldn't retain the last writer
+			enc.Reset(io.Discard)
+			cd.pool.Put(enc)
+			return
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	c, err := New(config.Default().Compression)
+	require.NoError(t, err)
+
+	for acceptEncoding, expected := range map[string]string{
+		"":                              "",
+		"identity":                      "",
+		"compress":                      "",
+		"br":                            Brotli,
+		"zstd":                          Zstd,
+		"gzip":                          Gzip,
+		"deflate":                       Deflate,
+		"GZIP, deflate":                 Gzip,
+		"deflate, gzip":                 Gzip,
+		"gzip, deflate, br, zstd":       Zstd,
+		"gzip;q=0.5, deflate":           Deflate,
+		"gzip;q=0, deflate;q=0.1":       Deflate,
+		"*":                             Zstd,
+		"*;q=0.5, zstd;q=0, br;q=0":     Gzip,
+		"gzip;q=0, *;q=0":               "",
+		"br;q=1.0, gzip;q=0.8, *;q=0.1": Brotli,
+		"compress;q=1.0, gzip;q=0.8":    Gzip,
+		"gzip;q=boh, deflate":           Deflate,
+	} {
+		assert.Equal(t, expected, c.Negotiate(acceptEncoding), acceptEncoding)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	for _, invalid := range []func(cfg *config.CompressionConfig){
+		func(cfg *config.CompressionConfig) { cfg.GzipLevel = 0 },
+		func(cfg *config.CompressionConfig) { cfg.DeflateLevel = 10 },
+		func(cfg *config.CompressionConfig) { cfg.ZstdLevel = 23 },
+		func(cfg *config.CompressionConfig) { cfg.BrotliLevel = -1 },
+	} {
+		cfg := config.Default().Compression
+		invalid(&cfg)
+		_, err := New(cfg)
+		assert.Error(t, err)
+	}
+
+	cfg := config.Default().Compression
+	cfg.GzipLevel, cfg.DeflateLevel, cfg.ZstdLevel, cfg.BrotliLevel = 9, 1, 19, 11
+	c, err := New(cfg)
+	require.NoError(t, err)
+	payload := bytes.Repeat([]byte(`"Fizz","Buzz",`), 1000)
+
+	for _, name := range []string{Zstd, Brotli, Gzip, Deflate} {
+		// the encoders are reusable
+		for i := 0; i < 2; i++ {
+			var compressed bytes.Buffer
+			enc := c.Get(name, &compressed)
+			require.NotNil(t, enc)
+			_, err := enc.Write(payload)
+			require.NoError(t, err)
+			require.NoError(t, enc.Close())
+			c.Put(name, enc)
+			assert.Less(t, compressed.Len(), len(payload)/10)
+
+			var r io.Reader
+			switch name {
+			case Zstd:
+				zr, err := zstd.NewReader(&compressed)
+				require.NoError(t, err)
+				defer zr.Close()
+				r = zr
+			case Brotli:
+				r = brotli.NewReader(&compressed)
+			case Gzip:
+				r, err = gzip.NewReader(&compressed)
+				require.NoError(t, err)
+			default:
+				r = flate.NewReader(&compressed)
+			}
+			decompressed, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, payload, decompressed)
+		}
+	}
+
+	assert.Nil(t, c.Get("compress", io.Discard))
+}
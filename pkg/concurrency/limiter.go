@@ -0,0 +1,218 @@
+package concurrency
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/metrics"
+)
+
+const (
+	// ReasonQueueFull is the reason of a request shed because too many requests are already waiting
+	ReasonQueueFull = "queue_full"
+	// ReasonQueueTimeout is the reason of a request shed because it waited longer than the queue timeout
+	ReasonQueueTimeout = "queue_timeout"
+
+	// multiplicative decrease of the limit when a request is slower than the latency target
+	backoffRatio = 0.9
+)
+
+// Shed indicates that a request is rejected to protect the server from overload
+type Shed struct {
+	reason string
+}
+
+// Error is the error interface implementation
+func (s Shed) Error() string {
+	return "request shed: " + s.reason
+}
+
+// Is allows errors.Is(err, Shed{}) regardless of the reason
+func (s Shed) Is(target error) bool {
+	_, ok := target.(Shed)
+	return ok
+}
+
+// Reason returns ReasonQueueFull or ReasonQueueTimeout
+func (s Shed) Reason() string {
+	return s.reason
+}
+
+// waiter is a request waiting for a slot
+type waiter struct {
+	weight int64
+	// arrival order, among waiters of the same weight
+	seq      uint64
+	admitted chan struct{}
+	// position in the queue, -1 once out of it
+	index int
+}
+
+// queue is a heap of waiters, the lightest first
+type queue []*waiter
+
+func (q queue) Len() int { return len(q) }
+
+func (q queue) Less(i, j int) bool {
+	if q[i].weight != q[j].weight {
+		return q[i].weight < q[j].weight
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q queue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *queue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *queue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
+// Limiter bounds the number of requests processed concurrently. The limit adapts to the latency of the requests
+// (AIMD): it grows by one when a request completes within the latency target while at least half of the limit is in
+// use, and shrinks by 10% when a request exceeds the target. Requests over the limit wait in a queue, the lightest
+// first, until a slot is available or the queue timeout expires; they are shed if the queue is full
+type Limiter struct {
+	metrics *metrics.Metrics
+	now     func() time.Time
+
+	mu       sync.Mutex
+	cfg      config.ConcurrencyConfig
+	limit    float64
+	inflight int
+	waiting  queue
+	seq      uint64
+}
+
+// New returns a Limiter configured by cfg, starting at a quarter of the maximum limit; its state is recorded in m
+func New(cfg config.ConcurrencyConfig, m *metrics.Metrics) *Limiter {
+	l := &Limiter{
+		metrics: m,
+		now:     time.Now,
+		cfg:     cfg,
+		limit:   math.Max(float64(cfg.MinLimit), float64(cfg.MaxLimit)/4),
+	}
+	l.record()
+	return l
+}
+
+// Update applies cfg, keeping the current limit within its new bounds
+func (l *Limiter) Update(cfg config.ConcurrencyConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cfg = cfg
+	l.limit = math.Min(float64(cfg.MaxLimit), math.Max(float64(cfg.MinLimit), l.limit))
+	l.admit()
+	l.record()
+}
+
+// Acquire waits for a slot for a request of weight, which orders the waiting requests. The returned function must
+// be called once the request is processed, so that its latency adapts the limit. An error of type Shed is returned if
+// the request is shed, the error of ctx if it is done while waiting
+func (l *Limiter) Acquire(ctx context.Context, weight int64) (func(), error) {
+	l.mu.Lock()
+	if l.inflight < l.capacity() && len(l.waiting) == 0 {
+		l.inflight++
+		l.record()
+		l.mu.Unlock()
+		return l.releaser(), nil
+	}
+	if len(l.waiting) >= l.cfg.QueueSize {
+		l.mu.Unlock()
+		l.metrics.ConcurrencyRejected(ReasonQueueFull)
+		return nil, Shed{reason: ReasonQueueFull}
+	}
+	w := &waiter{weight: weight, seq: l.seq, admitted: make(chan struct{})}
+	l.seq++
+	heap.Push(&l.waiting, w)
+	l.record()
+	timeout := time.NewTimer(l.cfg.QueueTimeout)
+	l.mu.Unlock()
+	defer timeout.Stop()
+
+	var err error
+	select {
+	case <-w.admitted:
+		return l.releaser(), nil
+	case <-timeout.C:
+		err = Shed{reason: ReasonQueueTimeout}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	if w.index < 0 {
+		// admitted meanwhile
+		l.mu.Unlock()
+		return l.releaser(), nil
+	}
+	heap.Remove(&l.waiting, w.index)
+	l.record()
+	l.mu.Unlock()
+
+	if shed, ok := err.(Shed); ok {
+		l.metrics.ConcurrencyRejected(shed.reason)
+	}
+	return nil, err
+}
+
+// releaser returns the function releasing a slot acquired now
+func (l *Limiter) releaser() func() {
+	start := l.now()
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			l.release(l.now().Sub(start))
+		})
+	}
+}
+
+func (l *Limiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if latency > l.cfg.LatencyTarget {
+		l.limit = math.Max(float64(l.cfg.MinLimit), l.limit*backoffRatio)
+	} else if float64(l.inflight)*2 >= l.limit {
+		l.limit = math.Min(float64(l.cfg.MaxLimit), l.limit+1)
+	}
+	l.inflight--
+	l.admit()
+	l.record()
+}
+
+// admit hands the available slots to the waiting requests, the lightest first
+func (l *Limiter) admit() {
+	for l.inflight < l.capacity() && len(l.waiting) > 0 {
+		w := heap.Pop(&l.waiting).(*waiter)
+		l.inflight++
+		close(w.admitted)
+	}
+}
+
+// capacity is the number of requests which can be processed concurrently
+func (l *Limiter) capacity() int {
+	return int(l.limit)
+}
+
+func (l *Limiter) record() {
+	l.metrics.Concurrency(l.limit, l.inflight, len(l.waiting))
+}
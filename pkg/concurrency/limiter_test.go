@@ -0,0 +1,134 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() config.ConcurrencyConfig {
+	return config.ConcurrencyConfig{
+		Enable:        true,
+		MinLimit:      1,
+		MaxLimit:      8,
+		LatencyTarget: 100 * time.Millisecond,
+		QueueSize:     2,
+		QueueTimeout:  time.Hour,
+	}
+}
+
+func TestLimiter_Queue(t *testing.T) {
+	// fixed limit
+	cfg := testConfig()
+	cfg.MinLimit = 2
+	cfg.MaxLimit = 2
+	l := New(cfg, nil)
+	require.Equal(t, 2, l.capacity())
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := l.Acquire(context.Background(), 10)
+		require.NoError(t, err)
+		releases = append(releases, release)
+	}
+
+	// the requests over the limit wait, the lightest first
+	admitted := make(chan int64, 2)
+	for i, weight := range []int64{100, 1} {
+		i, weight := i, weight
+		go func() {
+			release, err := l.Acquire(context.Background(), weight)
+			if err == nil {
+				admitted <- weight
+				release()
+			}
+		}()
+		require.Eventually(t, func() bool {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return len(l.waiting) == i+1
+		}, time.Second, time.Millisecond)
+	}
+
+	// the queue is full
+	_, err := l.Acquire(context.Background(), 1)
+	assert.ErrorIs(t, err, Shed{})
+	assert.Equal(t, ReasonQueueFull, err.(Shed).Reason())
+
+	releases[0]()
+	assert.Equal(t, int64(1), <-admitted)
+	releases[1]()
+	assert.Equal(t, int64(100), <-admitted)
+
+	// releasing twice has no effect
+	releases[0]()
+	assert.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.inflight == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestLimiter_Timeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxLimit = 1
+	cfg.QueueTimeout = 10 * time.Millisecond
+	l := New(cfg, nil)
+
+	release, err := l.Acquire(context.Background(), 1)
+	require.NoError(t, err)
+	defer release()
+
+	_, err = l.Acquire(context.Background(), 1)
+	assert.ErrorIs(t, err, Shed{})
+	assert.Equal(t, ReasonQueueTimeout, err.(Shed).Reason())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.Acquire(ctx, 1)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Empty(t, l.waiting)
+}
+
+func TestLimiter_Adaptive(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := New(testConfig(), nil)
+	l.now = func() time.Time { return now }
+
+	// fast requests using the limit increase it
+	for i := 0; i < 3; i++ {
+		release, err := l.Acquire(context.Background(), 1)
+		require.NoError(t, err)
+		release()
+	}
+	// only the first used at least half of the limit
+	assert.Equal(t, float64(3), l.limit)
+
+	r1, err := l.Acquire(context.Background(), 1)
+	require.NoError(t, err)
+	r2, err := l.Acquire(context.Background(), 1)
+	require.NoError(t, err)
+	r2()
+	r1()
+	assert.Equal(t, float64(4), l.limit)
+
+	// slow requests decrease it, down to the minimum
+	for i := 0; i < 50; i++ {
+		release, err := l.Acquire(context.Background(), 1)
+		require.NoError(t, err)
+		now = now.Add(time.Second)
+		release()
+	}
+	assert.Equal(t, float64(1), l.limit)
+
+	// the limit is kept within the configured bounds
+	cfg := testConfig()
+	cfg.MinLimit = 4
+	l.Update(cfg)
+	assert.Equal(t, float64(4), l.limit)
+}
@@ -0,0 +1,383 @@
+package config
+
+import "time"
+
+// Config is the whole configuration of the application. Each setting can be provided, in increasing order of
+// precedence, by its default value, the configuration file (YAML or TOML, keys given by the yaml/toml tags),
+// its environment variable (env tag) and its command-line flag (the dotted path of the file keys, e.g. -server.address).
+// Settings tagged as secret are redacted when the configuration is printed; settings tagged as reload are applied
+// by a configuration reload, all the others require a restart.
+type Config struct {
+	Server      ServerConfig      `yaml:"server" toml:"server"`
+	Admin       AdminConfig       `yaml:"admin" toml:"admin"`
+	Log         LogConfig         `yaml:"log" toml:"log"`
+	TLS         TLSConfig         `yaml:"tls" toml:"tls"`
+	Redis       RedisConfig       `yaml:"redis" toml:"redis"`
+	Webhooks    WebhooksConfig    `yaml:"webhooks" toml:"webhooks"`
+	Tracing     TracingConfig     `yaml:"tracing" toml:"tracing"`
+	Limits      LimitsConfig      `yaml:"limits" toml:"limits"`
+	Auth        AuthConfig        `yaml:"auth" toml:"auth"`
+	Cache       CacheConfig       `yaml:"cache" toml:"cache"`
+	Compression CompressionConfig `yaml:"compression" toml:"compression"`
+	Validation  ValidationConfig  `yaml:"validation" toml:"validation"`
+	Docs        DocsConfig        `yaml:"docs" toml:"docs"`
+	Jobs        JobsConfig        `yaml:"jobs" toml:"jobs"`
+}
+
+// ServerConfig is the configuration of the api listener
+type ServerConfig struct {
+	// listen address of the api server
+	Address string `yaml:"address" toml:"address" env:"FIZZBUZZ_ADDRESS" usage:"listen address of the api server"`
+	// maximum duration for reading an entire request
+	ReadTimeout time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"FIZZBUZZ_READ_TIMEOUT" usage:"maximum duration for reading an entire request"`
+	// maximum duration before timing out writes of a response
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"FIZZBUZZ_WRITE_TIMEOUT" usage:"maximum duration for writing a response"`
+	// maximum duration of the graceful shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"FIZZBUZZ_SHUTDOWN_TIMEOUT" usage:"maximum duration of the graceful shutdown"`
+	// delay between the withdrawal of the readiness and the graceful shutdown, letting the load balancers stop
+	// routing new requests to the server
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay" env:"FIZZBUZZ_SHUTDOWN_DELAY" usage:"delay between the withdrawal of the readiness and the graceful shutdown"`
+}
+
+// AdminConfig is the configuration of the administration listener
+type AdminConfig struct {
+	// listen address of the administration server
+	Address string `yaml:"address" toml:"address" env:"FIZZBUZZ_ADMIN_ADDRESS" usage:"listen address of the administration server"`
+}
+
+// LogConfig is the configuration of the logs
+type LogConfig struct {
+	// one of panic, error, warn, info, debug, trace
+	Level string `yaml:"level" toml:"level" env:"FIZZBUZZ_LOG_LEVEL" reload:"true" usage:"log level: panic, error, warn, info, debug, trace"`
+}
+
+// TLSConfig is the configuration of TLS for the api listener
+type TLSConfig struct {
+	// the server will listen for TLS connections
+	Enable bool `yaml:"enable" toml:"enable" env:"FIZZBUZZ_TLS_ENABLE" usage:"listen for TLS connections"`
+	// allows insecure connections
+	Insecure bool `yaml:"insecure" toml:"insecure" env:"FIZZBUZZ_INSECURE" usage:"allow insecure TLS connections"`
+	// client authentication type, one of no_client_cert, request_client_cert, require_any_client_cert,
+	// verify_client_cert_if_given, require_and_verify_client_cert (or the numeric value of tls.ClientAuthType)
+	ClientAuthType string `yaml:"client_auth_type" toml:"client_auth_type" env:"FIZZBUZZ_CLIENT_AUTH_TYPE" usage:"client authentication type: no_client_cert, request_client_cert, require_any_client_cert, verify_client_cert_if_given, require_and_verify_client_cert"`
+	// path of the server certificate
+	Cert string `yaml:"cert" toml:"cert" env:"FIZZBUZZ_TLS_CERT" reload:"true" usage:"path of the server certificate"`
+	// path of the server key
+	Key string `yaml:"key" toml:"key" env:"FIZZBUZZ_TLS_KEY" reload:"true" usage:"path of the server key"`
+	// path of the bundle of CAs trusted to verify the client certificates, mandatory if they are verified
+	ClientCA string `yaml:"client_ca" toml:"client_ca" env:"FIZZBUZZ_TLS_CLIENT_CA" reload:"true" usage:"path of the bundle of CAs trusted to verify the client certificates"`
+	// interval between two checks of the TLS files, which are reloaded when changed; 0 disables the checks
+	WatchInterval time.Duration `yaml:"watch_interval" toml:"watch_interval" env:"FIZZBUZZ_TLS_WATCH_INTERVAL" usage:"interval between two checks of the TLS files for changes, 0 disables the checks"`
+	// client identities, by name, recognized from the verified client certificates; file only
+	Identities map[string]IdentityConfig `yaml:"identities,omitempty" toml:"identities,omitempty" reload:"true"`
+	// identities allowed, by path prefix, the longest matching prefix applies; "*" allows any identity. Paths not
+	// matching any prefix are allowed to everybody; file only
+	Routes map[string][]string `yaml:"routes,omitempty" toml:"routes,omitempty" reload:"true"`
+}
+
+// IdentityConfig describes how a client identity is recognized from its certificate: the identity matches if the
+// certificate subject common name or one of its subject alternative names (DNS, email or URI) is listed
+type IdentityConfig struct {
+	// subject common names
+	CommonNames []string `yaml:"common_names,omitempty" toml:"common_names,omitempty"`
+	// subject alternative names
+	SANs []string `yaml:"sans,omitempty" toml:"sans,omitempty"`
+}
+
+// RedisConfig is the configuration of the connection to the redis DB
+type RedisConfig struct {
+	// address of the redis instance
+	Address string `yaml:"address" toml:"address" env:"REDIS_DB_ADDRESS" usage:"address of the redis instance"`
+	// username
+	Username string `yaml:"username" toml:"username" env:"REDIS_DB_USERNAME" usage:"redis username"`
+	// password
+	Password string `yaml:"password" toml:"password" env:"REDIS_DB_PASSWORD" secret:"true" usage:"redis password"`
+	// numeric id of the DB
+	DB int `yaml:"db" toml:"db" env:"REDIS_DB_ID" usage:"numeric id of the redis DB"`
+	// use TLS to establish the connection
+	TLS bool `yaml:"tls" toml:"tls" env:"REDIS_DB_TLS" usage:"use TLS to connect to redis"`
+	// allows insecure connection
+	TLSInsecure bool `yaml:"tls_insecure" toml:"tls_insecure" env:"REDIS_DB_TLS_INSECURE" usage:"allow insecure TLS connections to redis"`
+	// path of the client certificate
+	TLSCertificatePath string `yaml:"tls_certificate_path" toml:"tls_certificate_path" env:"REDIS_DB_TLS_CERTIFICATE_PATH" usage:"path of the redis client certificate"`
+	// path of the client key
+	TLSKeyPath string `yaml:"tls_key_path" toml:"tls_key_path" env:"REDIS_DB_TLS_KEY_PATH" usage:"path of the redis client key"`
+}
+
+// WebhooksConfig is the configuration of the webhooks deliveries
+type WebhooksConfig struct {
+	// maximum number of attempts for a single event
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts" env:"FIZZBUZZ_WEBHOOKS_MAX_ATTEMPTS" usage:"maximum number of delivery attempts of a webhook event"`
+	// delay before the first retry
+	Backoff time.Duration `yaml:"backoff" toml:"backoff" env:"FIZZBUZZ_WEBHOOKS_BACKOFF" usage:"delay before the first retry of a webhook delivery"`
+	// interval between two scans of the pending events
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval" env:"FIZZBUZZ_WEBHOOKS_POLL_INTERVAL" usage:"interval between two scans of the pending webhook events"`
+	// deliver the events to loopback, private and link-local addresses, refused otherwise
+	AllowPrivate bool `yaml:"allow_private" toml:"allow_private" env:"FIZZBUZZ_WEBHOOKS_ALLOW_PRIVATE" usage:"allow the delivery of the webhook events to loopback, private and link-local addresses"`
+}
+
+// TracingConfig is the configuration of the traces export
+type TracingConfig struct {
+	// one of none, stdout, otlp
+	Exporter string `yaml:"exporter" toml:"exporter" env:"FIZZBUZZ_TRACING_EXPORTER" usage:"exporter of the spans: none, stdout, otlp"`
+	// fraction of the new traces which are sampled
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"FIZZBUZZ_TRACING_SAMPLE_RATIO" usage:"fraction of the new traces which are sampled"`
+}
+
+// LimitsConfig is the configuration of the limits applied to the requests
+type LimitsConfig struct {
+	// maximum number of elements of a single fizzbuzz response, longer sequences are paginated
+	PaginationMax int `yaml:"pagination_max" toml:"pagination_max" env:"FIZZBUZZ_PAGINATION_MAX" reload:"true" usage:"maximum number of elements of a single fizzbuzz response"`
+	// maximum size in bytes of the body of a POST /fizzbuzz request, larger bodies are rejected
+	MaxBodyBytes int               `yaml:"max_body_bytes" toml:"max_body_bytes" env:"FIZZBUZZ_MAX_BODY_BYTES" reload:"true" usage:"maximum size in bytes of a fizzbuzz request body"`
+	RateLimit    RateLimitConfig   `yaml:"rate_limit" toml:"rate_limit"`
+	Concurrency  ConcurrencyConfig `yaml:"concurrency" toml:"concurrency"`
+	Batch        BatchConfig       `yaml:"batch" toml:"batch"`
+}
+
+// BatchConfig is the configuration of the batches of fizzbuzz requests
+type BatchConfig struct {
+	// maximum number of items of a batch; the size of its body is limited to max_body_bytes per item
+	MaxItems int `yaml:"max_items" toml:"max_items" env:"FIZZBUZZ_BATCH_MAX_ITEMS" reload:"true" usage:"maximum number of items of a fizzbuzz batch"`
+	// maximum number of elements generated by all the items of a batch
+	MaxElements int `yaml:"max_elements" toml:"max_elements" env:"FIZZBUZZ_BATCH_MAX_ELEMENTS" reload:"true" usage:"maximum number of elements generated by a fizzbuzz batch"`
+	// number of items of a batch generated concurrently
+	Workers int `yaml:"workers" toml:"workers" env:"FIZZBUZZ_BATCH_WORKERS" reload:"true" usage:"number of items of a fizzbuzz batch generated concurrently"`
+}
+
+// ConcurrencyConfig is the configuration of the adaptive limit of the fizzbuzz generations processed concurrently
+type ConcurrencyConfig struct {
+	// the concurrency of the fizzbuzz generations is limited, the requests over the limit are queued or shed
+	Enable bool `yaml:"enable" toml:"enable" env:"FIZZBUZZ_CONCURRENCY_ENABLE" usage:"limit the fizzbuzz generations processed concurrently"`
+	// bounds of the adaptive limit
+	MinLimit int `yaml:"min_limit" toml:"min_limit" env:"FIZZBUZZ_CONCURRENCY_MIN_LIMIT" reload:"true" usage:"minimum number of fizzbuzz generations processed concurrently"`
+	MaxLimit int `yaml:"max_limit" toml:"max_limit" env:"FIZZBUZZ_CONCURRENCY_MAX_LIMIT" reload:"true" usage:"maximum number of fizzbuzz generations processed concurrently"`
+	// latency over which a generation is considered a sign of overload, lowering the limit
+	LatencyTarget time.Duration `yaml:"latency_target" toml:"latency_target" env:"FIZZBUZZ_CONCURRENCY_LATENCY_TARGET" reload:"true" usage:"latency over which the concurrency limit is lowered"`
+	// maximum number of requests waiting for a slot
+	QueueSize int `yaml:"queue_size" toml:"queue_size" env:"FIZZBUZZ_CONCURRENCY_QUEUE_SIZE" reload:"true" usage:"maximum number of requests waiting for a slot"`
+	// maximum duration a request waits for a slot
+	QueueTimeout time.Duration `yaml:"queue_timeout" toml:"queue_timeout" env:"FIZZBUZZ_CONCURRENCY_QUEUE_TIMEOUT" reload:"true" usage:"maximum duration a request waits for a slot"`
+}
+
+// RateLimitConfig is the configuration of the rate limiting of the api clients, identified by their API key or token
+// subject if authenticated, by their IP address otherwise
+type RateLimitConfig struct {
+	// storage of the budgets of the clients, one of none, memory, redis; none disables the rate limiting, redis
+	// enforces a global budget across the server instances
+	Backend string `yaml:"backend" toml:"backend" env:"FIZZBUZZ_RATE_LIMIT_BACKEND" usage:"storage of the rate limiting budgets: none, memory, redis"`
+	// tokens earned by a client per second
+	Rate float64 `yaml:"rate" toml:"rate" env:"FIZZBUZZ_RATE_LIMIT_RATE" reload:"true" usage:"tokens earned by a client per second"`
+	// maximum number of tokens a client can accumulate
+	Burst int `yaml:"burst" toml:"burst" env:"FIZZBUZZ_RATE_LIMIT_BURST" reload:"true" usage:"maximum number of tokens a client can accumulate"`
+	// number of generated fizzbuzz elements costing one further token
+	CostElements int `yaml:"cost_elements" toml:"cost_elements" env:"FIZZBUZZ_RATE_LIMIT_COST_ELEMENTS" reload:"true" usage:"number of generated fizzbuzz elements costing one further token"`
+	// maximum number of tokens taken by a client along a day (UTC), 0 if unlimited
+	DailyQuota int `yaml:"daily_quota" toml:"daily_quota" env:"FIZZBUZZ_RATE_LIMIT_DAILY_QUOTA" reload:"true" usage:"maximum number of tokens taken by a client along a day, 0 if unlimited"`
+}
+
+// CacheConfig is the configuration of the cache of the fizzbuzz responses
+type CacheConfig struct {
+	// one of none, memory, redis; none disables the cache, redis shares the cached responses across the server
+	// instances, in addition to the in-memory cache of each instance
+	Backend string `yaml:"backend" toml:"backend" env:"FIZZBUZZ_CACHE_BACKEND" usage:"cache of the fizzbuzz responses: none, memory, redis"`
+	// maximum size of the in-memory cache, keys included
+	MaxBytes int `yaml:"max_bytes" toml:"max_bytes" env:"FIZZBUZZ_CACHE_MAX_BYTES" reload:"true" usage:"maximum size in bytes of the in-memory cache"`
+	// expiry of the responses cached in the redis DB
+	TTL time.Duration `yaml:"ttl" toml:"ttl" env:"FIZZBUZZ_CACHE_TTL" reload:"true" usage:"expiry of the responses cached in redis"`
+	// freshness of the responses advertised to the HTTP caches by the Cache-Control header
+	MaxAge           time.Duration `yaml:"max_age" toml:"max_age" env:"FIZZBUZZ_CACHE_MAX_AGE" reload:"true" usage:"freshness of the fizzbuzz responses for the HTTP caches"`
+	StatisticsMaxAge time.Duration `yaml:"statistics_max_age" toml:"statistics_max_age" env:"FIZZBUZZ_CACHE_STATISTICS_MAX_AGE" reload:"true" usage:"freshness of the statistics responses for the HTTP caches"`
+}
+
+// CompressionConfig is the configuration of the compression of the responses
+type CompressionConfig struct {
+	// the responses are compressed with the content coding negotiated with the client
+	Enable bool `yaml:"enable" toml:"enable" env:"FIZZBUZZ_COMPRESSION_ENABLE" reload:"true" usage:"compress the responses"`
+	// responses smaller than it are sent as is, as they wouldn't benefit from the compression
+	MinSize int `yaml:"min_size" toml:"min_size" env:"FIZZBUZZ_COMPRESSION_MIN_SIZE" reload:"true" usage:"minimum size in bytes of a compressed response"`
+	// compression levels, from 1 (best speed) to 9 (best compression)
+	GzipLevel    int `yaml:"gzip_level" toml:"gzip_level" env:"FIZZBUZZ_COMPRESSION_GZIP_LEVEL" reload:"true" usage:"gzip compression level, from 1 to 9"`
+	DeflateLevel int `yaml:"deflate_level" toml:"deflate_level" env:"FIZZBUZZ_COMPRESSION_DEFLATE_LEVEL" reload:"true" usage:"deflate compression level, from 1 to 9"`
+	// zstd compression level, from 1 (best speed) to 22 (best compression)
+	ZstdLevel int `yaml:"zstd_level" toml:"zstd_level" env:"FIZZBUZZ_COMPRESSION_ZSTD_LEVEL" reload:"true" usage:"zstd compression level, from 1 to 22"`
+	// brotli compression level, from 0 (best speed) to 11 (best compression)
+	BrotliLevel int `yaml:"brotli_level" toml:"brotli_level" env:"FIZZBUZZ_COMPRESSION_BROTLI_LEVEL" reload:"true" usage:"brotli compression level, from 0 to 11"`
+}
+
+// DocsConfig is the configuration of the documentation of the api
+type DocsConfig struct {
+	// the interactive explorer of the api is served by /docs; it should stay disabled in production
+	Enable bool `yaml:"enable" toml:"enable" env:"FIZZBUZZ_DOCS_ENABLE" usage:"serve the interactive api explorer on /docs"`
+	// absolute URL of the api server, e.g. https://fizzbuzz.example.com, filled in the served OpenAPI document;
+	// derived from each request if empty
+	ServerURL string `yaml:"server_url" toml:"server_url" env:"FIZZBUZZ_DOCS_SERVER_URL" reload:"true" usage:"absolute URL of the api server in the served OpenAPI document, derived from the requests if empty"`
+	// comma separated CIDRs of the proxies whose X-Forwarded-Proto and X-Forwarded-Host headers are honored when
+	// deriving the server URL; empty trusts none
+	TrustedProxies string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"FIZZBUZZ_DOCS_TRUSTED_PROXIES" reload:"true" usage:"comma separated CIDRs of the proxies whose forwarded headers are honored, e.g. 10.0.0.0/8"`
+}
+
+// JobsConfig is the configuration of the asynchronous generation of the fizzbuzz sequences
+type JobsConfig struct {
+	// the /jobs endpoints are served and the jobs processed
+	Enable bool `yaml:"enable" toml:"enable" env:"FIZZBUZZ_JOBS_ENABLE" usage:"serve the /jobs endpoints generating the sequences asynchronously"`
+	// directory of the jobs and of their results, kept across restarts
+	Dir string `yaml:"dir" toml:"dir" env:"FIZZBUZZ_JOBS_DIR" usage:"directory of the jobs and of their results"`
+	// number of jobs processed concurrently
+	Workers int `yaml:"workers" toml:"workers" env:"FIZZBUZZ_JOBS_WORKERS" usage:"number of jobs processed concurrently"`
+	// maximum number of jobs waiting to be processed, further submissions are rejected
+	QueueSize int `yaml:"queue_size" toml:"queue_size" env:"FIZZBUZZ_JOBS_QUEUE_SIZE" usage:"maximum number of jobs waiting to be processed"`
+	// maximum number of elements of the sequence of a job
+	MaxElements int `yaml:"max_elements" toml:"max_elements" env:"FIZZBUZZ_JOBS_MAX_ELEMENTS" usage:"maximum number of elements of the sequence of a job"`
+	// maximum number of pending or running jobs of a client, further submissions are rejected; 0 if unlimited
+	MaxActive int `yaml:"max_active" toml:"max_active" env:"FIZZBUZZ_JOBS_MAX_ACTIVE" usage:"maximum number of pending or running jobs of a client, 0 if unlimited"`
+	// maximum size in bytes of the stored results, compressed; 0 if unlimited
+	MaxStoreBytes int `yaml:"max_store_bytes" toml:"max_store_bytes" env:"FIZZBUZZ_JOBS_MAX_STORE_BYTES" usage:"maximum size in bytes of the stored job results, 0 if unlimited"`
+	// size in bytes of the uncompressed chunks of a result, each compressed in its own file
+	ChunkBytes int `yaml:"chunk_bytes" toml:"chunk_bytes" env:"FIZZBUZZ_JOBS_CHUNK_BYTES" usage:"size in bytes of the uncompressed chunks of a job result"`
+	// duration a job and its result are kept once finished
+	TTL time.Duration `yaml:"ttl" toml:"ttl" env:"FIZZBUZZ_JOBS_TTL" usage:"duration a finished job and its result are kept"`
+	// interval between two removals of the expired jobs
+	CleanupInterval time.Duration `yaml:"cleanup_interval" toml:"cleanup_interval" env:"FIZZBUZZ_JOBS_CLEANUP_INTERVAL" usage:"interval between two removals of the expired jobs"`
+}
+
+// ValidationConfig is the configuration of the rules checked on the fizzbuzz input parameters, in addition to
+// their format
+type ValidationConfig struct {
+	// maximum limit - start span, 0 if unlimited
+	MaxSpan int `yaml:"max_span" toml:"max_span" env:"FIZZBUZZ_VALIDATION_MAX_SPAN" reload:"true" usage:"maximum span between start and limit, 0 if unlimited"`
+	// maximum value of int1 and int2, 0 if unlimited
+	MaxInteger int `yaml:"max_integer" toml:"max_integer" env:"FIZZBUZZ_VALIDATION_MAX_INTEGER" reload:"true" usage:"maximum value of int1 and int2, 0 if unlimited"`
+	// maximum number of characters of str1 and str2, 0 if unlimited
+	MaxLength int `yaml:"max_length" toml:"max_length" env:"FIZZBUZZ_VALIDATION_MAX_LENGTH" reload:"true" usage:"maximum number of characters of str1 and str2, 0 if unlimited"`
+	// comma separated Unicode character classes allowed in str1 and str2, among letter, mark, digit, punct, symbol,
+	// space, control; empty allows any character
+	Characters string `yaml:"characters" toml:"characters" env:"FIZZBUZZ_VALIDATION_CHARACTERS" reload:"true" usage:"comma separated character classes allowed in str1 and str2: letter, mark, digit, punct, symbol, space, control; empty allows any"`
+	// words str1 and str2 can't include, whatever the case; file only
+	BannedWords []string `yaml:"banned_words,omitempty" toml:"banned_words,omitempty" reload:"true"`
+	// a start greater than limit is accepted, the sequence being empty
+	AllowStartAfterLimit bool `yaml:"allow_start_after_limit" toml:"allow_start_after_limit" env:"FIZZBUZZ_VALIDATION_ALLOW_START_AFTER_LIMIT" reload:"true" usage:"accept a start greater than limit"`
+}
+
+// CharacterClasses are the Unicode character classes of ValidationConfig.Characters
+var CharacterClasses = []string{"letter", "mark", "digit", "punct", "symbol", "space", "control"}
+
+// AuthConfig is the configuration of the authentication of the api clients
+type AuthConfig struct {
+	// source of the API keys, one of none, file, redis; none disables the API keys authentication
+	APIKeys string `yaml:"api_keys" toml:"api_keys" env:"FIZZBUZZ_AUTH_API_KEYS" usage:"source of the API keys: none, file, redis"`
+	// path of the API keys file, if the source is file
+	APIKeysFile string    `yaml:"api_keys_file" toml:"api_keys_file" env:"FIZZBUZZ_AUTH_API_KEYS_FILE" reload:"true" usage:"path of the API keys file"`
+	JWT         JWTConfig `yaml:"jwt" toml:"jwt"`
+}
+
+// JWTConfig is the configuration of the authentication by JWT bearer tokens, issued by an identity provider
+type JWTConfig struct {
+	// path or http(s) URL of the JSON Web Key Set verifying the token signatures; empty disables the JWT authentication
+	JWKS string `yaml:"jwks" toml:"jwks" env:"FIZZBUZZ_AUTH_JWT_JWKS" reload:"true" usage:"path or http(s) URL of the JWKS verifying the bearer tokens"`
+	// maximum age of the cached key set; a token signed by an unknown key also triggers a refresh
+	JWKSRefresh time.Duration `yaml:"jwks_refresh" toml:"jwks_refresh" env:"FIZZBUZZ_AUTH_JWT_JWKS_REFRESH" reload:"true" usage:"maximum age of the cached JWKS"`
+	// expected iss claim
+	Issuer string `yaml:"issuer" toml:"issuer" env:"FIZZBUZZ_AUTH_JWT_ISSUER" reload:"true" usage:"expected issuer of the bearer tokens"`
+	// expected value among the aud claim
+	Audience string `yaml:"audience" toml:"audience" env:"FIZZBUZZ_AUTH_JWT_AUDIENCE" reload:"true" usage:"expected audience of the bearer tokens"`
+	// tolerated clock skew checking the exp and nbf claims
+	Leeway time.Duration `yaml:"leeway" toml:"leeway" env:"FIZZBUZZ_AUTH_JWT_LEEWAY" reload:"true" usage:"tolerated clock skew checking the expiry of the bearer tokens"`
+	// claim holding the granted values, either a space separated string or an array of strings
+	ScopesClaim string `yaml:"scopes_claim" toml:"scopes_claim" env:"FIZZBUZZ_AUTH_JWT_SCOPES_CLAIM" reload:"true" usage:"claim of the bearer tokens holding the granted scopes"`
+	// scopes granted by each value of the scopes claim, e.g. a role or a group; if empty, the values are the scopes
+	Scopes map[string][]string `yaml:"scopes,omitempty" toml:"scopes" reload:"true"`
+}
+
+// Default returns the configuration used when no other source provides a setting
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Address:         ":3000",
+			ReadTimeout:     time.Minute,
+			WriteTimeout:    time.Minute,
+			ShutdownTimeout: 10 * time.Second,
+			ShutdownDelay:   5 * time.Second,
+		},
+		Admin: AdminConfig{
+			Address: "127.0.0.1:9090",
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		TLS: TLSConfig{
+			ClientAuthType: "require_and_verify_client_cert",
+			WatchInterval:  30 * time.Second,
+		},
+		Redis: RedisConfig{
+			Address: "localhost:6379",
+		},
+		Webhooks: WebhooksConfig{
+			MaxAttempts:  8,
+			Backoff:      time.Second,
+			PollInterval: time.Second,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Limits: LimitsConfig{
+			PaginationMax: 65536,
+			MaxBodyBytes:  16384,
+			RateLimit: RateLimitConfig{
+				Backend:      "none",
+				Rate:         10,
+				Burst:        100,
+				CostElements: 1000,
+			},
+			Concurrency: ConcurrencyConfig{
+				MinLimit:      1,
+				MaxLimit:      64,
+				LatencyTarget: 250 * time.Millisecond,
+				QueueSize:     128,
+				QueueTimeout:  time.Second,
+			},
+			Batch: BatchConfig{
+				MaxItems:    100,
+				MaxElements: 1 << 20,
+				Workers:     4,
+			},
+		},
+		Auth: AuthConfig{
+			APIKeys: "none",
+			JWT: JWTConfig{
+				JWKSRefresh: 5 * time.Minute,
+				Leeway:      30 * time.Second,
+				ScopesClaim: "scope",
+			},
+		},
+		Cache: CacheConfig{
+			Backend:          "none",
+			MaxBytes:         64 << 20,
+			TTL:              time.Hour,
+			MaxAge:           time.Hour,
+			StatisticsMaxAge: 5 * time.Second,
+		},
+		Compression: CompressionConfig{
+			MinSize:      1024,
+			GzipLevel:    6,
+			DeflateLevel: 6,
+			ZstdLevel:    3,
+			BrotliLevel:  4,
+		},
+		Validation: ValidationConfig{
+			AllowStartAfterLimit: true,
+		},
+		Jobs: JobsConfig{
+			Dir:             "jobs",
+			Workers:         2,
+			QueueSize:       64,
+			MaxElements:     100_000_000,
+			MaxActive:       4,
+			MaxStoreBytes:   10 << 30,
+			ChunkBytes:      4 << 20,
+			TTL:             24 * time.Hour,
+			CleanupInterval: time.Minute,
+		},
+	}
+}
@@ -0,0 +1,428 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Default(t *testing.T) {
+	cfg, options, err := Load(nil)
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+	assert.Equal(t, Options{}, options)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+server:
+  address: ":4000"
+  read_timeout: 30s
+log:
+  level: debug
+redis:
+  address: redis:6379
+`)
+
+	t.Setenv("FIZZBUZZ_LOG_LEVEL", "warn")
+	t.Setenv("REDIS_DB_ADDRESS", "other:6379")
+
+	cfg, options, err := Load([]string{"-config", path, "-redis.address", "flag:6379", "-webhooks.backoff=3s"})
+	require.NoError(t, err)
+	assert.Equal(t, path, options.File)
+	// file
+	assert.Equal(t, ":4000", cfg.Server.Address)
+	assert.Equal(t, 30*time.Second, cfg.Server.ReadTimeout)
+	// env over file
+	assert.Equal(t, "warn", cfg.Log.Level)
+	// flag over env
+	assert.Equal(t, "flag:6379", cfg.Redis.Address)
+	assert.Equal(t, 3*time.Second, cfg.Webhooks.Backoff)
+	// default
+	assert.Equal(t, time.Minute, cfg.Server.WriteTimeout)
+}
+
+func TestLoad_TOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+[server]
+address = ":4000"
+shutdown_timeout = "5s"
+shutdown_delay = "2s"
+
+[tracing]
+exporter = "stdout"
+sample_ratio = 0.5
+`)
+	t.Setenv("FIZZBUZZ_CONFIG_FILE", path)
+
+	cfg, _, err := Load(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ":4000", cfg.Server.Address)
+	assert.Equal(t, 5*time.Second, cfg.Server.ShutdownTimeout)
+	assert.Equal(t, 2*time.Second, cfg.Server.ShutdownDelay)
+	assert.Equal(t, "stdout", cfg.Tracing.Exporter)
+	assert.Equal(t, 0.5, cfg.Tracing.SampleRatio)
+}
+
+func TestLoad_FileErrors(t *testing.T) {
+	_, _, err := Load([]string{"-config", writeFile(t, "config.yaml", "server:\n  port: 3000\n")})
+	assert.Error(t, err)
+
+	_, _, err = Load([]string{"-config", writeFile(t, "config.toml", "[server]\nport = 3000\n")})
+	assert.Error(t, err)
+
+	_, _, err = Load([]string{"-config", writeFile(t, "config.json", "{}")})
+	assert.Error(t, err)
+
+	_, _, err = Load([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")})
+	assert.Error(t, err)
+}
+
+func TestLoad_AggregatedErrors(t *testing.T) {
+	t.Setenv("FIZZBUZZ_INSECURE", "boh")
+	t.Setenv("FIZZBUZZ_CLIENT_AUTH_TYPE", "boh")
+
+	_, _, err := Load([]string{"-server.read_timeout", "forever"})
+	require.Error(t, err)
+	for _, expected := range []string{"FIZZBUZZ_INSECURE", "tls.client_auth_type", "-server.read_timeout"} {
+		assert.Contains(t, err.Error(), expected)
+	}
+
+	_, _, err = Load([]string{"-unknown"})
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Default().Validate())
+
+	cfg := Default()
+	cfg.Log.Level = "verbose"
+	cfg.TLS.Enable = true
+	cfg.TLS.ClientAuthType = "boh"
+	cfg.Admin.Address = cfg.Server.Address
+	cfg.Tracing.SampleRatio = 2
+	cfg.Server.ShutdownDelay = -time.Second
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, expected := range []string{"server.shutdown_delay", "log.level", "tls.cert", "tls.key", "tls.client_auth_type", "admin.address", "tracing.sample_ratio"} {
+		assert.Contains(t, err.Error(), expected)
+	}
+	assert.Len(t, strings.Split(err.Error(), "\n"), 7)
+}
+
+func TestPrint(t *testing.T) {
+	cfg := Default()
+	cfg.Redis.Password = "s3cr3t"
+
+	var buf bytes.Buffer
+	require.NoError(t, cfg.Print(&buf))
+	assert.NotContains(t, buf.String(), "s3cr3t")
+	assert.Contains(t, buf.String(), "password: '*****'")
+	assert.Contains(t, buf.String(), "read_timeout: 1m0s")
+	// the original configuration is untouched
+	assert.Equal(t, "s3cr3t", cfg.Redis.Password)
+
+	// the printed configuration can be loaded back
+	_, _, err := Load([]string{"-config", writeFile(t, "printed.yaml", buf.String())})
+	assert.NoError(t, err)
+}
+
+func TestRestartRequired(t *testing.T) {
+	cfg := Default()
+	assert.Empty(t, cfg.RestartRequired(Default()))
+
+	next := Default()
+	next.Log.Level = "debug"
+	next.Limits.PaginationMax = 100
+	next.TLS.Cert = "/etc/fizzbuzz/cert.pem"
+	assert.Empty(t, cfg.RestartRequired(next))
+
+	next.Server.Address = ":4000"
+	next.Redis.Password = "s3cr3t"
+	assert.Equal(t, []string{"server.address", "redis.password"}, cfg.RestartRequired(next))
+}
+
+func TestReloaded(t *testing.T) {
+	next := Default()
+	next.Log.Level = "debug"
+	next.Limits.PaginationMax = 100
+	next.Server.Address = ":4000"
+
+	cfg := Default().Reloaded(next)
+	assert.Equal(t, "debug", cfg.Log.Level)
+	assert.Equal(t, 100, cfg.Limits.PaginationMax)
+	assert.Equal(t, ":3000", cfg.Server.Address)
+}
+
+func TestClientAuth(t *testing.T) {
+	tests := map[string]tls.ClientAuthType{
+		"no_client_cert":                 tls.NoClientCert,
+		"Request_Client_Cert":            tls.RequestClientCert,
+		"require_and_verify_client_cert": tls.RequireAndVerifyClientCert,
+		"3":                              tls.VerifyClientCertIfGiven,
+	}
+	for name, expected := range tests {
+		clientAuth, err := TLSConfig{ClientAuthType: name}.ClientAuth()
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, clientAuth, name)
+	}
+
+	for _, name := range []string{"", "5", "-1", "boh"} {
+		_, err := TLSConfig{ClientAuthType: name}.ClientAuth()
+		assert.Error(t, err, name)
+	}
+}
+
+func TestValidate_TLS(t *testing.T) {
+	cfg := Default()
+	cfg.TLS.Enable = true
+	cfg.TLS.Cert = "cert.pem"
+	cfg.TLS.Key = "key.pem"
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tls.client_ca")
+
+	cfg.TLS.ClientAuthType = "require_any_client_cert"
+	assert.NoError(t, cfg.Validate())
+
+	// routes can only be enforced on verified certificates
+	cfg.TLS.Identities = map[string]IdentityConfig{"ops": {CommonNames: []string{"ops.example.com"}}}
+	cfg.TLS.Routes = map[string][]string{"/api/v1/webhooks": {"ops"}}
+	assert.Error(t, cfg.Validate())
+
+	cfg.TLS.ClientAuthType = "verify_client_cert_if_given"
+	cfg.TLS.ClientCA = "ca.pem"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.TLS.Identities["empty"] = IdentityConfig{}
+	cfg.TLS.Routes["/api/v1/statistics"] = []string{"*", "dev"}
+	err = cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "tls.routes /api/v1/statistics: unknown identity \"dev\"\ntls.identities empty: at least one common name or SAN is required", err.Error())
+}
+
+func TestLoad_Identities(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+tls:
+  enable: true
+  cert: cert.pem
+  key: key.pem
+  client_ca: ca.pem
+  identities:
+    ops:
+      common_names: [ops.example.com]
+      sans: ["spiffe://example.com/ops"]
+  routes:
+    /api/v1/webhooks: [ops]
+`)
+
+	cfg, _, err := Load([]string{"-config", path})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]IdentityConfig{
+		"ops": {CommonNames: []string{"ops.example.com"}, SANs: []string{"spiffe://example.com/ops"}},
+	}, cfg.TLS.Identities)
+	assert.Equal(t, map[string][]string{"/api/v1/webhooks": {"ops"}}, cfg.TLS.Routes)
+
+	// structured settings are not available as flags
+	_, _, err = Load([]string{"-config", path, "-tls.routes", "boh"})
+	assert.Error(t, err)
+}
+
+func TestValidate_JWT(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.JWT.JWKS = "https://idp.example.com/jwks.json"
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "auth.jwt.issuer is mandatory if auth.jwt.jwks is provided\nauth.jwt.audience is mandatory if auth.jwt.jwks is provided", err.Error())
+
+	cfg.Auth.JWT.Issuer = "https://idp.example.com"
+	cfg.Auth.JWT.Audience = "fizzbuzz"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Auth.JWT.Scopes = map[string][]string{"analysts": {"statistics:read", "statistics:write"}}
+	err = cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "auth.jwt.scopes analysts: unknown scope \"statistics:write\"", err.Error())
+}
+
+func TestLoad_JWT(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+[auth.jwt]
+jwks = "https://idp.example.com/jwks.json"
+issuer = "https://idp.example.com"
+audience = "fizzbuzz"
+scopes_claim = "groups"
+
+[auth.jwt.scopes]
+analysts = ["statistics:read"]
+`)
+	t.Setenv("FIZZBUZZ_AUTH_JWT_LEEWAY", "5s")
+
+	cfg, _, err := Load([]string{"-config", path, "-auth.jwt.audience", "fizzbuzz-api"})
+	require.NoError(t, err)
+	assert.Equal(t, JWTConfig{
+		JWKS:        "https://idp.example.com/jwks.json",
+		JWKSRefresh: 5 * time.Minute,
+		Issuer:      "https://idp.example.com",
+		Audience:    "fizzbuzz-api",
+		Leeway:      5 * time.Second,
+		ScopesClaim: "groups",
+		Scopes:      map[string][]string{"analysts": {"statistics:read"}},
+	}, cfg.Auth.JWT)
+}
+
+func TestValidate_RateLimit(t *testing.T) {
+	cfg := Default()
+	cfg.Limits.RateLimit.Backend = "boh"
+	cfg.Limits.RateLimit.Rate = 0
+	cfg.Limits.RateLimit.DailyQuota = -1
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "limits.rate_limit.backend \"boh\" is not one of none, memory, redis\nlimits.rate_limit.rate should be positive\nlimits.rate_limit.daily_quota should not be negative", err.Error())
+
+	t.Setenv("FIZZBUZZ_RATE_LIMIT_RATE", "0.5")
+	loaded, _, err := Load([]string{"-limits.rate_limit.backend", "redis"})
+	require.NoError(t, err)
+	assert.Equal(t, "redis", loaded.Limits.RateLimit.Backend)
+	assert.Equal(t, 0.5, loaded.Limits.RateLimit.Rate)
+	assert.Equal(t, []string{"limits.rate_limit.backend"}, Default().RestartRequired(loaded))
+}
+
+func TestValidate_Concurrency(t *testing.T) {
+	cfg := Default()
+	cfg.Limits.Concurrency.MinLimit = 10
+	cfg.Limits.Concurrency.MaxLimit = 5
+	cfg.Limits.Concurrency.QueueTimeout = -time.Second
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "limits.concurrency.max_limit should not be lower than limits.concurrency.min_limit\nlimits.concurrency.queue_timeout should not be negative", err.Error())
+
+	t.Setenv("FIZZBUZZ_CONCURRENCY_LATENCY_TARGET", "100ms")
+	loaded, _, err := Load([]string{"-limits.concurrency.enable", "true"})
+	require.NoError(t, err)
+	assert.True(t, loaded.Limits.Concurrency.Enable)
+	assert.Equal(t, 100*time.Millisecond, loaded.Limits.Concurrency.LatencyTarget)
+	assert.Equal(t, []string{"limits.concurrency.enable"}, Default().RestartRequired(loaded))
+}
+
+func TestValidate_Batch(t *testing.T) {
+	cfg := Default()
+	cfg.Limits.Batch.MaxItems = 0
+	cfg.Limits.Batch.Workers = -1
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "limits.batch.max_items should be positive\nlimits.batch.workers should be positive", err.Error())
+
+	t.Setenv("FIZZBUZZ_BATCH_WORKERS", "16")
+	loaded, _, err := Load([]string{"-limits.batch.max_elements", "1000"})
+	require.NoError(t, err)
+	assert.Equal(t, 16, loaded.Limits.Batch.Workers)
+	assert.Equal(t, 1000, loaded.Limits.Batch.MaxElements)
+	assert.Empty(t, Default().RestartRequired(loaded))
+}
+
+func TestValidate_Jobs(t *testing.T) {
+	cfg := Default()
+	cfg.Jobs.Workers = 0
+	require.NoError(t, cfg.Validate())
+
+	cfg.Jobs.Enable = true
+	cfg.Jobs.Dir = ""
+	cfg.Jobs.MaxActive = -1
+	cfg.Jobs.TTL = 0
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "jobs.dir is mandatory if the jobs are enabled\njobs.workers should be positive\njobs.max_active should not be negative\n"+
+		"jobs.ttl should be positive", err.Error())
+
+	t.Setenv("FIZZBUZZ_JOBS_TTL", "1h")
+	loaded, _, err := Load([]string{"-jobs.enable", "true", "-jobs.dir", "/var/lib/fizzbuzz"})
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/fizzbuzz", loaded.Jobs.Dir)
+	assert.Equal(t, time.Hour, loaded.Jobs.TTL)
+	assert.Equal(t, []string{"jobs.enable", "jobs.dir", "jobs.ttl"}, Default().RestartRequired(loaded))
+}
+
+func TestValidate_Cache(t *testing.T) {
+	cfg := Default()
+	cfg.Cache.Backend = "boh"
+	cfg.Cache.TTL = 0
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "cache.backend \"boh\" is not one of none, memory, redis\ncache.ttl should be positive", err.Error())
+
+	t.Setenv("FIZZBUZZ_CACHE_MAX_BYTES", "1024")
+	loaded, _, err := Load([]string{"-cache.backend", "redis"})
+	require.NoError(t, err)
+	assert.Equal(t, 1024, loaded.Cache.MaxBytes)
+	assert.Equal(t, []string{"cache.backend"}, Default().RestartRequired(loaded))
+}
+
+func TestValidate_Validation(t *testing.T) {
+	cfg := Default()
+	cfg.Validation.MaxSpan = -1
+	cfg.Validation.Characters = "letter, emoji"
+	cfg.Validation.BannedWords = []string{" "}
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "validation.max_span should not be negative\n"+
+		"validation.characters \"emoji\" is not one of letter, mark, digit, punct, symbol, space, control\n"+
+		"validation.banned_words can't include an empty word", err.Error())
+
+	t.Setenv("FIZZBUZZ_VALIDATION_CHARACTERS", "letter,digit")
+	loaded, _, err := Load([]string{"-validation.allow_start_after_limit", "false"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"letter", "digit"}, loaded.Validation.Classes())
+	assert.False(t, loaded.Validation.AllowStartAfterLimit)
+	assert.Empty(t, Default().RestartRequired(loaded))
+}
+
+func TestValidate_Compression(t *testing.T) {
+	cfg := Default()
+	cfg.Compression.GzipLevel = 0
+	cfg.Compression.DeflateLevel = 10
+	cfg.Compression.ZstdLevel = 23
+	cfg.Compression.BrotliLevel = -1
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "compression.gzip_level should be between 1 and 9\ncompression.deflate_level should be between 1 and 9\n"+
+		"compression.zstd_level should be between 1 and 22\ncompression.brotli_level should be between 0 and 11", err.Error())
+
+	t.Setenv("FIZZBUZZ_COMPRESSION_ENABLE", "true")
+	loaded, _, err := Load([]string{"-compression.min_size", "256"})
+	require.NoError(t, err)
+	assert.True(t, loaded.Compression.Enable)
+	assert.Equal(t, 256, loaded.Compression.MinSize)
+	assert.Empty(t, Default().RestartRequired(loaded))
+}
+
+func TestValidate_Docs(t *testing.T) {
+	cfg := Default()
+	assert.False(t, cfg.Docs.Enable)
+	cfg.Docs.ServerURL = "example.com"
+	cfg.Docs.TrustedProxies = "10.0.0.0/8, 192.168.1.1"
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Equal(t, "docs.server_url \"example.com\" is not an absolute http(s) URL\ndocs.trusted_proxies \"192.168.1.1\" is not a CIDR", err.Error())
+
+	t.Setenv("FIZZBUZZ_DOCS_ENABLE", "true")
+	loaded, _, err := Load([]string{"-docs.server_url", "https://fizzbuzz.example.com", "-docs.trusted_proxies", "10.0.0.0/8, ::1/128"})
+	require.NoError(t, err)
+	assert.True(t, loaded.Docs.Enable)
+	assert.Equal(t, []string{"10.0.0.0/8", "::1/128"}, loaded.Docs.Proxies())
+	assert.Equal(t, []string{"docs.enable"}, Default().RestartRequired(loaded))
+}
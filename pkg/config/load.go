@@ -0,0 +1,460 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFileEnvVar = "FIZZBUZZ_CONFIG_FILE"
+	redacted         = "*****"
+)
+
+// Options are the command-line options which are not part of the configuration
+type Options struct {
+	// path of the configuration file, from flag -config or environment variable FIZZBUZZ_CONFIG_FILE
+	File string
+	// the effective configuration should be printed, from flag -print-config
+	PrintConfig bool
+}
+
+// setting is a single leaf of the Config structure
+type setting struct {
+	// dotted path of the file keys, used as flag name
+	path   string
+	env    string
+	usage  string
+	secret bool
+	reload bool
+	value  reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// settings walks the Config structure and returns its leaves
+func settings(cfg *Config) []setting {
+	var walk func(prefix string, v reflect.Value) []setting
+	walk = func(prefix string, v reflect.Value) []setting {
+		res := []setting{}
+		for i := 0; i < v.NumField(); i++ {
+			sf := v.Type().Field(i)
+			path := prefix + strings.Split(sf.Tag.Get("yaml"), ",")[0]
+			if sf.Type.Kind() == reflect.Struct {
+				res = append(res, walk(path+".", v.Field(i))...)
+				continue
+			}
+			res = append(res, setting{
+				path:   path,
+				env:    sf.Tag.Get("env"),
+				usage:  sf.Tag.Get("usage"),
+				secret: sf.Tag.Get("secret") == "true",
+				reload: sf.Tag.Get("reload") == "true",
+				value:  v.Field(i),
+			})
+		}
+		return res
+	}
+	return walk("", reflect.ValueOf(cfg).Elem())
+}
+
+// fileOnly reports if the setting can only be provided by the configuration file
+func (s setting) fileOnly() bool {
+	kind := s.value.Kind()
+	return kind == reflect.Map || kind == reflect.Slice
+}
+
+func (s setting) set(raw string) error {
+	switch {
+	case s.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(raw)
+	case s.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(b)
+	case s.value.Kind() == reflect.Int:
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(i))
+	case s.value.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
+
+// Load returns the validated configuration obtained by merging, in increasing order of precedence, the defaults,
+// the configuration file, the environment variables and the command-line flags in args. Every parsing and
+// validation error is reported in the returned error.
+func Load(args []string) (Config, Options, error) {
+	cfg := Default()
+	options := Options{}
+
+	fs := flag.NewFlagSet("fizzbuzz", flag.ContinueOnError)
+	fs.StringVar(&options.File, "config", "", "path of the configuration file (YAML or TOML), same as "+configFileEnvVar)
+	fs.BoolVar(&options.PrintConfig, "print-config", false, "print the effective configuration, secrets redacted, and exit")
+
+	// flags are applied after the file and the environment variables, so they are only collected here
+	type flagValue struct {
+		setting setting
+		raw     string
+	}
+	flagValues := []flagValue{}
+	all := settings(&cfg)
+	for _, s := range all {
+		s := s
+		// structured settings can only be provided by the configuration file
+		if s.fileOnly() {
+			continue
+		}
+		fs.Func(s.path, s.usage, func(raw string) error {
+			flagValues = append(flagValues, flagValue{setting: s, raw: raw})
+			return nil
+		})
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, options, err
+	}
+	if options.File == "" {
+		options.File = os.Getenv(configFileEnvVar)
+	}
+
+	errs := []error{}
+	if options.File != "" {
+		if err := loadFile(options.File, &cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, s := range all {
+		if s.env == "" || s.fileOnly() {
+			continue
+		}
+		raw, ok := os.LookupEnv(s.env)
+		if !ok {
+			continue
+		}
+		if err := s.set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("error with %s env var: %w", s.env, err))
+		}
+	}
+	for _, fv := range flagValues {
+		if err := fv.setting.set(fv.raw); err != nil {
+			errs = append(errs, fmt.Errorf("error with -%s flag: %w", fv.setting.path, err))
+		}
+	}
+	// the settings which could not be parsed keep their previous value, so the validation is still meaningful
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return Config{}, options, errors.Join(errs...)
+	}
+
+	return cfg, options, nil
+}
+
+// loadFile decodes the file at path onto cfg, based on its extension; unknown keys are reported as errors
+func loadFile(path string, cfg *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading configuration file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("error decoding configuration file: %w", err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(content), cfg)
+		if err != nil {
+			return fmt.Errorf("error decoding configuration file: %w", err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("error decoding configuration file: unknown keys %v", undecoded)
+		}
+	default:
+		return fmt.Errorf("unsupported configuration file extension: %s", path)
+	}
+
+	return nil
+}
+
+// Validate checks every setting and returns all the violations joined in a single error
+func (c Config) Validate() error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Address != "", "server.address can't be empty")
+	check(c.Server.ReadTimeout > 0, "server.read_timeout should be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout should be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout should be positive")
+	check(c.Server.ShutdownDelay >= 0, "server.shutdown_delay can't be negative")
+	check(c.Admin.Address != "", "admin.address can't be empty")
+	check(c.Admin.Address != c.Server.Address, "admin.address should differ from server.address")
+
+	switch c.Log.Level {
+	case "panic", "error", "warn", "info", "debug", "trace":
+	default:
+		check(false, "log.level %q is not one of panic, error, warn, info, debug, trace", c.Log.Level)
+	}
+
+	if _, err := c.TLS.ClientAuth(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.TLS.Enable {
+		check(c.TLS.Cert != "", "tls.cert is mandatory if TLS is enabled")
+		check(c.TLS.Key != "", "tls.key is mandatory if TLS is enabled")
+		// the system CAs would allow any certificate issued by a public CA
+		check(c.TLS.ClientCA != "" || !c.TLS.verifiesClientCert(), "tls.client_ca is mandatory if the client certificates are verified")
+	}
+	if len(c.TLS.Routes) > 0 {
+		check(c.TLS.Enable && c.TLS.verifiesClientCert(), "tls.routes requires TLS enabled and client certificates verified")
+	}
+	for _, prefix := range sortedKeys(c.TLS.Routes) {
+		for _, name := range c.TLS.Routes[prefix] {
+			_, ok := c.TLS.Identities[name]
+			check(ok || name == "*", "tls.routes %s: unknown identity %q", prefix, name)
+		}
+	}
+	for _, name := range sortedKeys(c.TLS.Identities) {
+		identity := c.TLS.Identities[name]
+		check(len(identity.CommonNames)+len(identity.SANs) > 0, "tls.identities %s: at least one common name or SAN is required", name)
+	}
+	check(c.TLS.WatchInterval >= 0, "tls.watch_interval should not be negative")
+
+	check(c.Redis.Address != "", "redis.address can't be empty")
+	check(c.Redis.DB >= 0, "redis.db should not be negative")
+	check((c.Redis.TLSCertificatePath == "") == (c.Redis.TLSKeyPath == ""), "redis.tls_certificate_path and redis.tls_key_path should be provided together")
+
+	check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts should be positive")
+	check(c.Webhooks.Backoff >= 0, "webhooks.backoff should not be negative")
+	check(c.Webhooks.PollInterval > 0, "webhooks.poll_interval should be positive")
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		check(false, "tracing.exporter %q is not one of none, stdout, otlp", c.Tracing.Exporter)
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio should be between 0 and 1")
+
+	check(c.Limits.PaginationMax > 0, "limits.pagination_max should be positive")
+	check(c.Limits.MaxBodyBytes > 0, "limits.max_body_bytes should be positive")
+	switch c.Limits.RateLimit.Backend {
+	case "none", "memory", "redis":
+	default:
+		check(false, "limits.rate_limit.backend %q is not one of none, memory, redis", c.Limits.RateLimit.Backend)
+	}
+	check(c.Limits.RateLimit.Rate > 0, "limits.rate_limit.rate should be positive")
+	check(c.Limits.RateLimit.Burst > 0, "limits.rate_limit.burst should be positive")
+	check(c.Limits.RateLimit.CostElements > 0, "limits.rate_limit.cost_elements should be positive")
+	check(c.Limits.RateLimit.DailyQuota >= 0, "limits.rate_limit.daily_quota should not be negative")
+	check(c.Limits.Concurrency.MinLimit > 0, "limits.concurrency.min_limit should be positive")
+	check(c.Limits.Concurrency.MaxLimit >= c.Limits.Concurrency.MinLimit, "limits.concurrency.max_limit should not be lower than limits.concurrency.min_limit")
+	check(c.Limits.Concurrency.LatencyTarget > 0, "limits.concurrency.latency_target should be positive")
+	check(c.Limits.Concurrency.QueueSize >= 0, "limits.concurrency.queue_size should not be negative")
+	check(c.Limits.Concurrency.QueueTimeout >= 0, "limits.concurrency.queue_timeout should not be negative")
+	check(c.Limits.Batch.MaxItems > 0, "limits.batch.max_items should be positive")
+	check(c.Limits.Batch.MaxElements > 0, "limits.batch.max_elements should be positive")
+	check(c.Limits.Batch.Workers > 0, "limits.batch.workers should be positive")
+
+	switch c.Auth.APIKeys {
+	case "none", "redis":
+	case "file":
+		check(c.Auth.APIKeysFile != "", "auth.api_keys_file is mandatory if auth.api_keys is file")
+	default:
+		check(false, "auth.api_keys %q is not one of none, file, redis", c.Auth.APIKeys)
+	}
+	if c.Auth.JWT.JWKS != "" {
+		check(c.Auth.JWT.Issuer != "", "auth.jwt.issuer is mandatory if auth.jwt.jwks is provided")
+		check(c.Auth.JWT.Audience != "", "auth.jwt.audience is mandatory if auth.jwt.jwks is provided")
+		check(c.Auth.JWT.JWKSRefresh > 0, "auth.jwt.jwks_refresh should be positive")
+		check(c.Auth.JWT.Leeway >= 0, "auth.jwt.leeway should not be negative")
+		check(c.Auth.JWT.ScopesClaim != "", "auth.jwt.scopes_claim can't be empty")
+	}
+	for _, value := range sortedKeys(c.Auth.JWT.Scopes) {
+		for _, scope := range c.Auth.JWT.Scopes[value] {
+			switch scope {
+			case model.ScopeFizzBuzzRead, model.ScopeStatisticsRead, model.ScopeAdmin:
+			default:
+				check(false, "auth.jwt.scopes %s: unknown scope %q", value, scope)
+			}
+		}
+	}
+
+	switch c.Cache.Backend {
+	case "none", "memory", "redis":
+	default:
+		check(false, "cache.backend %q is not one of none, memory, redis", c.Cache.Backend)
+	}
+	check(c.Cache.MaxBytes >= 0, "cache.max_bytes should not be negative")
+	check(c.Cache.TTL > 0, "cache.ttl should be positive")
+	check(c.Cache.MaxAge >= 0, "cache.max_age should not be negative")
+	check(c.Cache.StatisticsMaxAge >= 0, "cache.statistics_max_age should not be negative")
+
+	check(c.Compression.MinSize >= 0, "compression.min_size should not be negative")
+	check(c.Compression.GzipLevel >= 1 && c.Compression.GzipLevel <= 9, "compression.gzip_level should be between 1 and 9")
+	check(c.Compression.DeflateLevel >= 1 && c.Compression.DeflateLevel <= 9, "compression.deflate_level should be between 1 and 9")
+	check(c.Compression.ZstdLevel >= 1 && c.Compression.ZstdLevel <= 22, "compression.zstd_level should be between 1 and 22")
+	check(c.Compression.BrotliLevel >= 0 && c.Compression.BrotliLevel <= 11, "compression.brotli_level should be between 0 and 11")
+
+	check(c.Validation.MaxSpan >= 0, "validation.max_span should not be negative")
+	check(c.Validation.MaxInteger >= 0, "validation.max_integer should not be negative")
+	check(c.Validation.MaxLength >= 0, "validation.max_length should not be negative")
+	for _, class := range c.Validation.Classes() {
+		check(contains(CharacterClasses, class), "validation.characters %q is not one of %s", class, strings.Join(CharacterClasses, ", "))
+	}
+	for _, word := range c.Validation.BannedWords {
+		check(strings.TrimSpace(word) != "", "validation.banned_words can't include an empty word")
+	}
+
+	if c.Jobs.Enable {
+		check(c.Jobs.Dir != "", "jobs.dir is mandatory if the jobs are enabled")
+		check(c.Jobs.Workers > 0, "jobs.workers should be positive")
+		check(c.Jobs.QueueSize > 0, "jobs.queue_size should be positive")
+		check(c.Jobs.MaxElements > 0, "jobs.max_elements should be positive")
+		check(c.Jobs.MaxActive >= 0, "jobs.max_active should not be negative")
+		check(c.Jobs.MaxStoreBytes >= 0, "jobs.max_store_bytes should not be negative")
+		check(c.Jobs.ChunkBytes > 0, "jobs.chunk_bytes should be positive")
+		check(c.Jobs.TTL > 0, "jobs.ttl should be positive")
+		check(c.Jobs.CleanupInterval > 0, "jobs.cleanup_interval should be positive")
+	}
+
+	if c.Docs.ServerURL != "" {
+		u, err := url.Parse(c.Docs.ServerURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "docs.server_url %q is not an absolute http(s) URL", c.Docs.ServerURL)
+	}
+	for _, proxy := range c.Docs.Proxies() {
+		_, err := netip.ParsePrefix(proxy)
+		check(err == nil, "docs.trusted_proxies %q is not a CIDR", proxy)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Classes returns the character classes listed by Characters
+func (vc ValidationConfig) Classes() []string {
+	var res []string
+	for _, class := range strings.Split(vc.Characters, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			res = append(res, class)
+		}
+	}
+	return res
+}
+
+// Proxies returns the CIDRs listed by TrustedProxies
+func (dc DocsConfig) Proxies() []string {
+	var res []string
+	for _, proxy := range strings.Split(dc.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			res = append(res, proxy)
+		}
+	}
+	return res
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RestartRequired returns the paths of the settings which differ between c and next and can't be applied by
+// a configuration reload
+func (c Config) RestartRequired(next Config) []string {
+	res := []string{}
+	nextSettings := settings(&next)
+	for i, s := range settings(&c) {
+		if s.reload {
+			continue
+		}
+		if !reflect.DeepEqual(s.value.Interface(), nextSettings[i].value.Interface()) {
+			res = append(res, s.path)
+		}
+	}
+	return res
+}
+
+// Reloaded returns a copy of c where the settings which can be applied by a configuration reload are taken
+// from next
+func (c Config) Reloaded(next Config) Config {
+	nextSettings := settings(&next)
+	for i, s := range settings(&c) {
+		if s.reload {
+			s.value.Set(nextSettings[i].value)
+		}
+	}
+	return c
+}
+
+// Redacted returns a copy of the configuration where every non-empty secret is replaced by a placeholder
+func (c Config) Redacted() Config {
+	for _, s := range settings(&c) {
+		if s.secret && s.value.Kind() == reflect.String && s.value.String() != "" {
+			s.value.SetString(redacted)
+		}
+	}
+	return c
+}
+
+// Print writes the effective configuration, secrets redacted, in YAML format
+func (c Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return fmt.Errorf("error encoding configuration: %w", err)
+	}
+	return encoder.Close()
+}
+
+// sortedKeys returns the keys of m in increasing order, so that the errors are reported in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
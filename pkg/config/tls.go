@@ -0,0 +1,42 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// clientAuthTypes are the symbolic names of the client authentication types
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"no_client_cert":                 tls.NoClientCert,
+	"request_client_cert":            tls.RequestClientCert,
+	"require_any_client_cert":        tls.RequireAnyClientCert,
+	"verify_client_cert_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify_client_cert": tls.RequireAndVerifyClientCert,
+}
+
+// ClientAuth returns the tls.ClientAuthType named by ClientAuthType. For backward compatibility, the numeric
+// values of tls.ClientAuthType are accepted as well
+func (c TLSConfig) ClientAuth() (tls.ClientAuthType, error) {
+	if clientAuth, ok := clientAuthTypes[strings.ToLower(c.ClientAuthType)]; ok {
+		return clientAuth, nil
+	}
+	if i, err := strconv.Atoi(c.ClientAuthType); err == nil && i >= int(tls.NoClientCert) && i <= int(tls.RequireAndVerifyClientCert) {
+		return tls.ClientAuthType(i), nil
+	}
+
+	names := make([]string, 0, len(clientAuthTypes))
+	for name := range clientAuthTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return tls.NoClientCert, fmt.Errorf("tls.client_auth_type %q is not one of %s", c.ClientAuthType, strings.Join(names, ", "))
+}
+
+// verifiesClientCert reports if the client certificates are verified against ClientCA
+func (c TLSConfig) verifiesClientCert() bool {
+	clientAuth, err := c.ClientAuth()
+	return err == nil && clientAuth >= tls.VerifyClientCertIfGiven
+}
@@ -0,0 +1,168 @@
+// explorer of the api: lists the operations of the OpenAPI document served by the api and sends requests to them
+(function () {
+  'use strict';
+
+  const methods = ['get', 'put', 'post', 'delete', 'patch'];
+  // example values of the scalar types without example, strings excepted
+  const zeros = { integer: 0, number: 0, boolean: false };
+  const operations = document.getElementById('operations');
+  const credentials = document.getElementById('credentials');
+
+  // resolve returns the object referenced by a local $ref of the document, obj itself otherwise
+  function resolve(doc, obj) {
+    while (obj && obj.$ref) {
+      obj = obj.$ref.replace(/^#\//, '').split('/').reduce(function (node, token) {
+        return node[token.replace(/~1/g, '/').replace(/~0/g, '~')];
+      }, doc);
+    }
+    return obj;
+  }
+
+  // baseURL returns the base URL of the servers of a path item, the ones of the document by default
+  function baseURL(doc, item) {
+    const servers = (item.servers && item.servers.length ? item.servers : doc.servers) || [];
+    return servers.length ? servers[0].url.replace(/\/$/, '') : '';
+  }
+
+  function example(doc, schema) {
+    schema = resolve(doc, schema);
+    if (!schema) {
+      return null;
+    }
+    if (schema.example !== undefined) {
+      return schema.example;
+    }
+    if (schema.type === 'object') {
+      const obj = {};
+      Object.keys(schema.properties || {}).forEach(function (name) {
+        const property = resolve(doc, schema.properties[name]);
+        if (!property.readOnly) {
+          obj[name] = example(doc, property);
+        }
+      });
+      return obj;
+    }
+    if (schema.type === 'array') {
+      return [example(doc, schema.items)];
+    }
+    if (schema.enum) {
+      return schema.enum[0];
+    }
+    return schema.type in zeros ? zeros[schema.type] : '';
+  }
+
+  function render(doc, path, item, method, op) {
+    const node = document.getElementById('operation').content.firstElementChild.cloneNode(true);
+    node.querySelector('.method').textContent = method.toUpperCase();
+    node.querySelector('.method').classList.add(method);
+    node.querySelector('.path').textContent = baseURL(doc, item) + path;
+    node.querySelector('.description').textContent = op.description || '';
+
+    const parameters = (op.parameters || []).concat(item.parameters || []).map(function (p) {
+      return resolve(doc, p);
+    });
+    const container = node.querySelector('.parameters');
+    parameters.forEach(function (p) {
+      const label = document.createElement('label');
+      const name = document.createElement('span');
+      name.textContent = p.name + ' (' + p.in + ')';
+      if (p.required) {
+        name.classList.add('required');
+      }
+      const input = document.createElement('input');
+      input.name = p.in + ':' + p.name;
+      input.placeholder = (resolve(doc, p.schema) || {}).type || 'string';
+      label.append(name, input);
+      container.append(label);
+    });
+
+    const body = node.querySelector('textarea');
+    const requestBody = resolve(doc, op.requestBody);
+    if (requestBody && requestBody.content && requestBody.content['application/json']) {
+      body.value = JSON.stringify(example(doc, requestBody.content['application/json'].schema), null, 2);
+    } else {
+      node.querySelector('label.body').hidden = true;
+    }
+
+    node.querySelector('form').addEventListener('submit', function (event) {
+      event.preventDefault();
+      send(node, baseURL(doc, item), path, method, parameters, requestBody ? body.value : null);
+    });
+    return node;
+  }
+
+  function send(node, base, path, method, parameters, body) {
+    const form = node.querySelector('form');
+    const query = new URLSearchParams();
+    const headers = new Headers();
+    parameters.forEach(function (p) {
+      const value = form.elements[p.in + ':' + p.name].value;
+      if (value === '') {
+        return;
+      }
+      if (p.in === 'path') {
+        path = path.replace('{' + p.name + '}', encodeURIComponent(value));
+      } else if (p.in === 'query') {
+        query.append(p.name, value);
+      } else if (p.in === 'header') {
+        headers.set(p.name, value);
+      }
+    });
+    if (credentials.elements.apiKey.value) {
+      headers.set('X-Api-Key', credentials.elements.apiKey.value);
+    }
+    if (credentials.elements.token.value) {
+      headers.set('Authorization', 'Bearer ' + credentials.elements.token.value);
+    }
+    if (credentials.elements.language.value) {
+      headers.set('Accept-Language', credentials.elements.language.value);
+    }
+    if (body !== null) {
+      headers.set('Content-Type', 'application/json');
+    }
+
+    const url = base + path + (query.toString() ? '?' + query.toString() : '');
+    const response = node.querySelector('.response');
+    fetch(url, { method: method.toUpperCase(), headers: headers, body: body }).then(function (resp) {
+      return resp.text().then(function (text) {
+        response.hidden = false;
+        response.querySelector('.status').textContent = resp.status + ' ' + resp.statusText + ' - ' + method.toUpperCase() + ' ' + url;
+        const lines = [];
+        resp.headers.forEach(function (value, name) {
+          lines.push(name + ': ' + value);
+        });
+        response.querySelector('.headers').textContent = lines.join('\n');
+        try {
+          text = JSON.stringify(JSON.parse(text), null, 2);
+        } catch (e) {
+          // not JSON, shown as is
+        }
+        response.querySelector('.payload').textContent = text;
+      });
+    }).catch(function (err) {
+      response.hidden = false;
+      response.querySelector('.status').textContent = 'request failed: ' + err.message;
+    });
+  }
+
+  fetch('../api/v1/openapi.json').then(function (resp) {
+    if (!resp.ok) {
+      throw new Error(resp.status + ' ' + resp.statusText);
+    }
+    return resp.json();
+  }).then(function (doc) {
+    document.getElementById('title').textContent = doc.info.title + ' ' + doc.info.version;
+    document.getElementById('description').textContent = doc.info.description || '';
+    operations.textContent = '';
+    Object.keys(doc.paths).sort().forEach(function (path) {
+      const item = doc.paths[path];
+      methods.forEach(function (method) {
+        if (item[method]) {
+          operations.append(render(doc, path, item, method, item[method]));
+        }
+      });
+    });
+  }).catch(function (err) {
+    operations.textContent = 'error loading the OpenAPI document: ' + err.message;
+  });
+})();
@@ -0,0 +1,26 @@
+// Package docs serves the interactive explorer of the api, a static page built from the OpenAPI document served by
+// the api; its assets are embedded so that no CDN is involved
+package docs
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// ContentSecurityPolicy restricts the explorer to its own assets and to requests to the api
+const ContentSecurityPolicy = "default-src 'self'; img-src 'self' data:; frame-ancestors 'none'"
+
+// Handler returns the handler of the explorer assets, to be mounted under prefix, e.g. /docs/
+func Handler(prefix string) http.Handler {
+	root, _ := fs.Sub(assets, "assets")
+	files := http.StripPrefix(prefix, http.FileServer(http.FS(root)))
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(rw, r)
+	})
+}
@@ -1,6 +1,7 @@
 package fizzbuzz
 
 import (
+	"math"
 	"strconv"
 
 	"github.com/peano88/fizzbuzz-rest/pkg/model"
@@ -12,18 +13,44 @@ import (
 // is a multiple of int1 and/or int2
 func Fizzbuzz(input model.FizzBuzzInput) []string {
 	result := []string{}
-	for i := input.Start; i <= input.Limit; i++ {
-		output := ""
-		if i%input.Int1 == 0 {
-			output += input.Str1
-		}
-		if i%input.Int2 == 0 {
-			output += input.Str2
-		}
-		if output == "" {
-			output = strconv.Itoa(i)
+	if input.Limit < input.Start {
+		return result
+	}
+	// the loop stops on limit, i++ would wrap after math.MaxInt
+	for i := input.Start; ; i++ {
+		result = append(result, Element(input, i))
+		if i == input.Limit {
+			return result
 		}
-		result = append(result, output)
 	}
-	return result
+}
+
+// Length returns the number of elements of the sequence of input. The sequence of every int, one element longer
+// than representable, has a length of math.MaxUint64
+func Length(input model.FizzBuzzInput) uint64 {
+	if input.Limit < input.Start {
+		return 0
+	}
+	// the difference can't overflow as unsigned, unlike the signed one when start is far below 0
+	span := uint64(input.Limit) - uint64(input.Start)
+	if span == math.MaxUint64 {
+		return span
+	}
+	return span + 1
+}
+
+// Element returns the element of the sequence of input for the number i: input.str1 and/or input.str2 if i is a
+// multiple of input.int1 and/or input.int2, i itself otherwise
+func Element(input model.FizzBuzzInput, i int) string {
+	output := ""
+	if i%input.Int1 == 0 {
+		output += input.Str1
+	}
+	if i%input.Int2 == 0 {
+		output += input.Str2
+	}
+	if output == "" {
+		output = strconv.Itoa(i)
+	}
+	return output
 }
@@ -1,12 +1,24 @@
 package fizzbuzz
 
 import (
+	"math"
 	"testing"
 
 	"github.com/peano88/fizzbuzz-rest/pkg/model"
 	"github.com/stretchr/testify/assert"
 )
 
+func TestLength(t *testing.T) {
+	input := model.FizzBuzzInput{FizzBuzzInputStats: model.FizzBuzzInputStats{Limit: 7}, Start: 1}
+	assert.Equal(t, uint64(7), Length(input))
+	input.Start = 8
+	assert.Equal(t, uint64(0), Length(input))
+	input.Start, input.Limit = math.MinInt, 0
+	assert.Equal(t, uint64(math.MaxInt64)+2, Length(input))
+	input.Limit = math.MaxInt
+	assert.Equal(t, uint64(math.MaxUint64), Length(input))
+}
+
 func TestFizzBuzz(t *testing.T) {
 
 	buildInput := func(n, m, limit int, fizz, buzz string, start int) model.FizzBuzzInput {
@@ -32,6 +44,7 @@ func TestFizzBuzz(t *testing.T) {
 		{"not reachable buzz", buildInput(3, 7, 6, "fuzz", "buzz", 1), []string{"1", "2", "fuzz", "4", "5", "fuzz"}},
 		{"no fizz", buildInput(6, 7, 5, "fizz", "buzz", 1), []string{"1", "2", "3", "4", "5"}},
 		{"empty", buildInput(2, 3, 0, "fizz", "buzz", 1), []string{}},
+		{"largest", buildInput(2, 3, math.MaxInt, "f", "b", math.MaxInt-2), []string{"9223372036854775805", "fb", "9223372036854775807"}},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,122 @@
+package fizzbuzz
+
+import (
+	"math"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+)
+
+// Text returns the plain-text representation of the sequence of input, each element followed by a line feed
+func Text(input model.FizzBuzzInput) []byte {
+	if input.Limit < input.Start {
+		return []byte{}
+	}
+	text := make([]byte, 0, TextLength(input))
+	for i := input.Start; ; i++ {
+		text = append(text, Element(input, i)...)
+		text = append(text, '\n')
+		if i == input.Limit {
+			return text
+		}
+	}
+}
+
+// TextLength returns the length in bytes of the plain-text representation of the sequence of input (see Text),
+// computed without generating the sequence. The representation is expected to be short enough for its length to be
+// an int64
+func TextLength(input model.FizzBuzzInput) int64 {
+	if input.Limit < input.Start {
+		return 0
+	}
+	a, b := input.Start, input.Limit
+
+	// the numbers are split between the multiples of both int1 and int2, of int1 only, of int2 only and of none
+	both, bothDigits := int64(0), int64(0)
+	if l, ok := lcm(input.Int1, input.Int2); ok {
+		both, bothDigits = multiples(l, a, b), digits(l, a, b)
+	} else if a <= 0 && b >= 0 {
+		// 0 is the only representable multiple of both
+		both, bothDigits = 1, 1
+	}
+	only1, only1Digits := multiples(input.Int1, a, b)-both, digits(input.Int1, a, b)-bothDigits
+	only2, only2Digits := multiples(input.Int2, a, b)-both, digits(input.Int2, a, b)-bothDigits
+	noneDigits := digits(1, a, b) - bothDigits - only1Digits - only2Digits
+
+	// an element replaced by empty strings is the number itself
+	replaced := func(n, numberDigits int64, replacement string) int64 {
+		if replacement == "" {
+			return numberDigits
+		}
+		return n * int64(len(replacement))
+	}
+	// one line feed for each element
+	return multiples(1, a, b) + noneDigits +
+		replaced(both, bothDigits, input.Str1+input.Str2) +
+		replaced(only1, only1Digits, input.Str1) +
+		replaced(only2, only2Digits, input.Str2)
+}
+
+// lcm returns the least common multiple of the positive a and b, false if it overflows an int
+func lcm(a, b int) (int, bool) {
+	x, y := a, b
+	for y != 0 {
+		x, y = y, x%y
+	}
+	if a/x > math.MaxInt/b {
+		return 0, false
+	}
+	return a / x * b, true
+}
+
+// multiples returns the number of multiples of the positive k in [a, b]
+func multiples(k, a, b int) int64 {
+	if b < a {
+		return 0
+	}
+	// the difference is exact modulo 2^64
+	return int64(uint64(floorDiv(b, k)) - uint64(ceilDiv(a, k)) + 1)
+}
+
+// digits returns the number of characters of the decimal representations of the multiples of the positive k in
+// [a, b], the sign of the negative ones included
+func digits(k, a, b int) int64 {
+	total := int64(0)
+	if a <= 0 && b >= 0 {
+		total++
+	}
+	// the numbers of d digits are in [lo, hi] and, when negative, in [-hi, -lo]
+	lo := 1
+	for d := int64(1); ; d++ {
+		hi := math.MaxInt
+		if lo <= math.MaxInt/10 {
+			hi = lo*10 - 1
+		}
+		negativeLo := -hi
+		if hi == math.MaxInt {
+			negativeLo = math.MinInt
+		}
+		total += d*multiples(k, max(a, lo), min(b, hi)) + (d+1)*multiples(k, max(a, negativeLo), min(b, -lo))
+		if hi == math.MaxInt {
+			return total
+		}
+		lo = hi + 1
+	}
+}
+
+// floorDiv returns x/k rounded towards negative infinity, for a positive k
+func floorDiv(x, k int) int {
+	q := x / k
+	if x%k != 0 && x < 0 {
+		q--
+	}
+	return q
+}
+
+// ceilDiv returns x/k rounded towards positive infinity, for a positive k
+func ceilDiv(x, k int) int {
+	q := x / k
+	if x%k != 0 && x > 0 {
+		q++
+	}
+	return q
+}
@@ -0,0 +1,57 @@
+package fizzbuzz
+
+import (
+	"math"
+	"testing"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestText(t *testing.T) {
+	input := model.FizzBuzzInput{
+		FizzBuzzInputStats: model.FizzBuzzInputStats{Int1: 2, Int2: 3, Limit: 7, Str1: "fizz", Str2: "buzz"},
+		Start:              1,
+	}
+	assert.Equal(t, "1\nfizz\nbuzz\nfizz\n5\nfizzbuzz\n7\n", string(Text(input)))
+
+	input.Start = 8
+	assert.Equal(t, "", string(Text(input)))
+}
+
+func TestTextLength(t *testing.T) {
+	buildInput := func(n, m, start, limit int, fizz, buzz string) model.FizzBuzzInput {
+		return model.FizzBuzzInput{
+			FizzBuzzInputStats: model.FizzBuzzInputStats{
+				Int1:  n,
+				Int2:  m,
+				Limit: limit,
+				Str1:  fizz,
+				Str2:  buzz,
+			},
+			Start: start,
+		}
+	}
+
+	tests := []struct {
+		label string
+		input model.FizzBuzzInput
+	}{
+		{"normal case", buildInput(3, 5, 1, 100, "fizz", "buzz")},
+		{"many digits", buildInput(7, 11, 1, 12345, "fizz", "buzz")},
+		{"negative", buildInput(3, 5, -1234, 57, "fizz", "buzz")},
+		{"same ints", buildInput(4, 4, -20, 20, "fizz", "buzz")},
+		{"empty strings", buildInput(2, 3, -50, 50, "", "bé")},
+		{"no multiple", buildInput(1000, 2000, 1, 999, "fizz", "buzz")},
+		{"empty", buildInput(2, 3, 10, 9, "fizz", "buzz")},
+		{"largest", buildInput(3, 5, math.MaxInt-1000, math.MaxInt, "fizz", "buzz")},
+		{"smallest", buildInput(3, 5, math.MinInt, math.MinInt+1000, "fizz", "buzz")},
+		{"overflowing lcm", buildInput(math.MaxInt, math.MaxInt-1, -1000, 1000, "fizz", "buzz")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			assert.Equal(t, int64(len(Text(tt.input))), TextLength(tt.input))
+		})
+	}
+}
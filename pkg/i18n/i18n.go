@@ -0,0 +1,209 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// English is the language of the reference catalog, the last of every fallback chain
+	English = "en"
+)
+
+//go:embed catalogs/*.json
+var catalogFiles embed.FS
+
+// catalogs maps the supported languages to their messages, by ID
+var catalogs = loadCatalogs()
+
+// loadCatalogs reads the embedded catalogs, one JSON object of templates by message ID per language
+func loadCatalogs() map[string]map[string]string {
+	entries, err := catalogFiles.ReadDir("catalogs")
+	if err != nil {
+		panic(err)
+	}
+	res := map[string]map[string]string{}
+	for _, entry := range entries {
+		content, err := catalogFiles.ReadFile(path.Join("catalogs", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(content, &catalog); err != nil {
+			panic(fmt.Errorf("invalid catalog %s: %w", entry.Name(), err))
+		}
+		res[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+	return res
+}
+
+// Supported returns the languages having a catalog, sorted
+func Supported() []string {
+	res := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		res = append(res, lang)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Message is a localizable message: the ID of its template in the catalogs and the values of the template
+// parameters, written {name} in the templates. A value is rendered with fmt.Sprint, unless it is a Message or an
+// Error, which are localized as well
+type Message struct {
+	ID   string
+	Args map[string]any
+}
+
+// New returns the Message id, whose parameters are provided as name, value pairs
+func New(id string, args ...any) Message {
+	msg := Message{ID: id}
+	if len(args) > 0 {
+		msg.Args = make(map[string]any, len(args)/2)
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		msg.Args[fmt.Sprint(args[i])] = args[i+1]
+	}
+	return msg
+}
+
+// String returns the English rendering of m
+func (m Message) String() string {
+	return Languages(nil).Localize(m)
+}
+
+// Error is an error whose message is localizable
+type Error struct {
+	Message Message
+}
+
+// NewError returns the Error of the Message id, whose parameters are provided as name, value pairs
+func NewError(id string, args ...any) error {
+	return Error{Message: New(id, args...)}
+}
+
+// Error is the error interface implementation, in English
+func (e Error) Error() string {
+	return e.Message.String()
+}
+
+// Languages is a fallback chain of supported languages, the preferred first. English is implicitly the last one
+type Languages []string
+
+// Negotiate returns the supported languages accepted by an Accept-Language header, by decreasing quality. A regional
+// tag (e.g. fr-CH) falls back to its base language; the languages with a zero quality are excluded
+func Negotiate(acceptLanguage string) Languages {
+	type accepted struct {
+		tag string
+		q   float64
+	}
+	var tags []accepted
+	for _, element := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(element, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, accepted{tag: tag, q: q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	var res Languages
+	add := func(lang string) {
+		if _, ok := catalogs[lang]; !ok {
+			return
+		}
+		for _, l := range res {
+			if l == lang {
+				return
+			}
+		}
+		res = append(res, lang)
+	}
+	for _, t := range tags {
+		add(t.tag)
+		if base, _, ok := strings.Cut(t.tag, "-"); ok {
+			add(base)
+		}
+	}
+	return res
+}
+
+// Language returns the language of the messages localized by l, i.e. its first language, English if empty
+func (l Languages) Language() string {
+	if len(l) == 0 {
+		return English
+	}
+	return l[0]
+}
+
+// Localize returns msg in the first language of l whose catalog has it, in English otherwise. The ID of msg is
+// returned if no catalog has it
+func (l Languages) Localize(msg Message) string {
+	for _, lang := range append(l[:len(l):len(l)], English) {
+		if template, ok := catalogs[lang][msg.ID]; ok {
+			return l.interpolate(template, msg.Args)
+		}
+	}
+	return msg.ID
+}
+
+// interpolate replaces the {name} parameters of template by their value in args; the unknown parameters are kept
+func (l Languages) interpolate(template string, args map[string]any) string {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		sb.WriteString(template[:start])
+		if value, ok := args[template[start+1:end]]; ok {
+			sb.WriteString(l.render(value))
+		} else {
+			sb.WriteString(template[start : end+1])
+		}
+		template = template[end+1:]
+	}
+	sb.WriteString(template)
+	return sb.String()
+}
+
+// render returns the localized value of a parameter
+func (l Languages) render(value any) string {
+	switch v := value.(type) {
+	case Message:
+		return l.Localize(v)
+	case Error:
+		return l.Localize(v.Message)
+	}
+	return fmt.Sprint(value)
+}
+
+// LocalizeError returns the message of err, localized if err is an Error
+func (l Languages) LocalizeError(err error) string {
+	if e, ok := err.(Error); ok {
+		return l.Localize(e.Message)
+	}
+	return err.Error()
+}
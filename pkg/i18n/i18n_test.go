@@ -0,0 +1,71 @@
+package i18n
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalogs(t *testing.T) {
+	assert.Equal(t, []string{"de", "en", "fr", "it"}, Supported())
+
+	// every catalog translates every message of the reference catalog, and only them
+	for _, lang := range Supported() {
+		assert.Len(t, catalogs[lang], len(catalogs[English]), lang)
+		for id := range catalogs[English] {
+			assert.Contains(t, catalogs[lang], id, lang)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           Languages
+	}{
+		{"", nil},
+		{"*", nil},
+		{"fr", Languages{"fr"}},
+		{"fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7", Languages{"fr", "en", "de"}},
+		{"de;q=0.5, it", Languages{"it", "de"}},
+		{"es, IT-ch;q=0.3", Languages{"it"}},
+		{"fr;q=0, de", Languages{"de"}},
+		{"fr;q=high, de", Languages{"de"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Negotiate(tt.acceptLanguage), tt.acceptLanguage)
+	}
+	assert.Equal(t, English, Negotiate("es").Language())
+	assert.Equal(t, "it", Negotiate("es, it").Language())
+}
+
+func TestLocalize(t *testing.T) {
+	missing := NewError("validation.missing", "param", "int1")
+	assert.Equal(t, "missing mandatory parameter: int1", missing.Error())
+
+	// nested messages and errors are localized in the same languages
+	msg := New("validation.error", "error", missing)
+	assert.Equal(t, "validation error on parameter: missing mandatory parameter: int1", msg.String())
+	assert.Equal(t, "Validierungsfehler bei Parameter: fehlender Pflichtparameter: int1", Languages{"de"}.Localize(msg))
+	assert.Equal(t, "errore di validazione del parametro: boom", Languages{"it"}.Localize(New("validation.error", "error", errors.New("boom"))))
+	assert.Equal(t, "fehlender Pflichtparameter: int1", Languages{"de"}.LocalizeError(missing))
+	assert.Equal(t, "boom", Languages{"de"}.LocalizeError(fmt.Errorf("boom")))
+
+	// the unknown parameters are kept, the unknown messages are rendered as their ID
+	assert.Equal(t, "{param} : 7 n'est pas un entier", Languages{"fr"}.Localize(New("validation.not_integer", "value", 7)))
+	assert.Equal(t, "no.such.message", Languages{"fr"}.Localize(New("no.such.message")))
+}
+
+func TestLocalize_Fallback(t *testing.T) {
+	catalogs["xx"] = map[string]string{"error.internal": "xx internal"}
+	defer delete(catalogs, "xx")
+
+	langs := Languages{"xx", "fr"}
+	assert.Equal(t, "xx internal", langs.Localize(New("error.internal")))
+	// missing in the first catalog
+	assert.Equal(t, "délai de la requête dépassé", langs.Localize(New("error.timeout")))
+	// missing in every catalog but English
+	assert.Equal(t, "request canceled", Languages{"xx"}.Localize(New("error.canceled")))
+}
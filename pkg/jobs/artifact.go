@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// chunkWriter writes the result of a job as a sequence of chunks of size uncompressed bytes, the last one excepted,
+// each compressed in its own file. onChunk is called with the uncompressed size of each completed chunk
+type chunkWriter struct {
+	store   *FileStore
+	id      string
+	size    int64
+	onChunk func(size int64) error
+
+	// number of completed chunks
+	chunks int
+	// current chunk, nil if none
+	file    *os.File
+	gz      *gzip.Writer
+	written int64
+}
+
+// Write is the io.Writer interface implementation
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if cw.gz == nil {
+			file, err := cw.store.createChunk(cw.id, cw.chunks)
+			if err != nil {
+				return total, fmt.Errorf("error creating chunk: %w", err)
+			}
+			cw.file = file
+			cw.gz = gzip.NewWriter(file)
+			cw.written = 0
+		}
+		part := p
+		if remaining := cw.size - cw.written; int64(len(part)) > remaining {
+			part = part[:remaining]
+		}
+		n, err := cw.gz.Write(part)
+		cw.written += int64(n)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+		if cw.written == cw.size {
+			if err := cw.complete(); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// complete closes the current chunk, if any
+func (cw *chunkWriter) complete() error {
+	if cw.gz == nil {
+		return nil
+	}
+	err := cw.gz.Close()
+	if storedErr := cw.store.chunkStored(cw.file); err == nil {
+		err = storedErr
+	}
+	if closeErr := cw.file.Close(); err == nil {
+		err = closeErr
+	}
+	cw.gz, cw.file = nil, nil
+	if err != nil {
+		return fmt.Errorf("error writing chunk: %w", err)
+	}
+	cw.chunks++
+	return cw.onChunk(cw.written)
+}
+
+// Close completes the last chunk
+func (cw *chunkWriter) Close() error {
+	return cw.complete()
+}
+
+// artifact is an io.ReadSeekCloser over the result of a job, decompressing its chunks as they are read. A Seek only
+// decompresses the beginning of the chunk holding the new offset, so that a range of the result is read quickly
+type artifact struct {
+	store  *FileStore
+	id     string
+	chunks []int64
+	size   int64
+	// offset of the next Read
+	offset int64
+
+	// chunk being read, nil if none
+	file *os.File
+	gz   *gzip.Reader
+	// offsets in the result of the next byte of gz and of the end of its chunk
+	pos, end int64
+}
+
+// newArtifact returns the artifact of the result of the job id, made of chunks of the provided uncompressed sizes
+func newArtifact(store *FileStore, id string, chunks []int64) *artifact {
+	a := &artifact{store: store, id: id, chunks: chunks}
+	for _, size := range chunks {
+		a.size += size
+	}
+	return a
+}
+
+// Read is the io.Reader interface implementation
+func (a *artifact) Read(p []byte) (int, error) {
+	if a.offset >= a.size {
+		return 0, io.EOF
+	}
+	if a.gz == nil || a.pos != a.offset || a.pos == a.end {
+		if err := a.open(); err != nil {
+			return 0, err
+		}
+	}
+	if remaining := a.end - a.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := a.gz.Read(p)
+	a.pos += int64(n)
+	a.offset += int64(n)
+	if errors.Is(err, io.EOF) {
+		if a.pos < a.end {
+			return n, fmt.Errorf("chunk of job %s shorter than expected: %w", a.id, io.ErrUnexpectedEOF)
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// open opens the chunk holding the offset, positioned at the offset
+func (a *artifact) open() error {
+	a.closeChunk()
+	start := int64(0)
+	n := 0
+	for ; start+a.chunks[n] <= a.offset; n++ {
+		start += a.chunks[n]
+	}
+	file, err := a.store.openChunk(a.id, n)
+	if err != nil {
+		return fmt.Errorf("error opening chunk: %w", err)
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading chunk: %w", err)
+	}
+	a.file, a.gz = file, gz
+	a.pos, a.end = start, start+a.chunks[n]
+	skipped, err := io.CopyN(io.Discard, gz, a.offset-start)
+	a.pos += skipped
+	if err != nil {
+		return fmt.Errorf("error reading chunk: %w", err)
+	}
+	return nil
+}
+
+// Seek is the io.Seeker interface implementation
+func (a *artifact) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += a.offset
+	case io.SeekEnd:
+		offset += a.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	a.offset = offset
+	return offset, nil
+}
+
+// closeChunk closes the chunk being read, if any
+func (a *artifact) closeChunk() {
+	if a.file != nil {
+		a.file.Close()
+	}
+	a.file, a.gz = nil, nil
+}
+
+// Close is the io.Closer interface implementation
+func (a *artifact) Close() error {
+	a.closeChunk()
+	return nil
+}
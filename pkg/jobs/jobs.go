@@ -0,0 +1,432 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/fizzbuzz"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+)
+
+// size of the buffer of the encoded elements, written to the result at once
+const bufferBytes = 64 << 10
+
+// QueueFull indicates that a job can't be submitted because too many jobs are waiting to be processed
+type QueueFull struct{}
+
+// Error is the error interface implementation
+func (q QueueFull) Error() string {
+	return "jobs queue full"
+}
+
+// TooLarge indicates that the sequence of a job has more elements than allowed
+type TooLarge struct {
+	max int
+}
+
+// Error is the error interface implementation
+func (tl TooLarge) Error() string {
+	return fmt.Sprintf("the sequence of a job should not have more than %d elements", tl.max)
+}
+
+// Max returns the maximum number of elements of the sequence of a job
+func (tl TooLarge) Max() int {
+	return tl.max
+}
+
+// TooManyActive indicates that a job can't be submitted because its client has too many jobs pending or running
+type TooManyActive struct {
+	max int
+}
+
+// Error is the error interface implementation
+func (tma TooManyActive) Error() string {
+	return fmt.Sprintf("a client should not have more than %d pending or running jobs", tma.max)
+}
+
+// Max returns the maximum number of pending or running jobs of a client
+func (tma TooManyActive) Max() int {
+	return tma.max
+}
+
+// StoreFull indicates that the results stored have reached their size budget: no job can be submitted until some
+// expire, and a running job exceeding the budget fails
+type StoreFull struct{}
+
+// Error is the error interface implementation
+func (sf StoreFull) Error() string {
+	return "jobs store full"
+}
+
+// NotCompleted indicates that the result of a job is not available yet, or never will be if the job failed
+type NotCompleted struct {
+	status string
+}
+
+// Error is the error interface implementation
+func (nc NotCompleted) Error() string {
+	return "job " + nc.status
+}
+
+// Status returns the status of the job
+func (nc NotCompleted) Status() string {
+	return nc.status
+}
+
+func newID() (string, error) {
+	b := make([]byte, idLength/2)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Manager generates the fizzbuzz sequences of the submitted jobs in the background. The jobs and their results are
+// persisted in a FileStore, so that they survive a restart: the jobs interrupted by a restart are processed again
+// from the beginning. The result of a job is the encoded model.FizzBuzzOutput of its whole sequence, written as
+// chunks compressed separately; the progress of a job is saved along with each chunk. A job and its result are
+// removed once expired, a TTL after being finished. The pending and running jobs of each client are bounded, as well
+// as the size of the stored results
+type Manager struct {
+	store *FileStore
+	cfg   config.JobsConfig
+	queue chan string
+	// identifiers of the jobs to be processed again, found by NewManager
+	recovered []string
+
+	mu sync.Mutex
+	// number of pending or running jobs of each client
+	active map[string]int
+}
+
+// NewManager returns a Manager persisting the jobs in cfg.Dir. The expired jobs are removed and the jobs not
+// finished are queued again, to be processed by Run
+func NewManager(cfg config.JobsConfig) (*Manager, error) {
+	store, err := NewFileStore(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		store:  store,
+		cfg:    cfg,
+		queue:  make(chan string, cfg.QueueSize),
+		active: map[string]int{},
+	}
+	if err := m.Cleanup(time.Now()); err != nil {
+		return nil, err
+	}
+	recs, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range recs {
+		if rec.Job.Status == model.JobStatusPending || rec.Job.Status == model.JobStatusRunning {
+			m.recovered = append(m.recovered, rec.Job.ID)
+			m.active[rec.Client]++
+		}
+	}
+	return m, nil
+}
+
+// elements returns the number of elements of the sequence of input, false if it has more than max elements
+func elements(input model.FizzBuzzInput, max int) (int64, bool) {
+	length := fizzbuzz.Length(input)
+	if length > uint64(max) {
+		return 0, false
+	}
+	return int64(length), true
+}
+
+// Submit stores a job of client generating the sequence of input, which is expected to be already validated, and
+// queues it. TooLarge is returned if the sequence has more than cfg.MaxElements elements, TooManyActive if client has
+// already cfg.MaxActive jobs pending or running, StoreFull if the stored results have reached cfg.MaxStoreBytes and
+// QueueFull if cfg.QueueSize jobs are already waiting
+func (m *Manager) Submit(ctx context.Context, client string, input model.FizzBuzzInput) (model.Job, error) {
+	count, ok := elements(input, m.cfg.MaxElements)
+	if !ok {
+		return model.Job{}, TooLarge{max: m.cfg.MaxElements}
+	}
+	if m.storeFull() {
+		return model.Job{}, StoreFull{}
+	}
+	if !m.acquire(client) {
+		return model.Job{}, TooManyActive{max: m.cfg.MaxActive}
+	}
+	id, err := newID()
+	if err != nil {
+		m.release(client)
+		return model.Job{}, fmt.Errorf("error generating job id: %w", err)
+	}
+
+	job := model.Job{
+		ID:         id,
+		Status:     model.JobStatusPending,
+		Parameters: input,
+		Elements:   count,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := m.store.save(record{Job: job, Client: client}); err != nil {
+		m.release(client)
+		return model.Job{}, err
+	}
+	select {
+	case m.queue <- id:
+		return job, nil
+	default:
+		m.release(client)
+		if err := m.store.remove(id); err != nil {
+			log.Printf("error removing rejected job %s: %s", id, err.Error())
+		}
+		return model.Job{}, QueueFull{}
+	}
+}
+
+// acquire counts a new active job of client, false if client has already cfg.MaxActive active jobs
+func (m *Manager) acquire(client string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cfg.MaxActive > 0 && m.active[client] >= m.cfg.MaxActive {
+		return false
+	}
+	m.active[client]++
+	return true
+}
+
+// release discounts an active job of client, once finished or rejected
+func (m *Manager) release(client string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.active[client]--; m.active[client] <= 0 {
+		delete(m.active, client)
+	}
+}
+
+// storeFull returns true if the stored results have reached cfg.MaxStoreBytes
+func (m *Manager) storeFull() bool {
+	return m.cfg.MaxStoreBytes > 0 && m.store.Size() >= int64(m.cfg.MaxStoreBytes)
+}
+
+// Job returns the job id, JobNotFound if it doesn't exist or is expired
+func (m *Manager) Job(ctx context.Context, id string) (model.Job, error) {
+	rec, err := m.store.load(id)
+	if err != nil {
+		return model.Job{}, err
+	}
+	if expired(rec.Job, time.Now()) {
+		return model.Job{}, JobNotFound{}
+	}
+	return rec.Job, nil
+}
+
+// Result returns the job id and its result, to be closed once read; NotCompleted is returned if the job is not
+// completed, JobNotFound if it doesn't exist or is expired
+func (m *Manager) Result(ctx context.Context, id string) (model.Job, io.ReadSeekCloser, error) {
+	rec, err := m.store.load(id)
+	if err != nil {
+		return model.Job{}, nil, err
+	}
+	if expired(rec.Job, time.Now()) {
+		return model.Job{}, nil, JobNotFound{}
+	}
+	if rec.Job.Status != model.JobStatusCompleted {
+		return model.Job{}, nil, NotCompleted{status: rec.Job.Status}
+	}
+	return rec.Job, newArtifact(m.store, id, rec.Chunks), nil
+}
+
+// expired returns if job is expired at now
+func expired(job model.Job, now time.Time) bool {
+	return job.ExpiresAt != nil && job.ExpiresAt.Before(now)
+}
+
+// Run processes the queued jobs with cfg.Workers workers and removes the expired jobs every cfg.CleanupInterval,
+// until ctx is done. A job interrupted by the end of ctx is processed again by the next Run
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for w := 0; w < m.cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.work(ctx)
+		}()
+	}
+	defer wg.Wait()
+
+	// the recovered jobs wait for room in the queue, whereas the submissions are rejected if it is full
+	recovered := m.recovered
+	m.recovered = nil
+	for _, id := range recovered {
+		select {
+		case <-ctx.Done():
+			return
+		case m.queue <- id:
+		}
+	}
+
+	ticker := time.NewTicker(m.cfg.CleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := m.Cleanup(now); err != nil {
+				log.Printf("error removing expired jobs: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Cleanup removes the jobs expired at now, with their results
+func (m *Manager) Cleanup(now time.Time) error {
+	recs, err := m.store.list()
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if expired(rec.Job, now) {
+			if err := m.store.remove(rec.Job.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// work processes the queued jobs until ctx is done
+func (m *Manager) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-m.queue:
+			if err := m.process(ctx, id); err != nil {
+				log.Printf("error processing job %s: %s", id, err.Error())
+			}
+		}
+	}
+}
+
+// process generates the result of the job id, from the beginning, and saves it as completed or failed. The job is
+// left running if ctx is done meanwhile
+func (m *Manager) process(ctx context.Context, id string) error {
+	rec, err := m.store.load(id)
+	if err != nil {
+		return err
+	}
+	if rec.Job.Status != model.JobStatusPending && rec.Job.Status != model.JobStatusRunning {
+		return nil
+	}
+	if err := m.store.removeChunks(id); err != nil {
+		return err
+	}
+	rec.Job.Status = model.JobStatusRunning
+	rec.Job.Generated = 0
+	rec.Chunks = nil
+	if err := m.store.save(rec); err != nil {
+		return err
+	}
+
+	err = m.generate(ctx, &rec)
+	if ctx.Err() != nil {
+		return nil
+	}
+	defer m.release(rec.Client)
+	finished := time.Now().UTC()
+	expires := finished.Add(m.cfg.TTL)
+	rec.Job.FinishedAt = &finished
+	rec.Job.ExpiresAt = &expires
+	if err != nil {
+		log.Printf("job %s failed: %s", id, err.Error())
+		rec.Job.Status = model.JobStatusFailed
+		rec.Job.Error = "error generating the sequence"
+		if errors.Is(err, StoreFull{}) {
+			rec.Job.Error = "jobs storage exhausted"
+		}
+		rec.Chunks = nil
+		if err := m.store.removeChunks(id); err != nil {
+			return err
+		}
+	} else {
+		rec.Job.Status = model.JobStatusCompleted
+		rec.Job.Generated = rec.Job.Elements
+		rec.Job.Result = "/jobs/" + id + "/result"
+		for _, size := range rec.Chunks {
+			rec.Job.Size += size
+		}
+	}
+	return m.store.save(rec)
+}
+
+// generate writes the encoded model.FizzBuzzOutput of the sequence of the job of rec as its result, saving rec with
+// its progress along with each chunk
+func (m *Manager) generate(ctx context.Context, rec *record) error {
+	input := rec.Job.Parameters
+	var generated int64
+	w := &chunkWriter{
+		store: m.store,
+		id:    rec.Job.ID,
+		size:  int64(m.cfg.ChunkBytes),
+		onChunk: func(size int64) error {
+			if m.storeFull() {
+				return StoreFull{}
+			}
+			rec.Chunks = append(rec.Chunks, size)
+			rec.Job.Generated = generated
+			return m.store.save(*rec)
+		},
+	}
+
+	// the elements are either numbers or one of the strings, which may need to be escaped
+	quoted := map[string][]byte{}
+	for _, s := range []string{input.Str1, input.Str2, input.Str1 + input.Str2} {
+		q, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		quoted[s] = q
+	}
+
+	buf := make([]byte, 0, bufferBytes+256)
+	buf = append(buf, `{"Sequence":[`...)
+	for n := int64(0); n < rec.Job.Elements; n++ {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+		element := fizzbuzz.Element(input, input.Start+int(n))
+		if q, ok := quoted[element]; ok {
+			buf = append(buf, q...)
+		} else {
+			buf = append(buf, '"')
+			buf = append(buf, element...)
+			buf = append(buf, '"')
+		}
+		generated = n + 1
+		if len(buf) >= bufferBytes {
+			if err := ctx.Err(); err != nil {
+				w.Close()
+				return err
+			}
+			if _, err := w.Write(buf); err != nil {
+				w.Close()
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	buf = append(buf, `]}`...)
+	if _, err := w.Write(buf); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
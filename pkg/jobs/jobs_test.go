@@ -0,0 +1,234 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/fizzbuzz"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig(t *testing.T) config.JobsConfig {
+	cfg := config.Default().Jobs
+	cfg.Enable = true
+	cfg.Dir = t.TempDir()
+	cfg.QueueSize = 2
+	cfg.MaxElements = 1000
+	cfg.ChunkBytes = 64
+	return cfg
+}
+
+func testInput(limit int) model.FizzBuzzInput {
+	return model.FizzBuzzInput{
+		FizzBuzzInputStats: model.FizzBuzzInputStats{Int1: 3, Int2: 5, Limit: limit, Str1: "Fi\"zz", Str2: "bézé"},
+		Start:              -10,
+	}
+}
+
+// run runs m until the end of the test
+func run(t *testing.T, m *Manager) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// completed waits for the completion of the job id
+func completed(t *testing.T, m *Manager, id string) model.Job {
+	var job model.Job
+	require.Eventually(t, func() bool {
+		var err error
+		job, err = m.Job(context.Background(), id)
+		require.NoError(t, err)
+		return job.Status == model.JobStatusCompleted
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func TestManager(t *testing.T) {
+	m, err := NewManager(testConfig(t))
+	require.NoError(t, err)
+	run(t, m)
+
+	input := testInput(500)
+	submitted, err := m.Submit(context.Background(), "client", input)
+	require.NoError(t, err)
+	assert.Equal(t, model.JobStatusPending, submitted.Status)
+	assert.Equal(t, int64(511), submitted.Elements)
+
+	job := completed(t, m, submitted.ID)
+	assert.Equal(t, int64(511), job.Generated)
+	assert.Equal(t, "/jobs/"+job.ID+"/result", job.Result)
+	require.NotNil(t, job.ExpiresAt)
+	assert.WithinDuration(t, job.FinishedAt.Add(24*time.Hour), *job.ExpiresAt, 0)
+
+	_, result, err := m.Result(context.Background(), job.ID)
+	require.NoError(t, err)
+	defer result.Close()
+	content, err := io.ReadAll(result)
+	require.NoError(t, err)
+	assert.Equal(t, job.Size, int64(len(content)))
+	var output model.FizzBuzzOutput
+	require.NoError(t, json.Unmarshal(content, &output))
+	assert.Equal(t, fizzbuzz.Fizzbuzz(input), output.Sequence)
+	assert.Empty(t, output.Next)
+
+	// the result is chunked, any range can be read
+	chunks, err := filepath.Glob(filepath.Join(m.cfg.Dir, job.ID, "*.gz"))
+	require.NoError(t, err)
+	assert.Greater(t, len(chunks), 10)
+	for _, offset := range []int64{0, 63, 64, 100, job.Size - 5} {
+		_, err := result.Seek(offset, io.SeekStart)
+		require.NoError(t, err)
+		buf := make([]byte, 70)
+		n, err := io.ReadFull(result, buf)
+		if offset+70 > job.Size {
+			assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		} else {
+			require.NoError(t, err)
+		}
+		assert.Equal(t, content[offset:offset+int64(n)], buf[:n], offset)
+	}
+	size, err := result.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, job.Size, size)
+}
+
+func TestManager_Errors(t *testing.T) {
+	cfg := testConfig(t)
+	m, err := NewManager(cfg)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = m.Submit(ctx, "client", testInput(990))
+	assert.Equal(t, TooLarge{max: 1000}, err)
+
+	job, err := m.Submit(ctx, "client", testInput(989))
+	require.NoError(t, err)
+	_, _, err = m.Result(ctx, job.ID)
+	assert.Equal(t, NotCompleted{status: model.JobStatusPending}, err)
+
+	// the queue is full
+	_, err = m.Submit(ctx, "client", testInput(10))
+	require.NoError(t, err)
+	_, err = m.Submit(ctx, "client", testInput(10))
+	assert.Equal(t, QueueFull{}, err)
+	entries, err := os.ReadDir(cfg.Dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	for _, id := range []string{"0123456789abcdef0123456789abcdef", "..", "../" + job.ID} {
+		_, err = m.Job(ctx, id)
+		assert.Equal(t, JobNotFound{}, err, id)
+	}
+}
+
+func TestManager_Limits(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.QueueSize = 4
+	cfg.MaxActive = 2
+	m, err := NewManager(cfg)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// the active jobs are bounded for each client
+	first, err := m.Submit(ctx, "a", testInput(100))
+	require.NoError(t, err)
+	_, err = m.Submit(ctx, "a", testInput(100))
+	require.NoError(t, err)
+	_, err = m.Submit(ctx, "a", testInput(100))
+	assert.Equal(t, TooManyActive{max: 2}, err)
+	_, err = m.Submit(ctx, "b", testInput(100))
+	require.NoError(t, err)
+
+	// the finished jobs are not active anymore
+	run(t, m)
+	completed(t, m, first.ID)
+	require.Eventually(t, func() bool {
+		_, err := m.Submit(ctx, "a", testInput(100))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the size of the stored results is bounded
+	require.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.active) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	stored := m.store.Size()
+	assert.Positive(t, stored)
+	m.cfg.MaxStoreBytes = int(stored)
+	_, err = m.Submit(ctx, "a", testInput(100))
+	assert.Equal(t, StoreFull{}, err)
+
+	m.cfg.MaxStoreBytes = int(stored) + 100
+	job, err := m.Submit(ctx, "a", testInput(989))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, err = m.Job(ctx, job.ID)
+		require.NoError(t, err)
+		return job.Status == model.JobStatusFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "jobs storage exhausted", job.Error)
+	assert.Equal(t, stored, m.store.Size())
+
+	// the store size is accounted again on restart
+	restarted, err := NewManager(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, stored, restarted.store.Size())
+}
+
+func TestManager_Restart(t *testing.T) {
+	cfg := testConfig(t)
+	m, err := NewManager(cfg)
+	require.NoError(t, err)
+	pending, err := m.Submit(context.Background(), "client", testInput(100))
+	require.NoError(t, err)
+
+	// a job interrupted while running
+	interrupted, err := m.Submit(context.Background(), "client", testInput(200))
+	require.NoError(t, err)
+	rec, err := m.store.load(interrupted.ID)
+	require.NoError(t, err)
+	rec.Job.Status = model.JobStatusRunning
+	rec.Job.Generated = 20
+	rec.Chunks = []int64{100}
+	require.NoError(t, m.store.save(rec))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Dir, interrupted.ID, "000000.gz"), []byte("garbage"), 0o600))
+
+	restarted, err := NewManager(cfg)
+	require.NoError(t, err)
+	run(t, restarted)
+	completed(t, restarted, pending.ID)
+	job := completed(t, restarted, interrupted.ID)
+
+	_, result, err := restarted.Result(context.Background(), job.ID)
+	require.NoError(t, err)
+	defer result.Close()
+	var output model.FizzBuzzOutput
+	require.NoError(t, json.NewDecoder(result).Decode(&output))
+	assert.Equal(t, fizzbuzz.Fizzbuzz(testInput(200)), output.Sequence)
+
+	// the jobs expire
+	require.NoError(t, restarted.Cleanup(time.Now()))
+	_, err = restarted.Job(context.Background(), job.ID)
+	require.NoError(t, err)
+	require.NoError(t, restarted.Cleanup(job.ExpiresAt.Add(time.Second)))
+	_, err = restarted.Job(context.Background(), job.ID)
+	assert.Equal(t, JobNotFound{}, err)
+	assert.NoDirExists(t, filepath.Join(cfg.Dir, job.ID))
+}
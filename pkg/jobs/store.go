@@ -0,0 +1,232 @@
+package jobs
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+)
+
+const (
+	// name of the file of a job in its directory
+	jobFile = "job.json"
+	// length of the hex encoded identifier of a job
+	idLength = 32
+)
+
+// JobNotFound indicates that no job, or an expired one, has the requested identifier
+type JobNotFound struct{}
+
+// Error is the error interface implementation
+func (j JobNotFound) Error() string {
+	return "job not found"
+}
+
+// record is a job as persisted by the FileStore: the job itself, the uncompressed size of each chunk of its result and
+// its client
+type record struct {
+	Job    model.Job
+	Chunks []int64 `json:",omitempty"`
+	// client which submitted the job
+	Client string `json:",omitempty"`
+}
+
+// FileStore persists the jobs and their results in a directory: each job has its own directory, named by its
+// identifier, holding the job (job.json) and the chunks of its result, compressed by gzip (000000.gz, 000001.gz...).
+// The size of the stored chunks is accounted, so that it can be bounded (see Size)
+type FileStore struct {
+	dir string
+	// bytes of the stored chunks
+	size atomic.Int64
+}
+
+// NewFileStore returns a FileStore persisting the jobs in dir, created if missing
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating jobs directory: %w", err)
+	}
+	fs := &FileStore{dir: dir}
+	chunks, err := filepath.Glob(filepath.Join(dir, "*", "*.gz"))
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		info, err := os.Stat(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("error reading chunk: %w", err)
+		}
+		fs.size.Add(info.Size())
+	}
+	return fs, nil
+}
+
+// Size returns the size in bytes of the stored chunks, as compressed
+func (fs *FileStore) Size() int64 {
+	return fs.size.Load()
+}
+
+// jobDir returns the directory of the job id; JobNotFound if id is not a valid identifier, which could escape the
+// directory of the store
+func (fs *FileStore) jobDir(id string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || len(id) != idLength {
+		return "", JobNotFound{}
+	}
+	return filepath.Join(fs.dir, id), nil
+}
+
+// chunkPath returns the path of the chunk n of the result of the job id
+func (fs *FileStore) chunkPath(id string, n int) (string, error) {
+	dir, err := fs.jobDir(id)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("%06d.gz", n)), nil
+}
+
+// save stores or replaces rec; the job file is replaced atomically, so that it is never read partially written
+func (fs *FileStore) save(rec record) error {
+	dir, err := fs.jobDir(rec.Job.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("error creating job directory: %w", err)
+	}
+	content, err := json.Marshal(&rec)
+	if err != nil {
+		return fmt.Errorf("error marshaling job: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, jobFile+".*")
+	if err != nil {
+		return fmt.Errorf("error saving job: %w", err)
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, jobFile))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error saving job: %w", err)
+	}
+	return nil
+}
+
+// load returns the record of the job id, JobNotFound if it is not stored
+func (fs *FileStore) load(id string) (record, error) {
+	dir, err := fs.jobDir(id)
+	if err != nil {
+		return record{}, err
+	}
+	content, err := os.ReadFile(filepath.Join(dir, jobFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return record{}, JobNotFound{}
+	}
+	if err != nil {
+		return record{}, fmt.Errorf("error reading job: %w", err)
+	}
+	var rec record
+	if err := json.Unmarshal(content, &rec); err != nil {
+		return record{}, fmt.Errorf("error decoding job %s: %w", id, err)
+	}
+	return rec, nil
+}
+
+// list returns the records of every stored job, sorted by submission. The directories of the store which are not
+// jobs are ignored
+func (fs *FileStore) list() ([]record, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing jobs: %w", err)
+	}
+	var recs []record
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		rec, err := fs.load(entry.Name())
+		if errors.Is(err, JobNotFound{}) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Job.CreatedAt.Before(recs[j].Job.CreatedAt) })
+	return recs, nil
+}
+
+// remove deletes the job id and its result
+func (fs *FileStore) remove(id string) error {
+	dir, err := fs.jobDir(id)
+	if err != nil {
+		return err
+	}
+	if err := fs.removeChunks(id); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("error removing job: %w", err)
+	}
+	return nil
+}
+
+// removeChunks deletes the chunks of the result of the job id, e.g. those of an interrupted generation
+func (fs *FileStore) removeChunks(id string) error {
+	dir, err := fs.jobDir(id)
+	if err != nil {
+		return err
+	}
+	chunks, err := filepath.Glob(filepath.Join(dir, "*.gz"))
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		info, err := os.Stat(chunk)
+		if err != nil {
+			return fmt.Errorf("error reading chunk: %w", err)
+		}
+		if err := os.Remove(chunk); err != nil {
+			return fmt.Errorf("error removing chunk: %w", err)
+		}
+		fs.size.Add(-info.Size())
+	}
+	return nil
+}
+
+// createChunk creates the file of the chunk n of the result of the job id
+func (fs *FileStore) createChunk(id string, n int) (*os.File, error) {
+	path, err := fs.chunkPath(id, n)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// chunkStored accounts file, the chunk just written and about to be closed
+func (fs *FileStore) chunkStored(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading chunk: %w", err)
+	}
+	fs.size.Add(info.Size())
+	return nil
+}
+
+// openChunk opens the file of the chunk n of the result of the job id
+func (fs *FileStore) openChunk(id string, n int) (*os.File, error) {
+	path, err := fs.chunkPath(id, n)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
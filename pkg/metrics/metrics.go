@@ -0,0 +1,384 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fizzbuzz"
+
+// Metrics collects the metrics of the application and exposes them in the Prometheus format.
+// All methods can be called on a nil *Metrics, in which case nothing is recorded
+type Metrics struct {
+	registry           *prometheus.Registry
+	requests           *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	sequenceLength     prometheus.Histogram
+	validationFailures *prometheus.CounterVec
+	statsDuration      *prometheus.HistogramVec
+	statsErrors        *prometheus.CounterVec
+	configReloads      *prometheus.CounterVec
+	certificateReloads *prometheus.CounterVec
+	certificateExpiry  *prometheus.GaugeVec
+	authFailures       *prometheus.CounterVec
+	rateLimited        *prometheus.CounterVec
+	concurrencyLimit   prometheus.Gauge
+	inflight           prometheus.Gauge
+	queueDepth         prometheus.Gauge
+	shedRequests       *prometheus.CounterVec
+	cacheHits          *prometheus.CounterVec
+	cacheMisses        prometheus.Counter
+	cacheErrors        *prometheus.CounterVec
+	cacheSize          prometheus.Gauge
+	compressionErrors  *prometheus.CounterVec
+}
+
+// New returns a Metrics with its own registry, which includes the go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests by route, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		sequenceLength: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "sequence_length",
+			Help:      "Number of elements of the generated sequences.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 9),
+		}),
+		validationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "validation_failures_total",
+			Help:      "Number of invalid parameters of the requests rejected by the validation, by parameter.",
+		}, []string{"parameter"}),
+		statsDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "statistics",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the statistics backend operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		statsErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "statistics",
+			Name:      "operation_errors_total",
+			Help:      "Number of failed statistics backend operations.",
+		}, []string{"operation"}),
+		configReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "config",
+			Name:      "reloads_total",
+			Help:      "Number of configuration reloads, by result.",
+		}, []string{"result"}),
+		certificateReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "tls",
+			Name:      "certificate_reloads_total",
+			Help:      "Number of TLS credentials loadings, by result.",
+		}, []string{"result"}),
+		certificateExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "tls",
+			Name:      "certificate_expiry_timestamp_seconds",
+			Help:      "Expiry of the TLS certificates in use, as a unix timestamp.",
+		}, []string{"certificate"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "failures_total",
+			Help:      "Number of requests rejected by the authentication or the authorization, by reason.",
+		}, []string{"reason"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rate_limit",
+			Name:      "rejections_total",
+			Help:      "Number of requests rejected by the rate limiting, by reason.",
+		}, []string{"reason"}),
+		concurrencyLimit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "concurrency",
+			Name:      "limit",
+			Help:      "Current adaptive limit of the fizzbuzz generations processed concurrently.",
+		}),
+		inflight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "concurrency",
+			Name:      "inflight_requests",
+			Help:      "Number of fizzbuzz generations being processed.",
+		}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "concurrency",
+			Name:      "queue_depth",
+			Help:      "Number of fizzbuzz requests waiting for a slot.",
+		}),
+		shedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "concurrency",
+			Name:      "rejections_total",
+			Help:      "Number of fizzbuzz requests shed by the concurrency limiting, by reason.",
+		}, []string{"reason"}),
+		cacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "hits_total",
+			Help:      "Number of responses served from the cache, by tier.",
+		}, []string{"tier"}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "misses_total",
+			Help:      "Number of responses generated because not cached.",
+		}),
+		cacheErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "errors_total",
+			Help:      "Number of failed operations of the shared cache, by operation.",
+		}, []string{"operation"}),
+		cacheSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "size_bytes",
+			Help:      "Size of the in-memory cache.",
+		}),
+		compressionErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "compression",
+			Name:      "errors_total",
+			Help:      "Number of responses whose compression failed, by content coding.",
+		}, []string{"encoding"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.sequenceLength,
+		m.validationFailures,
+		m.statsDuration,
+		m.statsErrors,
+		m.configReloads,
+		m.certificateReloads,
+		m.certificateExpiry,
+		m.authFailures,
+		m.rateLimited,
+		m.concurrencyLimit,
+		m.inflight,
+		m.queueDepth,
+		m.shedRequests,
+		m.cacheHits,
+		m.cacheMisses,
+		m.cacheErrors,
+		m.cacheSize,
+		m.compressionErrors,
+	)
+
+	return m
+}
+
+// Register adds further collectors to the registry of m
+func (m *Metrics) Register(cs ...prometheus.Collector) error {
+	if m == nil {
+		return nil
+	}
+	for _, c := range cs {
+		if err := m.registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler returns the HTTP handler exposing the metrics
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware is an HTTP middleware counting the requests and measuring their latency. Requests are labeled
+// with the chi route pattern, so that the cardinality does not depend on the query or path parameters
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(rw, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := prometheus.Labels{
+			"route":  route,
+			"method": r.Method,
+			"status": strconv.Itoa(status),
+		}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveSequence records the length of a generated sequence
+func (m *Metrics) ObserveSequence(length int) {
+	if m == nil {
+		return
+	}
+	m.sequenceLength.Observe(float64(length))
+}
+
+// ValidationFailure counts an invalid parameter of a request rejected by the validation
+func (m *Metrics) ValidationFailure(parameter string) {
+	if m == nil {
+		return
+	}
+	m.validationFailures.WithLabelValues(parameter).Inc()
+}
+
+// ConfigReload counts a configuration reload, failed if err is not nil
+func (m *Metrics) ConfigReload(err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.configReloads.WithLabelValues(result).Inc()
+}
+
+// CertificateReload counts a loading of the TLS credentials, failed if err is not nil
+func (m *Metrics) CertificateReload(err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.certificateReloads.WithLabelValues(result).Inc()
+}
+
+// CertificateExpiry records the expiry of the TLS certificate in use labeled by certificate
+func (m *Metrics) CertificateExpiry(certificate string, notAfter time.Time) {
+	if m == nil {
+		return
+	}
+	m.certificateExpiry.WithLabelValues(certificate).Set(float64(notAfter.Unix()))
+}
+
+// AuthFailure counts a request rejected because of reason, e.g. missing or invalid credentials
+func (m *Metrics) AuthFailure(reason string) {
+	if m == nil {
+		return
+	}
+	m.authFailures.WithLabelValues(reason).Inc()
+}
+
+// RateLimited counts a request rejected by the rate limiting because of reason, i.e. rate or quota
+func (m *Metrics) RateLimited(reason string) {
+	if m == nil {
+		return
+	}
+	m.rateLimited.WithLabelValues(reason).Inc()
+}
+
+// Concurrency records the state of the concurrency limiting: the current limit, the requests being processed and the
+// requests waiting for a slot
+func (m *Metrics) Concurrency(limit float64, inflight, queued int) {
+	if m == nil {
+		return
+	}
+	m.concurrencyLimit.Set(limit)
+	m.inflight.Set(float64(inflight))
+	m.queueDepth.Set(float64(queued))
+}
+
+// ConcurrencyRejected counts a request shed by the concurrency limiting because of reason
+func (m *Metrics) ConcurrencyRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.shedRequests.WithLabelValues(reason).Inc()
+}
+
+// CacheHit counts a response served from the cache tier
+func (m *Metrics) CacheHit(tier string) {
+	if m == nil {
+		return
+	}
+	m.cacheHits.WithLabelValues(tier).Inc()
+}
+
+// CacheMiss counts a response generated because not cached
+func (m *Metrics) CacheMiss() {
+	if m == nil {
+		return
+	}
+	m.cacheMisses.Inc()
+}
+
+// CacheError counts a failed operation of the shared cache
+func (m *Metrics) CacheError(operation string) {
+	if m == nil {
+		return
+	}
+	m.cacheErrors.WithLabelValues(operation).Inc()
+}
+
+// CacheSize records the size in bytes of the in-memory cache
+func (m *Metrics) CacheSize(size int) {
+	if m == nil {
+		return
+	}
+	m.cacheSize.Set(float64(size))
+}
+
+// CompressionError counts a response whose compression with encoding failed
+func (m *Metrics) CompressionError(encoding string) {
+	if m == nil {
+		return
+	}
+	m.compressionErrors.WithLabelValues(encoding).Inc()
+}
+
+// observeStats records the outcome of a statistics backend operation started at start
+func (m *Metrics) observeStats(operation string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.statsDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	// no statistics available is a legit outcome of the backend
+	if err != nil && !errors.Is(err, statistics.NoStatsAvailable{}) {
+		m.statsErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingStats struct {
+	err error
+}
+
+func (fs failingStats) Increment(ctx context.Context, n, m, top int, fizz, buzz string) (int64, error) {
+	return 0, fs.err
+}
+
+func (fs failingStats) Stats(ctx context.Context) (model.FizzBuzzStatisticsOutput, error) {
+	return model.FizzBuzzStatisticsOutput{}, fs.err
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Get("/items/{id}", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	r.Get("/ok", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("ok"))
+	})
+
+	for _, path := range []string{"/items/1", "/items/2", "/ok", "/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("/items/{id}", http.MethodGet, "418")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("/ok", http.MethodGet, "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("unmatched", http.MethodGet, "404")))
+}
+
+func TestInstrumentedStats(t *testing.T) {
+	m := New()
+
+	stats := NewInstrumentedStats(failingStats{err: errors.New("dummy")}, m)
+	_, err := stats.Increment(context.TODO(), 3, 5, 15, "fizz", "buzz")
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.statsErrors.WithLabelValues("increment")))
+
+	stats = NewInstrumentedStats(failingStats{err: statistics.NoStatsAvailable{}}, m)
+	_, err = stats.Stats(context.TODO())
+	assert.ErrorIs(t, err, statistics.NoStatsAvailable{})
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.statsErrors.WithLabelValues("stats")))
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.ObserveSequence(15)
+	m.ValidationFailure("int1")
+	require.NoError(t, m.Register(NewRedisPoolCollector(redis.NewClient(&redis.Options{}))))
+
+	resp := httptest.NewRecorder()
+	m.Handler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com/metrics", nil))
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	for _, name := range []string{
+		"fizzbuzz_sequence_length_count 1",
+		`fizzbuzz_validation_failures_total{parameter="int1"} 1`,
+		"fizzbuzz_redis_pool_connections 0",
+		"go_goroutines",
+	} {
+		assert.True(t, strings.Contains(string(body), name), name)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+
+	m.ObserveSequence(1)
+	m.ValidationFailure("int1")
+	assert.NoError(t, m.Register(NewRedisPoolCollector(redis.NewClient(&redis.Options{}))))
+
+	called := false
+	m.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com", nil))
+	assert.True(t, called)
+
+	resp := httptest.NewRecorder()
+	m.Handler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, resp.Result().StatusCode)
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
+)
+
+// Stats is the statistics component measured by the InstrumentedStats
+type Stats interface {
+	// Increment receives the input parameters so that they can be registered, and returns their request count
+	Increment(ctx context.Context, n, m, top int, fizz, buzz string) (int64, error)
+	// Stats returns the model.FizzBuzzStatisticsOutput representing the #1 hit
+	Stats(ctx context.Context) (model.FizzBuzzStatisticsOutput, error)
+}
+
+// InstrumentedStats decorates a statistics component measuring the latency and the errors of each operation
+type InstrumentedStats struct {
+	stats   Stats
+	metrics *Metrics
+}
+
+// NewInstrumentedStats returns an InstrumentedStats decorating stats
+func NewInstrumentedStats(stats Stats, metrics *Metrics) *InstrumentedStats {
+	return &InstrumentedStats{
+		stats:   stats,
+		metrics: metrics,
+	}
+}
+
+// Increment calls Increment of the decorated component
+func (is *InstrumentedStats) Increment(ctx context.Context, n, m, top int, fizz, buzz string) (int64, error) {
+	start := time.Now()
+	hits, err := is.stats.Increment(ctx, n, m, top, fizz, buzz)
+	is.metrics.observeStats("increment", start, err)
+	return hits, err
+}
+
+// Stats calls Stats of the decorated component
+func (is *InstrumentedStats) Stats(ctx context.Context) (model.FizzBuzzStatisticsOutput, error) {
+	start := time.Now()
+	res, err := is.stats.Stats(ctx)
+	is.metrics.observeStats("stats", start, err)
+	return res, err
+}
+
+// HealthCheck forwards the check to the decorated component, if it supports health checks
+func (is *InstrumentedStats) HealthCheck(ctx context.Context) error {
+	if checker, ok := is.stats.(statistics.HealthChecker); ok {
+		return checker.HealthCheck(ctx)
+	}
+	return nil
+}
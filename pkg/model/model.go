@@ -1,5 +1,10 @@
 package model
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // Separator is s string used for the concatenation of the fields
 // of the input parameters
 const Separator string = "-"
@@ -7,8 +12,16 @@ const Separator string = "-"
 // InputContextKey is a specific type for a key of a value in a context.Context
 type InputContextKey int
 
-// InputKey is the key to use when adding a FizzBuzzInput in a context.Context
-const InputKey InputContextKey = iota
+const (
+	// InputKey is the key to use when adding a FizzBuzzInput in a context.Context
+	InputKey InputContextKey = iota
+	// IdentityKey is the key to use when adding the name of the client identity in a context.Context
+	IdentityKey
+	// PrincipalKey is the key to use when adding the authenticated Principal in a context.Context
+	PrincipalKey
+	// BatchKey is the key to use when adding the validated items of a batch in a context.Context
+	BatchKey
+)
 
 // FizzBuzzInputStats is a subset of the fizzbuzz input parameters, used to store
 // and calculate statistics afterwards
@@ -33,6 +46,18 @@ type FizzBuzzInput struct {
 	Start int
 }
 
+// FizzBuzzRequest is the JSON body of the POST /fizzbuzz endpoint, mirroring FizzBuzzInput with the names of the
+// query parameters of GET /fizzbuzz; a nil field is a parameter not provided
+type FizzBuzzRequest struct {
+	Int1  *int `json:"int1"`
+	Int2  *int `json:"int2"`
+	Limit *int `json:"limit"`
+	// defaulted to 1
+	Start *int    `json:"start,omitempty"`
+	Str1  *string `json:"str1"`
+	Str2  *string `json:"str2"`
+}
+
 // FizzBuzzOutput is the structure returned by the the /fizzbuzz endpoint: the fizzbuzz Sequence
 // and optionally a Next link, pointing to the next sequence, if the request underwent the pagination
 type FizzBuzzOutput struct {
@@ -42,6 +67,17 @@ type FizzBuzzOutput struct {
 	Next string `json:"next,omitempty"`
 }
 
+// FizzBuzzBatchResult is an item of the response of the POST /fizzbuzz/batch endpoint, in the order of the items of
+// the request: the HTTP Status of the item and either its Result, a FizzBuzzOutput, or its Error
+type FizzBuzzBatchResult struct {
+	// HTTP status of the item, as if it was requested alone
+	Status int `json:"status"`
+	// encoded FizzBuzzOutput of the item, if successful
+	Result json.RawMessage `json:"result,omitempty"`
+	// problem of the item, if failed
+	Error *Problem `json:"error,omitempty"`
+}
+
 // FizzBuzzStatisticsOutput is the structure returned by the /statistics endpoint: the most used
 // input parameters set and the number of times that it has been requested
 type FizzBuzzStatisticsOutput struct {
@@ -63,4 +99,188 @@ type ApplicationError struct {
 	Detail string `json:"detail,omitempty"`
 	// application identifier of the request generating the error
 	Instance string
+	// every invalid parameter of the request, only part of the Problem representation
+	Errors []ParameterError `json:"-"`
+}
+
+// Problem is the RFC 9457 problem details representation of an ApplicationError, returned
+// to the clients accepting the application/problem+json media type
+type Problem struct {
+	// URI reference identifying the type of the problem
+	Type string `json:"type"`
+	// short, human-readable summary of the type of the problem
+	Title string `json:"title"`
+	// HTTP status
+	Status int `json:"status"`
+	// human-readable explanation of this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// application identifier of the request generating the problem
+	Instance string `json:"instance,omitempty"`
+	// every invalid parameter of the request, for the validation problems
+	Errors []ParameterError `json:"errors,omitempty"`
+}
+
+// ParameterError describes an invalid parameter of a request
+type ParameterError struct {
+	// name of the parameter
+	Parameter string `json:"parameter"`
+	// why the parameter is invalid
+	Detail string `json:"detail"`
+	// constraint the parameter should satisfy
+	Constraint string `json:"constraint,omitempty"`
+}
+
+// WebhookTriggerThreshold is the trigger of a Webhook notified when a set of input parameters
+// reaches the Webhook Threshold hit-count
+const WebhookTriggerThreshold = "threshold"
+
+// WebhookTriggerTop is the trigger of a Webhook notified when a set of input parameters
+// becomes the most requested one
+const WebhookTriggerTop = "top"
+
+// Webhook is the registration of an HTTP receiver notified when the statistics of a set of
+// input parameters match its Trigger
+type Webhook struct {
+	// identifier of the webhook, assigned at registration
+	ID string
+	// absolute http(s) URL receiving the events via POST
+	URL string
+	// one of WebhookTriggerThreshold, WebhookTriggerTop
+	Trigger string
+	// hit-count notified with trigger WebhookTriggerThreshold
+	Threshold int64 `json:"Threshold,omitempty"`
+	// secret used to sign the events using HMAC-SHA256; never returned by the API
+	Secret string `json:"Secret,omitempty"`
+}
+
+// WebhookEvent is the payload sent to a Webhook receiver
+type WebhookEvent struct {
+	// identifier of the event, unique for each notification
+	ID string
+	// identifier of the notified webhook
+	WebhookID string
+	// the trigger of the webhook which generated the event
+	Trigger string
+	// Set of Input parameters of /fizzbuzz endpoint which triggered the event
+	Parameters FizzBuzzInputStats
+	// Number of times the Parameters set has been requested
+	Hits int64
+	// instant of the event generation
+	Timestamp time.Time
+}
+
+// HealthStatusOK is the status of a healthy component
+const HealthStatusOK = "ok"
+
+// HealthStatusUnavailable is the status of a failing component
+const HealthStatusUnavailable = "unavailable"
+
+// HealthOutput is the structure returned by the /healthz and /readyz endpoints
+type HealthOutput struct {
+	// overall status, one of HealthStatusOK, HealthStatusUnavailable
+	Status string
+	// optional description of the overall status
+	Detail string `json:"Detail,omitempty"`
+	// status of each dependency, by name; only checked by /readyz
+	Dependencies map[string]DependencyHealth `json:"Dependencies,omitempty"`
+}
+
+// DependencyHealth is the status of a single dependency of the server
+type DependencyHealth struct {
+	// one of HealthStatusOK, HealthStatusUnavailable
+	Status string
+	// the error returned by the check, if any
+	Error string `json:"Error,omitempty"`
+}
+
+// ConfigReloadOutput is the result of a configuration reload
+type ConfigReloadOutput struct {
+	Status string
+	// settings which changed but require a restart to be applied
+	RestartRequired []string `json:",omitempty"`
+}
+
+const (
+	// JobStatusPending is the status of a Job waiting to be processed
+	JobStatusPending = "pending"
+	// JobStatusRunning is the status of a Job whose sequence is being generated
+	JobStatusRunning = "running"
+	// JobStatusCompleted is the status of a Job whose result is available
+	JobStatusCompleted = "completed"
+	// JobStatusFailed is the status of a Job whose sequence couldn't be generated
+	JobStatusFailed = "failed"
+)
+
+// Job is the asynchronous generation of the fizzbuzz sequence of a set of input parameters, returned by the /jobs
+// endpoints. Its result is the FizzBuzzOutput of the whole sequence, never paginated
+type Job struct {
+	// identifier of the job, assigned at submission
+	ID string
+	// one of JobStatusPending, JobStatusRunning, JobStatusCompleted, JobStatusFailed
+	Status string
+	// input parameters of the sequence
+	Parameters FizzBuzzInput
+	// number of elements of the sequence
+	Elements int64
+	// number of elements already generated
+	Generated int64
+	// size in bytes of the result, once completed
+	Size int64 `json:"Size,omitempty"`
+	// why the job failed
+	Error string `json:"Error,omitempty"`
+	// link to the result, once completed
+	Result string `json:"Result,omitempty"`
+	// instant of the submission
+	CreatedAt time.Time
+	// instant when the job was completed or failed
+	FinishedAt *time.Time `json:"FinishedAt,omitempty"`
+	// instant after which the job and its result are removed, once finished
+	ExpiresAt *time.Time `json:"ExpiresAt,omitempty"`
+}
+
+const (
+	// ScopeFizzBuzzRead allows the generation of fizzbuzz sequences
+	ScopeFizzBuzzRead = "fizzbuzz:read"
+	// ScopeStatisticsRead allows the reading of the statistics
+	ScopeStatisticsRead = "statistics:read"
+	// ScopeAdmin allows the administration of the server, e.g. the webhooks registration
+	ScopeAdmin = "admin"
+
+	// AuthMethodAPIKey is the authentication method of a Principal authenticated by an API key
+	AuthMethodAPIKey = "api_key"
+	// AuthMethodJWT is the authentication method of a Principal authenticated by a JWT bearer token
+	AuthMethodJWT = "jwt"
+)
+
+// APIKey is an API key allowing its holder the operations of its scopes. The key itself is only known by its
+// holder: the server stores the hex encoded SHA-256 digest of the secret part
+type APIKey struct {
+	// identifier of the key, first part of the key
+	ID string
+	// hex encoded SHA-256 digest of the secret part of the key
+	Hash string `json:",omitempty"`
+	// scopes granted to the holder
+	Scopes []string
+	// the whole key, only returned at creation
+	Key string `json:",omitempty"`
+}
+
+// Principal is the authenticated client of a request
+type Principal struct {
+	// identifier of the client within its authentication method, e.g. the API key ID
+	ID string
+	// authentication method, e.g. AuthMethodAPIKey
+	Method string
+	// scopes granted to the client
+	Scopes []string
+}
+
+// HasScope returns if scope is granted to the principal; the admin scope grants every scope
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
 }
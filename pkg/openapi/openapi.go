@@ -0,0 +1,302 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Document is an OpenAPI 3.0 document, restricted to the subset needed to validate the requests and the responses
+// of the api. The local references ($ref) are resolved when it is loaded
+type Document struct {
+	Servers []Server             `yaml:"servers"`
+	Paths   map[string]*PathItem `yaml:"paths"`
+}
+
+// Server is the base URL of the paths; only its path is considered
+type Server struct {
+	URL string `yaml:"url"`
+}
+
+// PathItem describes the operations available on a path
+type PathItem struct {
+	// overrides the servers of the document, if not empty
+	Servers    []Server     `yaml:"servers"`
+	Parameters []*Parameter `yaml:"parameters"`
+	Get        *Operation   `yaml:"get"`
+	Put        *Operation   `yaml:"put"`
+	Post       *Operation   `yaml:"post"`
+	Delete     *Operation   `yaml:"delete"`
+	Patch      *Operation   `yaml:"patch"`
+}
+
+// Operation describes a single API operation on a path
+type Operation struct {
+	Parameters  []*Parameter         `yaml:"parameters"`
+	RequestBody *RequestBody         `yaml:"requestBody"`
+	Responses   map[string]*Response `yaml:"responses"`
+}
+
+// Parameter describes a single operation parameter
+type Parameter struct {
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+// RequestBody describes a request body, by media type
+type RequestBody struct {
+	Required bool                  `yaml:"required"`
+	Content  map[string]*MediaType `yaml:"content"`
+}
+
+// MediaType describes the content of a media type
+type MediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+// Response describes a single response of an operation
+type Response struct {
+	Headers map[string]*Header    `yaml:"headers"`
+	Content map[string]*MediaType `yaml:"content"`
+}
+
+// Header describes a single response header
+type Header struct {
+	Required bool    `yaml:"required"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+// Route is an operation of the document matching a request
+type Route struct {
+	Method string
+	// path template of the operation, e.g. /webhooks/{id}, without the base path of its servers
+	Path      string
+	Operation *Operation
+	// values of the path parameters, by name
+	PathParams map[string]string
+	// parameters of the path item and of the operation, the latter overriding the former
+	parameters []*Parameter
+}
+
+// Load parses an OpenAPI document in YAML or JSON, resolving its local references
+func Load(content []byte) (*Document, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("error parsing OpenAPI document: %w", err)
+	}
+	if err := resolveRefs(&root, &root, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	var doc Document
+	if err := root.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error decoding OpenAPI document: %w", err)
+	}
+	return &doc, nil
+}
+
+// resolveRefs replaces in place every node of node holding a local $ref by the referenced node. resolving holds the
+// references being resolved, so that cycles are reported
+func resolveRefs(root, node *yaml.Node, resolving map[string]bool) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := resolveRefs(root, child, resolving); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value != "$ref" {
+				continue
+			}
+			ref := node.Content[i+1].Value
+			if resolving[ref] {
+				return fmt.Errorf("circular reference %s", ref)
+			}
+			target, err := lookupRef(root, ref)
+			if err != nil {
+				return err
+			}
+			resolving[ref] = true
+			err = resolveRefs(root, target, resolving)
+			delete(resolving, ref)
+			if err != nil {
+				return err
+			}
+			// the siblings of a $ref are ignored
+			*node = *target
+			return nil
+		}
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := resolveRefs(root, node.Content[i], resolving); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookupRef returns the node of root referenced by ref, a local JSON pointer such as #/components/schemas/error
+func lookupRef(root *yaml.Node, ref string) (*yaml.Node, error) {
+	pointer, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported reference %s, only local references are", ref)
+	}
+	node := root.Content[0]
+	for _, token := range strings.Split(pointer, "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		var next *yaml.Node
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == token {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("unresolved reference %s", ref)
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// operations returns the operations of the path item, by method
+func (pi *PathItem) operations() map[string]*Operation {
+	res := map[string]*Operation{}
+	for method, op := range map[string]*Operation{
+		http.MethodGet:    pi.Get,
+		http.MethodPut:    pi.Put,
+		http.MethodPost:   pi.Post,
+		http.MethodDelete: pi.Delete,
+		http.MethodPatch:  pi.Patch,
+	} {
+		if op != nil {
+			res[method] = op
+		}
+	}
+	return res
+}
+
+// basePaths returns the base paths of the servers of the path item, "/" if none
+func (d *Document) basePaths(pi *PathItem) []string {
+	servers := d.Servers
+	if len(pi.Servers) > 0 {
+		servers = pi.Servers
+	}
+	if len(servers) == 0 {
+		return []string{""}
+	}
+	res := make([]string, 0, len(servers))
+	for _, server := range servers {
+		base := server.URL
+		// only the path of an absolute URL is considered
+		if _, rest, ok := strings.Cut(base, "://"); ok {
+			base = "/"
+			if _, path, ok := strings.Cut(rest, "/"); ok {
+				base += path
+			}
+		}
+		res = append(res, strings.TrimSuffix(base, "/"))
+	}
+	return res
+}
+
+// Operations returns the method and full path, base path included, of every operation of d, sorted
+func (d *Document) Operations() []string {
+	var res []string
+	for path, pi := range d.Paths {
+		for method := range pi.operations() {
+			for _, base := range d.basePaths(pi) {
+				res = append(res, method+" "+base+path)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Find returns the route of the operation of d matching method and path, false if there is none. A trailing
+// slash of path is ignored
+func (d *Document) Find(method, path string) (Route, bool) {
+	path = strings.TrimSuffix(path, "/")
+	for template, pi := range d.Paths {
+		op := pi.operations()[method]
+		if op == nil {
+			continue
+		}
+		for _, base := range d.basePaths(pi) {
+			rest, ok := strings.CutPrefix(path, base)
+			if !ok {
+				continue
+			}
+			if params, ok := matchTemplate(strings.TrimSuffix(template, "/"), rest); ok {
+				return Route{
+					Method:     method,
+					Path:       template,
+					Operation:  op,
+					PathParams: params,
+					parameters: mergeParameters(pi.Parameters, op.Parameters),
+				}, true
+			}
+		}
+	}
+	return Route{}, false
+}
+
+// matchTemplate matches path against a path template, returning the values of its parameters
+func matchTemplate(template, path string) (map[string]string, bool) {
+	templateSegments := strings.Split(template, "/")
+	pathSegments := strings.Split(path, "/")
+	if len(templateSegments) != len(pathSegments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, segment := range templateSegments {
+		if name, ok := strings.CutPrefix(segment, "{"); ok && strings.HasSuffix(name, "}") {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			params[strings.TrimSuffix(name, "}")] = pathSegments[i]
+			continue
+		}
+		if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// mergeParameters returns the parameters of a path item overridden by the ones of its operation
+func mergeParameters(pathParams, opParams []*Parameter) []*Parameter {
+	res := append([]*Parameter{}, opParams...)
+	for _, p := range pathParams {
+		overridden := false
+		for _, op := range opParams {
+			overridden = overridden || (op.Name == p.Name && op.In == p.In)
+		}
+		if !overridden {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// response returns the response of op documented for status: the exact status, its range (e.g. 4XX) or the
+// default response
+func (op *Operation) response(status int) (*Response, bool) {
+	code := strconv.Itoa(status)
+	for _, key := range []string{code, code[:1] + "XX", "default"} {
+		if resp, ok := op.Responses[key]; ok {
+			return resp, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,250 @@
+package openapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	fizzbuzzrest "github.com/peano88/fizzbuzz-rest"
+	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDocument = `
+openapi: 3.0.0
+servers:
+  - url: http://localhost/api
+paths:
+  /items/{id}:
+    parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+          minimum: 1
+    put:
+      parameters:
+        - $ref: '#/components/parameters/mode'
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/item'
+      responses:
+        '200':
+          description: the item
+          headers:
+            ETag:
+              required: true
+              schema:
+                type: string
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/item'
+        4XX:
+          description: error
+  /ping:
+    servers:
+      - url: /
+    get:
+      responses:
+        default:
+          description: pong
+components:
+  parameters:
+    mode:
+      name: mode
+      in: query
+      schema:
+        type: string
+        enum: [fast, safe]
+  schemas:
+    item:
+      type: object
+      additionalProperties: false
+      required: [ID, Name, Tags]
+      properties:
+        ID:
+          type: integer
+          format: int8
+          readOnly: true
+        Name:
+          type: string
+          pattern: '^[a-z]+$'
+        Tags:
+          type: array
+          maxItems: 2
+          items:
+            type: string
+            minLength: 1
+`
+
+func TestLoad(t *testing.T) {
+	doc, err := Load([]byte(testDocument))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GET /ping", "PUT /api/items/{id}"}, doc.Operations())
+	require.NotNil(t, doc.Paths["/items/{id}"].Put.RequestBody.Content["application/json"].Schema)
+	assert.False(t, doc.Paths["/items/{id}"].Put.RequestBody.Content["application/json"].Schema.AdditionalProperties.Allowed)
+
+	_, err = Load([]byte("paths:\n  /x:\n    get:\n      $ref: '#/components/missing'\n"))
+	assert.ErrorContains(t, err, "unresolved reference #/components/missing")
+	_, err = Load([]byte("components:\n  schemas:\n    a:\n      $ref: '#/components/schemas/a'\n"))
+	assert.ErrorContains(t, err, "circular reference")
+
+	// the embedded document of the api is valid
+	_, err = Load(fizzbuzzrest.OpenAPI)
+	assert.NoError(t, err)
+}
+
+func TestFind(t *testing.T) {
+	doc, err := Load([]byte(testDocument))
+	require.NoError(t, err)
+
+	route, ok := doc.Find(http.MethodPut, "/api/items/7/")
+	require.True(t, ok)
+	assert.Equal(t, "/items/{id}", route.Path)
+	assert.Equal(t, map[string]string{"id": "7"}, route.PathParams)
+
+	_, ok = doc.Find(http.MethodGet, "/ping")
+	assert.True(t, ok)
+	_, ok = doc.Find(http.MethodGet, "/api/items/7")
+	assert.False(t, ok)
+	_, ok = doc.Find(http.MethodPut, "/items/7")
+	assert.False(t, ok)
+	_, ok = doc.Find(http.MethodPut, "/api/items/")
+	assert.False(t, ok)
+}
+
+func TestValidateRequest(t *testing.T) {
+	doc, err := Load([]byte(testDocument))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name       string
+		target     string
+		body       string
+		parameters []string
+		details    []string
+	}{
+		{"valid", "/api/items/7?mode=fast", `{"Name":"abc","Tags":["x"]}`, nil, nil},
+		{"path", "/api/items/0", `{"Name":"abc","Tags":[]}`, []string{"id"}, []string{"id: 0 is lower than 1"}},
+		{"query", "/api/items/x?mode=slow", `{"Name":"abc","Tags":[]}`, []string{"mode", "id"}, []string{
+			"mode: slow is not one of fast, safe",
+			"id: x is not an integer",
+		}},
+		{"body", "/api/items/7", `{"ID":3,"Name":"ABC","Tags":["", "b", "c"],"Color":"red"}`, []string{"Color", "Name", "Tags"}, []string{
+			"unknown parameter: Color",
+			"Name: ABC does not match ^[a-z]+$",
+			"Tags: 3 items are more than 2",
+		}},
+		{"missing", "/api/items/7", `{"Tags":[1]}`, []string{"Name", "Tags[0]"}, []string{
+			"missing mandatory parameter: Name",
+			"Tags[0] is not of type string",
+		}},
+		{"type", "/api/items/7", `[]`, []string{"body"}, []string{"body is not of type object"}},
+		{"empty", "/api/items/7", ``, []string{"body"}, []string{"missing mandatory parameter: body"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, tc.target, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+			route, ok := doc.Find(req.Method, req.URL.Path)
+			require.True(t, ok)
+
+			err := route.ValidateRequest(req)
+			if tc.parameters == nil {
+				assert.NoError(t, err)
+				return
+			}
+			errs := validation.Errors(err)
+			require.Len(t, errs, len(tc.parameters))
+			for i, valErr := range errs {
+				assert.Equal(t, tc.parameters[i], valErr.Parameter())
+				assert.Equal(t, tc.details[i], valErr.Unwrap().Error())
+				assert.NotEmpty(t, valErr.Constraint())
+			}
+		})
+	}
+
+	// the body can be read again
+	req := httptest.NewRequest(http.MethodPut, "/api/items/7", strings.NewReader(`{"Name":"abc","Tags":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+	route, _ := doc.Find(req.Method, req.URL.Path)
+	require.NoError(t, route.ValidateRequest(req))
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"Name":"abc","Tags":[]}`, string(body))
+
+	// only the documented media types are accepted
+	req = httptest.NewRequest(http.MethodPut, "/api/items/7", strings.NewReader(`name=abc`))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	errs := validation.Errors(route.ValidateRequest(req))
+	require.Len(t, errs, 1)
+	assert.Equal(t, "Content-Type", errs[0].Parameter())
+
+	// a malformed body is not a violation
+	req = httptest.NewRequest(http.MethodPut, "/api/items/7", strings.NewReader(`{`))
+	req.Header.Set("Content-Type", "application/json")
+	err = route.ValidateRequest(req)
+	assert.Error(t, err)
+	assert.Empty(t, validation.Errors(err))
+}
+
+func TestValidateResponse(t *testing.T) {
+	doc, err := Load([]byte(testDocument))
+	require.NoError(t, err)
+	route, ok := doc.Find(http.MethodPut, "/api/items/7")
+	require.True(t, ok)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("ETag", `"7"`)
+	assert.NoError(t, route.ValidateResponse(http.StatusOK, header, []byte(`{"ID":7,"Name":"abc","Tags":[]}`)))
+	// the range of the status is documented
+	assert.NoError(t, route.ValidateResponse(http.StatusNotFound, header, []byte(`{"whatever":true}`)))
+	assert.ErrorContains(t, route.ValidateResponse(http.StatusInternalServerError, header, nil), "undocumented response status 500")
+
+	// the readOnly properties are required in the responses, within the bounds of their format
+	errs := validation.Errors(route.ValidateResponse(http.StatusOK, header, []byte(`{"Name":"abc","Tags":[]}`)))
+	require.Len(t, errs, 1)
+	assert.Equal(t, "ID", errs[0].Parameter())
+	errs = validation.Errors(route.ValidateResponse(http.StatusOK, header, []byte(`{"ID":300,"Name":"abc","Tags":[]}`)))
+	require.Len(t, errs, 1)
+	assert.Equal(t, "ID: 300 is greater than 127", errs[0].Unwrap().Error())
+	assert.Equal(t, "ID should be an integer between -128 and 127", errs[0].Constraint())
+
+	header.Del("ETag")
+	errs = validation.Errors(route.ValidateResponse(http.StatusOK, header, []byte(`{"ID":7,"Name":"abc","Tags":[]}`)))
+	require.Len(t, errs, 1)
+	assert.Equal(t, "ETag", errs[0].Parameter())
+}
+
+func TestPublish(t *testing.T) {
+	published, err := Publish([]byte("openapi: 3.0.0\ninfo:\n  title: test\n  version: 0.0.0\nservers:\n  - url: /api\n  - url: https://example.org/api\npaths:\n  /ping:\n    servers:\n      - url: /\n"), "1.2.3")
+	require.NoError(t, err)
+
+	content, err := published.JSON("https://example.com/")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"openapi": "3.0.0",
+		"info": {"title": "test", "version": "1.2.3"},
+		"servers": [{"url": "https://example.com/api"}, {"url": "https://example.org/api"}],
+		"paths": {"/ping": {"servers": [{"url": "https://example.com/"}]}}
+	}`, string(content))
+
+	// the document is left untouched by a publication
+	content, err = published.YAML("")
+	require.NoError(t, err)
+	doc, err := Load(content)
+	require.NoError(t, err)
+	assert.Equal(t, "/api", doc.Servers[0].URL)
+	assert.Contains(t, string(content), "version: 1.2.3")
+
+	_, err = Publish([]byte("openapi: 3.0.0\n"), "1.2.3")
+	assert.Error(t, err)
+}
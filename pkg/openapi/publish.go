@@ -0,0 +1,111 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Published is an OpenAPI document as served to the clients, stamped with the version of the server
+type Published struct {
+	root yaml.Node
+}
+
+// Publish parses the OpenAPI document content, in YAML or JSON, and sets its info.version to version
+func Publish(content []byte, version string) (*Published, error) {
+	var p Published
+	if err := yaml.Unmarshal(content, &p.root); err != nil {
+		return nil, fmt.Errorf("error parsing OpenAPI document: %w", err)
+	}
+	if len(p.root.Content) == 0 || p.root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("error parsing OpenAPI document: not an object")
+	}
+	info := mappingValue(p.root.Content[0], "info")
+	if info == nil {
+		return nil, fmt.Errorf("error parsing OpenAPI document: no info")
+	}
+	setMappingValue(info, "version", version)
+	return &p, nil
+}
+
+// YAML returns the document in YAML, its relative server URLs made absolute with serverURL, e.g.
+// https://example.com, if not empty
+func (p *Published) YAML(serverURL string) ([]byte, error) {
+	return yaml.Marshal(p.withServer(serverURL))
+}
+
+// JSON returns the document in JSON, its relative server URLs made absolute with serverURL, e.g.
+// https://example.com, if not empty
+func (p *Published) JSON(serverURL string) ([]byte, error) {
+	var document any
+	if err := p.withServer(serverURL).Decode(&document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+}
+
+// withServer returns a copy of the document, prefixing its relative server URLs with serverURL
+func (p *Published) withServer(serverURL string) *yaml.Node {
+	root := copyNode(&p.root)
+	if serverURL == "" {
+		return root
+	}
+	serverURL = strings.TrimSuffix(serverURL, "/")
+	var fill func(node *yaml.Node)
+	fill = func(node *yaml.Node) {
+		if node.Kind != yaml.MappingNode && node.Kind != yaml.DocumentNode && node.Kind != yaml.SequenceNode {
+			return
+		}
+		if node.Kind == yaml.MappingNode {
+			if servers := mappingValue(node, "servers"); servers != nil && servers.Kind == yaml.SequenceNode {
+				for _, server := range servers.Content {
+					if url := mappingValue(server, "url"); url != nil && strings.HasPrefix(url.Value, "/") {
+						url.Value = serverURL + url.Value
+					}
+				}
+			}
+		}
+		for _, child := range node.Content {
+			fill(child)
+		}
+	}
+	fill(root)
+	return root
+}
+
+// mappingValue returns the value of key in the mapping node, nil if none
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets the scalar value of key in the mapping node, adding key if needed
+func setMappingValue(node *yaml.Node, key, value string) {
+	if v := mappingValue(node, key); v != nil {
+		v.SetString(value)
+		return
+	}
+	k := &yaml.Node{}
+	k.SetString(key)
+	v := &yaml.Node{}
+	v.SetString(value)
+	node.Content = append(node.Content, k, v)
+}
+
+func copyNode(node *yaml.Node) *yaml.Node {
+	res := *node
+	res.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		res.Content[i] = copyNode(child)
+	}
+	return &res
+}
@@ -0,0 +1,332 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
+	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is an OpenAPI 3.0 schema object, restricted to the validation keywords used by the api
+type Schema struct {
+	Type             string             `yaml:"type"`
+	Format           string             `yaml:"format"`
+	Enum             []any              `yaml:"enum"`
+	Nullable         bool               `yaml:"nullable"`
+	Minimum          *float64           `yaml:"minimum"`
+	Maximum          *float64           `yaml:"maximum"`
+	ExclusiveMinimum bool               `yaml:"exclusiveMinimum"`
+	ExclusiveMaximum bool               `yaml:"exclusiveMaximum"`
+	MinLength        *int               `yaml:"minLength"`
+	MaxLength        *int               `yaml:"maxLength"`
+	Pattern          string             `yaml:"pattern"`
+	Items            *Schema            `yaml:"items"`
+	MinItems         *int               `yaml:"minItems"`
+	MaxItems         *int               `yaml:"maxItems"`
+	Required         []string           `yaml:"required"`
+	Properties       map[string]*Schema `yaml:"properties"`
+	// nil if any additional property is allowed
+	AdditionalProperties *Additional `yaml:"additionalProperties"`
+	// the property is only expected in the responses, respectively in the requests
+	ReadOnly  bool `yaml:"readOnly"`
+	WriteOnly bool `yaml:"writeOnly"`
+}
+
+// Additional is the additionalProperties keyword, either a boolean or a schema
+type Additional struct {
+	Allowed bool
+	// schema of the additional properties, nil if they are not constrained
+	Schema *Schema
+}
+
+// UnmarshalYAML is the yaml.Unmarshaler interface implementation
+func (a *Additional) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&a.Allowed)
+	}
+	a.Allowed = true
+	return node.Decode(&a.Schema)
+}
+
+// direction of a validation: the required readOnly properties are not expected in the requests, nor the writeOnly
+// ones in the responses
+type direction int
+
+const (
+	inRequest direction = iota
+	inResponse
+)
+
+// bounds of the integer formats
+var integerFormats = map[string][2]int64{
+	"int8":  {math.MinInt8, math.MaxInt8},
+	"int32": {math.MinInt32, math.MaxInt32},
+	"int64": {math.MinInt64, math.MaxInt64},
+}
+
+// patterns caches the compiled patterns of the schemas
+var patterns sync.Map
+
+// validate returns a ValidationError, on param, for every violation of s by value, which is decoded from JSON with
+// json.Decoder.UseNumber
+func (s *Schema) validate(param string, value any, dir direction) validation.ValidationErrors {
+	if s == nil {
+		return nil
+	}
+	// the root of a body is named body
+	label := param
+	if label == "" {
+		label = "body"
+	}
+	if value == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return s.violation(label, i18n.NewError("validation.wrong_type", "param", label, "type", s.Type))
+	}
+
+	var errs validation.ValidationErrors
+	switch s.Type {
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return s.violation(label, i18n.NewError("validation.wrong_type", "param", label, "type", s.Type))
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return s.violation(label, i18n.NewError("validation.not_integer", "param", label, "value", n))
+		}
+		min, max := s.integerBounds()
+		if i < min {
+			return s.violation(label, i18n.NewError("validation.integer_below", "param", label, "value", i, "min", min))
+		}
+		if i > max {
+			return s.violation(label, i18n.NewError("validation.integer_exceeded", "param", label, "value", i, "max", max))
+		}
+	case "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			return s.violation(label, i18n.NewError("validation.wrong_type", "param", label, "type", s.Type))
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return s.violation(label, i18n.NewError("validation.not_number", "param", label, "value", n))
+		}
+		if s.Minimum != nil && (f < *s.Minimum || (s.ExclusiveMinimum && f == *s.Minimum)) {
+			return s.violation(label, i18n.NewError("validation.integer_below", "param", label, "value", n, "min", *s.Minimum))
+		}
+		if s.Maximum != nil && (f > *s.Maximum || (s.ExclusiveMaximum && f == *s.Maximum)) {
+			return s.violation(label, i18n.NewError("validation.integer_exceeded", "param", label, "value", n, "max", *s.Maximum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return s.violation(label, i18n.NewError("validation.wrong_type", "param", label, "type", s.Type))
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return s.violation(label, i18n.NewError("validation.wrong_type", "param", label, "type", s.Type))
+		}
+		if err := s.validateString(param, str); err != nil {
+			return s.violation(label, err)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return s.violation(label, i18n.NewError("validation.wrong_type", "param", label, "type", s.Type))
+		}
+		if s.MinItems != nil && len(items) < *s.MinItems {
+			return s.violation(label, i18n.NewError("validation.too_few_items", "param", label, "count", len(items), "min", *s.MinItems))
+		}
+		if s.MaxItems != nil && len(items) > *s.MaxItems {
+			return s.violation(label, i18n.NewError("validation.too_many_items", "param", label, "count", len(items), "max", *s.MaxItems))
+		}
+		for i, item := range items {
+			errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", param, i), item, dir)...)
+		}
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return s.violation(label, i18n.NewError("validation.wrong_type", "param", label, "type", s.Type))
+		}
+		errs = append(errs, s.validateObject(param, object, dir)...)
+	}
+
+	if len(s.Enum) > 0 && !s.allows(value) {
+		errs = append(errs, s.violation(label, i18n.NewError("validation.not_enum", "param", label, "value", value, "values", s.enumValues()))...)
+	}
+	return errs
+}
+
+// validateString returns the first violation of s by the string value of param, nil if none
+func (s *Schema) validateString(param, value string) error {
+	length := utf8.RuneCountInString(value)
+	if s.MinLength != nil && length < *s.MinLength {
+		return i18n.NewError("validation.too_short", "param", param, "length", length, "min", *s.MinLength)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		return i18n.NewError("validation.too_long", "param", param, "length", length, "max", *s.MaxLength)
+	}
+	if s.Pattern != "" && !compiledPattern(s.Pattern).MatchString(value) {
+		return i18n.NewError("validation.pattern", "param", param, "value", value, "pattern", s.Pattern)
+	}
+	if s.Format == "date-time" {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return i18n.NewError("validation.format", "param", param, "value", value, "format", s.Format)
+		}
+	}
+	return nil
+}
+
+// validateObject returns the violations of s by the properties of object
+func (s *Schema) validateObject(param string, object map[string]any, dir direction) validation.ValidationErrors {
+	var errs validation.ValidationErrors
+	for _, name := range s.Required {
+		property := s.Properties[name]
+		if property != nil && ((dir == inRequest && property.ReadOnly) || (dir == inResponse && property.WriteOnly)) {
+			continue
+		}
+		if _, ok := object[name]; !ok {
+			path := joinPath(param, name)
+			errs = append(errs, property.violation(path, i18n.NewError("validation.missing", "param", path))...)
+		}
+	}
+	for _, name := range sortedKeys(object) {
+		path := joinPath(param, name)
+		if property, ok := s.Properties[name]; ok {
+			errs = append(errs, property.validate(path, object[name], dir)...)
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			continue
+		}
+		if !s.AdditionalProperties.Allowed {
+			errs = append(errs, (*Schema)(nil).violation(path, i18n.NewError("validation.unknown", "param", path))...)
+			continue
+		}
+		errs = append(errs, s.AdditionalProperties.Schema.validate(path, object[name], dir)...)
+	}
+	return errs
+}
+
+// parse converts the raw value of a parameter to the type of s, as if decoded from JSON
+func (s *Schema) parse(raw string) any {
+	if s == nil {
+		return raw
+	}
+	switch s.Type {
+	case "integer", "number":
+		return json.Number(raw)
+	case "boolean":
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	return raw
+}
+
+// integerBounds returns the bounds of an integer schema, given its format, minimum and maximum
+func (s *Schema) integerBounds() (int64, int64) {
+	bounds, ok := integerFormats[s.Format]
+	if !ok {
+		bounds = integerFormats["int64"]
+	}
+	min, max := bounds[0], bounds[1]
+	if s.Minimum != nil {
+		bound := int64(math.Ceil(*s.Minimum))
+		if s.ExclusiveMinimum && float64(bound) == *s.Minimum {
+			bound++
+		}
+		if bound > min {
+			min = bound
+		}
+	}
+	if s.Maximum != nil {
+		bound := int64(math.Floor(*s.Maximum))
+		if s.ExclusiveMaximum && float64(bound) == *s.Maximum {
+			bound--
+		}
+		if bound < max {
+			max = bound
+		}
+	}
+	return min, max
+}
+
+// constraint returns the localizable constraint of s on param
+func (s *Schema) constraint(param string) i18n.Message {
+	switch {
+	case s == nil:
+		return i18n.New("constraint.unknown", "param", param)
+	case len(s.Enum) > 0:
+		return i18n.New("constraint.enum", "param", param, "values", s.enumValues())
+	case s.Type == "integer":
+		min, max := s.integerBounds()
+		return i18n.New("constraint.integer", "param", param, "min", min, "max", max)
+	case s.Type == "string" && s.Pattern != "":
+		return i18n.New("constraint.pattern", "param", param, "pattern", s.Pattern)
+	}
+	return i18n.New("constraint.type", "param", param, "type", s.Type)
+}
+
+// violation returns the ValidationError of param violating s because of err
+func (s *Schema) violation(param string, err error) validation.ValidationErrors {
+	return validation.ValidationErrors{validation.NewValidationError(param, err, s.constraint(param))}
+}
+
+// allows reports whether value is one of the enum of s
+func (s *Schema) allows(value any) bool {
+	for _, allowed := range s.Enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) enumValues() string {
+	values := make([]string, 0, len(s.Enum))
+	for _, allowed := range s.Enum {
+		values = append(values, fmt.Sprint(allowed))
+	}
+	return strings.Join(values, ", ")
+}
+
+func compiledPattern(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		// an invalid pattern can't be matched
+		re = regexp.MustCompile(`^\b$`)
+	}
+	patterns.Store(pattern, re)
+	return re
+}
+
+func joinPath(param, name string) string {
+	if param == "" {
+		return name
+	}
+	return param + "." + name
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,145 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
+	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+)
+
+// ValidateRequest validates the parameters and the JSON body of r against the route, returning a
+// validation.ValidationErrors listing every violation, nil if none. The body of r can be read again afterwards
+func (rt Route) ValidateRequest(r *http.Request) error {
+	var errs validation.ValidationErrors
+	query := r.URL.Query()
+	for _, p := range rt.parameters {
+		var raw string
+		var present bool
+		switch p.In {
+		case "query":
+			present = query.Has(p.Name)
+			raw = query.Get(p.Name)
+		case "path":
+			raw, present = rt.PathParams[p.Name]
+		case "header":
+			raw = r.Header.Get(p.Name)
+			present = raw != ""
+		default:
+			continue
+		}
+		if !present {
+			if p.Required {
+				errs = append(errs, p.Schema.violation(p.Name, i18n.NewError("validation.missing", "param", p.Name))...)
+			}
+			continue
+		}
+		errs = append(errs, p.Schema.validate(p.Name, p.Schema.parse(raw), inRequest)...)
+	}
+
+	if body := rt.Operation.RequestBody; body != nil {
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(content))
+		bodyErrs, err := validateContent(body.Content, r.Header.Get("Content-Type"), content, body.Required, inRequest)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, bodyErrs...)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateResponse validates a response of the route, given its status, headers and body: the status has to be
+// documented, the required headers present and the body valid against the schema of its media type. It returns a
+// validation.ValidationErrors listing every violation, nil if none
+func (rt Route) ValidateResponse(status int, header http.Header, body []byte) error {
+	resp, ok := rt.Operation.response(status)
+	if !ok {
+		return fmt.Errorf("%s %s: undocumented response status %d", rt.Method, rt.Path, status)
+	}
+	var errs validation.ValidationErrors
+	for _, name := range sortedHeaders(resp.Headers) {
+		h := resp.Headers[name]
+		raw := header.Get(name)
+		if raw == "" {
+			if h.Required {
+				errs = append(errs, h.Schema.violation(name, i18n.NewError("validation.missing", "param", name))...)
+			}
+			continue
+		}
+		errs = append(errs, h.Schema.validate(name, h.Schema.parse(raw), inResponse)...)
+	}
+	bodyErrs, err := validateContent(resp.Content, header.Get("Content-Type"), body, false, inResponse)
+	if err != nil {
+		return err
+	}
+	errs = append(errs, bodyErrs...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateContent validates body against the schema of its media type in content. An empty body is only a
+// violation if required; a body with an undocumented media type always is, unless contentType is empty and a
+// single media type is documented
+func validateContent(content map[string]*MediaType, contentType string, body []byte, required bool, dir direction) (validation.ValidationErrors, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		if required {
+			return (*Schema)(nil).violation("body", i18n.NewError("validation.missing", "param", "body")), nil
+		}
+		return nil, nil
+	}
+	if len(content) == 0 {
+		return nil, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if contentType == "" && len(content) == 1 {
+		// a body without media type is assumed to be of the only documented one
+		for name := range content {
+			mediaType = name
+		}
+	}
+	mt, ok := content[mediaType]
+	if !ok {
+		values := make([]string, 0, len(content))
+		for name := range content {
+			values = append(values, name)
+		}
+		sort.Strings(values)
+		err := i18n.NewError("validation.media_type", "value", contentType, "values", strings.Join(values, ", "))
+		return (*Schema)(nil).violation("Content-Type", err), nil
+	}
+	if mt.Schema == nil || !strings.HasSuffix(mediaType, "json") {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("error decoding %s body: %w", mediaType, err)
+	}
+	return mt.Schema.validate("", value, dir), nil
+}
+
+func sortedHeaders(headers map[string]*Header) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// interval between two removals of the idle buckets
+	sweepInterval = time.Minute
+)
+
+// bucket is the budget of a client
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// day of used, in days since the epoch
+	day  int64
+	used int64
+}
+
+// MemoryLimiter is a Limiter keeping the budgets in memory, enforced by a single server instance. The buckets of
+// the idle clients are removed once full, unless they hold the usage of the current day
+type MemoryLimiter struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryLimiter returns a MemoryLimiter without any budget taken
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Take is the Limiter interface implementation
+func (ml *MemoryLimiter) Take(ctx context.Context, key string, cost int64, policy Policy) (Decision, error) {
+	cost = policy.cost(cost)
+	if d, ok := policy.exceeds(cost); ok {
+		return d, nil
+	}
+	now := ml.now()
+
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if now.Sub(ml.lastSweep) >= sweepInterval {
+		ml.sweep(now, policy)
+	}
+
+	b, ok := ml.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(policy.Burst), last: now}
+		ml.buckets[key] = b
+	}
+	today := b.refill(now, policy)
+
+	allowed := b.tokens >= float64(cost) && (policy.DailyQuota == 0 || b.used+cost <= policy.DailyQuota)
+	if allowed {
+		b.tokens -= float64(cost)
+		b.used += cost
+	}
+
+	return decide(policy, cost, allowed, state{
+		tokens:  b.tokens,
+		used:    b.used,
+		elapsed: now.Sub(time.Unix(today*int64(day/time.Second), 0)),
+	}), nil
+}
+
+// refill adds the tokens earned since the last Take, resets the usage of a past day and returns the current day
+func (b *bucket) refill(now time.Time, policy Policy) int64 {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(policy.Burst), b.tokens+elapsed*policy.Rate)
+		b.last = now
+	}
+	// the burst may have been lowered by a configuration reload
+	b.tokens = math.Min(float64(policy.Burst), b.tokens)
+
+	today := now.Unix() / int64(day/time.Second)
+	if b.day != today {
+		b.day = today
+		b.used = 0
+	}
+	return today
+}
+
+// sweep removes the buckets which are full and don't hold any usage of the current day to be enforced
+func (ml *MemoryLimiter) sweep(now time.Time, policy Policy) {
+	ml.lastSweep = now
+	for key, b := range ml.buckets {
+		b.refill(now, policy)
+		if b.tokens >= float64(policy.Burst) && (b.used == 0 || policy.DailyQuota == 0) {
+			delete(ml.buckets, key)
+		}
+	}
+}
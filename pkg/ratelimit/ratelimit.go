@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+const (
+	// ReasonRate is the reason of a request denied because the bucket of the client doesn't hold enough tokens
+	ReasonRate = "rate"
+	// ReasonQuota is the reason of a request denied because the daily quota of the client is exhausted
+	ReasonQuota = "quota"
+	// ReasonCost is the reason of a request denied because it costs more than the capacity of the bucket, so that it
+	// would never be allowed
+	ReasonCost = "cost"
+
+	day = 24 * time.Hour
+)
+
+// Policy is the budget granted to each client. The cost of a request is taken from a token bucket holding at most
+// Burst tokens and refilled at Rate tokens per second; the tokens taken along a day (UTC) can be limited as well
+type Policy struct {
+	// tokens added to the bucket per second
+	Rate float64
+	// capacity of the bucket
+	Burst int64
+	// maximum number of tokens taken along a day, 0 if unlimited
+	DailyQuota int64
+}
+
+// cost returns the cost actually taken for a request of the provided cost, at least one token
+func (p Policy) cost(cost int64) int64 {
+	if cost < 1 {
+		return 1
+	}
+	return cost
+}
+
+// exceeds returns the Decision denying a request of cost, and true, if it costs more than the capacity of the
+// bucket: such a request is denied without reading nor taking the budget of its client, as it would never be allowed
+func (p Policy) exceeds(cost int64) (Decision, bool) {
+	if cost <= p.Burst {
+		return Decision{}, false
+	}
+	return Decision{Reason: ReasonCost, Limit: p.Burst}, true
+}
+
+// Decision is the outcome of a Limiter Take. Limit, Remaining and Reset describe the most restrictive of the bucket
+// and the daily quota, as expected by the RateLimit headers
+type Decision struct {
+	Allowed bool
+	// ReasonRate, ReasonQuota or ReasonCost if the request is denied
+	Reason string
+	// capacity of the bucket or daily quota
+	Limit int64
+	// tokens left in the bucket or in the daily quota
+	Remaining int64
+	// delay before the bucket is full again, or before the daily quota is reset
+	Reset time.Duration
+	// delay before a request of the same cost can be allowed, zero if allowed
+	RetryAfter time.Duration
+}
+
+// Err returns nil if the request is allowed, an error of type Exceeded otherwise
+func (d Decision) Err() error {
+	if d.Allowed {
+		return nil
+	}
+	return Exceeded{reason: d.Reason}
+}
+
+// Exceeded indicates that a request is denied because it exceeds the budget of its client
+type Exceeded struct {
+	reason string
+}
+
+// Error is the error interface implementation
+func (e Exceeded) Error() string {
+	return "request rate limited: " + e.reason
+}
+
+// Is allows errors.Is(err, Exceeded{}) regardless of the reason
+func (e Exceeded) Is(target error) bool {
+	_, ok := target.(Exceeded)
+	return ok
+}
+
+// Reason returns ReasonRate, ReasonQuota or ReasonCost
+func (e Exceeded) Reason() string {
+	return e.reason
+}
+
+// Limiter takes the cost of the requests from the budget of the clients
+type Limiter interface {
+	// Take takes cost tokens from the budget of the client identified by key, according to policy. If the budget
+	// doesn't allow the cost, nothing is taken and the request should be denied
+	Take(ctx context.Context, key string, cost int64, policy Policy) (Decision, error)
+}
+
+// state is the budget of a client after a Take
+type state struct {
+	// tokens left in the bucket
+	tokens float64
+	// tokens taken along the current day
+	used int64
+	// elapsed time of the current day
+	elapsed time.Duration
+}
+
+// decide returns the Decision of a request of cost, allowed or not, leading to s
+func decide(p Policy, cost int64, allowed bool, s state) Decision {
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     p.Burst,
+		Remaining: int64(math.Floor(s.tokens)),
+		Reset:     seconds((float64(p.Burst) - s.tokens) / p.Rate),
+	}
+	quotaLeft := p.DailyQuota - s.used
+	if p.DailyQuota > 0 && quotaLeft < d.Remaining {
+		d.Limit = p.DailyQuota
+		d.Remaining = quotaLeft
+		d.Reset = day - s.elapsed
+	}
+	if allowed {
+		return d
+	}
+
+	if float64(cost) > s.tokens {
+		d.Reason = ReasonRate
+		d.RetryAfter = seconds((float64(cost) - s.tokens) / p.Rate)
+	}
+	if p.DailyQuota > 0 && cost > quotaLeft {
+		d.Reason = ReasonQuota
+		d.RetryAfter = day - s.elapsed
+	}
+	return d
+}
+
+func seconds(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimiter_Bucket(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	ml := NewMemoryLimiter()
+	ml.now = func() time.Time { return now }
+	policy := Policy{Rate: 2, Burst: 10}
+
+	d, err := ml.Take(context.Background(), "a", 4, policy)
+	require.NoError(t, err)
+	assert.Equal(t, Decision{Allowed: true, Limit: 10, Remaining: 6, Reset: 2 * time.Second}, d)
+
+	d, err = ml.Take(context.Background(), "a", 7, policy)
+	require.NoError(t, err)
+	assert.Equal(t, Decision{Limit: 10, Remaining: 6, Reset: 2 * time.Second, Reason: ReasonRate, RetryAfter: 500 * time.Millisecond}, d)
+
+	// other clients have their own bucket
+	d, err = ml.Take(context.Background(), "b", 7, policy)
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+
+	// tokens are earned over time, up to the burst
+	now = now.Add(500 * time.Millisecond)
+	d, err = ml.Take(context.Background(), "a", 7, policy)
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, int64(0), d.Remaining)
+
+	now = now.Add(time.Hour)
+	d, err = ml.Take(context.Background(), "a", 1, policy)
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), d.Remaining)
+
+	// a request costing more than the burst is denied, without taking anything
+	d, err = ml.Take(context.Background(), "c", 11, policy)
+	require.NoError(t, err)
+	assert.Equal(t, Decision{Limit: 10, Reason: ReasonCost}, d)
+	assert.Equal(t, Exceeded{reason: ReasonCost}, d.Err())
+	d, err = ml.Take(context.Background(), "c", 10, policy)
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, int64(0), d.Remaining)
+	assert.Equal(t, 5*time.Second, d.Reset)
+}
+
+func TestMemoryLimiter_Quota(t *testing.T) {
+	now := time.Date(2023, 6, 1, 23, 0, 0, 0, time.UTC)
+	ml := NewMemoryLimiter()
+	ml.now = func() time.Time { return now }
+	policy := Policy{Rate: 100, Burst: 10, DailyQuota: 15}
+
+	d, err := ml.Take(context.Background(), "a", 10, policy)
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+	// the bucket is the most restrictive
+	assert.Equal(t, int64(10), d.Limit)
+	assert.Equal(t, int64(0), d.Remaining)
+
+	now = now.Add(time.Second)
+	d, err = ml.Take(context.Background(), "a", 2, policy)
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+	// the quota is the most restrictive
+	assert.Equal(t, Decision{Allowed: true, Limit: 15, Remaining: 3, Reset: time.Hour - time.Second}, d)
+
+	d, err = ml.Take(context.Background(), "a", 4, policy)
+	require.NoError(t, err)
+	assert.Equal(t, Decision{Limit: 15, Remaining: 3, Reset: time.Hour - time.Second, Reason: ReasonQuota, RetryAfter: time.Hour - time.Second}, d)
+	assert.ErrorIs(t, d.Err(), Exceeded{})
+	assert.Equal(t, ReasonQuota, d.Err().(Exceeded).Reason())
+
+	// the quota is reset at midnight UTC
+	now = now.Add(time.Hour)
+	d, err = ml.Take(context.Background(), "a", 4, policy)
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+	assert.NoError(t, d.Err())
+}
+
+func TestMemoryLimiter_Sweep(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	ml := NewMemoryLimiter()
+	ml.now = func() time.Time { return now }
+
+	policy := Policy{Rate: 1, Burst: 10, DailyQuota: 100}
+	_, err := ml.Take(context.Background(), "a", 5, policy)
+	require.NoError(t, err)
+
+	// a is idle and full, but still holds its daily usage
+	now = now.Add(sweepInterval)
+	_, err = ml.Take(context.Background(), "b", 5, policy)
+	require.NoError(t, err)
+	assert.Len(t, ml.buckets, 2)
+
+	now = now.Add(24 * time.Hour)
+	_, err = ml.Take(context.Background(), "c", 1, policy)
+	require.NoError(t, err)
+	assert.Len(t, ml.buckets, 1)
+	assert.Contains(t, ml.buckets, "c")
+
+	// without quota, the usage is not kept
+	now = now.Add(sweepInterval)
+	_, err = ml.Take(context.Background(), "d", 1, Policy{Rate: 1, Burst: 10})
+	require.NoError(t, err)
+	assert.Len(t, ml.buckets, 1)
+	assert.Contains(t, ml.buckets, "d")
+}
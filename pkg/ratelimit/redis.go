@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	rateLimitPrefix = "fizzbuzz:ratelimit:"
+)
+
+// takeScript atomically refills the bucket of a client, stored in a redis hash, and takes the cost if allowed by the
+// bucket and by the daily quota. The redis clock is used, so that the replicas sharing the budgets don't need
+// synchronized clocks. It returns whether the cost was taken, the tokens left, the tokens taken along the current day
+// and the elapsed seconds of the current day
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local quota = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+local today = math.floor(now / 86400)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last', 'day', 'used')
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+local used = tonumber(bucket[4]) or 0
+if tonumber(bucket[3]) ~= today then
+	used = 0
+end
+tokens = math.min(burst, tokens + math.max(0, now - last) * rate)
+
+local allowed = 0
+if tokens >= cost and (quota == 0 or used + cost <= quota) then
+	allowed = 1
+	tokens = tokens - cost
+	used = used + cost
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now), 'day', today, 'used', used)
+local ttl = math.ceil((burst - tokens) / rate)
+if used > 0 then
+	ttl = math.max(ttl, math.ceil((today + 1) * 86400 - now))
+end
+redis.call('EXPIRE', KEYS[1], ttl + 1)
+
+return {allowed, tostring(tokens), used, tostring(now - today * 86400)}
+`)
+
+// RedisLimiter is a Limiter keeping the budgets in the redis DB, so that several server instances enforce a
+// global budget for each client. The budget of a client expires once its bucket is full and its daily usage is reset
+type RedisLimiter struct {
+	rdb *redis.Client
+}
+
+// NewRedisLimiter returns a RedisLimiter using the provided client
+func NewRedisLimiter(rdb *redis.Client) *RedisLimiter {
+	return &RedisLimiter{
+		rdb: rdb,
+	}
+}
+
+// Take is the Limiter interface implementation
+func (rl *RedisLimiter) Take(ctx context.Context, key string, cost int64, policy Policy) (Decision, error) {
+	cost = policy.cost(cost)
+	if d, ok := policy.exceeds(cost); ok {
+		return d, nil
+	}
+	res, err := takeScript.Run(ctx, rl.rdb, []string{rateLimitPrefix + key},
+		strconv.FormatFloat(policy.Rate, 'f', -1, 64), policy.Burst, policy.DailyQuota, cost).Slice()
+	if err != nil {
+		return Decision{}, fmt.Errorf("error taking rate limit budget: %w", err)
+	}
+	if len(res) != 4 {
+		return Decision{}, fmt.Errorf("unexpected rate limit script result: %v", res)
+	}
+
+	allowed, _ := res[0].(int64)
+	used, _ := res[2].(int64)
+	tokens, err := parseFloat(res[1])
+	if err != nil {
+		return Decision{}, err
+	}
+	elapsed, err := parseFloat(res[3])
+	if err != nil {
+		return Decision{}, err
+	}
+
+	return decide(policy, cost, allowed == 1, state{
+		tokens:  tokens,
+		used:    used,
+		elapsed: seconds(elapsed),
+	}), nil
+}
+
+func parseFloat(v interface{}) (float64, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected rate limit script value: %v", v)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected rate limit script value: %w", err)
+	}
+	return f, nil
+}
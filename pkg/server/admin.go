@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/utils"
+)
+
+// ConfigureAdmin will return a configured *http.Server serving the administration endpoints, which are kept
+// apart from the api so that they are not exposed to the api clients. The server listens on cfg.Admin.Address
+// (the loopback by default) and exposes:
+// - /metrics: the server metrics in the Prometheus format
+// - /config/reload: reloads the configuration (POST), if LoadConfig is set
+// - /apikeys: creates (POST), lists (GET) and revokes (DELETE /apikeys/{id}) the API keys, if APIKeys is set
+// When the authentication is enabled, /config/reload and /apikeys require the admin scope (see requireAdmin)
+func (fbs *FizzBuzzServer) ConfigureAdmin(cfg config.Config) (*http.Server, error) {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+
+	r.Method(http.MethodGet, "/metrics", fbs.Metrics.Handler())
+	r.Group(func(r chi.Router) {
+		r.Use(fbs.AuthenticationMiddleware)
+		r.Use(fbs.requireAdmin)
+		if fbs.LoadConfig != nil {
+			r.Post("/config/reload", fbs.PostReloadHandler)
+		}
+		if fbs.APIKeys != nil {
+			r.Route("/apikeys", func(r chi.Router) {
+				r.Post("/", fbs.PostAPIKeyHandler)
+				r.Get("/", fbs.GetAPIKeysHandler)
+				r.Delete("/{id}", fbs.DeleteAPIKeyHandler)
+			})
+		}
+	})
+
+	return &http.Server{
+		Addr:         cfg.Admin.Address,
+		Handler:      r,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}, nil
+}
+
+// requireAdmin is the middleware allowing only the principals granted the admin scope on the administration
+// endpoints, when the authentication is enabled (see RequireScope). A key can be created once without credentials,
+// so that the first admin key of the redis source can be bootstrapped (see bootstrapAPIKeyHandler)
+func (fbs *FizzBuzzServer) requireAdmin(next http.Handler) http.Handler {
+	scoped := fbs.RequireScope(model.ScopeAdmin)(next)
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if _, authenticated := utils.PrincipalFromContext(r.Context()); !authenticated && fbs.authEnabled() &&
+			fbs.APIKeys != nil && r.Method == http.MethodPost && strings.TrimSuffix(r.URL.Path, "/") == "/apikeys" {
+			fbs.bootstrapAPIKeyHandler(rw, r)
+			return
+		}
+		scoped.ServeHTTP(rw, r)
+	})
+}
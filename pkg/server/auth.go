@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/httplog"
+	"github.com/peano88/fizzbuzz-rest/pkg/auth"
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/utils"
+	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+)
+
+const (
+	// reasons of the authentication failures, as recorded in the metrics
+	authFailureMissing   = "missing_credentials"
+	authFailureInvalid   = "invalid_credentials"
+	authFailureForbidden = "insufficient_scope"
+)
+
+// authenticator authenticates the requests carrying a kind of credentials, see auth.APIKeyAuthenticator and
+// auth.JWTAuthenticator
+type authenticator interface {
+	// Authenticate returns the principal of r; the boolean is false if r doesn't carry the credentials
+	Authenticate(r *http.Request) (model.Principal, bool, error)
+}
+
+// authEnabled reports if the requests have to be authenticated
+func (fbs *FizzBuzzServer) authEnabled() bool {
+	live := fbs.live.Load()
+	return live != nil && len(live.authenticators) > 0
+}
+
+// AuthenticationMiddleware is the middleware authenticating the requests carrying an API key (header X-Api-Key) or
+// a JWT bearer token (header Authorization), when enabled by the configuration. The authenticated model.Principal
+// is added to the request context (see utils.PrincipalFromContext) and the key ID or the token subject to the
+// request logs. A request carrying invalid credentials is rejected with 401; a request without credentials is let
+// through, so that the routes not requiring a scope (see RequireScope) are still available
+func (fbs *FizzBuzzServer) AuthenticationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		live := fbs.live.Load()
+		if live == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		for _, authenticator := range live.authenticators {
+			principal, ok, err := authenticator.Authenticate(r)
+			if errors.Is(err, auth.InvalidCredentials{}) {
+				fbs.Metrics.AuthFailure(authFailureInvalid)
+				renderError(rw, r, err)
+				return
+			}
+			if err != nil {
+				renderError(rw, r, operationError{appType: AppErrorTypeAuth, title: "error.credentials", err: err})
+				return
+			}
+			if !ok {
+				continue
+			}
+
+			ctx := context.WithValue(r.Context(), model.PrincipalKey, principal)
+			switch principal.Method {
+			case model.AuthMethodAPIKey:
+				httplog.LogEntrySetField(ctx, "api_key_id", principal.ID)
+			case model.AuthMethodJWT:
+				httplog.LogEntrySetField(ctx, "jwt_subject", principal.ID)
+			}
+			next.ServeHTTP(rw, r.WithContext(ctx))
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// RequireAuthenticated is the middleware serving a route only if its requests are authenticated, either by the API
+// keys or bearer tokens (see AuthenticationMiddleware) or by the client identities restricting its path (see
+// IdentityMiddleware). Otherwise the route is answered with 404, as if it wasn't served, so that it's never open to
+// the anonymous clients
+func (fbs *FizzBuzzServer) RequireAuthenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !fbs.authEnabled() && fbs.identities().allowed(r.URL.Path) == nil {
+			http.NotFound(rw, r)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// RequireScope returns a middleware allowing only the principals granted scope, when the authentication is enabled:
+// a request without credentials is rejected with 401, a principal without the scope with 403
+func (fbs *FizzBuzzServer) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if !fbs.authEnabled() {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			principal, ok := utils.PrincipalFromContext(r.Context())
+			if !ok {
+				fbs.Metrics.AuthFailure(authFailureMissing)
+				renderError(rw, r, unauthenticated{i18n.New("error.authentication_required")})
+				return
+			}
+			if !principal.HasScope(scope) {
+				fbs.Metrics.AuthFailure(authFailureForbidden)
+				renderError(rw, r, forbidden{i18n.New("error.scope_required", "scope", scope)})
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// PostAPIKeyHandler is the handler for the /apikeys administration endpoint under method POST. The request body
+// is a model.APIKey whose Scopes are validated; a new key granting them is generated and stored. The response is the
+// stored key including the whole key, which is not available anymore afterwards
+func (fbs *FizzBuzzServer) PostAPIKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	fbs.createAPIKey(rw, r, func(ctx context.Context, key model.APIKey) error {
+		if err := fbs.APIKeys.SaveAPIKey(ctx, key); err != nil {
+			return apiKeysError(err)
+		}
+		return nil
+	})
+}
+
+// bootstrapAPIKeyHandler is the handler of the unauthenticated requests to the /apikeys administration endpoint
+// under method POST. The key is created as by PostAPIKeyHandler only if no key has ever been stored, atomically so
+// that a single key is bootstrapped by concurrent requests; the authentication is required otherwise
+func (fbs *FizzBuzzServer) bootstrapAPIKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	fbs.createAPIKey(rw, r, func(ctx context.Context, key model.APIKey) error {
+		err := fbs.APIKeys.BootstrapAPIKey(ctx, key)
+		if errors.Is(err, auth.BootstrapClosed{}) {
+			fbs.Metrics.AuthFailure(authFailureMissing)
+			return unauthenticated{i18n.New("error.authentication_required")}
+		}
+		if err != nil {
+			return apiKeysError(err)
+		}
+		return nil
+	})
+}
+
+// createAPIKey generates the key requested by r, stores it by save and answers with it; the error returned by
+// save is rendered as is
+func (fbs *FizzBuzzServer) createAPIKey(rw http.ResponseWriter, r *http.Request, save func(ctx context.Context, key model.APIKey) error) {
+	var request model.APIKey
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		renderError(rw, r, parsingError{err})
+		return
+	}
+
+	if err := validation.ValidateAPIKey(request); err != nil {
+		renderError(rw, r, err)
+		return
+	}
+
+	key, err := auth.GenerateAPIKey(request.Scopes)
+	if err != nil {
+		renderError(rw, r, apiKeysError(err))
+		return
+	}
+	if err := save(r.Context(), key); err != nil {
+		renderError(rw, r, err)
+		return
+	}
+	key.Hash = ""
+
+	respPayload, err := json.Marshal(&key)
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusCreated)
+	rw.Write(respPayload)
+}
+
+// GetAPIKeysHandler is the handler for the /apikeys administration endpoint under method GET. The response is
+// the list of stored keys, without their hash
+func (fbs *FizzBuzzServer) GetAPIKeysHandler(rw http.ResponseWriter, r *http.Request) {
+	keys, err := fbs.APIKeys.APIKeys(r.Context())
+	if err != nil {
+		renderError(rw, r, apiKeysError(err))
+		return
+	}
+	for i := range keys {
+		keys[i].Hash = ""
+	}
+
+	respPayload, err := json.Marshal(&keys)
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(respPayload)
+}
+
+// DeleteAPIKeyHandler is the handler for the /apikeys/{id} administration endpoint under method DELETE. The key
+// is revoked
+func (fbs *FizzBuzzServer) DeleteAPIKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	if err := fbs.APIKeys.DeleteAPIKey(r.Context(), chi.URLParam(r, "id")); err != nil {
+		renderError(rw, r, apiKeysError(err))
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/auth"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/server/mocks"
+	"github.com/peano88/fizzbuzz-rest/pkg/webhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func serveWithKey(handler http.Handler, method, path, key string) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "http://example.com"+path, nil)
+	if key != "" {
+		req.Header.Set(auth.APIKeyHeader, key)
+	}
+	handler.ServeHTTP(resp, req)
+	return resp
+}
+
+func assertAuthError(t *testing.T, resp *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	require.Equal(t, status, resp.Result().StatusCode)
+	var appError model.ApplicationError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&appError))
+	assert.Equal(t, AppErrorTypeAuth, appError.Type)
+}
+
+func TestAPIKeyAuthentication_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+keys:
+  - {id: generator, hash: `+auth.Hash("g")+`, scopes: [fizzbuzz:read]}
+  - {id: reporting, hash: `+auth.Hash("r")+`, scopes: [statistics:read]}
+  - {id: ops, hash: `+auth.Hash("o")+`, scopes: [admin]}
+`), 0o600))
+
+	cfg := config.Default()
+	cfg.Auth.APIKeys = "file"
+	cfg.Auth.APIKeysFile = path
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 3, 5, 15, "fizz", "buzz").Return(int64(1), nil)
+	stats.On("Stats", mock.Anything).Return(model.FizzBuzzStatisticsOutput{}, nil)
+	tbs := FizzBuzzServer{
+		Stats:    stats,
+		Webhooks: webhooks.NewDispatcher(webhooks.NewMemoryStore(), config.Default().Webhooks),
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	fizzbuzz := "/api/v1/fizzbuzz?int1=3&int2=5&limit=15&str1=fizz&str2=buzz"
+	assert.Equal(t, http.StatusOK, serveWithKey(s.Handler, http.MethodGet, fizzbuzz, "generator.g").Result().StatusCode)
+	assert.Equal(t, http.StatusOK, serveWithKey(s.Handler, http.MethodGet, "/api/v1/statistics", "reporting.r").Result().StatusCode)
+	// admin grants every scope
+	assert.Equal(t, http.StatusOK, serveWithKey(s.Handler, http.MethodGet, fizzbuzz, "ops.o").Result().StatusCode)
+	assert.Equal(t, http.StatusOK, serveWithKey(s.Handler, http.MethodGet, "/api/v1/webhooks", "ops.o").Result().StatusCode)
+
+	assertAuthError(t, serveWithKey(s.Handler, http.MethodGet, fizzbuzz, ""), http.StatusUnauthorized)
+	assertAuthError(t, serveWithKey(s.Handler, http.MethodGet, fizzbuzz, "generator.wrong"), http.StatusUnauthorized)
+	assertAuthError(t, serveWithKey(s.Handler, http.MethodGet, "/api/v1/statistics", "generator.g"), http.StatusForbidden)
+	assertAuthError(t, serveWithKey(s.Handler, http.MethodGet, "/api/v1/webhooks", "reporting.r"), http.StatusForbidden)
+	// probes are never authenticated
+	assert.Equal(t, http.StatusOK, serveWithKey(s.Handler, http.MethodGet, "/healthz", "").Result().StatusCode)
+
+	// the keys file is reloaded with the configuration; an invalid one is refused
+	require.NoError(t, os.WriteFile(path, []byte("keys:\n  - {id: generator, hash: "+auth.Hash("g2")+", scopes: [fizzbuzz:read]}\n"), 0o600))
+	_, err = tbs.Reload(cfg)
+	require.NoError(t, err)
+	assertAuthError(t, serveWithKey(s.Handler, http.MethodGet, fizzbuzz, "generator.g"), http.StatusUnauthorized)
+	assert.Equal(t, http.StatusOK, serveWithKey(s.Handler, http.MethodGet, fizzbuzz, "generator.g2").Result().StatusCode)
+
+	require.NoError(t, os.WriteFile(path, []byte("keys: ["), 0o600))
+	_, err = tbs.Reload(cfg)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusOK, serveWithKey(s.Handler, http.MethodGet, fizzbuzz, "generator.g2").Result().StatusCode)
+}
+
+func TestAPIKeyAuthentication_Disabled(t *testing.T) {
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Stats", mock.Anything).Return(model.FizzBuzzStatisticsOutput{}, nil)
+	tbs := FizzBuzzServer{
+		Stats:    stats,
+		Webhooks: webhooks.NewDispatcher(webhooks.NewMemoryStore(), config.Default().Webhooks),
+	}
+	s, err := tbs.Configure(config.Default())
+	require.NoError(t, err)
+
+	// keys are ignored
+	assert.Equal(t, http.StatusOK, serveWithKey(s.Handler, http.MethodGet, "/api/v1/statistics", "").Result().StatusCode)
+	assert.Equal(t, http.StatusOK, serveWithKey(s.Handler, http.MethodGet, "/api/v1/statistics", "boh").Result().StatusCode)
+	// the webhooks are never open to the anonymous clients
+	assert.Equal(t, http.StatusNotFound, serveWithKey(s.Handler, http.MethodGet, "/api/v1/webhooks", "").Result().StatusCode)
+	assert.Equal(t, http.StatusNotFound, serveWithKey(s.Handler, http.MethodPost, "/api/v1/webhooks", "").Result().StatusCode)
+}
+
+func TestAPIKeyHandlers(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.APIKeys = "redis"
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Stats", mock.Anything).Return(model.FizzBuzzStatisticsOutput{}, nil)
+	registry := auth.NewMemoryKeyStore()
+	tbs := FizzBuzzServer{
+		Stats:   stats,
+		APIKeys: registry,
+		LoadConfig: func() (config.Config, error) {
+			return cfg, nil
+		},
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+	admin, err := tbs.ConfigureAdmin(cfg)
+	require.NoError(t, err)
+
+	create := func(body, key string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/apikeys", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(auth.APIKeyHeader, key)
+		}
+		admin.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// the first key is created without credentials, once among concurrent requests
+	responses := make([]*httptest.ResponseRecorder, 8)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = create(`{"Scopes": ["admin"]}`, "")
+		}(i)
+	}
+	wg.Wait()
+	var adminKey model.APIKey
+	for _, resp := range responses {
+		if resp.Result().StatusCode == http.StatusCreated {
+			require.Empty(t, adminKey.Key, "a single key should be bootstrapped")
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&adminKey))
+			continue
+		}
+		assertAuthError(t, resp, http.StatusUnauthorized)
+	}
+	require.NotEmpty(t, adminKey.Key)
+
+	// then the admin scope is required
+	assertAuthError(t, create(`{"Scopes": ["admin"]}`, ""), http.StatusUnauthorized)
+	assertAuthError(t, serveWithKey(admin.Handler, http.MethodGet, "/apikeys", ""), http.StatusUnauthorized)
+	assertAuthError(t, serveWithKey(admin.Handler, http.MethodPost, "/config/reload", ""), http.StatusUnauthorized)
+
+	resp := create(`{"Scopes": ["statistics:read"]}`, adminKey.Key)
+	require.Equal(t, http.StatusCreated, resp.Result().StatusCode)
+	var created model.APIKey
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.NotEmpty(t, created.Key)
+	assert.Empty(t, created.Hash)
+	assert.Equal(t, []string{model.ScopeStatisticsRead}, created.Scopes)
+
+	// the key is usable right away and only its hash is stored
+	assert.Equal(t, http.StatusOK, serveWithKey(s.Handler, http.MethodGet, "/api/v1/statistics", created.Key).Result().StatusCode)
+	stored, err := registry.APIKey(context.Background(), created.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Key)
+	assert.NotEmpty(t, stored.Hash)
+
+	assertAuthError(t, serveWithKey(admin.Handler, http.MethodGet, "/apikeys", created.Key), http.StatusForbidden)
+	assertAuthError(t, serveWithKey(admin.Handler, http.MethodPost, "/config/reload", created.Key), http.StatusForbidden)
+	assert.Equal(t, http.StatusOK, serveWithKey(admin.Handler, http.MethodPost, "/config/reload", adminKey.Key).Result().StatusCode)
+
+	resp = serveWithKey(admin.Handler, http.MethodGet, "/apikeys", adminKey.Key)
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	var keys []model.APIKey
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&keys))
+	assert.ElementsMatch(t, []model.APIKey{{ID: adminKey.ID, Scopes: adminKey.Scopes}, {ID: created.ID, Scopes: created.Scopes}}, keys)
+
+	assert.Equal(t, http.StatusBadRequest, create(`{"Scopes": ["write"]}`, adminKey.Key).Result().StatusCode)
+
+	assert.Equal(t, http.StatusNoContent, serveWithKey(admin.Handler, http.MethodDelete, "/apikeys/"+created.ID, adminKey.Key).Result().StatusCode)
+	assert.Equal(t, http.StatusNotFound, serveWithKey(admin.Handler, http.MethodDelete, "/apikeys/"+created.ID, adminKey.Key).Result().StatusCode)
+	assertAuthError(t, serveWithKey(s.Handler, http.MethodGet, "/api/v1/statistics", created.Key), http.StatusUnauthorized)
+
+	// the bootstrap doesn't reopen once the keys are deleted
+	assert.Equal(t, http.StatusNoContent, serveWithKey(admin.Handler, http.MethodDelete, "/apikeys/"+adminKey.ID, adminKey.Key).Result().StatusCode)
+	assertAuthError(t, create(`{"Scopes": ["admin"]}`, ""), http.StatusUnauthorized)
+}
+
+// writeJWKS writes the key set of a new Ed25519 key in dir, and returns its path and a function signing the tokens
+// of the claims with the key
+func writeJWKS(t *testing.T, dir string) (string, func(claims map[string]any) string) {
+	encode := base64.RawURLEncoding.EncodeToString
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	path := filepath.Join(dir, "jwks.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"keys": [{"kty": "OKP", "crv": "Ed25519", "kid": "k1", "x": "`+encode(pub)+`"}]}`), 0o600))
+
+	return path, func(claims map[string]any) string {
+		payload, err := json.Marshal(claims)
+		require.NoError(t, err)
+		signed := encode([]byte(`{"alg":"EdDSA","kid":"k1"}`)) + "." + encode(payload)
+		return signed + "." + encode(ed25519.Sign(key, []byte(signed)))
+	}
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	cfg := config.Default()
+	path, sign := writeJWKS(t, t.TempDir())
+	cfg.Auth.JWT.JWKS = path
+	cfg.Auth.JWT.Issuer = "https://idp.example.com"
+	cfg.Auth.JWT.Audience = "fizzbuzz"
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Stats", mock.Anything).Return(model.FizzBuzzStatisticsOutput{}, nil)
+	tbs := FizzBuzzServer{
+		Stats: stats,
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	serveWithToken := func(path, token string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		s.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+	token := func(scope string, exp time.Time) string {
+		return sign(map[string]any{
+			"iss":   "https://idp.example.com",
+			"aud":   "fizzbuzz",
+			"sub":   "reporting",
+			"exp":   exp.Unix(),
+			"scope": scope,
+		})
+	}
+
+	assert.Equal(t, http.StatusOK, serveWithToken("/api/v1/statistics", token("statistics:read", time.Now().Add(time.Hour))).Result().StatusCode)
+	assertAuthError(t, serveWithToken("/api/v1/statistics", token("fizzbuzz:read", time.Now().Add(time.Hour))), http.StatusForbidden)
+	assertAuthError(t, serveWithToken("/api/v1/statistics", token("statistics:read", time.Now().Add(-time.Hour))), http.StatusUnauthorized)
+	assertAuthError(t, serveWithToken("/api/v1/statistics", "boh"), http.StatusUnauthorized)
+	assertAuthError(t, serveWithKey(s.Handler, http.MethodGet, "/api/v1/statistics", ""), http.StatusUnauthorized)
+
+	// an unavailable key set is refused
+	cfg.Auth.JWT.JWKS = filepath.Join(t.TempDir(), "missing.json")
+	_, err = tbs.Reload(cfg)
+	assert.Error(t, err)
+	_, err = (&FizzBuzzServer{}).Configure(cfg)
+	assert.Error(t, err)
+}
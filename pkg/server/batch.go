@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/httplog"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/tracing"
+	"github.com/peano88/fizzbuzz-rest/pkg/utils"
+	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// batchItem is an item of a batch of fizzbuzz requests: its validated input parameters, or the error rejecting it
+type batchItem struct {
+	input model.FizzBuzzInput
+	err   error
+}
+
+// batchFromContext returns the items of the batch contained in the context.Context
+func batchFromContext(ctx context.Context) []batchItem {
+	items, _ := ctx.Value(model.BatchKey).([]batchItem)
+	return items
+}
+
+// BatchValidationMiddleware is an HTTP middleware decoding the JSON body of the request, an array of
+// model.FizzBuzzRequest, and validating each item as the body of POST /fizzbuzz: an invalid item is rejected on its
+// own, the other items of the batch being processed anyway. The whole batch is rejected if it can't be decoded, if
+// it includes more than limits.batch.max_items items, if its body is larger than limits.max_body_bytes per item or
+// if its valid items generate more than limits.batch.max_elements elements. It forwards a modified context.Context
+// to the next handler obtained by inserting the items of the batch
+func (fbs *FizzBuzzServer) BatchValidationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), "BatchValidationMiddleware")
+		items, err := fbs.validateBatch(ctx, r)
+		tracing.End(span, err)
+		if err != nil {
+			renderError(rw, r, err)
+			return
+		}
+
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), model.BatchKey, items)))
+	})
+}
+
+// validateBatch decodes and validates within ctx the items of the batch of r, as described by
+// BatchValidationMiddleware. The body can be read again afterwards
+func (fbs *FizzBuzzServer) validateBatch(ctx context.Context, r *http.Request) ([]batchItem, error) {
+	cfg := fbs.batchConfig()
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, parsingError{err}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(content))
+
+	var raws []json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	if err := decoder.Decode(&raws); err != nil {
+		return nil, parsingError{err}
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return nil, parsingError{errors.New("unexpected data after the request body")}
+	}
+	if len(raws) == 0 {
+		return nil, parsingError{errors.New("the batch should include at least one item")}
+	}
+	if len(raws) > cfg.MaxItems {
+		return nil, batchTooLarge{i18n.New("error.batch_items", "max", cfg.MaxItems)}
+	}
+
+	items := make([]batchItem, len(raws))
+	elements := 0
+	for i, raw := range raws {
+		items[i] = fbs.validateBatchItem(ctx, raw)
+		if items[i].err == nil {
+			elements += fbs.pageElements(items[i].input)
+		}
+	}
+	if elements > cfg.MaxElements {
+		return nil, batchTooLarge{i18n.New("error.batch_elements", "elements", elements, "max", cfg.MaxElements)}
+	}
+	return items, nil
+}
+
+// validateBatchItem strictly decodes raw, a model.FizzBuzzRequest, and validates it
+func (fbs *FizzBuzzServer) validateBatchItem(ctx context.Context, raw json.RawMessage) batchItem {
+	var req model.FizzBuzzRequest
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return batchItem{err: parsingError{err}}
+	}
+
+	validated, err := fbs.validator().RunBodyValidations(ctx, req)
+	if err != nil {
+		for _, valErr := range validation.Errors(err) {
+			fbs.Metrics.ValidationFailure(valErr.Parameter())
+		}
+		return batchItem{err: err}
+	}
+	return batchItem{input: utils.FizzBuzzInputFromContext(validated)}
+}
+
+// batchCost is the cost of a validated batch on top of its flat requestCost, so that the whole batch costs the sum of
+// the costs of its valid items as fizzbuzz requests, at least one token
+func (fbs *FizzBuzzServer) batchCost(r *http.Request, cfg config.RateLimitConfig) int64 {
+	var cost int64
+	for _, item := range batchFromContext(r.Context()) {
+		if item.err == nil {
+			cost += 1 + int64(fbs.pageElements(item.input)/cfg.CostElements)
+		}
+	}
+	return cost - requestCost(r, cfg)
+}
+
+// PostFizzBuzzBatchHandler is the handler for the /fizzbuzz/batch endpoint under method POST. The valid items of the
+// batch, validated by BatchValidationMiddleware, are processed concurrently by limits.batch.workers workers, each
+// item as a POST /fizzbuzz request: it waits for a slot of the concurrency limit, if enabled, is counted in the
+// statistics and is paginated and cached alike. The response lists, in the order of the request, the result of each
+// item or its problem document (see model.FizzBuzzBatchResult); it is successful even if some items failed
+func (fbs *FizzBuzzServer) PostFizzBuzzBatchHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(r.Context(), "PostFizzBuzzBatchHandler")
+	defer span.End()
+
+	items := batchFromContext(r.Context())
+	span.SetAttributes(attribute.Int("fizzbuzz.batch_size", len(items)))
+	payloads := make([][]byte, len(items))
+	errs := make([]error, len(items))
+	for i, item := range items {
+		errs[i] = item.err
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < fbs.batchConfig().Workers && w < len(items); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				payloads[i], errs[i] = fbs.processBatchItem(ctx, r, items[i].input)
+			}
+		}()
+	}
+	for i, item := range items {
+		if item.err == nil {
+			indexes <- i
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	langs := i18n.Negotiate(r.Header.Get(AcceptLanguageHeader))
+	results := make([]model.FizzBuzzBatchResult, len(items))
+	for i := range items {
+		if errs[i] != nil {
+			status, appError := applicationError(r, langs, errs[i])
+			results[i] = model.FizzBuzzBatchResult{Status: status, Error: problem(status, appError)}
+			continue
+		}
+		results[i] = model.FizzBuzzBatchResult{Status: http.StatusOK, Result: payloads[i]}
+	}
+
+	respPayload, err := json.Marshal(&results)
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+
+	rw.Header().Set(ContentLanguageHeader, langs.Language())
+	rw.Header().Add(VaryHeader, AcceptLanguageHeader)
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(respPayload)
+}
+
+// processBatchItem returns the encoded response to input, an item of the batch of r, as ToStatisticsMiddleware and
+// PostFizzBuzzHandler would process it
+func (fbs *FizzBuzzServer) processBatchItem(ctx context.Context, r *http.Request, input model.FizzBuzzInput) ([]byte, error) {
+	oplog := httplog.LogEntry(r.Context())
+	if _, err := fbs.Stats.Increment(ctx, input.Int1, input.Int2, input.Limit, input.Str1, input.Str2); err != nil {
+		oplog.Err(fmt.Errorf("error incrementing stats: %w", err)).Msg("")
+	}
+
+	paginationMax := fbs.paginationMax()
+	payload, err := fbs.fizzBuzzResponse(ctx, input, paginationMax, fizzBuzzCacheKey(input, paginationMax))
+	if err != nil {
+		oplog.Err(err).Msg("")
+	}
+	return payload, err
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/server/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostFizzBuzzBatchHandler(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.PaginationMax = 5
+	cfg.Limits.MaxBodyBytes = 64
+	cfg.Limits.Batch = config.BatchConfig{MaxItems: 4, MaxElements: 12, Workers: 2}
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 2, 3, 7, "f", "b").Return(int64(1), nil).Once()
+	stats.On("Increment", mock.Anything, 3, 5, 4, "fizz", "bézé").Return(int64(1), nil).Once()
+	tbs := FizzBuzzServer{
+		Stats: stats,
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	serve := func(body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/api/v1/fizzbuzz/batch", strings.NewReader(body))
+		req.Header.Set(ContentTypeHeader, JSONContentType)
+		req.Header.Set(AcceptLanguageHeader, "fr")
+		s.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// every item is processed on its own, in the order of the request
+	resp := serve(`[{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b"},{"int1":2,"limit":7,"str1":"f","str2":"b","str3":"z"},` +
+		`{"int1":0,"int2":3,"limit":7,"str1":"f","str2":"b"},{"int1":3,"int2":5,"limit":4,"str1":"fizz","str2":"bézé"}]`)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "fr", resp.Header().Get(ContentLanguageHeader))
+	var results []model.FizzBuzzBatchResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+	require.Len(t, results, 4)
+
+	assert.Equal(t, http.StatusOK, results[0].Status)
+	assert.Nil(t, results[0].Error)
+	var output model.FizzBuzzOutput
+	require.NoError(t, json.Unmarshal(results[0].Result, &output))
+	assert.Equal(t, []string{"1", "f", "b", "f", "5"}, output.Sequence)
+	assert.Equal(t, "/fizzbuzz?int1=2&int2=3&limit=7&start=6&str1=f&str2=b", output.Next)
+
+	assert.Equal(t, http.StatusBadRequest, results[1].Status)
+	require.NotNil(t, results[1].Error)
+	assert.Equal(t, AppErrorTypeParsing, results[1].Error.Type)
+	assert.Empty(t, results[1].Result)
+
+	assert.Equal(t, http.StatusBadRequest, results[2].Status)
+	require.NotNil(t, results[2].Error)
+	assert.Equal(t, AppErrorTypeInput, results[2].Error.Type)
+	require.Len(t, results[2].Error.Errors, 1)
+	assert.Equal(t, "int1", results[2].Error.Errors[0].Parameter)
+
+	assert.Equal(t, http.StatusOK, results[3].Status)
+	require.NoError(t, json.Unmarshal(results[3].Result, &output))
+	assert.Equal(t, []string{"1", "2", "fizz", "4"}, output.Sequence)
+	stats.AssertExpectations(t)
+
+	// the whole batch is rejected
+	for body, expected := range map[string]struct {
+		status  int
+		appType string
+	}{
+		`{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b"}`: {http.StatusBadRequest, AppErrorTypeInput},
+		`[]`: {http.StatusBadRequest, AppErrorTypeInput},
+		`[{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b"}] []`: {http.StatusBadRequest, AppErrorTypeParsing},
+		`[{}, {}, {}, {}, {}]`: {http.StatusRequestEntityTooLarge, AppErrorTypeTooLarge},
+		`[{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"` + strings.Repeat("b", 256) + `"}]`: {http.StatusRequestEntityTooLarge, AppErrorTypeTooLarge},
+	} {
+		resp := serve(body)
+		assert.Equal(t, expected.status, resp.Code, body)
+		var appError model.ApplicationError
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&appError), body)
+		assert.Equal(t, expected.appType, appError.Type, body)
+	}
+
+	// the budget of elements is checked on the generated pages
+	resp = serve(`[{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b"},{"int1":2,"int2":3,"limit":70,"str1":"f","str2":"b"},` +
+		`{"int1":2,"int2":3,"limit":9,"start":6,"str1":"f","str2":"b"}]`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	assert.Contains(t, resp.Body.String(), "le lot génère 14 éléments, plus de 12")
+}
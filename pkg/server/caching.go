@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// headers of the HTTP caching and of the conditional requests
+	CacheControlHeader    = "Cache-Control"
+	ETagHeader            = "ETag"
+	LastModifiedHeader    = "Last-Modified"
+	IfNoneMatchHeader     = "If-None-Match"
+	IfModifiedSinceHeader = "If-Modified-Since"
+)
+
+// statisticsVersion tracks the statistics served by the server instance, so that they can be validated by the
+// clients: the ETag of the last statistics served and when they were first served
+type statisticsVersion struct {
+	mu       sync.Mutex
+	etag     string
+	modified time.Time
+}
+
+// observe returns the time when the statistics identified by etag were first served, now if they differ from the
+// last ones served
+func (sv *statisticsVersion) observe(etag string, now time.Time) time.Time {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if sv.etag != etag {
+		sv.etag = etag
+		// Last-Modified has a resolution of a second
+		sv.modified = now.UTC().Truncate(time.Second)
+	}
+	return sv.modified
+}
+
+// strongETag returns a strong ETag derived from the content
+func strongETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// setCacheHeaders sets the Cache-Control, ETag and, if not zero, Last-Modified headers of the response to r. The
+// response is cacheable by the shared caches only if neither the authentication nor the client identities restrict
+// the path of r, as it would be served to any client otherwise
+func (fbs *FizzBuzzServer) setCacheHeaders(rw http.ResponseWriter, r *http.Request, maxAge time.Duration, etag string, modified time.Time) {
+	visibility := "public"
+	if fbs.authEnabled() || fbs.identities().allowed(r.URL.Path) != nil {
+		visibility = "private"
+	}
+	rw.Header().Set(CacheControlHeader, fmt.Sprintf("%s, max-age=%d", visibility, int64(maxAge.Seconds())))
+	rw.Header().Set(ETagHeader, etag)
+	if !modified.IsZero() {
+		rw.Header().Set(LastModifiedHeader, modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified reports whether the representation of r, identified by etag and last modified at modified (if not zero),
+// is still the one of the client, according to the If-None-Match header or, if missing, the If-Modified-Since header
+// of r (RFC 9110 section 13.2.2)
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get(IfNoneMatchHeader); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			// weak comparison
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get(IfModifiedSinceHeader); ims != "" && !modified.IsZero() {
+		since, err := http.ParseTime(ims)
+		return err == nil && !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/compression"
+)
+
+const (
+	// headers of the content negotiation of the compression
+	AcceptEncodingHeader  = "Accept-Encoding"
+	ContentEncodingHeader = "Content-Encoding"
+	VaryHeader            = "Vary"
+)
+
+// CompressionMiddleware is an HTTP middleware compressing the responses with the content coding preferred by the
+// client (see compression.Codecs.Negotiate), when enabled by the configuration. The responses are buffered until
+// they reach compression.min_size, smaller responses being sent as is; a response flushed by its handler, e.g. a
+// streamed one, is compressed from then on. Only the textual responses are compressed and, as the compressed
+// representation differs from the original one, their ETag becomes weak and the byte Range requests are no longer
+// advertised; the partial responses to byte ranges are never compressed, unlike those to the items ranges
+func (fbs *FizzBuzzServer) CompressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		live := fbs.live.Load()
+		if live == nil || live.codecs == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		rw.Header().Add(VaryHeader, AcceptEncodingHeader)
+		encoding := live.codecs.Negotiate(r.Header.Get(AcceptEncodingHeader))
+		if encoding == "" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: rw,
+			codecs:         live.codecs,
+			encoding:       encoding,
+			minSize:        live.cfg.Compression.MinSize,
+		}
+		next.ServeHTTP(cw, r)
+		if err := cw.close(); err != nil {
+			// the client is likely gone, nothing can be sent anyway
+			fbs.Metrics.CompressionError(encoding)
+		}
+	})
+}
+
+// compressWriter is an http.ResponseWriter compressing the body of the response, once it is large enough
+type compressWriter struct {
+	http.ResponseWriter
+	codecs   *compression.Codecs
+	encoding string
+	minSize  int
+
+	// status of the response, 0 until provided
+	status int
+	// the headers are sent and the body is written as is or through enc
+	decided bool
+	buf     []byte
+	enc     compression.Encoder
+}
+
+// WriteHeader is the http.ResponseWriter interface implementation. The headers are sent once it is known whether the
+// body is compressed
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		// superfluous call, as for the http.ResponseWriter of net/http
+		return
+	}
+	cw.status = status
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		// no body
+		cw.decide(false)
+	}
+}
+
+// Write is the http.ResponseWriter interface implementation
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush is the http.Flusher interface implementation, the response is compressed from now on whatever its size
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return
+		}
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for the http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide sends the headers, compressing the body from now on if compress and if the response is compressible, then
+// writes the buffered body
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	header := cw.ResponseWriter.Header()
+	// the byte ranges of a partial response refer to the original representation, the other units are not affected
+	byteRange := strings.HasPrefix(header.Get(ContentRangeHeader), "bytes")
+	if compress && !byteRange && header.Get(ContentEncodingHeader) == "" && compressible(header.Get(ContentTypeHeader)) {
+		header.Set(ContentEncodingHeader, cw.encoding)
+		header.Del("Content-Length")
+		if header.Get(AcceptRangesHeader) == "bytes" {
+			header.Del(AcceptRangesHeader)
+		}
+		if etag := header.Get(ETagHeader); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set(ETagHeader, "W/"+etag)
+		}
+		cw.enc = cw.codecs.Get(cw.encoding, cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close completes the response: the buffered body is sent as is if it's too small, the compressed one is terminated
+func (cw *compressWriter) close() error {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// nothing written by the handler
+			return nil
+		}
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		return cw.decide(false)
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	cw.codecs.Put(cw.encoding, cw.enc)
+	cw.enc = nil
+	return err
+}
+
+// compressible reports whether a response of contentType benefits from the compression, i.e. if it is textual
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "/json") || strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "/yaml") || strings.HasSuffix(mediaType, "/xml") || strings.HasSuffix(mediaType, "+xml")
+}
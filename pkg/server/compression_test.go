@@ -0,0 +1,138 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/server/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressionMiddleware(t *testing.T) {
+	cfg := config.Default()
+	cfg.Compression.Enable = true
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 3, 5, mock.Anything, "fizz", "buzz").Return(int64(1), nil)
+	tbs := FizzBuzzServer{
+		Stats: stats,
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	serve := func(limit, acceptEncoding string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz?int1=3&int2=5&str1=fizz&str2=buzz&limit="+limit, nil)
+		req.Header.Set(AcceptEncodingHeader, acceptEncoding)
+		s.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	raw := serve("1000", "")
+	require.Equal(t, http.StatusOK, raw.Result().StatusCode)
+	assert.Empty(t, raw.Header().Get(ContentEncodingHeader))
+	assert.Equal(t, AcceptEncodingHeader, raw.Header().Get(VaryHeader))
+
+	resp := serve("1000", "compress, gzip;q=0.9")
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Equal(t, "gzip", resp.Header().Get(ContentEncodingHeader))
+	assert.Equal(t, JSONContentType, resp.Header().Get(ContentTypeHeader))
+	assert.Equal(t, AcceptEncodingHeader, resp.Header().Get(VaryHeader))
+	assert.Equal(t, "W/"+raw.Header().Get(ETagHeader), resp.Header().Get(ETagHeader))
+	assert.Less(t, resp.Body.Len(), raw.Body.Len())
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, raw.Body.String(), string(decompressed))
+
+	// zstd and br are preferred by the server
+	resp = serve("1000", "gzip, deflate, br, zstd")
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Equal(t, "zstd", resp.Header().Get(ContentEncodingHeader))
+	zr, err := zstd.NewReader(resp.Body)
+	require.NoError(t, err)
+	defer zr.Close()
+	decompressed, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, raw.Body.String(), string(decompressed))
+
+	resp = serve("1000", "gzip, br")
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Equal(t, "br", resp.Header().Get(ContentEncodingHeader))
+	decompressed, err = io.ReadAll(brotli.NewReader(resp.Body))
+	require.NoError(t, err)
+	assert.Equal(t, raw.Body.String(), string(decompressed))
+
+	// the weak ETag validates the response
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz?int1=3&int2=5&str1=fizz&str2=buzz&limit=1000", nil)
+	req.Header.Set(AcceptEncodingHeader, "gzip")
+	req.Header.Set(IfNoneMatchHeader, resp.Header().Get(ETagHeader))
+	notModified := httptest.NewRecorder()
+	s.Handler.ServeHTTP(notModified, req)
+	assert.Equal(t, http.StatusNotModified, notModified.Result().StatusCode)
+	assert.Empty(t, notModified.Header().Get(ContentEncodingHeader))
+	assert.Empty(t, notModified.Body.Bytes())
+
+	// an items range is compressed, the items being the same in any coding
+	assert.Equal(t, itemsUnit, resp.Header().Get(AcceptRangesHeader))
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz?int1=3&int2=5&str1=fizz&str2=buzz&limit=1000", nil)
+	req.Header.Set(AcceptEncodingHeader, "gzip")
+	req.Header.Set(RangeHeader, "items=100-899")
+	partial := httptest.NewRecorder()
+	s.Handler.ServeHTTP(partial, req)
+	assert.Equal(t, http.StatusPartialContent, partial.Result().StatusCode)
+	assert.Equal(t, "gzip", partial.Header().Get(ContentEncodingHeader))
+	assert.Equal(t, "items 100-899/1000", partial.Header().Get(ContentRangeHeader))
+
+	// small responses are sent as is
+	resp = serve("5", "deflate")
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Empty(t, resp.Header().Get(ContentEncodingHeader))
+	assert.True(t, strings.HasPrefix(resp.Body.String(), `{"Sequence":`))
+
+	// the compression is reloadable
+	cfg.Compression.Enable = false
+	_, err = tbs.Reload(cfg)
+	require.NoError(t, err)
+	resp = serve("1000", "gzip")
+	assert.Empty(t, resp.Header().Get(ContentEncodingHeader))
+	assert.NotContains(t, resp.Header().Values(VaryHeader), AcceptEncodingHeader)
+}
+
+func TestCompressionMiddleware_Flush(t *testing.T) {
+	cfg := config.Default()
+	cfg.Compression.Enable = true
+	tbs := FizzBuzzServer{}
+	require.NoError(t, tbs.apply(cfg))
+
+	handler := tbs.CompressionMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set(ContentTypeHeader, "text/plain")
+		rw.Write([]byte("first chunk\n"))
+		rw.(http.Flusher).Flush()
+		rw.Write([]byte("second chunk\n"))
+	}))
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set(AcceptEncodingHeader, "gzip")
+	handler.ServeHTTP(resp, req)
+
+	// a streamed response is compressed whatever its size
+	assert.True(t, resp.Flushed)
+	assert.Equal(t, "gzip", resp.Header().Get(ContentEncodingHeader))
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "first chunk\nsecond chunk\n", string(decompressed))
+}
@@ -1,17 +1,25 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/httplog"
+	"github.com/peano88/fizzbuzz-rest/pkg/auth"
+	"github.com/peano88/fizzbuzz-rest/pkg/concurrency"
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
+	"github.com/peano88/fizzbuzz-rest/pkg/jobs"
 	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/ratelimit"
 	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
 	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+	"github.com/peano88/fizzbuzz-rest/pkg/webhooks"
 )
 
 const (
@@ -23,82 +31,521 @@ const (
 	AppErrorTypeStats = "/fizzbuzz/errors/stats"
 	// ApplicationError type for error generated during validation of the input parameters
 	AppErrorTypeInput = "/fizzbuzz/errors/input"
+	// ApplicationError type for webhooks registration error
+	AppErrorTypeWebhook = "/fizzbuzz/errors/webhook"
+	// ApplicationError type for an invalid configuration provided to a reload
+	AppErrorTypeConfig = "/fizzbuzz/errors/config"
+	// ApplicationError type for a request rejected because of the client identity
+	AppErrorTypeAuth = "/fizzbuzz/errors/auth"
+	// ApplicationError type for API keys management error
+	AppErrorTypeAPIKey = "/fizzbuzz/errors/apikey"
+	// ApplicationError type for a request exceeding the budget of its client
+	AppErrorTypeRateLimit = "/fizzbuzz/errors/ratelimit"
+	// ApplicationError type for a request shed because the server is overloaded
+	AppErrorTypeOverload = "/fizzbuzz/errors/overload"
+	// ApplicationError type for a request not completed in time
+	AppErrorTypeTimeout = "/fizzbuzz/errors/timeout"
+	// ApplicationError type for a request abandoned by its client
+	AppErrorTypeCanceled = "/fizzbuzz/errors/canceled"
+	// ApplicationError type for an unexpected error
+	AppErrorTypeInternal = "/fizzbuzz/errors/internal"
+	// ApplicationError type for a request body exceeding the configured limit
+	AppErrorTypeTooLarge = "/fizzbuzz/errors/too_large"
+	// ApplicationError type for asynchronous jobs error
+	AppErrorTypeJob = "/fizzbuzz/errors/job"
+	// ApplicationError type for a Range request selecting no element of the sequence
+	AppErrorTypeRange = "/fizzbuzz/errors/range"
+
+	// non-standard status of a request abandoned by its client before the response
+	StatusClientClosedRequest = 499
 )
 
-func jsonApplicationError(rw http.ResponseWriter, r *http.Request) {
-	rw.WriteHeader(http.StatusInternalServerError)
-	appError := model.ApplicationError{
-		Type:     AppErrorTypeJSON,
-		Title:    "error marshaling response",
-		Status:   strconv.Itoa(http.StatusInternalServerError),
-		Instance: middleware.GetReqID(r.Context()),
+// errorEntry is the rendering of the errors it matches: the status, type and title of their ApplicationError. The
+// title is the ID of its message in the i18n catalogs
+type errorEntry struct {
+	matches func(err error) bool
+	status  int
+	appType string
+	title   string
+	// completes the ApplicationError of err, localized for langs, e.g. with its detail, if not nil
+	describe func(err error, langs i18n.Languages, appError *model.ApplicationError)
+}
+
+// errorRegistry maps the errors to their rendering; the first entry matching an error applies, internalError if none
+var errorRegistry = []errorEntry{
+	{
+		matches:  func(err error) bool { return len(validation.Errors(err)) > 0 },
+		status:   http.StatusBadRequest,
+		appType:  AppErrorTypeInput,
+		describe: describeValidation,
+	},
+	{
+		matches:  isError(statistics.NoStatsAvailable{}),
+		status:   http.StatusServiceUnavailable,
+		appType:  AppErrorTypeStats,
+		title:    "error.statistics",
+		describe: withDetail(i18n.New("error.no_statistics")),
+	},
+	{
+		matches: isError(webhooks.WebhookNotFound{}),
+		status:  http.StatusNotFound,
+		appType: AppErrorTypeWebhook,
+		title:   "error.webhook_not_found",
+	},
+	{
+		matches: isError(auth.APIKeyNotFound{}),
+		status:  http.StatusNotFound,
+		appType: AppErrorTypeAPIKey,
+		title:   "error.apikey_not_found",
+	},
+	{
+		matches: isError(auth.InvalidCredentials{}),
+		status:  http.StatusUnauthorized,
+		appType: AppErrorTypeAuth,
+		describe: func(err error, langs i18n.Languages, appError *model.ApplicationError) {
+			var invalid auth.InvalidCredentials
+			errors.As(err, &invalid)
+			appError.Title = langs.Localize(i18n.New("error.invalid_credentials", "reason", invalid.Reason()))
+		},
+	},
+	{
+		matches:  asError[unauthenticated],
+		status:   http.StatusUnauthorized,
+		appType:  AppErrorTypeAuth,
+		describe: withTitleOf[unauthenticated],
+	},
+	{
+		matches:  asError[forbidden],
+		status:   http.StatusForbidden,
+		appType:  AppErrorTypeAuth,
+		describe: withTitleOf[forbidden],
+	},
+	{
+		matches: isError(ratelimit.Exceeded{}),
+		status:  http.StatusTooManyRequests,
+		appType: AppErrorTypeRateLimit,
+		title:   "error.rate_limited",
+		describe: func(err error, langs i18n.Languages, appError *model.ApplicationError) {
+			var exceeded ratelimit.Exceeded
+			errors.As(err, &exceeded)
+			detail := i18n.New("error.rate_exceeded")
+			switch exceeded.Reason() {
+			case ratelimit.ReasonQuota:
+				detail = i18n.New("error.quota_exhausted")
+			case ratelimit.ReasonCost:
+				detail = i18n.New("error.cost_exceeded")
+			}
+			appError.Detail = langs.Localize(detail)
+		},
+	},
+	{
+		matches: isError(concurrency.Shed{}),
+		status:  http.StatusServiceUnavailable,
+		appType: AppErrorTypeOverload,
+		title:   "error.overloaded",
+		describe: func(err error, langs i18n.Languages, appError *model.ApplicationError) {
+			var shed concurrency.Shed
+			errors.As(err, &shed)
+			appError.Detail = langs.Localize(i18n.New("error.shed", "reason", shed.Reason()))
+		},
+	},
+	{
+		matches: isError(context.DeadlineExceeded),
+		status:  http.StatusServiceUnavailable,
+		appType: AppErrorTypeTimeout,
+		title:   "error.timeout",
+	},
+	{
+		matches: isError(context.Canceled),
+		status:  StatusClientClosedRequest,
+		appType: AppErrorTypeCanceled,
+		title:   "error.canceled",
+	},
+	{
+		matches:  asError[*http.MaxBytesError],
+		status:   http.StatusRequestEntityTooLarge,
+		appType:  AppErrorTypeTooLarge,
+		title:    "error.too_large",
+		describe: describeBodyLimit,
+	},
+	{
+		matches: asError[batchTooLarge],
+		status:  http.StatusRequestEntityTooLarge,
+		appType: AppErrorTypeTooLarge,
+		title:   "error.too_large",
+		describe: func(err error, langs i18n.Languages, appError *model.ApplicationError) {
+			var tooLarge batchTooLarge
+			errors.As(err, &tooLarge)
+			appError.Detail = langs.Localize(tooLarge.reason)
+		},
+	},
+	{
+		matches: isError(jobs.JobNotFound{}),
+		status:  http.StatusNotFound,
+		appType: AppErrorTypeJob,
+		title:   "error.job_not_found",
+	},
+	{
+		matches: asError[jobs.NotCompleted],
+		status:  http.StatusConflict,
+		appType: AppErrorTypeJob,
+		title:   "error.job_not_completed",
+		describe: func(err error, langs i18n.Languages, appError *model.ApplicationError) {
+			var notCompleted jobs.NotCompleted
+			errors.As(err, &notCompleted)
+			appError.Detail = langs.Localize(i18n.New("error.job_status", "status", notCompleted.Status()))
+		},
+	},
+	{
+		matches:  isError(jobs.QueueFull{}),
+		status:   http.StatusServiceUnavailable,
+		appType:  AppErrorTypeOverload,
+		title:    "error.overloaded",
+		describe: withDetail(i18n.New("error.jobs_queue_full")),
+	},
+	{
+		matches:  isError(jobs.StoreFull{}),
+		status:   http.StatusServiceUnavailable,
+		appType:  AppErrorTypeOverload,
+		title:    "error.overloaded",
+		describe: withDetail(i18n.New("error.jobs_store_full")),
+	},
+	{
+		matches: asError[jobs.TooManyActive],
+		status:  http.StatusTooManyRequests,
+		appType: AppErrorTypeRateLimit,
+		title:   "error.rate_limited",
+		describe: func(err error, langs i18n.Languages, appError *model.ApplicationError) {
+			var tooMany jobs.TooManyActive
+			errors.As(err, &tooMany)
+			appError.Detail = langs.Localize(i18n.New("error.jobs_active", "max", tooMany.Max()))
+		},
+	},
+	{
+		matches: asError[jobs.TooLarge],
+		status:  http.StatusRequestEntityTooLarge,
+		appType: AppErrorTypeTooLarge,
+		title:   "error.too_large",
+		describe: func(err error, langs i18n.Languages, appError *model.ApplicationError) {
+			var tooLarge jobs.TooLarge
+			errors.As(err, &tooLarge)
+			appError.Detail = langs.Localize(i18n.New("error.job_elements", "max", tooLarge.Max()))
+		},
+	},
+	{
+		matches: asError[rangeNotSatisfiable],
+		status:  http.StatusRequestedRangeNotSatisfiable,
+		appType: AppErrorTypeRange,
+		title:   "error.range_not_satisfiable",
+		describe: func(err error, langs i18n.Languages, appError *model.ApplicationError) {
+			var notSatisfiable rangeNotSatisfiable
+			errors.As(err, &notSatisfiable)
+			appError.Detail = langs.Localize(i18n.New("error.range_"+notSatisfiable.unit, "length", notSatisfiable.length))
+		},
+	},
+	{
+		matches:  asError[parsingError],
+		status:   http.StatusBadRequest,
+		appType:  AppErrorTypeParsing,
+		title:    "error.parsing",
+		describe: withDetailOf[parsingError],
+	},
+	{
+		matches:  asError[configError],
+		status:   http.StatusBadRequest,
+		appType:  AppErrorTypeConfig,
+		title:    "error.config",
+		describe: withDetailOf[configError],
+	},
+	{
+		matches: asError[operationError],
+		status:  http.StatusInternalServerError,
+		describe: func(err error, langs i18n.Languages, appError *model.ApplicationError) {
+			var opErr operationError
+			errors.As(err, &opErr)
+			appError.Type = opErr.appType
+			appError.Title = langs.Localize(i18n.New(opErr.title))
+		},
+	},
+}
+
+// internalError renders the errors not matched by any entry of errorRegistry
+var internalError = errorEntry{
+	status:  http.StatusInternalServerError,
+	appType: AppErrorTypeInternal,
+	title:   "error.internal",
+}
+
+// parsingError is a request body which can't be decoded; err is its detail
+type parsingError struct {
+	err error
+}
+
+func (pe parsingError) Error() string {
+	return pe.err.Error()
+}
+
+func (pe parsingError) Unwrap() error {
+	return pe.err
+}
+
+// configError is a configuration which can't be reloaded; err is its detail
+type configError struct {
+	err error
+}
+
+func (ce configError) Error() string {
+	return ce.err.Error()
+}
+
+func (ce configError) Unwrap() error {
+	return ce.err
+}
+
+// batchTooLarge is a batch rejected because it exceeds the limit described by reason
+type batchTooLarge struct {
+	reason i18n.Message
+}
+
+func (btl batchTooLarge) Error() string {
+	return btl.reason.String()
+}
+
+// rangeNotSatisfiable is a Range request selecting nothing of a representation of length units, items or bytes
+type rangeNotSatisfiable struct {
+	unit   string
+	length uint64
+}
+
+func (rns rangeNotSatisfiable) Error() string {
+	return fmt.Sprintf("no %s of the %d %s in the requested range", rns.unit, rns.length, rns.unit)
+}
+
+// unauthenticated is a request rejected because it lacks the credentials or identity described by reason
+type unauthenticated struct {
+	reason i18n.Message
+}
+
+func (u unauthenticated) Error() string {
+	return u.reason.String()
+}
+
+func (u unauthenticated) Message() i18n.Message {
+	return u.reason
+}
+
+// forbidden is a request rejected because its principal or identity isn't allowed, as described by reason
+type forbidden struct {
+	reason i18n.Message
+}
+
+func (f forbidden) Error() string {
+	return f.reason.String()
+}
+
+func (f forbidden) Message() i18n.Message {
+	return f.reason
+}
+
+// operationError is an unexpected error of an operation, rendered with the type and title (a message ID) of the
+// operation, unless err itself is matched by a previous entry of errorRegistry. Its details are not exposed to
+// the client
+type operationError struct {
+	appType string
+	title   string
+	err     error
+}
+
+func (oe operationError) Error() string {
+	return i18n.New(oe.title).String() + ": " + oe.err.Error()
+}
+
+func (oe operationError) Unwrap() error {
+	return oe.err
+}
+
+// marshalingError is the operationError of a response which can't be encoded
+func marshalingError(err error) error {
+	return operationError{appType: AppErrorTypeJSON, title: "error.marshaling", err: err}
+}
+
+// webhooksError is the operationError of the webhooks registry
+func webhooksError(err error) error {
+	return operationError{appType: AppErrorTypeWebhook, title: "error.webhooks", err: err}
+}
+
+// jobsError is the operationError of the jobs component
+func jobsError(err error) error {
+	return operationError{appType: AppErrorTypeJob, title: "error.jobs", err: err}
+}
+
+// apiKeysError is the operationError of the API keys registry
+func apiKeysError(err error) error {
+	return operationError{appType: AppErrorTypeAPIKey, title: "error.apikeys", err: err}
+}
+
+// isError returns a matcher of the errors wrapping target
+func isError(target error) func(error) bool {
+	return func(err error) bool {
+		return errors.Is(err, target)
 	}
+}
 
-	appErrorPayload, err := json.Marshal(&appError)
-	if err != nil {
-		oplog := httplog.LogEntry(r.Context())
-		oplog.Err(fmt.Errorf("app error marshaling issue: %w", err)).Msg("")
-		return
+// asError matches the errors wrapping an error of type T
+func asError[T error](err error) bool {
+	var target T
+	return errors.As(err, &target)
+}
+
+// withDetail returns a describe setting the detail to detail
+func withDetail(detail i18n.Message) func(error, i18n.Languages, *model.ApplicationError) {
+	return func(_ error, langs i18n.Languages, appError *model.ApplicationError) {
+		appError.Detail = langs.Localize(detail)
 	}
+}
 
-	rw.Header().Add(ContentTypeHeader, JSONContentType)
-	rw.Write(appErrorPayload)
+// withDetailOf sets the detail to the message of the error of type T wrapped by err, which is not localized
+func withDetailOf[T error](err error, _ i18n.Languages, appError *model.ApplicationError) {
+	var target T
+	if errors.As(err, &target) {
+		appError.Detail = target.Error()
+	}
 }
 
-func validationApplicationError(rw http.ResponseWriter, r *http.Request, err error) {
-	rw.WriteHeader(http.StatusBadRequest)
+// withTitleOf sets the title to the localized message of the error of type T wrapped by err
+func withTitleOf[T interface {
+	error
+	Message() i18n.Message
+}](err error, langs i18n.Languages, appError *model.ApplicationError) {
+	var target T
+	if errors.As(err, &target) {
+		appError.Title = langs.Localize(target.Message())
+	}
+}
 
-	var valErr validation.ValidationError
-	if !errors.As(err, &valErr) {
-		oplog := httplog.LogEntry(r.Context())
-		oplog.Err(fmt.Errorf("validation error expected")).Msg("")
-		return
+// describeBodyLimit sets the detail to the limit exceeded by the request body
+func describeBodyLimit(err error, langs i18n.Languages, appError *model.ApplicationError) {
+	var target *http.MaxBytesError
+	if errors.As(err, &target) {
+		appError.Detail = langs.Localize(i18n.New("error.body_limit", "limit", target.Limit))
 	}
+}
 
-	appError := model.ApplicationError{
-		Type:     AppErrorTypeInput,
-		Title:    valErr.Error(),
-		Status:   strconv.Itoa(http.StatusBadRequest),
-		Detail:   valErr.Constraint(),
-		Instance: middleware.GetReqID(r.Context()),
+// describeValidation describes every invalid parameter; the legacy representation describes the first one only
+func describeValidation(err error, langs i18n.Languages, appError *model.ApplicationError) {
+	valErrs := validation.Errors(err)
+	appError.Title = langs.Localize(valErrs[0].Message())
+	appError.Detail = langs.Localize(valErrs[0].ConstraintMessage())
+	for _, valErr := range valErrs {
+		appError.Errors = append(appError.Errors, model.ParameterError{
+			Parameter:  valErr.Parameter(),
+			Detail:     langs.LocalizeError(errors.Unwrap(valErr)),
+			Constraint: langs.Localize(valErr.ConstraintMessage()),
+		})
 	}
+}
 
-	appErrorPayload, err := json.Marshal(&appError)
-	if err != nil {
-		oplog := httplog.LogEntry(r.Context())
-		oplog.Err(fmt.Errorf("application error marshaling issue: %w", err)).Msg("")
-		return
+// lookupError returns the entry of errorRegistry rendering err
+func lookupError(err error) errorEntry {
+	for _, entry := range errorRegistry {
+		if entry.matches(err) {
+			return entry
+		}
 	}
+	return internalError
+}
 
-	rw.Header().Add(ContentTypeHeader, JSONContentType)
-	rw.Write(appErrorPayload)
+// renderError logs err and writes its ApplicationError, as described by errorRegistry, as the response to r. The
+// title and detail are localized in the languages accepted by the Accept-Language header of r, English by default
+func renderError(rw http.ResponseWriter, r *http.Request, err error) {
+	oplog := httplog.LogEntry(r.Context())
+	oplog.Err(err).Msg("")
+
+	langs := i18n.Negotiate(r.Header.Get(AcceptLanguageHeader))
+	status, appError := applicationError(r, langs, err)
+
+	rw.Header().Set(ContentLanguageHeader, langs.Language())
+	rw.Header().Add(VaryHeader, AcceptLanguageHeader)
+	writeApplicationError(rw, r, status, appError)
 }
 
-func statisticsApplicationError(rw http.ResponseWriter, r *http.Request, err error) {
+// applicationError returns the status and the ApplicationError, localized for langs, of err as the error of r, as
+// described by errorRegistry
+func applicationError(r *http.Request, langs i18n.Languages, err error) (int, model.ApplicationError) {
+	entry := lookupError(err)
 	appError := model.ApplicationError{
-		Type:     AppErrorTypeStats,
-		Title:    "internal issue retrieving statistics",
+		Type:     entry.appType,
+		Status:   strconv.Itoa(entry.status),
 		Instance: middleware.GetReqID(r.Context()),
 	}
-	if errors.Is(err, statistics.NoStatsAvailable{}) {
-		rw.WriteHeader(http.StatusServiceUnavailable)
-		appError.Status = strconv.Itoa(http.StatusServiceUnavailable)
-		appError.Detail = "no previous request available"
-
-	} else {
-		rw.WriteHeader(http.StatusInternalServerError)
-		appError.Status = strconv.Itoa(http.StatusInternalServerError)
+	if entry.title != "" {
+		appError.Title = langs.Localize(i18n.New(entry.title))
+	}
+	if entry.describe != nil {
+		entry.describe(err, langs, &appError)
+	}
+	return entry.status, appError
+}
+
+// problem returns the problem document of appError, whose status is status
+func problem(status int, appError model.ApplicationError) *model.Problem {
+	return &model.Problem{
+		Type:     appError.Type,
+		Title:    appError.Title,
+		Status:   status,
+		Detail:   appError.Detail,
+		Instance: appError.Instance,
+		Errors:   appError.Errors,
+	}
+}
+
+// writeApplicationError writes appError as the response to r, as a problem document (see model.Problem) if the
+// client accepts it (see acceptsProblem), in the legacy representation otherwise
+func writeApplicationError(rw http.ResponseWriter, r *http.Request, status int, appError model.ApplicationError) {
+	contentType := JSONContentType
+	var payload any = &appError
+	if acceptsProblem(r) {
+		contentType = ProblemJSONContentType
+		payload = problem(status, appError)
 	}
-	oplog := httplog.LogEntry(r.Context())
-	oplog.Err(fmt.Errorf("error getting statistics: %w", err)).Msg("")
 
-	appErrorPayload, err := json.Marshal(&appError)
+	appErrorPayload, err := json.Marshal(payload)
 	if err != nil {
 		oplog := httplog.LogEntry(r.Context())
 		oplog.Err(fmt.Errorf("application error marshaling issue: %w", err)).Msg("")
+		rw.WriteHeader(status)
 		return
 	}
 
-	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.Header().Add(ContentTypeHeader, contentType)
+	rw.WriteHeader(status)
 	rw.Write(appErrorPayload)
 }
+
+// acceptsProblem reports whether the client of r prefers the problem details representation of the errors, i.e. if
+// its Accept header lists application/problem+json with a quality not lower than application/json
+func acceptsProblem(r *http.Request) bool {
+	qualities := acceptQualities(r)
+	return qualities[ProblemJSONContentType] > 0 && qualities[ProblemJSONContentType] >= qualities[JSONContentType]
+}
+
+// acceptQualities returns the qualities of the media types listed by the Accept header of r, lowercased and without
+// their parameters; the elements with an invalid quality are skipped
+func acceptQualities(r *http.Request) map[string]float64 {
+	qualities := map[string]float64{}
+	for _, accept := range r.Header.Values(AcceptHeader) {
+		for _, element := range strings.Split(accept, ",") {
+			mediaType, params, _ := strings.Cut(element, ";")
+			q := 1.0
+			if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+			qualities[strings.ToLower(strings.TrimSpace(mediaType))] = q
+		}
+	}
+	return qualities
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/auth"
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/ratelimit"
+	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
+	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+	"github.com/peano88/fizzbuzz-rest/pkg/webhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderError(t *testing.T) {
+	_, valErr := validation.NewFizzBuzzValidator().RunValidations(
+		httptest.NewRequest(http.MethodGet, "http://example.com?int1=two&int2=3&limit=7&str1=f&str2=b", nil))
+	require.Error(t, valErr)
+
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		appType string
+		title   string
+		detail  string
+	}{
+		{"validation", valErr, http.StatusBadRequest, AppErrorTypeInput, valErr.Error(), "integer"},
+		{"no statistics", fmt.Errorf("wrapped: %w", statistics.NoStatsAvailable{}), http.StatusServiceUnavailable, AppErrorTypeStats, "internal issue retrieving statistics", "no previous request available"},
+		{"statistics", operationError{appType: AppErrorTypeStats, title: "error.statistics", err: errors.New("dummy")}, http.StatusInternalServerError, AppErrorTypeStats, "internal issue retrieving statistics", ""},
+		{"webhook not found", webhooksError(webhooks.WebhookNotFound{}), http.StatusNotFound, AppErrorTypeWebhook, "webhook not found", ""},
+		{"api key not found", apiKeysError(auth.APIKeyNotFound{}), http.StatusNotFound, AppErrorTypeAPIKey, "api key not found", ""},
+		{"forbidden", forbidden{i18n.New("error.identity_not_allowed")}, http.StatusForbidden, AppErrorTypeAuth, "identity not allowed", ""},
+		{"rate limited", ratelimit.Decision{Reason: ratelimit.ReasonQuota}.Err(), http.StatusTooManyRequests, AppErrorTypeRateLimit, "too many requests", "daily quota exhausted"},
+		{"timeout", operationError{appType: AppErrorTypeStats, title: "error.statistics", err: context.DeadlineExceeded}, http.StatusServiceUnavailable, AppErrorTypeTimeout, "request timed out", ""},
+		{"canceled", context.Canceled, StatusClientClosedRequest, AppErrorTypeCanceled, "request canceled", ""},
+		{"parsing", parsingError{errors.New("unexpected EOF")}, http.StatusBadRequest, AppErrorTypeParsing, "error parsing request body", "unexpected EOF"},
+		{"marshaling", marshalingError(errors.New("dummy")), http.StatusInternalServerError, AppErrorTypeJSON, "error marshaling response", ""},
+		{"unknown", errors.New("dummy"), http.StatusInternalServerError, AppErrorTypeInternal, "internal error", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			renderError(resp, httptest.NewRequest(http.MethodGet, "http://example.com", nil), tt.err)
+
+			require.Equal(t, tt.status, resp.Result().StatusCode)
+			assert.Equal(t, JSONContentType, resp.Result().Header.Get(ContentTypeHeader))
+			var appError model.ApplicationError
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&appError))
+			assert.Equal(t, tt.appType, appError.Type)
+			assert.Equal(t, tt.title, appError.Title)
+			assert.Contains(t, appError.Detail, tt.detail)
+		})
+	}
+}
+
+func TestRenderError_Localized(t *testing.T) {
+	_, valErr := validation.NewFizzBuzzValidator().RunValidations(
+		httptest.NewRequest(http.MethodGet, "http://example.com?int1=two&int2=3&limit=seven&str1=f&str2=b", nil))
+	require.Error(t, valErr)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set(AcceptLanguageHeader, "es, fr-CH;q=0.9, de;q=0.5")
+	req.Header.Set(AcceptHeader, ProblemJSONContentType)
+	resp := httptest.NewRecorder()
+	renderError(resp, req, valErr)
+
+	require.Equal(t, http.StatusBadRequest, resp.Result().StatusCode)
+	assert.Equal(t, "fr", resp.Result().Header.Get(ContentLanguageHeader))
+	assert.Contains(t, resp.Result().Header.Values(VaryHeader), AcceptLanguageHeader)
+	var problem model.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, "erreur de validation du paramètre : int1 : two n'est pas un entier positif", problem.Title)
+	assert.Equal(t, "int1 doit être un entier positif entre 0 (exclu) et 9223372036854775807", problem.Detail)
+	require.Len(t, problem.Errors, 2)
+	assert.Equal(t, "limit : seven n'est pas un entier", problem.Errors[1].Detail)
+
+	// the titles of the operations are localized as well
+	req.Header.Set(AcceptLanguageHeader, "it")
+	resp = httptest.NewRecorder()
+	renderError(resp, req, webhooksError(errors.New("dummy")))
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, "problema interno nella gestione dei webhook", problem.Title)
+	assert.Equal(t, "it", resp.Result().Header.Get(ContentLanguageHeader))
+}
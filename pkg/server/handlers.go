@@ -1,14 +1,22 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"time"
 
-	"github.com/go-chi/httplog"
+	"github.com/go-chi/chi/v5"
+	"github.com/peano88/fizzbuzz-rest/pkg/concurrency"
 	"github.com/peano88/fizzbuzz-rest/pkg/fizzbuzz"
 	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/tracing"
 	"github.com/peano88/fizzbuzz-rest/pkg/utils"
+	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -16,61 +24,276 @@ const (
 	ContentTypeHeader = "Content-Type"
 	// Header value for JSON content type
 	JSONContentType = "application/json"
+	// Header value for the RFC 9457 problem details content type
+	ProblemJSONContentType = "application/problem+json"
+	// Header value for YAML content type
+	YAMLContentType = "application/yaml"
+	// Header value for plain-text content type
+	PlainTextContentType = "text/plain; charset=utf-8"
+	// Header key for the media types accepted by the client
+	AcceptHeader = "Accept"
+	// Header key for the natural languages accepted by the client
+	AcceptLanguageHeader = "Accept-Language"
+	// Header key for the natural language of the response
+	ContentLanguageHeader = "Content-Language"
 
-	// Limit of items for a single response to the fizzbuzz sequence
-	// if [start,limit] is an interval containing more than paginationLimit elements,
-	// the response will contains a sequence of paginationLimit elements and
+	// Default limit of items for a single response to the fizzbuzz sequence, the actual
+	// limit is given by the configuration (see paginationMax).
+	// if [start,limit] is an interval containing more than the limit elements,
+	// the response will contains a sequence of limit elements and
 	// a link to the request completing/extending the sequence
 	paginationLimit = 65536
+
+	// version of the encoding of the fizzbuzz responses, to be changed along with model.FizzBuzzOutput or its
+	// encoding so that the cached responses are invalidated
+	fizzBuzzFormatVersion = "json.v1"
+	// version of the plain-text representation of the fizzbuzz responses, see fizzBuzzFormatVersion
+	fizzBuzzTextFormatVersion = "text.v1"
 )
 
 // GetFizzBuzzHandler is the handler for the /fizzbuzz endpoint under method GET.
 // It expects int1, int2, limit, str1, str2 query parameters and allows the optional
 // start parameter. The response is a fizz-buzz-alike sequence from start to limit (start
-// is defaulted to 1 if not provided). If the sequence consists of more than paginationLimit
-// elements, the response is paginated.
+// is defaulted to 1 if not provided). If the sequence consists of more than the configured
+// limits.pagination_max elements, the response is paginated. If the cache is enabled, the
+// encoded responses are cached (see fizzBuzzCacheKey). The responses carry a strong ETag derived
+// from their key and are cacheable for cache.max_age; a conditional request whose If-None-Match
+// matches the ETag is answered with 304 Not Modified, without generating the sequence. A client
+// preferring text/plain is served the plain-text representation instead (see serveFizzBuzzText)
 func (fbs *FizzBuzzServer) GetFizzBuzzHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(r.Context(), "GetFizzBuzzHandler")
+	defer span.End()
+
 	input := utils.FizzBuzzInputFromContext(r.Context())
-	oplog := httplog.LogEntry(r.Context())
+	paginationMax := fbs.paginationMax()
+	plainText := acceptsPlainText(r)
+	key := fizzBuzzCacheKey(input, paginationMax)
+	if plainText {
+		key = fizzBuzzTextCacheKey(input, paginationMax)
+	}
 
-	output := model.FizzBuzzOutput{}
-	pagination := (input.Limit - input.Start) > paginationLimit
+	// the response is a function of its key, which identifies it
+	etag := strongETag([]byte(key))
+	maxAge := fbs.cacheConfig().MaxAge
+	rw.Header().Add(VaryHeader, AcceptHeader)
+	if notModified(r, etag, time.Time{}) {
+		fbs.setCacheHeaders(rw, r, maxAge, etag, time.Time{})
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if plainText {
+		fbs.serveFizzBuzzText(ctx, rw, r, input, paginationMax, key, etag, maxAge)
+		return
+	}
+	rw.Header().Set(AcceptRangesHeader, itemsUnit)
+	if fbs.serveItemsRange(ctx, rw, r, input, paginationMax, etag, maxAge) {
+		return
+	}
 
-	if pagination {
-		output.Next = fmt.Sprintf("/fizzbuzz?int1=%d&int2=%d&limit=%d&start=%d&str1=%s&str2=%s", input.Int1, input.Int2, input.Limit, input.Start+paginationLimit, input.Str1, input.Str2)
-		input.Limit = input.Start + paginationLimit - 1
+	respPayload, err := fbs.fizzBuzzResponse(ctx, input, paginationMax, key)
+	if err != nil {
+		renderGenerationError(rw, r, err)
+		return
 	}
+	fbs.setCacheHeaders(rw, r, maxAge, etag, time.Time{})
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(respPayload)
+}
 
-	output.Sequence = fizzbuzz.Fizzbuzz(input)
+// PostFizzBuzzHandler is the handler for the /fizzbuzz endpoint under method POST. It expects the input
+// parameters as a JSON body (see model.FizzBuzzRequest), validated as the query parameters of GET /fizzbuzz, and
+// answers with the same fizz-buzz-alike sequence, paginated and cached alike. The response is not cacheable by
+// the HTTP caches, the request not being a GET
+func (fbs *FizzBuzzServer) PostFizzBuzzHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(r.Context(), "PostFizzBuzzHandler")
+	defer span.End()
 
-	respPayload, err := json.Marshal(&output)
+	input := utils.FizzBuzzInputFromContext(r.Context())
+	paginationMax := fbs.paginationMax()
+	respPayload, err := fbs.fizzBuzzResponse(ctx, input, paginationMax, fizzBuzzCacheKey(input, paginationMax))
 	if err != nil {
-		oplog.Err(fmt.Errorf("error marshaling response: %w", err)).Msg("")
-		jsonApplicationError(rw, r)
+		renderGenerationError(rw, r, err)
 		return
 	}
 	rw.Header().Add(ContentTypeHeader, JSONContentType)
-	rw.Write(respPayload)
 	rw.WriteHeader(http.StatusOK)
+	rw.Write(respPayload)
+}
+
+// fizzBuzzResponse returns the encoded response to input, paginated by paginationMax, from the cache of the
+// responses by key if enabled. If the concurrency limiting is enabled, the generation of a response holds a slot of
+// the limit, the requests generating the smallest pages being served first; a generation which can't be queued or
+// waits longer than the queue timeout is shed with concurrency.Shed. The cached responses are served without a slot
+func (fbs *FizzBuzzServer) fizzBuzzResponse(ctx context.Context, input model.FizzBuzzInput, paginationMax int, key string) ([]byte, error) {
+	return fbs.cachedGeneration(ctx, input, key, func() ([]byte, error) {
+		return fbs.generateFizzBuzz(ctx, input, paginationMax)
+	})
+}
+
+// cachedGeneration returns the response generated by generate for the page of input, from the cache of the
+// responses by key if enabled, holding a slot of the concurrency limit while generating it (see fizzBuzzResponse)
+func (fbs *FizzBuzzServer) cachedGeneration(ctx context.Context, input model.FizzBuzzInput, key string, generate func() ([]byte, error)) ([]byte, error) {
+	limited := func() ([]byte, error) {
+		if fbs.generations != nil {
+			release, err := fbs.generations.Acquire(ctx, int64(fbs.pageElements(input)))
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+		}
+		return generate()
+	}
+	if fbs.responses != nil {
+		return fbs.responses.Get(ctx, key, limited)
+	}
+	return limited()
+}
+
+// renderGenerationError renders err, returned by fizzBuzzResponse; a request shed by the concurrency limiting can be
+// retried a second later
+func renderGenerationError(rw http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, concurrency.Shed{}) {
+		rw.Header().Set(RetryAfterHeader, "1")
+	}
+	renderError(rw, r, err)
+}
+
+// generateFizzBuzz returns the encoded response to input, paginated by paginationMax
+func (fbs *FizzBuzzServer) generateFizzBuzz(ctx context.Context, input model.FizzBuzzInput, paginationMax int) ([]byte, error) {
+	output := model.FizzBuzzOutput{}
+	input, output.Next = paginate(input, paginationMax)
+
+	_, generationSpan := tracing.Start(ctx, "fizzbuzz.Fizzbuzz")
+	output.Sequence = fizzbuzz.Fizzbuzz(input)
+	generationSpan.SetAttributes(attribute.Int("fizzbuzz.sequence_length", len(output.Sequence)))
+	generationSpan.End()
+	fbs.Metrics.ObserveSequence(len(output.Sequence))
+
+	_, encodingSpan := tracing.Start(ctx, "json.Marshal")
+	respPayload, err := json.Marshal(&output)
+	tracing.End(encodingSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling response: %w", err)
+	}
+	return respPayload, nil
+}
+
+// paginate returns the first page of the sequence of input, of at most paginationMax elements, and the link to the
+// request of the next page, empty if the sequence isn't paginated
+func paginate(input model.FizzBuzzInput, paginationMax int) (model.FizzBuzzInput, string) {
+	if fizzbuzz.Length(input) <= uint64(paginationMax) {
+		return input, ""
+	}
+	next := fmt.Sprintf("/fizzbuzz?int1=%d&int2=%d&limit=%d&start=%d&str1=%s&str2=%s", input.Int1, input.Int2, input.Limit, input.Start+paginationMax, url.QueryEscape(input.Str1), url.QueryEscape(input.Str2))
+	input.Limit = input.Start + paginationMax - 1
+	return input, next
+}
+
+// fizzBuzzCacheKey is the canonical key of the response to input, paginated by paginationMax. It includes the
+// version of the encoding, so that the responses cached by a previous version are not served
+func fizzBuzzCacheKey(input model.FizzBuzzInput, paginationMax int) string {
+	return formatCacheKey(fizzBuzzFormatVersion, input, paginationMax)
+}
+
+// formatCacheKey is the canonical key of the response to input, paginated by paginationMax, in the representation
+// of version
+func formatCacheKey(version string, input model.FizzBuzzInput, paginationMax int) string {
+	return fmt.Sprintf("%s:%d:%d:%d:%d:%d:%q:%q", version, paginationMax, input.Int1, input.Int2,
+		input.Start, input.Limit, input.Str1, input.Str2)
+}
+
+// pageElements returns the number of elements of the page of the fizzbuzz sequence generated for input
+func (fbs *FizzBuzzServer) pageElements(input model.FizzBuzzInput) int {
+	paginationMax := fbs.paginationMax()
+	if length := fizzbuzz.Length(input); length < uint64(paginationMax) {
+		return int(length)
+	}
+	return paginationMax
 }
 
 // GetStatisticsHandler is the handler for the /statistics endpoint under method GET. The
 // response is the set of input parameters most requested. If two sets share the same request count,
 // then the set returned is the first by reversed lexicographical order. Please note that the start parameter
 // of GET /fizzbuzz is not considered in the input parameter set; furthermore, only a validated set (i.e. a set
-// where the input parameters are complaint with the validations) is considered for the statistics
+// where the input parameters are complaint with the validations) is considered for the statistics.
+// The responses carry a strong ETag derived from their content and, as Last-Modified, the time when
+// the server instance first served the current statistics; they are cacheable for the short
+// cache.statistics_max_age and validated by If-None-Match or If-Modified-Since
 func (fbs *FizzBuzzServer) GetStatisticsHandler(rw http.ResponseWriter, r *http.Request) {
 	res, err := fbs.Stats.Stats(r.Context())
 	if err != nil {
-		statisticsApplicationError(rw, r, err)
+		renderError(rw, r, operationError{appType: AppErrorTypeStats, title: "error.statistics", err: err})
 		return
 	}
 
 	respPayload, err := json.Marshal(&res)
 	if err != nil {
-		oplog := httplog.LogEntry(r.Context())
-		oplog.Err(fmt.Errorf("error marshaling response: %w", err)).Msg("")
-		jsonApplicationError(rw, r)
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+
+	etag := strongETag(respPayload)
+	modified := fbs.statistics.observe(etag, time.Now())
+	fbs.setCacheHeaders(rw, r, fbs.cacheConfig().StatisticsMaxAge, etag, modified)
+	if notModified(r, etag, modified) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(respPayload)
+}
+
+// PostWebhookHandler is the handler for the /webhooks endpoint under method POST. The request body is
+// a model.Webhook, which is validated and registered; the response is the registered webhook with its
+// identifier. The secret is never part of a response.
+func (fbs *FizzBuzzServer) PostWebhookHandler(rw http.ResponseWriter, r *http.Request) {
+	var webhook model.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		renderError(rw, r, parsingError{err})
+		return
+	}
+
+	if err := validation.ValidateWebhook(webhook); err != nil {
+		renderError(rw, r, err)
+		return
+	}
+
+	registered, err := fbs.Webhooks.Register(r.Context(), webhook)
+	if err != nil {
+		renderError(rw, r, webhooksError(err))
+		return
+	}
+	registered.Secret = ""
+
+	respPayload, err := json.Marshal(&registered)
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusCreated)
+	rw.Write(respPayload)
+}
+
+// GetWebhooksHandler is the handler for the /webhooks endpoint under method GET. The response is the list
+// of registered webhooks, without their secrets
+func (fbs *FizzBuzzServer) GetWebhooksHandler(rw http.ResponseWriter, r *http.Request) {
+	registered, err := fbs.Webhooks.Webhooks(r.Context())
+	if err != nil {
+		renderError(rw, r, webhooksError(err))
+		return
+	}
+	for i := range registered {
+		registered[i].Secret = ""
+	}
+
+	respPayload, err := json.Marshal(&registered)
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
 		return
 	}
 
@@ -78,3 +301,14 @@ func (fbs *FizzBuzzServer) GetStatisticsHandler(rw http.ResponseWriter, r *http.
 	rw.WriteHeader(http.StatusOK)
 	rw.Write(respPayload)
 }
+
+// DeleteWebhookHandler is the handler for the /webhooks/{id} endpoint under method DELETE. The webhook
+// is unregistered and its pending events are discarded
+func (fbs *FizzBuzzServer) DeleteWebhookHandler(rw http.ResponseWriter, r *http.Request) {
+	if err := fbs.Webhooks.Unregister(r.Context(), chi.URLParam(r, "id")); err != nil {
+		renderError(rw, r, webhooksError(err))
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
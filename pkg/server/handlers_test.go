@@ -7,12 +7,19 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/go-chi/chi/v5"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/metrics"
 	"github.com/peano88/fizzbuzz-rest/pkg/model"
 	"github.com/peano88/fizzbuzz-rest/pkg/server/mocks"
 	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
+	"github.com/peano88/fizzbuzz-rest/pkg/webhooks"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -68,6 +75,108 @@ func TestGetFizzBuzzHandler_OK_WithPagination(t *testing.T) {
 	assert.Equal(t, 65536, len(output.Sequence))
 }
 
+func TestGetFizzBuzzHandler_OK_NegativeStart(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.PaginationMax = 10
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 3, 5, 100, "a", "b").Return(int64(1), nil)
+	tbs := FizzBuzzServer{
+		Stats: stats,
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	// the span between start and limit overflows an int, the sequence is still paginated
+	resp := httptest.NewRecorder()
+	s.Handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz?int1=3&int2=5&str1=a&str2=b&limit=100&start=-9223372036854775800", nil))
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+
+	var output model.FizzBuzzOutput
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+	assert.Len(t, output.Sequence, 10)
+	assert.Equal(t, []string{"ab", "-9223372036854775799"}, output.Sequence[:2])
+	assert.Equal(t, "/fizzbuzz?int1=3&int2=5&limit=100&start=-9223372036854775790&str1=a&str2=b", output.Next)
+}
+
+func TestGetFizzBuzzHandler_Cache(t *testing.T) {
+	cfg := config.Default()
+	cfg.Cache.Backend = "memory"
+	cfg.Limits.PaginationMax = 5
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 2, 3, mock.Anything, "f", "b").Return(int64(1), nil)
+	tbs := FizzBuzzServer{
+		Stats:   stats,
+		Metrics: metrics.New(),
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	serve := func(query string) string {
+		resp := httptest.NewRecorder()
+		s.Handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz?int1=2&int2=3&str1=f&str2=b&"+query, nil))
+		require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+		return resp.Body.String()
+	}
+
+	first := serve("limit=7")
+	assert.Equal(t, first, serve("limit=7"))
+	exposed := httptest.NewRecorder()
+	tbs.Metrics.Handler().ServeHTTP(exposed, httptest.NewRequest(http.MethodGet, "http://example.com/metrics", nil))
+	assert.Contains(t, exposed.Body.String(), `fizzbuzz_cache_hits_total{tier="memory"} 1`)
+	assert.Contains(t, exposed.Body.String(), "fizzbuzz_cache_misses_total 1")
+
+	// the pagination is part of the key
+	cfg.Limits.PaginationMax = 10
+	_, err = tbs.Reload(cfg)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, serve("limit=7"))
+
+	// the requests are still counted in the statistics
+	stats.AssertNumberOfCalls(t, "Increment", 3)
+}
+
+func TestGetFizzBuzzHandler_Concurrency(t *testing.T) {
+	cfg := config.Default()
+	cfg.Cache.Backend = "memory"
+	cfg.Limits.Concurrency.Enable = true
+	cfg.Limits.Concurrency.MaxLimit = 1
+	cfg.Limits.Concurrency.QueueSize = 0
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 2, 3, mock.Anything, "f", "b").Return(int64(1), nil)
+	tbs := FizzBuzzServer{
+		Stats: stats,
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz?int1=2&int2=3&str1=f&str2=b&"+query, nil)
+		req.Header.Set(AcceptHeader, ProblemJSONContentType)
+		s.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+	require.Equal(t, http.StatusOK, serve("limit=7").Code)
+
+	// the only slot is taken and no request can wait
+	release, err := tbs.generations.Acquire(context.Background(), 1)
+	require.NoError(t, err)
+	defer release()
+
+	// a cached response doesn't need a slot
+	assert.Equal(t, http.StatusOK, serve("limit=7").Code)
+
+	resp := serve("limit=8")
+	require.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, "1", resp.Header().Get(RetryAfterHeader))
+	var problem model.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, AppErrorTypeOverload, problem.Type)
+}
+
 func TestGetStatistics_Ok(t *testing.T) {
 	resp := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
@@ -96,6 +205,84 @@ func TestGetStatistics_Ok(t *testing.T) {
 	assert.Equal(t, toReturn, output)
 }
 
+func TestGetFizzBuzzHandler_ConditionalGet(t *testing.T) {
+	input := model.FizzBuzzInput{
+		FizzBuzzInputStats: model.FizzBuzzInputStats{
+			Int1:  2,
+			Int2:  3,
+			Limit: 7,
+			Str1:  "f",
+			Str2:  "b",
+		},
+		Start: 1,
+	}
+	fbs := FizzBuzzServer{}
+	serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set(IfNoneMatchHeader, ifNoneMatch)
+		}
+		fbs.GetFizzBuzzHandler(resp, req.WithContext(context.WithValue(req.Context(), model.InputKey, input)))
+		return resp
+	}
+
+	resp := serve("")
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	etag := resp.Header().Get(ETagHeader)
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, "public, max-age=3600", resp.Header().Get(CacheControlHeader))
+	// the ETag is deterministic
+	assert.Equal(t, etag, serve("").Header().Get(ETagHeader))
+
+	resp = serve(`"other", W/` + etag)
+	assert.Equal(t, http.StatusNotModified, resp.Result().StatusCode)
+	assert.Empty(t, resp.Body.Bytes())
+	assert.Equal(t, etag, resp.Header().Get(ETagHeader))
+
+	assert.Equal(t, http.StatusOK, serve(`"other"`).Result().StatusCode)
+
+	// another page has another ETag
+	input.Start = 2
+	assert.NotEqual(t, etag, serve("").Header().Get(ETagHeader))
+}
+
+func TestGetStatistics_ConditionalGet(t *testing.T) {
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Stats", mock.Anything).Return(model.FizzBuzzStatisticsOutput{Hits: 9}, nil).Times(3)
+	stats.On("Stats", mock.Anything).Return(model.FizzBuzzStatisticsOutput{Hits: 10}, nil)
+	fbs := FizzBuzzServer{
+		Stats: stats,
+	}
+	serve := func(header, value string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		fbs.GetStatisticsHandler(resp, req)
+		return resp
+	}
+
+	resp := serve("", "")
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Equal(t, "public, max-age=5", resp.Header().Get(CacheControlHeader))
+	etag := resp.Header().Get(ETagHeader)
+	lastModified := resp.Header().Get(LastModifiedHeader)
+	_, err := http.ParseTime(lastModified)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusNotModified, serve(IfNoneMatchHeader, etag).Result().StatusCode)
+	resp = serve(IfModifiedSinceHeader, lastModified)
+	assert.Equal(t, http.StatusNotModified, resp.Result().StatusCode)
+	assert.Equal(t, lastModified, resp.Header().Get(LastModifiedHeader))
+
+	// the statistics changed
+	resp = serve(IfNoneMatchHeader, etag)
+	assert.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.NotEqual(t, etag, resp.Header().Get(ETagHeader))
+}
+
 func TestGetStatistics_Ko_noResults(t *testing.T) {
 	resp := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
@@ -110,6 +297,7 @@ func TestGetStatistics_Ko_noResults(t *testing.T) {
 
 	fbs.GetStatisticsHandler(resp, req)
 	assert.Equal(t, http.StatusServiceUnavailable, resp.Result().StatusCode)
+	assert.Equal(t, JSONContentType, resp.Header().Get(ContentTypeHeader))
 	var output model.ApplicationError
 	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
 	assert.Equal(t, AppErrorTypeStats, output.Type)
@@ -129,7 +317,184 @@ func TestGetStatistics_Ko_otherError(t *testing.T) {
 
 	fbs.GetStatisticsHandler(resp, req)
 	assert.Equal(t, http.StatusInternalServerError, resp.Result().StatusCode)
+	assert.Equal(t, JSONContentType, resp.Header().Get(ContentTypeHeader))
 	var output model.ApplicationError
 	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
 	assert.Equal(t, AppErrorTypeStats, output.Type)
 }
+
+func newWebhookRouter(t *testing.T) (http.Handler, *webhooks.Dispatcher) {
+	dispatcher := webhooks.NewDispatcher(webhooks.NewMemoryStore(), config.Default().Webhooks)
+
+	fbs := FizzBuzzServer{
+		Webhooks: dispatcher,
+	}
+	r := chi.NewRouter()
+	r.Post("/webhooks", fbs.PostWebhookHandler)
+	r.Get("/webhooks", fbs.GetWebhooksHandler)
+	r.Delete("/webhooks/{id}", fbs.DeleteWebhookHandler)
+	return r, dispatcher
+}
+
+func TestWebhookHandlers_Ok(t *testing.T) {
+	router, _ := newWebhookRouter(t)
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/webhooks", strings.NewReader(`{"URL":"http://example.com/hook","Trigger":"threshold","Threshold":10,"Secret":"s3cr3t"}`))
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusCreated, resp.Result().StatusCode)
+	var registered model.Webhook
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&registered))
+	assert.NotEmpty(t, registered.ID)
+	assert.Empty(t, registered.Secret)
+
+	resp = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/webhooks", nil)
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	var list []model.Webhook
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Len(t, list, 1)
+	assert.Equal(t, registered, list[0])
+
+	resp = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "http://example.com/webhooks/"+registered.ID, nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNoContent, resp.Result().StatusCode)
+}
+
+func TestWebhookHandlers_Ko(t *testing.T) {
+	router, _ := newWebhookRouter(t)
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/webhooks", strings.NewReader(`{"URL":`))
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Result().StatusCode)
+	var output model.ApplicationError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+	assert.Equal(t, AppErrorTypeParsing, output.Type)
+
+	resp = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "http://example.com/webhooks", strings.NewReader(`{"URL":"http://example.com/hook","Trigger":"bottom","Secret":"s3cr3t"}`))
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Result().StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+	assert.Equal(t, AppErrorTypeInput, output.Type)
+
+	resp = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "http://example.com/webhooks/unknown", nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Result().StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+	assert.Equal(t, AppErrorTypeWebhook, output.Type)
+}
+
+// checkedStats is a FizzBuzzStats implementing HealthChecker
+type checkedStats struct {
+	*mocks.FizzBuzzStats
+	err error
+}
+
+func (cs checkedStats) HealthCheck(ctx context.Context) error {
+	return cs.err
+}
+
+func TestHealthz(t *testing.T) {
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/healthz", nil)
+
+	fbs := FizzBuzzServer{}
+	fbs.GetHealthzHandler(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	var output model.HealthOutput
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+	assert.Equal(t, model.HealthStatusOK, output.Status)
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		label        string
+		stats        FizzBuzzStats
+		shuttingDown bool
+		status       int
+		dependencies map[string]model.DependencyHealth
+	}{
+		{"no checker", mocks.NewFizzBuzzStats(t), false, http.StatusOK, nil},
+		{"ready", checkedStats{}, false, http.StatusOK, map[string]model.DependencyHealth{
+			"statistics": {Status: model.HealthStatusOK},
+		}},
+		{"statistics down", checkedStats{err: errors.New("dummy")}, false, http.StatusServiceUnavailable, map[string]model.DependencyHealth{
+			"statistics": {Status: model.HealthStatusUnavailable, Error: "dummy"},
+		}},
+		{"shutting down", checkedStats{}, true, http.StatusServiceUnavailable, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/readyz", nil)
+
+			fbs := FizzBuzzServer{
+				Stats: tt.stats,
+			}
+			if tt.shuttingDown {
+				fbs.SetShuttingDown()
+			}
+			fbs.GetReadyzHandler(resp, req)
+
+			assert.Equal(t, tt.status, resp.Result().StatusCode)
+			assert.Equal(t, JSONContentType, resp.Result().Header.Get(ContentTypeHeader))
+			var output model.HealthOutput
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+			assert.Equal(t, tt.dependencies, output.Dependencies)
+		})
+	}
+}
+
+func TestPostFizzBuzzHandler(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.PaginationMax = 5
+	cfg.Limits.MaxBodyBytes = 128
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 2, 3, 7, "fizz & co", "bézé").Return(int64(1), nil).Once()
+	tbs := FizzBuzzServer{
+		Stats: stats,
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	serve := func(body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/api/v1/fizzbuzz", strings.NewReader(body))
+		req.Header.Set(ContentTypeHeader, JSONContentType)
+		s.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := serve(`{"int1":2,"int2":3,"limit":7,"str1":"fizz & co","str2":"bézé"}`)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var output model.FizzBuzzOutput
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+	assert.Equal(t, []string{"1", "fizz & co", "bézé", "fizz & co", "5"}, output.Sequence)
+	assert.Equal(t, "/fizzbuzz?int1=2&int2=3&limit=7&start=6&str1=fizz+%26+co&str2=b%C3%A9z%C3%A9", output.Next)
+	assert.Empty(t, resp.Header().Get(ETagHeader))
+	stats.AssertExpectations(t)
+
+	for body, appErrorType := range map[string]string{
+		`{"int1":0,"int2":3,"limit":7,"str1":"f","str2":"b"}`:                                AppErrorTypeInput,
+		`{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b","str3":"z"}`:                     AppErrorTypeInput,
+		`{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b"} {"int1":2}`:                     AppErrorTypeParsing,
+		`{"int1":"2","int2":3,"limit":7,"str1":"f","str2":"b"}`:                              AppErrorTypeInput,
+		`{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"` + strings.Repeat("b", 128) + `"}`: AppErrorTypeTooLarge,
+	} {
+		resp := serve(body)
+		var appError model.ApplicationError
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&appError), body)
+		assert.Equal(t, appErrorType, appError.Type, body)
+	}
+	resp = serve(`{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"` + strings.Repeat("b", 128) + `"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	assert.Contains(t, resp.Body.String(), "the request body should not be larger than 128 bytes")
+}
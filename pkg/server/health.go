@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/httplog"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
+)
+
+const (
+	// maximum duration of a dependency check
+	healthCheckTimeout = 2 * time.Second
+)
+
+// HealthChecker is an optional interface of the components the server depends on (e.g. FizzBuzzStats):
+// components implementing it are checked by /readyz
+type HealthChecker = statistics.HealthChecker
+
+// SetShuttingDown marks the server as shutting down: /readyz answers 503 from now on, so that the orchestrator
+// stops routing new requests to the server before the connections are closed
+func (fbs *FizzBuzzServer) SetShuttingDown() {
+	fbs.shuttingDown.Store(true)
+}
+
+// GetHealthzHandler is the handler for the /healthz endpoint under method GET. It answers 200 as long as the
+// process is able to serve HTTP requests; the dependencies are not checked
+func (fbs *FizzBuzzServer) GetHealthzHandler(rw http.ResponseWriter, r *http.Request) {
+	writeHealth(rw, r, http.StatusOK, model.HealthOutput{
+		Status: model.HealthStatusOK,
+	})
+}
+
+// GetReadyzHandler is the handler for the /readyz endpoint under method GET. It checks every dependency implementing
+// HealthChecker and answers 200 if all of them are available, 503 otherwise or if the server is shutting down.
+// The status of each dependency is reported in the response.
+func (fbs *FizzBuzzServer) GetReadyzHandler(rw http.ResponseWriter, r *http.Request) {
+	if fbs.shuttingDown.Load() {
+		writeHealth(rw, r, http.StatusServiceUnavailable, model.HealthOutput{
+			Status: model.HealthStatusUnavailable,
+			Detail: "shutting down",
+		})
+		return
+	}
+
+	output := model.HealthOutput{
+		Status:       model.HealthStatusOK,
+		Dependencies: map[string]model.DependencyHealth{},
+	}
+	status := http.StatusOK
+
+	dependencies := map[string]interface{}{
+		"statistics": fbs.Stats,
+	}
+	for name, dependency := range dependencies {
+		checker, ok := dependency.(HealthChecker)
+		if !ok {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		err := checker.HealthCheck(ctx)
+		cancel()
+
+		if err != nil {
+			oplog := httplog.LogEntry(r.Context())
+			oplog.Err(fmt.Errorf("dependency %s not ready: %w", name, err)).Msg("")
+			output.Dependencies[name] = model.DependencyHealth{
+				Status: model.HealthStatusUnavailable,
+				Error:  err.Error(),
+			}
+			output.Status = model.HealthStatusUnavailable
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		output.Dependencies[name] = model.DependencyHealth{
+			Status: model.HealthStatusOK,
+		}
+	}
+
+	writeHealth(rw, r, status, output)
+}
+
+func writeHealth(rw http.ResponseWriter, r *http.Request, status int, output model.HealthOutput) {
+	respPayload, err := json.Marshal(&output)
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(status)
+	rw.Write(respPayload)
+}
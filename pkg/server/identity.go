@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/httplog"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// allowAnyIdentity allows any recognized identity on a path prefix
+	allowAnyIdentity = "*"
+)
+
+// identities recognizes the client identities from their certificates and authorizes them on the requested paths,
+// based on the Identities and Routes of a config.TLSConfig
+type identities struct {
+	// identity names, in increasing order: the first matching identity is the recognized one
+	names  []string
+	byName map[string]config.IdentityConfig
+	// path prefixes, longest first: the first matching prefix applies
+	prefixes []string
+	routes   map[string][]string
+}
+
+func newIdentities(cfg config.TLSConfig) *identities {
+	ids := &identities{
+		names:    make([]string, 0, len(cfg.Identities)),
+		byName:   cfg.Identities,
+		prefixes: make([]string, 0, len(cfg.Routes)),
+		routes:   cfg.Routes,
+	}
+	for name := range cfg.Identities {
+		ids.names = append(ids.names, name)
+	}
+	sort.Strings(ids.names)
+	for prefix := range cfg.Routes {
+		ids.prefixes = append(ids.prefixes, prefix)
+	}
+	sort.Slice(ids.prefixes, func(i, j int) bool {
+		if len(ids.prefixes[i]) != len(ids.prefixes[j]) {
+			return len(ids.prefixes[i]) > len(ids.prefixes[j])
+		}
+		return ids.prefixes[i] < ids.prefixes[j]
+	})
+	return ids
+}
+
+// identify returns the name of the identity matching the certificate, empty if none
+func (ids *identities) identify(certificate *x509.Certificate) string {
+	sans := make([]string, 0, len(certificate.DNSNames)+len(certificate.EmailAddresses)+len(certificate.URIs))
+	sans = append(sans, certificate.DNSNames...)
+	sans = append(sans, certificate.EmailAddresses...)
+	for _, uri := range certificate.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	for _, name := range ids.names {
+		identity := ids.byName[name]
+		if contains(identity.CommonNames, certificate.Subject.CommonName) {
+			return name
+		}
+		for _, san := range sans {
+			if contains(identity.SANs, san) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// allowed returns the identities allowed on path, nil if the path is not restricted
+func (ids *identities) allowed(path string) []string {
+	for _, prefix := range ids.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return ids.routes[prefix]
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityMiddleware is the middleware recognizing the client identity from the verified client certificate, as
+// configured by the TLS identities. The name of the identity is added to the request context (see
+// utils.IdentityFromContext), to the request logs and to the server span. If the requested path is restricted by
+// the TLS routes, a request without a recognized identity is rejected with 401, a recognized identity which is
+// not allowed with 403
+func (fbs *FizzBuzzServer) IdentityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ids := fbs.identities()
+
+		identity := ""
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			identity = ids.identify(r.TLS.VerifiedChains[0][0])
+		}
+
+		ctx := r.Context()
+		if identity != "" {
+			ctx = context.WithValue(ctx, model.IdentityKey, identity)
+			httplog.LogEntrySetField(ctx, "identity", identity)
+			trace.SpanFromContext(ctx).SetAttributes(semconv.EnduserID(identity))
+		}
+
+		if allowed := ids.allowed(r.URL.Path); allowed != nil {
+			if identity == "" {
+				renderError(rw, r, unauthenticated{i18n.New("error.identity_required")})
+				return
+			}
+			if !contains(allowed, identity) && !contains(allowed, allowAnyIdentity) {
+				renderError(rw, r, forbidden{i18n.New("error.identity_not_allowed")})
+				return
+			}
+		}
+
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
+
+// identities returns the identities of the current configuration
+func (fbs *FizzBuzzServer) identities() *identities {
+	if live := fbs.live.Load(); live != nil {
+		return live.identities
+	}
+	return newIdentities(config.TLSConfig{})
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/utils"
+	"github.com/peano88/fizzbuzz-rest/pkg/webhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func identitiesConfig() config.TLSConfig {
+	return config.TLSConfig{
+		Identities: map[string]config.IdentityConfig{
+			"ops":     {CommonNames: []string{"ops.example.com"}},
+			"spiffe":  {SANs: []string{"spiffe://example.com/ops", "bot@example.com"}},
+			"reports": {SANs: []string{"reports.example.com"}},
+		},
+		Routes: map[string][]string{
+			"/api/v1/webhooks":      {"ops", "spiffe"},
+			"/api/v1/webhooks/read": {"*"},
+			"/api/v1/statistics":    {"reports"},
+		},
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	ids := newIdentities(identitiesConfig())
+
+	spiffe, err := url.Parse("spiffe://example.com/ops")
+	require.NoError(t, err)
+	tests := map[string]*x509.Certificate{
+		"ops":     {Subject: pkix.Name{CommonName: "ops.example.com"}},
+		"spiffe":  {Subject: pkix.Name{CommonName: "unknown"}, URIs: []*url.URL{spiffe}},
+		"reports": {DNSNames: []string{"other.example.com", "reports.example.com"}},
+		"":        {Subject: pkix.Name{CommonName: "reports.example.com"}, EmailAddresses: []string{"ops@example.com"}},
+	}
+	for expected, certificate := range tests {
+		assert.Equal(t, expected, ids.identify(certificate), expected)
+	}
+	assert.Equal(t, "spiffe", ids.identify(&x509.Certificate{EmailAddresses: []string{"bot@example.com"}}))
+
+	assert.Equal(t, []string{"ops", "spiffe"}, ids.allowed("/api/v1/webhooks/42"))
+	assert.Equal(t, []string{"*"}, ids.allowed("/api/v1/webhooks/read"))
+	assert.Equal(t, []string{"reports"}, ids.allowed("/api/v1/statistics"))
+	assert.Nil(t, ids.allowed("/api/v1/fizzbuzz"))
+}
+
+func TestIdentityMiddleware(t *testing.T) {
+	cfg := config.Default()
+	cfg.TLS.Identities = identitiesConfig().Identities
+	cfg.TLS.Routes = identitiesConfig().Routes
+	tbs := FizzBuzzServer{}
+	_, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	var identity string
+	handler := tbs.IdentityMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		identity = utils.IdentityFromContext(r.Context())
+	}))
+
+	request := func(path, commonName string) *httptest.ResponseRecorder {
+		identity = ""
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		if commonName != "" {
+			leaf := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := request("/api/v1/fizzbuzz", "")
+	assert.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Equal(t, "", identity)
+
+	resp = request("/api/v1/fizzbuzz", "ops.example.com")
+	assert.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Equal(t, "ops", identity)
+
+	resp = request("/api/v1/webhooks", "ops.example.com")
+	assert.Equal(t, http.StatusOK, resp.Result().StatusCode)
+
+	resp = request("/api/v1/webhooks", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Result().StatusCode)
+	assert.Equal(t, JSONContentType, resp.Header().Get(ContentTypeHeader))
+	var appError model.ApplicationError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&appError))
+	assert.Equal(t, AppErrorTypeAuth, appError.Type)
+	assert.Equal(t, "401", appError.Status)
+
+	// a certificate not matching any identity is not enough
+	resp = request("/api/v1/webhooks/read", "unknown.example.com")
+	assert.Equal(t, http.StatusUnauthorized, resp.Result().StatusCode)
+
+	resp = request("/api/v1/statistics", "ops.example.com")
+	assert.Equal(t, http.StatusForbidden, resp.Result().StatusCode)
+	appError = model.ApplicationError{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&appError))
+	assert.Equal(t, AppErrorTypeAuth, appError.Type)
+	assert.Equal(t, "403", appError.Status)
+
+	// the routes are reloaded with the configuration
+	cfg.TLS.Routes = map[string][]string{"/api/v1/statistics": {"ops"}}
+	_, err = tbs.Reload(cfg)
+	require.NoError(t, err)
+	resp = request("/api/v1/statistics", "ops.example.com")
+	assert.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	resp = request("/api/v1/webhooks", "")
+	assert.Equal(t, http.StatusOK, resp.Result().StatusCode)
+}
+
+func TestSetCacheHeaders_Identities(t *testing.T) {
+	cfg := config.Default()
+	cfg.TLS.Identities = identitiesConfig().Identities
+	cfg.TLS.Routes = identitiesConfig().Routes
+	tbs := FizzBuzzServer{}
+	_, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	// the responses of the restricted paths are not stored by the shared caches
+	resp := httptest.NewRecorder()
+	tbs.setCacheHeaders(resp, httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/statistics", nil), time.Minute, `"e"`, time.Time{})
+	assert.Equal(t, "private, max-age=60", resp.Header().Get(CacheControlHeader))
+
+	resp = httptest.NewRecorder()
+	tbs.setCacheHeaders(resp, httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz", nil), time.Minute, `"e"`, time.Time{})
+	assert.Equal(t, "public, max-age=60", resp.Header().Get(CacheControlHeader))
+}
+
+func TestConfigureServer_MutualTLS(t *testing.T) {
+	opsCert, opsKey := writeKeyPair(t, t.TempDir(), "ops.example.com")
+	devCert, devKey := writeKeyPair(t, t.TempDir(), "dev.example.com")
+	bundle := []byte{}
+	for _, path := range []string{opsCert, devCert} {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		bundle = append(bundle, content...)
+	}
+
+	cfg := config.Default()
+	cfg.TLS.Enable = true
+	cfg.TLS.ClientAuthType = "verify_client_cert_if_given"
+	cfg.TLS.Cert, cfg.TLS.Key = writeKeyPair(t, t.TempDir(), "localhost")
+	cfg.TLS.ClientCA = filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(cfg.TLS.ClientCA, bundle, 0o600))
+	cfg.TLS.Identities = map[string]config.IdentityConfig{
+		"ops": {CommonNames: []string{"ops.example.com"}},
+		"dev": {CommonNames: []string{"dev.example.com"}},
+	}
+	cfg.TLS.Routes = map[string][]string{"/api/v1/webhooks": {"ops"}}
+	require.NoError(t, cfg.Validate())
+
+	tbs := FizzBuzzServer{
+		Webhooks: webhooks.NewDispatcher(webhooks.NewMemoryStore(), config.Default().Webhooks),
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(tls.NewListener(ln, s.TLSConfig))
+	defer s.Close()
+
+	get := func(certificates ...tls.Certificate) int {
+		client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       certificates,
+		}}}
+		resp, err := client.Get("https://" + ln.Addr().String() + "/api/v1/webhooks")
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	ops, err := tls.LoadX509KeyPair(opsCert, opsKey)
+	require.NoError(t, err)
+	dev, err := tls.LoadX509KeyPair(devCert, devKey)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, get(ops))
+	assert.Equal(t, http.StatusForbidden, get(dev))
+	assert.Equal(t, http.StatusUnauthorized, get())
+
+	// a certificate not issued by the client CA bundle is refused during the handshake
+	other, err := tls.LoadX509KeyPair(writeKeyPair(t, t.TempDir(), "ops.example.com"))
+	require.NoError(t, err)
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{other},
+	}}}
+	_, err = client.Get("https://" + ln.Addr().String() + "/api/v1/webhooks")
+	assert.Error(t, err)
+}
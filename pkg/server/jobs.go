@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/fizzbuzz"
+	"github.com/peano88/fizzbuzz-rest/pkg/jobs"
+	"github.com/peano88/fizzbuzz-rest/pkg/utils"
+)
+
+const (
+	// Header key for the URL of a created resource
+	LocationHeader = "Location"
+
+	// delay, in seconds, before a job rejected because the queue, the store or the active jobs of its client are full
+	// can be submitted again
+	jobsRetryAfter = "30"
+)
+
+// jobCost is the cost of a validated job submission on top of its flat requestCost: one token for each
+// cfg.CostElements elements of the whole sequence
+func (fbs *FizzBuzzServer) jobCost(r *http.Request, cfg config.RateLimitConfig) int64 {
+	input := utils.FizzBuzzInputFromContext(r.Context())
+	elements := fizzbuzz.Length(input) / uint64(cfg.CostElements)
+	if elements >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(elements)
+}
+
+// PostJobHandler is the handler for the /jobs endpoint under method POST. It expects the input parameters as the
+// body of POST /fizzbuzz, validated alike, and submits a job of the client of the request (see clientKey) generating
+// the whole sequence, never paginated, in the background. The response is the submitted job, with status 202
+// Accepted and its URL as Location
+func (fbs *FizzBuzzServer) PostJobHandler(rw http.ResponseWriter, r *http.Request) {
+	job, err := fbs.Jobs.Submit(r.Context(), clientKey(r), utils.FizzBuzzInputFromContext(r.Context()))
+	if err != nil {
+		if errors.Is(err, jobs.QueueFull{}) || errors.Is(err, jobs.StoreFull{}) || asError[jobs.TooManyActive](err) {
+			rw.Header().Set(RetryAfterHeader, jobsRetryAfter)
+		}
+		renderError(rw, r, jobsError(err))
+		return
+	}
+
+	respPayload, err := json.Marshal(&job)
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+
+	rw.Header().Set(LocationHeader, "/api/v1/jobs/"+job.ID)
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusAccepted)
+	rw.Write(respPayload)
+}
+
+// GetJobHandler is the handler for the /jobs/{id} endpoint under method GET. The response is the job, reporting its
+// status and progress, and the link to its result once completed
+func (fbs *FizzBuzzServer) GetJobHandler(rw http.ResponseWriter, r *http.Request) {
+	job, err := fbs.Jobs.Job(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		renderError(rw, r, jobsError(err))
+		return
+	}
+
+	respPayload, err := json.Marshal(&job)
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(respPayload)
+}
+
+// GetJobResultHandler is the handler for the /jobs/{id}/result endpoint under method GET. The response streams the
+// result of the completed job, a model.FizzBuzzOutput, supporting the Range requests. The result never changes: it
+// carries a strong ETag and, as Last-Modified, the completion time of the job, validating the conditional and If-Range
+// requests. A job not completed is answered with 409 Conflict. A result can take longer than the write timeout of the
+// server to be streamed: the write deadline is pushed back before each write instead, so that only a stalled client is
+// timed out
+func (fbs *FizzBuzzServer) GetJobResultHandler(rw http.ResponseWriter, r *http.Request) {
+	job, result, err := fbs.Jobs.Result(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		renderError(rw, r, jobsError(err))
+		return
+	}
+	defer result.Close()
+
+	rw.Header().Set(ContentTypeHeader, JSONContentType)
+	rw.Header().Set(ETagHeader, strongETag([]byte(job.ID)))
+	if timeout := fbs.writeTimeout(); timeout > 0 {
+		rw = deadlineWriter{ResponseWriter: rw, rc: http.NewResponseController(rw), timeout: timeout}
+	}
+	http.ServeContent(rw, r, "", *job.FinishedAt, result)
+}
+
+// deadlineWriter is an http.ResponseWriter setting the write deadline of the response timeout after each write starts
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+// Write is the http.ResponseWriter interface implementation
+func (dw deadlineWriter) Write(p []byte) (int, error) {
+	// without support for the write deadlines, e.g. by a recorder, the write timeout of the server applies
+	_ = dw.rc.SetWriteDeadline(time.Now().Add(dw.timeout))
+	return dw.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for the http.ResponseController
+func (dw deadlineWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
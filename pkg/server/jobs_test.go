@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/fizzbuzz"
+	"github.com/peano88/fizzbuzz-rest/pkg/jobs"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/server/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newJobsManager returns a jobs manager storing the jobs in a temporary directory, running until the end of the test
+func newJobsManager(t *testing.T, cfg config.JobsConfig) *jobs.Manager {
+	cfg.Enable = true
+	cfg.Dir = t.TempDir()
+	manager, err := jobs.NewManager(cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		manager.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return manager
+}
+
+func TestJobHandlers(t *testing.T) {
+	cfg := config.Default()
+	cfg.Jobs.MaxElements = 1000
+	cfg.Jobs.ChunkBytes = 128
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 3, 5, 500, "fizz", "bézé").Return(int64(1), nil).Once()
+	tbs := FizzBuzzServer{
+		Stats: stats,
+		Jobs:  newJobsManager(t, cfg.Jobs),
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	serve := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com"+target, strings.NewReader(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp := httptest.NewRecorder()
+		s.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := serve(http.MethodPost, "/api/v1/jobs", `{"int1":3,"int2":5,"limit":500,"str1":"fizz","str2":"bézé"}`, nil)
+	require.Equal(t, http.StatusAccepted, resp.Code)
+	var job model.Job
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.Equal(t, "/api/v1/jobs/"+job.ID, resp.Header().Get(LocationHeader))
+	assert.Equal(t, int64(500), job.Elements)
+	stats.AssertExpectations(t)
+
+	require.Eventually(t, func() bool {
+		resp := serve(http.MethodGet, "/api/v1/jobs/"+job.ID, "", nil)
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+		return job.Status == model.JobStatusCompleted
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(500), job.Generated)
+	require.Equal(t, "/jobs/"+job.ID+"/result", job.Result)
+
+	// the whole result
+	resp = serve(http.MethodGet, "/api/v1"+job.Result, "", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, JSONContentType, resp.Header().Get(ContentTypeHeader))
+	etag := resp.Header().Get(ETagHeader)
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, resp.Header().Get(LastModifiedHeader))
+	assert.Equal(t, "bytes", resp.Header().Get(AcceptRangesHeader))
+	content := resp.Body.Bytes()
+	assert.Equal(t, job.Size, int64(len(content)))
+	var output model.FizzBuzzOutput
+	require.NoError(t, json.Unmarshal(content, &output))
+	assert.Equal(t, fizzbuzz.Fizzbuzz(model.FizzBuzzInput{
+		FizzBuzzInputStats: model.FizzBuzzInputStats{Int1: 3, Int2: 5, Limit: 500, Str1: "fizz", Str2: "bézé"},
+		Start:              1,
+	}), output.Sequence)
+
+	// a range of the result, across chunks, never compressed
+	resp = serve(http.MethodGet, "/api/v1"+job.Result, "", http.Header{
+		"Range":              []string{"bytes=100-299"},
+		AcceptEncodingHeader: []string{"gzip"},
+	})
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Empty(t, resp.Header().Get(ContentEncodingHeader))
+	assert.Equal(t, "bytes 100-299/"+strconv.FormatInt(job.Size, 10), resp.Header().Get("Content-Range"))
+	assert.Equal(t, content[100:300], resp.Body.Bytes())
+
+	// the range is ignored if the result changed
+	resp = serve(http.MethodGet, "/api/v1"+job.Result, "", http.Header{"Range": []string{"bytes=100-299"}, "If-Range": []string{`"other"`}})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = serve(http.MethodGet, "/api/v1"+job.Result, "", http.Header{"Range": []string{"bytes=100-299"}, "If-Range": []string{etag}})
+	assert.Equal(t, http.StatusPartialContent, resp.Code)
+
+	resp = serve(http.MethodGet, "/api/v1"+job.Result, "", http.Header{"Range": []string{"bytes=100000-"}})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.Code)
+	resp = serve(http.MethodGet, "/api/v1"+job.Result, "", http.Header{IfNoneMatchHeader: []string{etag}})
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+}
+
+func TestJobHandlers_Errors(t *testing.T) {
+	cfg := config.Default()
+	cfg.Jobs.MaxElements = 1000
+	cfg.Jobs.QueueSize = 1
+	cfg.Jobs.Enable = true
+	cfg.Jobs.Dir = t.TempDir()
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 3, 5, mock.Anything, "fizz", "buzz").Return(int64(1), nil)
+	manager, err := jobs.NewManager(cfg.Jobs)
+	require.NoError(t, err)
+	// the manager doesn't run, the jobs stay pending
+	tbs := FizzBuzzServer{
+		Stats: stats,
+		Jobs:  manager,
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com"+target, strings.NewReader(body))
+		req.Header.Set(AcceptHeader, ProblemJSONContentType)
+		resp := httptest.NewRecorder()
+		s.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+	problem := func(resp *httptest.ResponseRecorder) model.Problem {
+		var problem model.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		return problem
+	}
+
+	resp := serve(http.MethodPost, "/api/v1/jobs", `{"int1":3,"int2":5,"limit":1001,"str1":"fizz","str2":"buzz"}`)
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	assert.Equal(t, AppErrorTypeTooLarge, problem(resp).Type)
+
+	resp = serve(http.MethodPost, "/api/v1/jobs", `{"int1":3,"int2":5,"limit":1000,"str1":"fizz","str2":"buzz"}`)
+	require.Equal(t, http.StatusAccepted, resp.Code)
+	var job model.Job
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+
+	resp = serve(http.MethodPost, "/api/v1/jobs", `{"int1":3,"int2":5,"limit":10,"str1":"fizz","str2":"buzz"}`)
+	require.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, jobsRetryAfter, resp.Header().Get(RetryAfterHeader))
+	assert.Equal(t, AppErrorTypeOverload, problem(resp).Type)
+
+	resp = serve(http.MethodGet, "/api/v1/jobs/"+job.ID+"/result", "")
+	require.Equal(t, http.StatusConflict, resp.Code)
+	assert.Equal(t, AppErrorTypeJob, problem(resp).Type)
+
+	for _, target := range []string{"/api/v1/jobs/0123456789abcdef0123456789abcdef", "/api/v1/jobs/0123456789abcdef0123456789abcdef/result", "/api/v1/jobs/..%2F..%2Fetc/result"} {
+		resp = serve(http.MethodGet, target, "")
+		require.Equal(t, http.StatusNotFound, resp.Code, target)
+		assert.Equal(t, AppErrorTypeJob, problem(resp).Type, target)
+	}
+}
+
+func TestJobHandlers_Limits(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.RateLimit.Backend = "memory"
+	cfg.Limits.RateLimit.Burst = 10
+	cfg.Limits.RateLimit.CostElements = 10
+	cfg.Jobs.MaxElements = 1000
+	cfg.Jobs.MaxActive = 1
+	cfg.Jobs.QueueSize = 1
+	cfg.Jobs.Enable = true
+	cfg.Jobs.Dir = t.TempDir()
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 3, 5, mock.Anything, "fizz", "buzz").Return(int64(1), nil)
+	manager, err := jobs.NewManager(cfg.Jobs)
+	require.NoError(t, err)
+	// the manager doesn't run, the jobs stay pending
+	tbs := FizzBuzzServer{
+		Stats: stats,
+		Jobs:  manager,
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	serve := func(remoteAddr, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/api/v1/jobs", strings.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(AcceptHeader, ProblemJSONContentType)
+		resp := httptest.NewRecorder()
+		s.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+	problem := func(resp *httptest.ResponseRecorder) model.Problem {
+		var problem model.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		return problem
+	}
+
+	// a job costing more than the burst is rejected, it would never be allowed
+	resp := serve("192.0.2.1:1234", `{"int1":3,"int2":5,"limit":1000,"str1":"fizz","str2":"buzz"}`)
+	require.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Empty(t, resp.Header().Get(RetryAfterHeader))
+	assert.Equal(t, "request costing more than the whole budget of the client", problem(resp).Detail)
+
+	resp = serve("192.0.2.1:1234", `{"int1":3,"int2":5,"limit":50,"str1":"fizz","str2":"buzz"}`)
+	require.Equal(t, http.StatusAccepted, resp.Code)
+
+	// the active jobs of each client are bounded
+	resp = serve("192.0.2.1:1234", `{"int1":3,"int2":5,"limit":10,"str1":"fizz","str2":"buzz"}`)
+	require.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, jobsRetryAfter, resp.Header().Get(RetryAfterHeader))
+	assert.Equal(t, "a client should not have more than 1 pending or running jobs", problem(resp).Detail)
+
+	resp = serve("198.51.100.1:1234", `{"int1":3,"int2":5,"limit":10,"str1":"fizz","str2":"buzz"}`)
+	require.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, AppErrorTypeOverload, problem(resp).Type)
+}
+
+// slowJobs is a JobQueue serving a completed job whose result is read slowly
+type slowJobs struct {
+	JobQueue
+	result []byte
+	delay  time.Duration
+}
+
+func (s slowJobs) Result(_ context.Context, id string) (model.Job, io.ReadSeekCloser, error) {
+	finished := time.Now()
+	return model.Job{ID: id, Status: model.JobStatusCompleted, FinishedAt: &finished},
+		slowResult{Reader: bytes.NewReader(s.result), delay: s.delay}, nil
+}
+
+// slowResult is a result read by small chunks, each taking delay
+type slowResult struct {
+	*bytes.Reader
+	delay time.Duration
+}
+
+func (s slowResult) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.Reader.Read(p[:min(len(p), 1024)])
+}
+
+func (s slowResult) Close() error {
+	return nil
+}
+
+func TestGetJobResultHandler_WriteTimeout(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.WriteTimeout = 100 * time.Millisecond
+	cfg.Jobs.Enable = true
+
+	result := bytes.Repeat([]byte("fizzbuzz"), 1024)
+	tbs := FizzBuzzServer{
+		Jobs: slowJobs{result: result, delay: 25 * time.Millisecond},
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(s.Handler)
+	ts.Config.WriteTimeout = cfg.Server.WriteTimeout
+	ts.Start()
+	defer ts.Close()
+
+	// the result takes 8 times the write timeout to be streamed
+	resp, err := http.Get(ts.URL + "/api/v1/jobs/9f86d081884c7d659a2feaa0c55ad015/result")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, result, body)
+}
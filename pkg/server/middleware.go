@@ -1,32 +1,88 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/go-chi/httplog"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/tracing"
 	"github.com/peano88/fizzbuzz-rest/pkg/utils"
 	"github.com/peano88/fizzbuzz-rest/pkg/validation"
 )
 
-// ValidationMiddleware is an HTTP middleware which runs a set of validation on the
-// query parameter of the request. It forwards a modified context.Context to the next handler
+// BodyLimitMiddleware is an HTTP middleware bounding the body of the requests to limit() bytes, so that the
+// middlewares reading it whole, e.g. OpenAPIMiddleware, can't be made to buffer an arbitrarily large one. Reading
+// beyond the limit fails with a *http.MaxBytesError
+func (fbs *FizzBuzzServer) BodyLimitMiddleware(limit func() int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(rw, r.Body, limit())
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// ValidationMiddleware is an HTTP middleware which runs a set of validation, including the rules
+// configured by the validation settings, on the query parameter of the request, or on its JSON body for the POST
+// requests (see validateBody). It forwards a modified context.Context to the next handler
 // obtained by inserting the validated set of input parameters
 func (fbs *FizzBuzzServer) ValidationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-
-		forwardContext, err := validation.NewFizzBuzzValidator().RunValidations(r)
+		ctx, span := tracing.Start(r.Context(), "ValidationMiddleware")
+		var validated context.Context
+		var err error
+		if r.Method == http.MethodPost {
+			validated, err = fbs.validateBody(ctx, r)
+		} else {
+			validated, err = fbs.validator().RunValidations(r.WithContext(ctx))
+		}
+		tracing.End(span, err)
 		if err != nil {
-			oplog := httplog.LogEntry(r.Context())
-			oplog.Err(fmt.Errorf("validation error: %w", err)).Msg("")
-			validationApplicationError(rw, r, err)
+			for _, valErr := range validation.Errors(err) {
+				fbs.Metrics.ValidationFailure(valErr.Parameter())
+			}
+			renderError(rw, r, err)
 			return
 		}
 
+		// the next handlers aren't parented by the ended span of the validation
+		forwardContext := context.WithValue(r.Context(), model.InputKey, utils.FizzBuzzInputFromContext(validated))
 		next.ServeHTTP(rw, r.WithContext(forwardContext))
 	})
 }
 
+// validateBody strictly decodes the JSON body of r, a model.FizzBuzzRequest, and validates it within ctx: a body
+// larger than the limit of BodyLimitMiddleware, including unknown fields or followed by other data is rejected. The
+// body can be read again afterwards
+func (fbs *FizzBuzzServer) validateBody(ctx context.Context, r *http.Request) (context.Context, error) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, parsingError{err}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(content))
+
+	var req model.FizzBuzzRequest
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, parsingError{err}
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return nil, parsingError{errors.New("unexpected data after the request body")}
+	}
+	return fbs.validator().RunBodyValidations(ctx, req)
+}
+
 // ToStatisticsMiddleware is an HTTP middleware sending the set of input parameters to the statistics component.
 // the set is retrieved via the request context.Context. If an error arises, then the error is logged, but the
 // next handler is called anyway
@@ -34,7 +90,10 @@ func (fbs *FizzBuzzServer) ToStatisticsMiddleware(next http.Handler) http.Handle
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		input := utils.FizzBuzzInputFromContext(r.Context())
 
-		if err := fbs.Stats.Increment(r.Context(), input.Int1, input.Int2, input.Limit, input.Str1, input.Str2); err != nil {
+		ctx, span := tracing.Start(r.Context(), "ToStatisticsMiddleware")
+		_, err := fbs.Stats.Increment(ctx, input.Int1, input.Int2, input.Limit, input.Str1, input.Str2)
+		tracing.End(span, err)
+		if err != nil {
 			oplog := httplog.LogEntry(r.Context())
 			oplog.Err(fmt.Errorf("error incrementing stats: %w", err)).Msg("")
 		}
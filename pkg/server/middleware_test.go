@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
 	"github.com/peano88/fizzbuzz-rest/pkg/model"
 	"github.com/peano88/fizzbuzz-rest/pkg/server/mocks"
 	"github.com/peano88/fizzbuzz-rest/pkg/utils"
@@ -49,13 +50,92 @@ func TestValidationMiddleware_Ko(t *testing.T) {
 	assert.Equal(t, AppErrorTypeInput, appError.Type)
 }
 
+func TestValidationMiddleware_Problem(t *testing.T) {
+	fbs := FizzBuzzServer{}
+	handler := fbs.ValidationMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Fail(t, "should not call next handler")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com?int1=two&int2=3&limit=seven&str1=f&str2=b", nil)
+	req.Header.Set(AcceptHeader, "application/json;q=0.5, application/problem+json")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Result().StatusCode)
+	assert.Equal(t, ProblemJSONContentType, resp.Header().Get(ContentTypeHeader))
+	var problem model.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, AppErrorTypeInput, problem.Type)
+	assert.Equal(t, http.StatusBadRequest, problem.Status)
+	require.Len(t, problem.Errors, 2)
+	assert.Equal(t, "int1", problem.Errors[0].Parameter)
+	assert.Equal(t, "int1: two is not a positive integer", problem.Errors[0].Detail)
+	assert.NotEmpty(t, problem.Errors[0].Constraint)
+	assert.Equal(t, "limit", problem.Errors[1].Parameter)
+
+	// the legacy representation is kept by default
+	req.Header.Del(AcceptHeader)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, JSONContentType, resp.Header().Get(ContentTypeHeader))
+	var legacy map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&legacy))
+	assert.Equal(t, AppErrorTypeInput, legacy["err_type"])
+	assert.Equal(t, "400", legacy["Status"])
+	assert.NotContains(t, legacy, "errors")
+}
+
+func TestValidationMiddleware_Rules(t *testing.T) {
+	fbs := FizzBuzzServer{}
+	_, err := fbs.Configure(config.Default())
+	require.NoError(t, err)
+
+	called := 0
+	handler := fbs.ValidationMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called++
+	}))
+	target := "http://example.com?int1=2&int2=3&limit=7&str1=f&str2=b%20b"
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	assert.Equal(t, 1, called)
+
+	// the rules are reloaded with the configuration
+	cfg := config.Default()
+	cfg.Validation.Characters = "letter"
+	_, err = fbs.Reload(cfg)
+	require.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, target, nil))
+	require.Equal(t, http.StatusBadRequest, resp.Result().StatusCode)
+	var appError model.ApplicationError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&appError))
+	assert.Equal(t, "str2 can only include characters of the classes letter", appError.Detail)
+	assert.Equal(t, 1, called)
+}
+
+func TestAcceptsProblem(t *testing.T) {
+	for accept, expected := range map[string]bool{
+		"":                         false,
+		"*/*":                      false,
+		"application/json":         false,
+		"application/problem+json": true,
+		"application/json, application/problem+json":       true,
+		"application/problem+json;q=0.5, application/json": false,
+		"application/problem+json;q=0":                     false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set(AcceptHeader, accept)
+		assert.Equal(t, expected, acceptsProblem(req), accept)
+	}
+}
+
 func TestStatisticsMiddleware_Ok(t *testing.T) {
 
 	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
 	resp := httptest.NewRecorder()
 
 	stats := mocks.NewFizzBuzzStats(t)
-	stats.On("Increment", mock.AnythingOfType("*context.valueCtx"), 2, 3, 6, "f", "b").Return(nil)
+	stats.On("Increment", mock.AnythingOfType("*context.valueCtx"), 2, 3, 6, "f", "b").Return(int64(1), nil)
 
 	fbs := FizzBuzzServer{
 		Stats: stats,
@@ -84,7 +164,7 @@ func TestStatisticsMiddleware_Ko(t *testing.T) {
 	resp := httptest.NewRecorder()
 
 	stats := mocks.NewFizzBuzzStats(t)
-	stats.On("Increment", mock.AnythingOfType("*context.valueCtx"), 2, 3, 6, "f", "b").Return(errors.New("dummy"))
+	stats.On("Increment", mock.AnythingOfType("*context.valueCtx"), 2, 3, 6, "f", "b").Return(int64(0), errors.New("dummy"))
 
 	fbs := FizzBuzzServer{
 		Stats: stats,
@@ -15,17 +15,27 @@ type FizzBuzzStats struct {
 }
 
 // Increment provides a mock function with given fields: ctx, n, m, top, fizz, buzz
-func (_m *FizzBuzzStats) Increment(ctx context.Context, n int, m int, top int, fizz string, buzz string) error {
+func (_m *FizzBuzzStats) Increment(ctx context.Context, n int, m int, top int, fizz string, buzz string) (int64, error) {
 	ret := _m.Called(ctx, n, m, top, fizz, buzz)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, int, int, int, string, string) error); ok {
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, int, int, string, string) (int64, error)); ok {
+		return rf(ctx, n, m, top, fizz, buzz)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, int, int, string, string) int64); ok {
 		r0 = rf(ctx, n, m, top, fizz, buzz)
 	} else {
-		r0 = ret.Error(0)
+		r0 = ret.Get(0).(int64)
 	}
 
-	return r0
+	if rf, ok := ret.Get(1).(func(context.Context, int, int, int, string, string) error); ok {
+		r1 = rf(ctx, n, m, top, fizz, buzz)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
 // Stats provides a mock function with given fields: ctx
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/httplog"
+	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// Header key for the protocol used by the client, set by the proxies
+	ForwardedProtoHeader = "X-Forwarded-Proto"
+	// Header key for the host requested by the client, set by the proxies
+	ForwardedHostHeader = "X-Forwarded-Host"
+)
+
+// OpenAPIMiddleware is an HTTP middleware validating the requests against the OpenAPI document of the api: the
+// parameters and the body of a request not matching the operation of its route are rejected with 400. When the log
+// level is debug or trace, the responses are validated too and every mismatch is logged, so that the document and
+// the handlers can't drift apart unnoticed. The requests of routes not documented are forwarded as they are
+func (fbs *FizzBuzzServer) OpenAPIMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if fbs.openAPI == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		route, ok := fbs.openAPI.Find(r.Method, r.URL.Path)
+		if !ok {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		if err := route.ValidateRequest(r); err != nil {
+			var errs validation.ValidationErrors
+			var tooLarge *http.MaxBytesError
+			if !errors.As(err, &errs) && !errors.As(err, &tooLarge) {
+				err = parsingError{err}
+			}
+			for _, valErr := range errs {
+				fbs.Metrics.ValidationFailure(valErr.Parameter())
+			}
+			renderError(rw, r, err)
+			return
+		}
+
+		if zerolog.GlobalLevel() > zerolog.DebugLevel {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		ww := middleware.NewWrapResponseWriter(rw, r.ProtoMajor)
+		var body bytes.Buffer
+		ww.Tee(&body)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if err := route.ValidateResponse(status, ww.Header(), body.Bytes()); err != nil {
+			oplog := httplog.LogEntry(r.Context())
+			oplog.Warn().Err(err).Int("status", status).Msg("response not matching the OpenAPI document")
+		}
+	})
+}
+
+// GetOpenAPIJSONHandler is the handler for the /openapi.json endpoint under method GET. The response is the OpenAPI
+// document of the api in JSON, stamped with the version of the server and with its server URL (see serverURL)
+func (fbs *FizzBuzzServer) GetOpenAPIJSONHandler(rw http.ResponseWriter, r *http.Request) {
+	payload, err := fbs.published.JSON(fbs.serverURL(r))
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(payload)
+}
+
+// GetOpenAPIYAMLHandler is the handler for the /openapi.yaml endpoint under method GET. The response is the OpenAPI
+// document of the api in YAML, stamped with the version of the server and with its server URL (see serverURL)
+func (fbs *FizzBuzzServer) GetOpenAPIYAMLHandler(rw http.ResponseWriter, r *http.Request) {
+	payload, err := fbs.published.YAML(fbs.serverURL(r))
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+	rw.Header().Add(ContentTypeHeader, YAMLContentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(payload)
+}
+
+// serverURL returns the absolute URL of the api server: the configured one if any, otherwise the one used by the
+// client, as forwarded by a proxy if the request comes from a trusted one (see docs.trusted_proxies)
+func (fbs *FizzBuzzServer) serverURL(r *http.Request) string {
+	if configured := fbs.docsConfig().ServerURL; configured != "" {
+		return configured
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if fbs.trustedProxy(r) {
+		if proto := r.Header.Get(ForwardedProtoHeader); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := r.Header.Get(ForwardedHostHeader); forwarded != "" {
+			host, _, _ = strings.Cut(forwarded, ",")
+		}
+	}
+	return scheme + "://" + strings.TrimSpace(host)
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	fizzbuzzrest "github.com/peano88/fizzbuzz-rest"
+	"github.com/peano88/fizzbuzz-rest/pkg/auth"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/docs"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/server/mocks"
+	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
+	"github.com/peano88/fizzbuzz-rest/pkg/webhooks"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// openAPITestKey is the API key granting the admin scope on the server of newOpenAPIServer
+const openAPITestKey = "ops.o"
+
+func newOpenAPIServer(t *testing.T, stats FizzBuzzStats) (*FizzBuzzServer, http.Handler) {
+	// the webhooks are only served to authenticated requests
+	cfg := config.Default()
+	cfg.Auth.APIKeys = "file"
+	cfg.Auth.APIKeysFile = filepath.Join(t.TempDir(), "keys.yaml")
+	require.NoError(t, os.WriteFile(cfg.Auth.APIKeysFile, []byte("keys:\n  - {id: ops, hash: "+auth.Hash("o")+", scopes: [admin]}\n"), 0o600))
+
+	tbs := &FizzBuzzServer{
+		Stats:    stats,
+		Webhooks: webhooks.NewDispatcher(webhooks.NewMemoryStore(), config.Default().Webhooks),
+		Jobs:     newJobsManager(t, config.Default().Jobs),
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+	return tbs, s.Handler
+}
+
+func TestOpenAPI_Routes(t *testing.T) {
+	tbs, handler := newOpenAPIServer(t, mocks.NewFizzBuzzStats(t))
+
+	var routes []string
+	require.NoError(t, chi.Walk(handler.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routes = append(routes, method+" "+strings.TrimSuffix(route, "/"))
+		return nil
+	}))
+	sort.Strings(routes)
+	// every route is documented, and every documented operation is served
+	assert.Equal(t, tbs.openAPI.Operations(), routes)
+}
+
+func TestOpenAPI_Responses(t *testing.T) {
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 2, 3, 7, "f", "b").Return(int64(1), nil)
+	stats.On("Stats", mock.Anything).Return(model.FizzBuzzStatisticsOutput{}, statistics.NoStatsAvailable{}).Once()
+	stats.On("Stats", mock.Anything).Return(model.FizzBuzzStatisticsOutput{
+		Parameters: model.FizzBuzzInputStats{Int1: 2, Int2: 3, Limit: 7, Str1: "f", Str2: "b"},
+		Hits:       9,
+	}, nil).Once()
+	tbs, handler := newOpenAPIServer(t, stats)
+
+	serve := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com"+target, strings.NewReader(body))
+		req.Header.Set(auth.APIKeyHeader, openAPITestKey)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		route, ok := tbs.openAPI.Find(method, req.URL.Path)
+		require.True(t, ok, target)
+		assert.NoError(t, route.ValidateResponse(resp.Code, resp.Header(), resp.Body.Bytes()), "%s %s", method, target)
+		return resp
+	}
+	problem := http.Header{AcceptHeader: []string{ProblemJSONContentType}}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/fizzbuzz?int1=2&int2=3&limit=7&str1=f&str2=b", "", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/api/v1/fizzbuzz?int1=two&int2=3&limit=7&str1=f", "", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/api/v1/fizzbuzz?int1=two&int2=3&limit=7&str1=f", "", problem).Code)
+	assert.Equal(t, http.StatusPartialContent, serve(http.MethodGet, "/api/v1/fizzbuzz?int1=2&int2=3&limit=7&str1=f&str2=b", "", http.Header{RangeHeader: []string{"items=2-4"}}).Code)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, serve(http.MethodGet, "/api/v1/fizzbuzz?int1=2&int2=3&limit=7&str1=f&str2=b", "", http.Header{RangeHeader: []string{"items=7-"}}).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/fizzbuzz", `{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b"}`, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/api/v1/fizzbuzz", `{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b","str3":"z"}`, problem).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/api/v1/fizzbuzz", `{"int1":2,"int2":3,"limit":7,"str1":"f"}`, nil).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/fizzbuzz/batch", `[{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b"},{"int1":0}]`, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/api/v1/fizzbuzz/batch", `[1]`, problem).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodGet, "/api/v1/statistics", "", nil).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/statistics", "", nil).Code)
+
+	resp := serve(http.MethodPost, "/api/v1/webhooks", `{"URL":"http://example.com/hook","Trigger":"threshold","Threshold":10,"Secret":"s3cr3t"}`, nil)
+	require.Equal(t, http.StatusCreated, resp.Code)
+	var registered model.Webhook
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&registered))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/webhooks", "", nil).Code)
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/api/v1/webhooks/"+registered.ID, "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/api/v1/webhooks/"+registered.ID, "", problem).Code)
+
+	resp = serve(http.MethodPost, "/api/v1/jobs", `{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b"}`, nil)
+	require.Equal(t, http.StatusAccepted, resp.Code)
+	var submitted model.Job
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&submitted))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/jobs/"+submitted.ID, "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/api/v1/jobs/unknown", "", problem).Code)
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/openapi.json", "", nil).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/openapi.yaml", "", nil).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/healthz", "", nil).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/readyz", "", nil).Code)
+}
+
+func TestOpenAPIMiddleware(t *testing.T) {
+	_, handler := newOpenAPIServer(t, mocks.NewFizzBuzzStats(t))
+
+	// the body is validated against the document before reaching the handler
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/api/v1/webhooks", strings.NewReader(`{"URL":"http://example.com/hook","Trigger":5}`))
+	req.Header.Set(auth.APIKeyHeader, openAPITestKey)
+	req.Header.Set(AcceptHeader, ProblemJSONContentType)
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	var problem model.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, AppErrorTypeInput, problem.Type)
+	require.Len(t, problem.Errors, 1)
+	assert.Equal(t, "Trigger", problem.Errors[0].Parameter)
+	assert.Equal(t, "Trigger is not of type string", problem.Errors[0].Detail)
+
+	// a body which can't be decoded is a parsing error
+	resp = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "http://example.com/api/v1/webhooks", strings.NewReader(`{"URL":`))
+	req.Header.Set(auth.APIKeyHeader, openAPITestKey)
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	var appError model.ApplicationError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&appError))
+	assert.Equal(t, AppErrorTypeParsing, appError.Type)
+
+	// in debug mode, the responses are forwarded as they are once validated
+	previous := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(previous)
+	fbs := FizzBuzzServer{}
+	_, err := fbs.Configure(config.Default())
+	require.NoError(t, err)
+	resp = httptest.NewRecorder()
+	fbs.OpenAPIMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		renderError(rw, r, errors.New("undocumented"))
+	})).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com/healthz", nil))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.Equal(t, JSONContentType, resp.Header().Get(ContentTypeHeader))
+	assert.NotEmpty(t, resp.Body.String())
+}
+
+func TestOpenAPIMiddleware_BeforeValidation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	_, handler := newOpenAPIServer(t, mocks.NewFizzBuzzStats(t))
+
+	// the body satisfies the fizzbuzz validation rules, but not the media type documented by the operation
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/api/v1/fizzbuzz", strings.NewReader(`{"int1":2,"int2":3,"limit":7,"str1":"f","str2":"b"}`))
+	req.Header.Set(auth.APIKeyHeader, openAPITestKey)
+	req.Header.Set(ContentTypeHeader, "text/plain")
+	req.Header.Set(AcceptHeader, ProblemJSONContentType)
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	var problem model.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, AppErrorTypeInput, problem.Type)
+	require.Len(t, problem.Errors, 1)
+	assert.Equal(t, "Content-Type", problem.Errors[0].Parameter)
+
+	// the request never reached the fizzbuzz validation
+	for _, span := range recorder.Ended() {
+		assert.NotEqual(t, "ValidationMiddleware", span.Name())
+	}
+
+	// the body is bounded before being read by the OpenAPI check
+	resp = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "http://example.com/api/v1/jobs", strings.NewReader(`{"str1":"`+strings.Repeat("f", 1<<20)+`"}`))
+	req.Header.Set(auth.APIKeyHeader, openAPITestKey)
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+}
+
+func TestGetOpenAPIHandlers(t *testing.T) {
+	_, handler := newOpenAPIServer(t, mocks.NewFizzBuzzStats(t))
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/openapi.json", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, JSONContentType, resp.Header().Get(ContentTypeHeader))
+	var document struct {
+		Info    struct{ Version string }
+		Servers []struct{ URL string }
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&document))
+	assert.Equal(t, fizzbuzzrest.Version, document.Info.Version)
+	assert.Equal(t, "http://example.com/api/v1", document.Servers[0].URL)
+
+	// the forwarded headers of an untrusted client are ignored
+	resp = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/openapi.yaml", nil)
+	req.Header.Set(ForwardedProtoHeader, "https")
+	req.Header.Set(ForwardedHostHeader, "fizzbuzz.example.com, proxy.local")
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, YAMLContentType, resp.Header().Get(ContentTypeHeader))
+	assert.Contains(t, resp.Body.String(), "url: http://example.com/api/v1")
+
+	// the URL used by the client is the one forwarded by a trusted proxy
+	tbs := FizzBuzzServer{}
+	cfg := config.Default()
+	cfg.Docs.TrustedProxies = "10.0.0.0/8, 192.0.2.0/24"
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+	resp = httptest.NewRecorder()
+	s.Handler.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "url: https://fizzbuzz.example.com/api/v1")
+
+	// the configured URL prevails
+	cfg.Docs.ServerURL = "https://api.example.com"
+	_, err = tbs.Reload(cfg)
+	require.NoError(t, err)
+	resp = httptest.NewRecorder()
+	s.Handler.ServeHTTP(resp, req)
+	assert.Contains(t, resp.Body.String(), "url: https://api.example.com/api/v1")
+}
+
+func TestDocs(t *testing.T) {
+	_, handler := newOpenAPIServer(t, mocks.NewFizzBuzzStats(t))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com/docs/", nil))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	tbs := FizzBuzzServer{}
+	cfg := config.Default()
+	cfg.Docs.Enable = true
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	resp = httptest.NewRecorder()
+	s.Handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com/docs", nil))
+	assert.Equal(t, http.StatusMovedPermanently, resp.Code)
+	assert.Equal(t, "/docs/", resp.Header().Get("Location"))
+
+	for path, contentType := range map[string]string{
+		"/docs/":             "text/html; charset=utf-8",
+		"/docs/explorer.js":  "text/javascript; charset=utf-8",
+		"/docs/explorer.css": "text/css; charset=utf-8",
+	} {
+		resp = httptest.NewRecorder()
+		s.Handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil))
+		require.Equal(t, http.StatusOK, resp.Code, path)
+		assert.Equal(t, contentType, resp.Header().Get(ContentTypeHeader), path)
+		assert.Equal(t, docs.ContentSecurityPolicy, resp.Header().Get("Content-Security-Policy"), path)
+	}
+}
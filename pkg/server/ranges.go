@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/fizzbuzz"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+)
+
+const (
+	// Header key of the ranges of a representation requested by the client
+	RangeHeader = "Range"
+	// Header key making the Range header conditional on the representation of the client
+	IfRangeHeader = "If-Range"
+	// Header key of the range of the representation enclosed in a partial response
+	ContentRangeHeader = "Content-Range"
+	// Header key advertising the support of the Range requests
+	AcceptRangesHeader = "Accept-Ranges"
+
+	// range unit of the elements of a fizzbuzz sequence
+	itemsUnit = "items"
+	// range unit of the bytes of the plain-text representation of a fizzbuzz sequence
+	bytesUnit = "bytes"
+)
+
+// indexRange is a range of the elements or of the bytes of a representation, by their index from 0, both bounds
+// included
+type indexRange struct {
+	first, last uint64
+}
+
+// parseRange parses value, a Range header, as a single range in unit of a representation of length units,
+// following the syntax of the byte ranges (RFC 9110 section 14.1.2): "unit=first-last", "unit=first-" or
+// "unit=-suffix". ok is false if the header should be ignored, i.e. if it is malformed, of another unit or made of
+// many ranges; satisfiable is false if the range selects nothing
+func parseRange(value string, unit string, length uint64) (rng indexRange, ok bool, satisfiable bool) {
+	requested, spec, found := strings.Cut(value, "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(requested), unit) || strings.Contains(spec, ",") {
+		return indexRange{}, false, false
+	}
+	start, end, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return indexRange{}, false, false
+	}
+	if start == "" {
+		// the last suffix elements
+		suffix, err := strconv.ParseUint(end, 10, 64)
+		if err != nil {
+			return indexRange{}, false, false
+		}
+		if suffix == 0 || length == 0 {
+			return indexRange{}, true, false
+		}
+		if suffix > length {
+			suffix = length
+		}
+		return indexRange{first: length - suffix, last: length - 1}, true, true
+	}
+	first, err := strconv.ParseUint(start, 10, 64)
+	if err != nil {
+		return indexRange{}, false, false
+	}
+	last := length - 1
+	if end != "" {
+		if last, err = strconv.ParseUint(end, 10, 64); err != nil || last < first {
+			return indexRange{}, false, false
+		}
+	}
+	if first >= length {
+		return indexRange{}, true, false
+	}
+	if last >= length {
+		last = length - 1
+	}
+	return indexRange{first: first, last: last}, true, true
+}
+
+// ifRangeMatches reports whether the Range header of r applies to the representation identified by etag, according
+// to its If-Range header: the ranges are ignored if the client holds another representation. The comparison is
+// strong, and a date never matches as the sequences have no modification time (RFC 9110 section 13.1.5)
+func ifRangeMatches(r *http.Request, etag string) bool {
+	ifRange := r.Header.Get(IfRangeHeader)
+	return ifRange == "" || strings.TrimSpace(ifRange) == etag
+}
+
+// serveItemsRange answers r, if it requests a range of the items of the sequence of input, with the requested
+// elements of the sequence, the representation identified by etag. At most paginationMax elements are returned, the
+// Content-Range header reporting the actual range. The response is the one of GET /fizzbuzz for the requested
+// elements, with status 206 Partial Content, and is cached alike. It returns false if the whole sequence should be
+// served instead
+func (fbs *FizzBuzzServer) serveItemsRange(ctx context.Context, rw http.ResponseWriter, r *http.Request, input model.FizzBuzzInput,
+	paginationMax int, etag string, maxAge time.Duration) bool {
+	value := r.Header.Get(RangeHeader)
+	if value == "" || !ifRangeMatches(r, etag) {
+		return false
+	}
+	length := fizzbuzz.Length(input)
+	rng, ok, satisfiable := parseRange(value, itemsUnit, length)
+	if !ok {
+		return false
+	}
+	if !satisfiable {
+		rw.Header().Set(ContentRangeHeader, fmt.Sprintf("%s */%d", itemsUnit, length))
+		renderError(rw, r, rangeNotSatisfiable{unit: itemsUnit, length: length})
+		return true
+	}
+	if rng.last-rng.first >= uint64(paginationMax) {
+		rng.last = rng.first + uint64(paginationMax) - 1
+	}
+
+	// the bounds are within the sequence, the wrapping sums are exact
+	page := input
+	page.Start = input.Start + int(rng.first)
+	page.Limit = input.Start + int(rng.last)
+	respPayload, err := fbs.fizzBuzzResponse(ctx, page, paginationMax, fizzBuzzCacheKey(page, paginationMax))
+	if err != nil {
+		renderGenerationError(rw, r, err)
+		return true
+	}
+	fbs.setCacheHeaders(rw, r, maxAge, etag, time.Time{})
+	rw.Header().Set(ContentRangeHeader, fmt.Sprintf("%s %d-%d/%d", itemsUnit, rng.first, rng.last, length))
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusPartialContent)
+	rw.Write(respPayload)
+	return true
+}
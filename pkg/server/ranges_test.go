@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	for value, expected := range map[string]struct {
+		rng         indexRange
+		ok          bool
+		satisfiable bool
+	}{
+		"items=10-19":                 {indexRange{10, 19}, true, true},
+		"Items = 10-19":               {indexRange{10, 19}, true, true},
+		"items=10-":                   {indexRange{10, 99}, true, true},
+		"items=90-200":                {indexRange{90, 99}, true, true},
+		"items=-5":                    {indexRange{95, 99}, true, true},
+		"items=-500":                  {indexRange{0, 99}, true, true},
+		"items=100-":                  {indexRange{}, true, false},
+		"items=-0":                    {indexRange{}, true, false},
+		"items=19-10":                 {indexRange{}, false, false},
+		"items=1-2,5-6":               {indexRange{}, false, false},
+		"items=a-b":                   {indexRange{}, false, false},
+		"items=10":                    {indexRange{}, false, false},
+		"bytes=0-99":                  {indexRange{}, false, false},
+		"items":                       {indexRange{}, false, false},
+		"items=-18446744073709551616": {indexRange{}, false, false},
+	} {
+		rng, ok, satisfiable := parseRange(value, itemsUnit, 100)
+		assert.Equal(t, expected.rng, rng, value)
+		assert.Equal(t, expected.ok, ok, value)
+		assert.Equal(t, expected.satisfiable, satisfiable, value)
+	}
+
+	_, ok, satisfiable := parseRange("items=0-", itemsUnit, 0)
+	assert.True(t, ok)
+	assert.False(t, satisfiable)
+}
+
+func TestGetFizzBuzzHandler_Range(t *testing.T) {
+	input := model.FizzBuzzInput{
+		FizzBuzzInputStats: model.FizzBuzzInputStats{
+			Int1:  2,
+			Int2:  3,
+			Limit: 100,
+			Str1:  "f",
+			Str2:  "b",
+		},
+		Start: 5,
+	}
+	cfg := config.Default()
+	cfg.Limits.PaginationMax = 10
+	fbs := FizzBuzzServer{}
+	_, err := fbs.Configure(cfg)
+	require.NoError(t, err)
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		fbs.GetFizzBuzzHandler(resp, req.WithContext(context.WithValue(req.Context(), model.InputKey, input)))
+		return resp
+	}
+	decode := func(resp *httptest.ResponseRecorder) model.FizzBuzzOutput {
+		var output model.FizzBuzzOutput
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+		return output
+	}
+
+	resp := serve(nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, itemsUnit, resp.Header().Get(AcceptRangesHeader))
+	etag := resp.Header().Get(ETagHeader)
+
+	// the items are indexed from the element start
+	resp = serve(http.Header{RangeHeader: []string{"items=2-5"}})
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Equal(t, "items 2-5/96", resp.Header().Get(ContentRangeHeader))
+	assert.Equal(t, etag, resp.Header().Get(ETagHeader))
+	output := decode(resp)
+	assert.Equal(t, []string{"7", "f", "b", "f"}, output.Sequence)
+	assert.Empty(t, output.Next)
+
+	// at most a page is returned
+	resp = serve(http.Header{RangeHeader: []string{"items=50-"}})
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Equal(t, "items 50-59/96", resp.Header().Get(ContentRangeHeader))
+	assert.Len(t, decode(resp).Sequence, 10)
+
+	resp = serve(http.Header{RangeHeader: []string{"items=-3"}})
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Equal(t, "items 93-95/96", resp.Header().Get(ContentRangeHeader))
+	assert.Equal(t, []string{"f", "b", "f"}, decode(resp).Sequence)
+
+	resp = serve(http.Header{RangeHeader: []string{"items=2-5"}, IfRangeHeader: []string{etag}})
+	assert.Equal(t, http.StatusPartialContent, resp.Code)
+
+	// the whole sequence is served if the range is ignored
+	for _, header := range []http.Header{
+		{RangeHeader: []string{"items=2-5"}, IfRangeHeader: []string{`"other"`}},
+		{RangeHeader: []string{"items=2-5"}, IfRangeHeader: []string{"W/" + etag}},
+		{RangeHeader: []string{"bytes=0-10"}},
+		{RangeHeader: []string{"items=0-1,4-5"}},
+	} {
+		resp = serve(header)
+		require.Equal(t, http.StatusOK, resp.Code, header)
+		assert.Empty(t, resp.Header().Get(ContentRangeHeader), header)
+		assert.Len(t, decode(resp).Sequence, 10, header)
+	}
+
+	resp = serve(http.Header{RangeHeader: []string{"items=96-"}, AcceptHeader: []string{ProblemJSONContentType}})
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.Code)
+	assert.Equal(t, "items */96", resp.Header().Get(ContentRangeHeader))
+	var problem model.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, AppErrorTypeRange, problem.Type)
+	assert.Equal(t, "the sequence has 96 items", problem.Detail)
+
+	// the validators prevail
+	resp = serve(http.Header{RangeHeader: []string{"items=2-5"}, IfNoneMatchHeader: []string{etag}})
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+}
+
+func TestGetFizzBuzzHandler_MaxInt(t *testing.T) {
+	input := model.FizzBuzzInput{
+		FizzBuzzInputStats: model.FizzBuzzInputStats{
+			Int1:  2,
+			Int2:  3,
+			Limit: math.MaxInt,
+			Str1:  "f",
+			Str2:  "b",
+		},
+		Start: math.MaxInt - 2,
+	}
+	fbs := FizzBuzzServer{}
+	_, err := fbs.Configure(config.Default())
+	require.NoError(t, err)
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		fbs.GetFizzBuzzHandler(resp, req.WithContext(context.WithValue(req.Context(), model.InputKey, input)))
+		return resp
+	}
+	expected := []string{"9223372036854775805", "fb", "9223372036854775807"}
+
+	// the generation stops on the last int
+	resp := serve(nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var output model.FizzBuzzOutput
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+	assert.Equal(t, expected, output.Sequence)
+
+	resp = serve(http.Header{RangeHeader: []string{"items=0-"}})
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Equal(t, "items 0-2/3", resp.Header().Get(ContentRangeHeader))
+	output = model.FizzBuzzOutput{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+	assert.Equal(t, expected, output.Sequence)
+}
+
+func TestGetFizzBuzzHandler_PlainText(t *testing.T) {
+	input := model.FizzBuzzInput{
+		FizzBuzzInputStats: model.FizzBuzzInputStats{
+			Int1:  2,
+			Int2:  3,
+			Limit: 100,
+			Str1:  "f",
+			Str2:  "b",
+		},
+		Start: 5,
+	}
+	cfg := config.Default()
+	cfg.Limits.PaginationMax = 10
+	fbs := FizzBuzzServer{}
+	_, err := fbs.Configure(cfg)
+	require.NoError(t, err)
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set(AcceptHeader, "text/plain, application/json;q=0.9")
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		fbs.GetFizzBuzzHandler(resp, req.WithContext(context.WithValue(req.Context(), model.InputKey, input)))
+		return resp
+	}
+
+	// the page is served, one element per line
+	text := "5\nfb\n7\nf\nb\nf\n11\nfb\n13\nf\n"
+	resp := serve(nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, PlainTextContentType, resp.Header().Get(ContentTypeHeader))
+	assert.Equal(t, text, resp.Body.String())
+	assert.Equal(t, bytesUnit, resp.Header().Get(AcceptRangesHeader))
+	assert.Equal(t, `</fizzbuzz?int1=2&int2=3&limit=100&start=15&str1=f&str2=b>; rel="next"`, resp.Header().Get(LinkHeader))
+	assert.Equal(t, AcceptHeader, resp.Header().Get(VaryHeader))
+	etag := resp.Header().Get(ETagHeader)
+
+	jsonResp := serve(http.Header{AcceptHeader: []string{"text/plain;q=0.5, application/json"}})
+	require.Equal(t, http.StatusOK, jsonResp.Code)
+	assert.Equal(t, JSONContentType, jsonResp.Header().Get(ContentTypeHeader))
+	assert.NotEqual(t, etag, jsonResp.Header().Get(ETagHeader))
+
+	// any range of bytes is served
+	for first := 0; first < len(text); first++ {
+		for last := first; last < len(text); last++ {
+			resp = serve(http.Header{RangeHeader: []string{fmt.Sprintf("bytes=%d-%d", first, last)}})
+			require.Equal(t, http.StatusPartialContent, resp.Code)
+			assert.Equal(t, fmt.Sprintf("bytes %d-%d/24", first, last), resp.Header().Get(ContentRangeHeader))
+			assert.Equal(t, text[first:last+1], resp.Body.String())
+			assert.Equal(t, etag, resp.Header().Get(ETagHeader))
+		}
+	}
+
+	resp = serve(http.Header{RangeHeader: []string{"bytes=-4"}, IfRangeHeader: []string{etag}})
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Equal(t, "bytes 20-23/24", resp.Header().Get(ContentRangeHeader))
+	assert.Equal(t, "3\nf\n", resp.Body.String())
+
+	// the page is served if the range is ignored
+	for _, header := range []http.Header{
+		{RangeHeader: []string{"bytes=2-5"}, IfRangeHeader: []string{jsonResp.Header().Get(ETagHeader)}},
+		{RangeHeader: []string{"items=2-5"}},
+		{RangeHeader: []string{"bytes=0-1,4-5"}},
+	} {
+		resp = serve(header)
+		require.Equal(t, http.StatusOK, resp.Code, header)
+		assert.Empty(t, resp.Header().Get(ContentRangeHeader), header)
+		assert.Equal(t, text, resp.Body.String(), header)
+	}
+
+	resp = serve(http.Header{RangeHeader: []string{"bytes=24-"}, AcceptHeader: []string{"text/plain, " + ProblemJSONContentType + ";q=0.9"}})
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.Code)
+	assert.Equal(t, "bytes */24", resp.Header().Get(ContentRangeHeader))
+	var problem model.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, AppErrorTypeRange, problem.Type)
+	assert.Equal(t, "the representation has 24 bytes", problem.Detail)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/httplog"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/ratelimit"
+	"github.com/peano88/fizzbuzz-rest/pkg/utils"
+)
+
+const (
+	// headers describing the budget of the client, as of the IETF draft "RateLimit header fields for HTTP"
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	RateLimitPolicyHeader    = "RateLimit-Policy"
+	// header of the delay before a rate limited request can be retried
+	RetryAfterHeader = "Retry-After"
+)
+
+// rateLimit returns the rate limiting configuration currently applied, false if the rate limiting is disabled
+func (fbs *FizzBuzzServer) rateLimit() (config.RateLimitConfig, bool) {
+	live := fbs.live.Load()
+	if live == nil || fbs.RateLimiter == nil || live.cfg.Limits.RateLimit.Backend == "none" {
+		return config.RateLimitConfig{}, false
+	}
+	return live.cfg.Limits.RateLimit, true
+}
+
+// RateLimitMiddleware returns a middleware taking the cost of each request, as returned by cost, from the budget of
+// its client (see clientKey) with RateLimiter, when the rate limiting is enabled by the configuration. The budget of
+// the client is described by the RateLimit headers of the response; a request exceeding it is rejected with 429 and
+// a Retry-After header, unless it costs more than the whole bucket and would never be allowed. A request costing no
+// token is let through untouched, so that a route can take a flat cost before validating the requests and their
+// further cost afterwards. If the budget can't be checked, e.g. because the redis DB is not available, the request
+// is let through
+func (fbs *FizzBuzzServer) RateLimitMiddleware(cost func(r *http.Request, cfg config.RateLimitConfig) int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			cfg, ok := fbs.rateLimit()
+			if !ok {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			tokens := cost(r, cfg)
+			if tokens <= 0 {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			policy := ratelimit.Policy{
+				Rate:       cfg.Rate,
+				Burst:      int64(cfg.Burst),
+				DailyQuota: int64(cfg.DailyQuota),
+			}
+			decision, err := fbs.RateLimiter.Take(r.Context(), clientKey(r), tokens, policy)
+			if err != nil {
+				oplog := httplog.LogEntry(r.Context())
+				oplog.Err(fmt.Errorf("error checking rate limit: %w", err)).Msg("")
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			rw.Header().Set(RateLimitLimitHeader, strconv.FormatInt(decision.Limit, 10))
+			rw.Header().Set(RateLimitRemainingHeader, strconv.FormatInt(decision.Remaining, 10))
+			rw.Header().Set(RateLimitResetHeader, ceilSeconds(decision.Reset))
+			rw.Header().Set(RateLimitPolicyHeader, rateLimitPolicy(policy))
+			if !decision.Allowed {
+				fbs.Metrics.RateLimited(decision.Reason)
+				// a request costing more than the burst can't be retried
+				if decision.Reason != ratelimit.ReasonCost {
+					rw.Header().Set(RetryAfterHeader, ceilSeconds(decision.RetryAfter))
+				}
+				renderError(rw, r, decision.Err())
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// requestCost is the cost of a request whose processing doesn't depend on its parameters, and the flat cost of any
+// request before its validation, so that the invalid requests are limited as well
+func requestCost(*http.Request, config.RateLimitConfig) int64 {
+	return 1
+}
+
+// fizzBuzzCost is the cost of a validated fizzbuzz request on top of its flat requestCost: one token for each
+// cfg.CostElements elements of the generated page
+func (fbs *FizzBuzzServer) fizzBuzzCost(r *http.Request, cfg config.RateLimitConfig) int64 {
+	elements := fbs.pageElements(utils.FizzBuzzInputFromContext(r.Context()))
+	return int64(elements / cfg.CostElements)
+}
+
+// clientKey identifies the client of r: the authenticated principal, the client certificate identity or the IP
+// address, in this order
+func clientKey(r *http.Request) string {
+	if principal, ok := utils.PrincipalFromContext(r.Context()); ok {
+		return principal.Method + ":" + principal.ID
+	}
+	if identity := utils.IdentityFromContext(r.Context()); identity != "" {
+		return "identity:" + identity
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimitPolicy returns the RateLimit-Policy header value of policy: the bucket capacity with the time needed to
+// fill it, and the daily quota if any
+func rateLimitPolicy(policy ratelimit.Policy) string {
+	window := time.Duration(float64(policy.Burst) / policy.Rate * float64(time.Second))
+	res := []string{fmt.Sprintf("%d;w=%s", policy.Burst, ceilSeconds(window))}
+	if policy.DailyQuota > 0 {
+		res = append(res, fmt.Sprintf("%d;w=%d", policy.DailyQuota, int64((24*time.Hour).Seconds())))
+	}
+	return strings.Join(res, ", ")
+}
+
+// ceilSeconds formats d as a number of seconds, rounded up
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
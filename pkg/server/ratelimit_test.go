@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/ratelimit"
+	"github.com/peano88/fizzbuzz-rest/pkg/server/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// failingLimiter is a ratelimit.Limiter whose storage is not available
+type failingLimiter struct{}
+
+func (failingLimiter) Take(context.Context, string, int64, ratelimit.Policy) (ratelimit.Decision, error) {
+	return ratelimit.Decision{}, errors.New("boom")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.PaginationMax = 5000
+	cfg.Limits.RateLimit.Backend = "memory"
+	cfg.Limits.RateLimit.Rate = 0.001
+	cfg.Limits.RateLimit.Burst = 10
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 3, 5, mock.Anything, "fizz", "buzz").Return(int64(1), nil)
+	tbs := FizzBuzzServer{
+		Stats: stats,
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	serve := func(remoteAddr, query string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz?int1=3&int2=5&str1=fizz&str2=buzz&"+query, nil)
+		req.RemoteAddr = remoteAddr
+		s.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// one token, plus one for each 1000 elements of the page
+	resp := serve("192.0.2.1:1234", "limit=15")
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Equal(t, "9", resp.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, "10", resp.Header().Get(RateLimitLimitHeader))
+	assert.Equal(t, "1000", resp.Header().Get(RateLimitResetHeader))
+	assert.Equal(t, "10;w=10000", resp.Header().Get(RateLimitPolicyHeader))
+
+	resp = serve("192.0.2.1:4321", "limit=2999")
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Equal(t, "6", resp.Header().Get(RateLimitRemainingHeader))
+
+	// the page is bounded by the pagination
+	resp = serve("192.0.2.1:1234", "limit=9223372036854775807")
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Equal(t, "0", resp.Header().Get(RateLimitRemainingHeader))
+
+	resp = serve("192.0.2.1:1234", "limit=15")
+	require.Equal(t, http.StatusTooManyRequests, resp.Result().StatusCode)
+	assert.Equal(t, "1000", resp.Header().Get(RetryAfterHeader))
+	var appError model.ApplicationError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&appError))
+	assert.Equal(t, AppErrorTypeRateLimit, appError.Type)
+	stats.AssertNumberOfCalls(t, "Increment", 3)
+
+	// other clients have their own budget
+	assert.Equal(t, http.StatusOK, serve("198.51.100.1:1234", "limit=15").Result().StatusCode)
+
+	// invalid requests cost one token as well
+	resp = serve("198.51.100.1:1234", "limit=boh")
+	assert.Equal(t, http.StatusBadRequest, resp.Result().StatusCode)
+	assert.Equal(t, "8", resp.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, http.StatusTooManyRequests, serve("192.0.2.1:1234", "limit=boh").Result().StatusCode)
+
+	// the policy is reloadable, the budgets are kept
+	cfg.Limits.RateLimit.Burst = 100
+	_, err = tbs.Reload(cfg)
+	require.NoError(t, err)
+	resp = serve("192.0.2.1:1234", "limit=15")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Result().StatusCode)
+	assert.Equal(t, "100", resp.Header().Get(RateLimitLimitHeader))
+}
+
+func TestRateLimitMiddleware_Quota(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.RateLimit.Backend = "memory"
+	cfg.Limits.RateLimit.DailyQuota = 2
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Stats", mock.Anything).Return(model.FizzBuzzStatisticsOutput{}, nil)
+	tbs := FizzBuzzServer{
+		Stats: stats,
+	}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		resp := serveWithKey(s.Handler, http.MethodGet, "/api/v1/statistics", "")
+		require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+		assert.Equal(t, "2", resp.Header().Get(RateLimitLimitHeader))
+		assert.Equal(t, "100;w=10, 2;w=86400", resp.Header().Get(RateLimitPolicyHeader))
+	}
+	resp := serveWithKey(s.Handler, http.MethodGet, "/api/v1/statistics", "")
+	require.Equal(t, http.StatusTooManyRequests, resp.Result().StatusCode)
+	assert.Equal(t, "0", resp.Header().Get(RateLimitRemainingHeader))
+	assert.NotEmpty(t, resp.Header().Get(RetryAfterHeader))
+}
+
+func TestRateLimitMiddleware_Unavailable(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.RateLimit.Backend = "redis"
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Stats", mock.Anything).Return(model.FizzBuzzStatisticsOutput{}, nil)
+	tbs := FizzBuzzServer{
+		Stats: stats,
+	}
+	_, err := tbs.Configure(cfg)
+	assert.Error(t, err)
+
+	// requests are let through if the budget can't be checked
+	tbs.RateLimiter = failingLimiter{}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+	resp := serveWithKey(s.Handler, http.MethodGet, "/api/v1/statistics", "")
+	assert.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	assert.Empty(t, resp.Header().Get(RateLimitRemainingHeader))
+}
+
+func TestClientKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/statistics", nil)
+	req.RemoteAddr = "[2001:db8::1]:1234"
+	assert.Equal(t, "ip:2001:db8::1", clientKey(req))
+
+	ctx := context.WithValue(req.Context(), model.IdentityKey, "ops")
+	assert.Equal(t, "identity:ops", clientKey(req.WithContext(ctx)))
+
+	ctx = context.WithValue(ctx, model.PrincipalKey, model.Principal{ID: "k1", Method: model.AuthMethodAPIKey})
+	assert.Equal(t, "api_key:k1", clientKey(req.WithContext(ctx)))
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/auth"
+	"github.com/peano88/fizzbuzz-rest/pkg/compression"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/validation"
+	"github.com/rs/zerolog"
+)
+
+// liveConfig holds the configuration currently applied by the server
+type liveConfig struct {
+	cfg        config.Config
+	identities *identities
+	// empty if the authentication is disabled
+	authenticators []authenticator
+	// nil if the compression is disabled
+	codecs *compression.Codecs
+	// validator of the fizzbuzz input parameters, checking the configured rules
+	validator *validation.Validator
+	// proxies whose forwarded headers are honored
+	trustedProxies []netip.Prefix
+}
+
+// apply makes cfg the current configuration, updating the components derived from it; on error, the current
+// configuration is left untouched. Only the settings tagged as reload in config.Config are expected to change
+// between two calls
+func (fbs *FizzBuzzServer) apply(cfg config.Config) error {
+	level, err := zerolog.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return fmt.Errorf("error parsing log level: %w", err)
+	}
+
+	live := &liveConfig{
+		cfg:        cfg,
+		identities: newIdentities(cfg.TLS),
+		validator:  validation.NewFizzBuzzValidator(validation.Rules(cfg.Validation)...),
+	}
+	for _, proxy := range cfg.Docs.Proxies() {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return fmt.Errorf("error parsing trusted proxy: %w", err)
+		}
+		live.trustedProxies = append(live.trustedProxies, prefix)
+	}
+	switch cfg.Auth.APIKeys {
+	case "file":
+		store, err := auth.LoadKeyFile(cfg.Auth.APIKeysFile)
+		if err != nil {
+			return err
+		}
+		live.authenticators = append(live.authenticators, auth.NewAPIKeyAuthenticator(store))
+	case "redis":
+		if fbs.APIKeys == nil {
+			return errors.New("no api keys registry available")
+		}
+		live.authenticators = append(live.authenticators, auth.NewAPIKeyAuthenticator(fbs.APIKeys))
+	}
+	if cfg.Auth.JWT.JWKS != "" {
+		keys := auth.NewJWKS(cfg.Auth.JWT.JWKS, cfg.Auth.JWT.JWKSRefresh)
+		if err := keys.Load(context.Background()); err != nil {
+			return err
+		}
+		live.authenticators = append(live.authenticators, auth.NewJWTAuthenticator(keys, cfg.Auth.JWT))
+	}
+
+	if cfg.Limits.RateLimit.Backend == "redis" && fbs.RateLimiter == nil {
+		return errors.New("no rate limiter available")
+	}
+	if cfg.Cache.Backend == "redis" && fbs.SharedCache == nil {
+		return errors.New("no shared cache available")
+	}
+	if cfg.Compression.Enable {
+		codecs, err := compression.New(cfg.Compression)
+		if err != nil {
+			return err
+		}
+		live.codecs = codecs
+	}
+
+	// the TLS credentials are loaded last, as they can't be rolled back
+	if cfg.TLS.Enable {
+		if err := fbs.Certificates.Update(cfg.TLS); err != nil {
+			return fmt.Errorf("error loading TLS credentials: %w", err)
+		}
+	}
+
+	zerolog.SetGlobalLevel(level)
+	if fbs.generations != nil {
+		fbs.generations.Update(cfg.Limits.Concurrency)
+	}
+	if fbs.responses != nil {
+		fbs.responses.Update(cfg.Cache.MaxBytes, cfg.Cache.TTL)
+	}
+	fbs.live.Store(live)
+	return nil
+}
+
+// Reload applies cfg, which is expected to be validated, to the running server: the log level, the TLS
+// credentials and identities, the API keys file, the JWT settings, the limits, the cache, the compression settings
+// and the validation rules are swapped atomically, so that the requests being served are not disturbed.
+// TLS files are read again even if their paths didn't change, so that renewed credentials are picked up.
+// The returned paths are the settings which differ from the current configuration but require a restart to be
+// applied; they are ignored. If an error is returned, the current configuration is kept.
+func (fbs *FizzBuzzServer) Reload(cfg config.Config) ([]string, error) {
+	fbs.reloadMu.Lock()
+	defer fbs.reloadMu.Unlock()
+
+	current := fbs.live.Load()
+	if current == nil {
+		return nil, errors.New("server not configured")
+	}
+
+	restartRequired := current.cfg.RestartRequired(cfg)
+	err := fbs.apply(current.cfg.Reloaded(cfg))
+	fbs.Metrics.ConfigReload(err)
+	if err != nil {
+		return nil, err
+	}
+	return restartRequired, nil
+}
+
+// ReloadConfig obtains a new configuration from LoadConfig and applies it with Reload
+func (fbs *FizzBuzzServer) ReloadConfig() ([]string, error) {
+	if fbs.LoadConfig == nil {
+		return nil, errors.New("configuration reload not supported")
+	}
+	cfg, err := fbs.LoadConfig()
+	if err != nil {
+		fbs.Metrics.ConfigReload(err)
+		return nil, err
+	}
+	return fbs.Reload(cfg)
+}
+
+// PostReloadHandler is the handler for the /config/reload administration endpoint under method POST. The
+// configuration is reloaded and applied; the response lists the changed settings which require a restart.
+// If the new configuration is invalid, the running server is left untouched and every violation is reported
+// in the response.
+func (fbs *FizzBuzzServer) PostReloadHandler(rw http.ResponseWriter, r *http.Request) {
+	restartRequired, err := fbs.ReloadConfig()
+	if err != nil {
+		renderError(rw, r, configError{err})
+		return
+	}
+
+	output := model.ConfigReloadOutput{
+		Status:          model.HealthStatusOK,
+		RestartRequired: restartRequired,
+	}
+	respPayload, err := json.Marshal(&output)
+	if err != nil {
+		renderError(rw, r, marshalingError(err))
+		return
+	}
+
+	rw.Header().Add(ContentTypeHeader, JSONContentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(respPayload)
+}
+
+// cacheConfig returns the cache configuration currently applied
+func (fbs *FizzBuzzServer) cacheConfig() config.CacheConfig {
+	if live := fbs.live.Load(); live != nil {
+		return live.cfg.Cache
+	}
+	return config.Default().Cache
+}
+
+// validator returns the validator of the fizzbuzz input parameters of the current configuration
+func (fbs *FizzBuzzServer) validator() *validation.Validator {
+	if live := fbs.live.Load(); live != nil {
+		return live.validator
+	}
+	return validation.NewFizzBuzzValidator()
+}
+
+// maxBodyBytes returns the maximum size of a fizzbuzz request body
+func (fbs *FizzBuzzServer) maxBodyBytes() int64 {
+	if live := fbs.live.Load(); live != nil {
+		return int64(live.cfg.Limits.MaxBodyBytes)
+	}
+	return int64(config.Default().Limits.MaxBodyBytes)
+}
+
+// maxBatchBytes returns the maximum size of a batch request body, as large as its items can be
+func (fbs *FizzBuzzServer) maxBatchBytes() int64 {
+	return fbs.maxBodyBytes() * int64(fbs.batchConfig().MaxItems)
+}
+
+// writeTimeout returns the maximum duration of a write of a response
+func (fbs *FizzBuzzServer) writeTimeout() time.Duration {
+	if live := fbs.live.Load(); live != nil {
+		return live.cfg.Server.WriteTimeout
+	}
+	return config.Default().Server.WriteTimeout
+}
+
+// batchConfig returns the configuration of the batches of fizzbuzz requests
+func (fbs *FizzBuzzServer) batchConfig() config.BatchConfig {
+	if live := fbs.live.Load(); live != nil {
+		return live.cfg.Limits.Batch
+	}
+	return config.Default().Limits.Batch
+}
+
+// docsConfig returns the configuration of the documentation of the api
+func (fbs *FizzBuzzServer) docsConfig() config.DocsConfig {
+	if live := fbs.live.Load(); live != nil {
+		return live.cfg.Docs
+	}
+	return config.Default().Docs
+}
+
+// trustedProxy returns true if r comes from one of the trusted proxies, whose forwarded headers are honored
+func (fbs *FizzBuzzServer) trustedProxy(r *http.Request) bool {
+	live := fbs.live.Load()
+	if live == nil {
+		return false
+	}
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range live.trustedProxies {
+		if prefix.Contains(addrPort.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// paginationMax returns the maximum number of elements of a single fizzbuzz response
+func (fbs *FizzBuzzServer) paginationMax() int {
+	if live := fbs.live.Load(); live != nil {
+		return live.cfg.Limits.PaginationMax
+	}
+	return paginationLimit
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyPair writes in dir a self-signed certificate for commonName and its key, returning their paths
+func writeKeyPair(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return certPath, keyPath
+}
+
+func certificateCommonName(t *testing.T, certificate *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestReload(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+
+	tbs := FizzBuzzServer{}
+	_, err := tbs.Reload(config.Default())
+	assert.Error(t, err)
+
+	_, err = tbs.Configure(config.Default())
+	require.NoError(t, err)
+	assert.Equal(t, 65536, tbs.paginationMax())
+
+	cfg := config.Default()
+	cfg.Log.Level = "debug"
+	cfg.Limits.PaginationMax = 10
+	cfg.Server.Address = ":4000"
+
+	restartRequired, err := tbs.Reload(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server.address"}, restartRequired)
+	assert.Equal(t, 10, tbs.paginationMax())
+	assert.Equal(t, zerolog.DebugLevel, zerolog.GlobalLevel())
+
+	// the ignored settings are still reported by the next reload
+	restartRequired, err = tbs.Reload(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server.address"}, restartRequired)
+}
+
+func TestReload_TLS(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.TLS.Enable = true
+	cfg.TLS.Cert, cfg.TLS.Key = writeKeyPair(t, dir, "before")
+
+	tbs := FizzBuzzServer{}
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	// the renewed key pair is picked up
+	writeKeyPair(t, dir, "after")
+	_, err = tbs.Reload(cfg)
+	require.NoError(t, err)
+	certificate, err := s.TLSConfig.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "after", certificateCommonName(t, certificate))
+
+	// an invalid key pair leaves the running configuration untouched
+	invalid := cfg
+	invalid.TLS.Key = filepath.Join(t.TempDir(), "missing.pem")
+	invalid.Limits.PaginationMax = 10
+	_, err = tbs.Reload(invalid)
+	assert.Error(t, err)
+	certificate, err = s.TLSConfig.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "after", certificateCommonName(t, certificate))
+	assert.Equal(t, 65536, tbs.paginationMax())
+}
+
+func TestPostReloadHandler(t *testing.T) {
+	next := config.Default()
+	next.Limits.PaginationMax = 10
+	next.Admin.Address = ":9191"
+	var loadErr error
+
+	tbs := FizzBuzzServer{
+		LoadConfig: func() (config.Config, error) {
+			return next, loadErr
+		},
+	}
+	_, err := tbs.Configure(config.Default())
+	require.NoError(t, err)
+	admin, err := tbs.ConfigureAdmin(config.Default())
+	require.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	admin.Handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "http://example.com/config/reload", nil))
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	var output model.ConfigReloadOutput
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&output))
+	assert.Equal(t, model.ConfigReloadOutput{Status: model.HealthStatusOK, RestartRequired: []string{"admin.address"}}, output)
+	assert.Equal(t, 10, tbs.paginationMax())
+
+	loadErr = errors.Join(errors.New("log.level is invalid"), errors.New("limits.pagination_max should be positive"))
+	resp = httptest.NewRecorder()
+	admin.Handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "http://example.com/config/reload", nil))
+	require.Equal(t, http.StatusBadRequest, resp.Result().StatusCode)
+	assert.Equal(t, JSONContentType, resp.Header().Get(ContentTypeHeader))
+	var appError model.ApplicationError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&appError))
+	assert.Equal(t, AppErrorTypeConfig, appError.Type)
+	assert.Equal(t, loadErr.Error(), appError.Detail)
+	assert.Equal(t, 10, tbs.paginationMax())
+}
@@ -3,103 +3,254 @@ package server
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
-	"fmt"
+	"io"
 	"net/http"
-	"strconv"
-	"time"
+	"sync"
+	"sync/atomic"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/httplog"
+	fizzbuzzrest "github.com/peano88/fizzbuzz-rest"
+	"github.com/peano88/fizzbuzz-rest/pkg/auth"
+	"github.com/peano88/fizzbuzz-rest/pkg/cache"
+	"github.com/peano88/fizzbuzz-rest/pkg/certificates"
+	"github.com/peano88/fizzbuzz-rest/pkg/concurrency"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/docs"
+	"github.com/peano88/fizzbuzz-rest/pkg/metrics"
 	"github.com/peano88/fizzbuzz-rest/pkg/model"
-	"github.com/peano88/fizzbuzz-rest/pkg/utils"
-)
-
-const (
-	TLSEnvVar            = "FIZZBUZZ_TLS_ENABLE"
-	insecureEnvVar       = "FIZZBUZZ_INSECURE"
-	clientAuthTypeEnvVar = "FIZZBUZZ_CLIENT_AUTH_TYPE"
-	logLevelEnvVar       = "FIZZBUZZ_LOG_LEVEL"
+	"github.com/peano88/fizzbuzz-rest/pkg/openapi"
+	"github.com/peano88/fizzbuzz-rest/pkg/ratelimit"
+	"github.com/peano88/fizzbuzz-rest/pkg/tracing"
 )
 
 // FizzBuzzStats is the interface representing what is expected by the statistics component
 //
 //go:generate mockery --name FizzBuzzStats
 type FizzBuzzStats interface {
-	// Increment receives the input parameters so that they can be registered
-	Increment(ctx context.Context, n, m, top int, fizz, buzz string) error
+	// Increment receives the input parameters so that they can be registered, and returns their request count
+	Increment(ctx context.Context, n, m, top int, fizz, buzz string) (int64, error)
 	// Stats should return the model.FizzBuzzStatisticsOutput representing the #1 hit for the GET /fizzbuzz
 	// error otherwise
 	Stats(ctx context.Context) (model.FizzBuzzStatisticsOutput, error)
 }
 
+// WebhookRegistry is the interface representing what is expected by the webhooks component
+type WebhookRegistry interface {
+	// Register stores a validated model.Webhook and returns it with its assigned identifier
+	Register(ctx context.Context, webhook model.Webhook) (model.Webhook, error)
+	// Webhooks returns all registered webhooks
+	Webhooks(ctx context.Context) ([]model.Webhook, error)
+	// Unregister removes the webhook with the provided identifier; webhooks.WebhookNotFound is expected
+	// if no such webhook exists
+	Unregister(ctx context.Context, id string) error
+}
+
+// JobQueue is the interface representing what is expected by the jobs component
+type JobQueue interface {
+	// Submit stores a job of client generating the sequence of the validated input and queues it; jobs.TooLarge is
+	// expected if the sequence is too long, jobs.TooManyActive if client has too many jobs pending or running,
+	// jobs.StoreFull if the stored results are too large and jobs.QueueFull if too many jobs are waiting
+	Submit(ctx context.Context, client string, input model.FizzBuzzInput) (model.Job, error)
+	// Job returns the job with the provided identifier; jobs.JobNotFound is expected if no such job exists
+	Job(ctx context.Context, id string) (model.Job, error)
+	// Result returns the job with the provided identifier and its result, to be closed once read; jobs.NotCompleted
+	// is expected if the job is not completed
+	Result(ctx context.Context, id string) (model.Job, io.ReadSeekCloser, error)
+}
+
 // FizzBuzzServer is the structure defining the HTTP requests handling and middleware
 type FizzBuzzServer struct {
 	// instance of FizzBuzzStats
 	Stats FizzBuzzStats
+	// instance of WebhookRegistry; the /webhooks endpoints are not served if nil
+	Webhooks WebhookRegistry
+	// instance of JobQueue; the /jobs endpoints are not served if nil
+	Jobs JobQueue
+	// metrics of the server; nothing is recorded if nil
+	Metrics *metrics.Metrics
+	// LoadConfig returns the configuration to be applied by a reload (see Reload); the /config/reload
+	// administration endpoint is not served if nil
+	LoadConfig func() (config.Config, error)
+	// registry of the API keys kept in the statistics store, used if the configured source of the API keys is
+	// redis; the /apikeys administration endpoints are served if not nil
+	APIKeys auth.KeyRegistry
+	// TLS credentials of the api listener, created by Configure if nil and TLS is enabled; see
+	// certificates.Reloader.Watch to reload them when the files change
+	Certificates *certificates.Reloader
+	// limiter of the requests of the api clients, created by Configure if nil and the configured rate limiting
+	// backend is memory; it has to be provided if the backend is redis
+	RateLimiter ratelimit.Limiter
+	// shared tier of the cache of the fizzbuzz responses, it has to be provided if the configured cache backend is
+	// redis
+	SharedCache cache.Store
+
+	// limiter of the fizzbuzz generations, created by Configure if enabled
+	generations *concurrency.Limiter
+	// cache of the fizzbuzz responses, created by Configure if enabled
+	responses *cache.Cache
+	// statistics served, validating the conditional requests
+	statistics statisticsVersion
+	// OpenAPI document of the api, loaded by Configure
+	openAPI *openapi.Document
+	// OpenAPI document served to the clients, loaded by Configure
+	published *openapi.Published
+
+	shuttingDown atomic.Bool
+	live         atomic.Pointer[liveConfig]
+	reloadMu     sync.Mutex
 }
 
-// Configure will return a configured *http.Server which can be used to serve requests
-// if environment variable FIZZBUZZ_TLS_ENABLE is set to true, than the server will be configured to be used
-// with ListenAndServeTLS method. in this case, the TLS configuration will allow insecure connection when
-// FIZZBUZZ_TLS_INSECURE is set to true; the client authentification type can be changed using environment variable
-// FIZZBUZZ_CLIENT_AUTH_TYPE
-// Standard variable SSL_CERT_FILE and SSL_CERT_DIR can be used to change the default loading of system CAs.
+// Configure will return a configured *http.Server which can be used to serve requests, listening on cfg.Server.Address.
+// If cfg.TLS.Enable is true, than the server will be configured to be used with ListenAndServeTLS method, with empty
+// certificate and key paths: the key pair cfg.TLS.Cert, cfg.TLS.Key and the client CA bundle cfg.TLS.ClientCA are
+// provided by Certificates, so that they can be replaced while serving. In this case, the TLS configuration will allow
+// insecure connection when cfg.TLS.Insecure is true; the client authentification type is cfg.TLS.ClientAuthType.
+// Requests to /api/v1 are authorized based on the identity of their verified client certificate, according to
+// cfg.TLS.Identities and cfg.TLS.Routes (see IdentityMiddleware).
+// If an API keys source or a JWKS is configured by cfg.Auth, requests to /fizzbuzz, /statistics and /webhooks require
+// an API key or a bearer token granting respectively the fizzbuzz:read, statistics:read and admin scopes (see
+// AuthenticationMiddleware). /webhooks is only served to authenticated requests, by API key, bearer token or client
+// identity (see RequireAuthenticated).
+// If a rate limiting backend is configured by cfg.Limits.RateLimit, requests to /fizzbuzz and /statistics are
+// limited by the budget of their client; the cost of a fizzbuzz request grows with the size of the generated page
+// (see RateLimitMiddleware), and is taken in two steps so that the invalid requests cost one token; a batch of
+// fizzbuzz requests costs as much as its items. If cfg.Limits.Concurrency is enabled, the fizzbuzz generations
+// processed concurrently are limited and the requests shed when overloaded (see fizzBuzzResponse). If a cache backend
+// is configured by cfg.Cache, the fizzbuzz responses are cached in memory and, with the redis backend, in SharedCache.
+// If Jobs is provided, the sequences are also generated asynchronously by the /jobs endpoints, requiring the
+// fizzbuzz:read scope; the results are streamed with Range support.
+// If cfg.Compression is enabled, the responses are compressed as negotiated with the clients (see
+// CompressionMiddleware).
+// The requests are validated against the embedded OpenAPI document before the fizzbuzz validation rules, their body
+// being bounded first, as well as the responses when logging at debug level (see OpenAPIMiddleware).
 // The serve will create a unique identifier for each incoming request, will log each request processing based on
-// variable FIZZBUZZ_LOG_LEVEL and will automatically recover from panics
-func (fbs *FizzBuzzServer) Configure() (*http.Server, error) {
+// cfg.Log.Level and will automatically recover from panics.
+// The OpenAPI document is served by /openapi.json and /openapi.yaml; if cfg.Docs.Enable is true, an interactive
+// explorer of the api is served by /docs, outside of /api/v1.
+// Liveness and readiness of the server are exposed by /healthz and /readyz, outside of /api/v1
+func (fbs *FizzBuzzServer) Configure(cfg config.Config) (*http.Server, error) {
 	logger := httplog.NewLogger("fizzbuzz-rest", httplog.Options{
-		LogLevel: utils.GetEnv(logLevelEnvVar, "info"),
+		LogLevel: cfg.Log.Level,
 		JSON:     true,
 	})
 
+	if cfg.TLS.Enable && fbs.Certificates == nil {
+		fbs.Certificates = certificates.New(fbs.Metrics)
+	}
+	if cfg.Limits.RateLimit.Backend == "memory" && fbs.RateLimiter == nil {
+		fbs.RateLimiter = ratelimit.NewMemoryLimiter()
+	}
+	if cfg.Limits.Concurrency.Enable && fbs.generations == nil {
+		fbs.generations = concurrency.New(cfg.Limits.Concurrency, fbs.Metrics)
+	}
+	if err := fbs.apply(cfg); err != nil {
+		return nil, err
+	}
+	if fbs.openAPI == nil {
+		doc, err := openapi.Load(fizzbuzzrest.OpenAPI)
+		if err != nil {
+			return nil, err
+		}
+		fbs.openAPI = doc
+	}
+	if fbs.published == nil {
+		published, err := openapi.Publish(fizzbuzzrest.OpenAPI, fizzbuzzrest.Version)
+		if err != nil {
+			return nil, err
+		}
+		fbs.published = published
+	}
+	if cfg.Cache.Backend != "none" && fbs.responses == nil {
+		var shared cache.Store
+		if cfg.Cache.Backend == "redis" {
+			shared = fbs.SharedCache
+		}
+		fbs.responses = cache.New(cfg.Cache.MaxBytes, shared, cfg.Cache.TTL, fbs.Metrics)
+	}
+
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
+	r.Use(tracing.Middleware)
 	r.Use(httplog.RequestLogger(logger))
 	r.Use(middleware.Recoverer)
+	r.Use(fbs.Metrics.Middleware)
+	r.Use(fbs.CompressionMiddleware)
+	r.Use(fbs.IdentityMiddleware)
+	r.Use(fbs.AuthenticationMiddleware)
 
 	r.Route("/fizzbuzz", func(r chi.Router) {
-		r.Use(fbs.ValidationMiddleware)
-		r.Use(fbs.ToStatisticsMiddleware)
-		r.Get("/", fbs.GetFizzBuzzHandler)
+		r.Use(fbs.RequireScope(model.ScopeFizzBuzzRead))
+		r.Group(func(r chi.Router) {
+			r.Use(fbs.RateLimitMiddleware(requestCost))
+			r.Use(fbs.BodyLimitMiddleware(fbs.maxBodyBytes))
+			r.Use(fbs.OpenAPIMiddleware)
+			r.Use(fbs.ValidationMiddleware)
+			r.Use(fbs.RateLimitMiddleware(fbs.fizzBuzzCost))
+			r.Use(fbs.ToStatisticsMiddleware)
+			r.Get("/", fbs.GetFizzBuzzHandler)
+			r.Post("/", fbs.PostFizzBuzzHandler)
+		})
+		r.With(fbs.RateLimitMiddleware(requestCost), fbs.BodyLimitMiddleware(fbs.maxBatchBytes), fbs.OpenAPIMiddleware,
+			fbs.BatchValidationMiddleware, fbs.RateLimitMiddleware(fbs.batchCost)).Post("/batch", fbs.PostFizzBuzzBatchHandler)
 	})
 
-	r.Get("/statistics", fbs.GetStatisticsHandler)
+	r.With(fbs.RequireScope(model.ScopeStatisticsRead), fbs.OpenAPIMiddleware, fbs.RateLimitMiddleware(requestCost)).Get("/statistics", fbs.GetStatisticsHandler)
+
+	if fbs.Webhooks != nil {
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Use(fbs.RequireAuthenticated)
+			r.Use(fbs.RequireScope(model.ScopeAdmin))
+			r.Use(fbs.OpenAPIMiddleware)
+			r.Post("/", fbs.PostWebhookHandler)
+			r.Get("/", fbs.GetWebhooksHandler)
+			r.Delete("/{id}", fbs.DeleteWebhookHandler)
+		})
+	}
+
+	if fbs.Jobs != nil {
+		r.Route("/jobs", func(r chi.Router) {
+			r.Use(fbs.RequireScope(model.ScopeFizzBuzzRead))
+			r.With(fbs.RateLimitMiddleware(requestCost), fbs.BodyLimitMiddleware(fbs.maxBodyBytes), fbs.OpenAPIMiddleware,
+				fbs.ValidationMiddleware, fbs.RateLimitMiddleware(fbs.jobCost), fbs.ToStatisticsMiddleware).Post("/", fbs.PostJobHandler)
+			r.With(fbs.OpenAPIMiddleware, fbs.RateLimitMiddleware(requestCost)).Get("/{id}", fbs.GetJobHandler)
+			// the result is not checked against the OpenAPI document, which would buffer it
+			r.With(fbs.RateLimitMiddleware(requestCost)).Get("/{id}/result", fbs.GetJobResultHandler)
+		})
+	}
+
+	r.With(fbs.OpenAPIMiddleware).Get("/openapi.json", fbs.GetOpenAPIJSONHandler)
+	r.With(fbs.OpenAPIMiddleware).Get("/openapi.yaml", fbs.GetOpenAPIYAMLHandler)
 
 	apiRouter := chi.NewRouter()
 	apiRouter.Mount("/api/v1/", r)
+	apiRouter.With(fbs.OpenAPIMiddleware).Get("/healthz", fbs.GetHealthzHandler)
+	apiRouter.With(fbs.OpenAPIMiddleware).Get("/readyz", fbs.GetReadyzHandler)
+	if cfg.Docs.Enable {
+		apiRouter.Get("/docs", http.RedirectHandler("/docs/", http.StatusMovedPermanently).ServeHTTP)
+		apiRouter.Handle("/docs/*", docs.Handler("/docs/"))
+	}
 
 	s := http.Server{
-		Addr:         ":3000",
+		Addr:         cfg.Server.Address,
 		Handler:      apiRouter,
-		ReadTimeout:  time.Minute,
-		WriteTimeout: time.Minute,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
 	}
 
-	if utils.IsTLSEnabled(TLSEnvVar) {
-
-		insecure, err := strconv.ParseBool(utils.GetEnv(insecureEnvVar, "false"))
-		if err != nil {
-			return nil, fmt.Errorf("error with insecure tls env var: %w", err)
-		}
-
-		clientAuthType, err := strconv.Atoi(utils.GetEnv(clientAuthTypeEnvVar, strconv.Itoa(int(tls.RequireAndVerifyClientCert))))
+	if cfg.TLS.Enable {
+		clientAuth, err := cfg.TLS.ClientAuth()
 		if err != nil {
-			return nil, fmt.Errorf("error with client auth type env var: %w", err)
+			return nil, err
 		}
-
-		certPool, err := x509.SystemCertPool()
-		if err != nil {
-			return nil, fmt.Errorf("error loading system cert pool: %w", err)
-		}
-
 		s.TLSConfig = &tls.Config{
-			ClientAuth:         tls.ClientAuthType(clientAuthType),
-			ClientCAs:          certPool,
-			InsecureSkipVerify: insecure,
+			ClientAuth:         clientAuth,
+			InsecureSkipVerify: cfg.TLS.Insecure,
 			MinVersion:         tls.VersionTLS12,
+			GetCertificate:     fbs.Certificates.GetCertificate,
 		}
+		s.TLSConfig.GetConfigForClient = fbs.Certificates.GetConfigForClient(s.TLSConfig.Clone())
 	}
 
 	return &s, nil
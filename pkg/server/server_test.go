@@ -1,39 +1,146 @@
 package server
 
 import (
-	"os"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/metrics"
+	"github.com/peano88/fizzbuzz-rest/pkg/server/mocks"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestConfigureServer_NoTLS(t *testing.T) {
 	tbs := FizzBuzzServer{}
 
-	s, err := tbs.Configure()
+	s, err := tbs.Configure(config.Default())
 	assert.NoError(t, err)
 	assert.NotNil(t, s)
+	assert.Nil(t, s.TLSConfig)
+	assert.Equal(t, ":3000", s.Addr)
 }
 
 func TestConfigureServer_TLS(t *testing.T) {
 	tbs := FizzBuzzServer{}
 
-	os.Setenv(TLSEnvVar, "true")
+	cfg := config.Default()
+	cfg.TLS.Enable = true
+	cfg.TLS.Insecure = true
+	cfg.TLS.ClientAuthType = "verify_client_cert_if_given"
+	cfg.TLS.Cert, cfg.TLS.Key = writeKeyPair(t, t.TempDir(), "localhost")
 
-	s, err := tbs.Configure()
-	assert.NoError(t, err)
-	assert.NotNil(t, s)
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+	require.NotNil(t, s.TLSConfig)
+	assert.True(t, s.TLSConfig.InsecureSkipVerify)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, s.TLSConfig.ClientAuth)
 
-	// creating issues
-	os.Setenv(insecureEnvVar, "boh")
-	s, err = tbs.Configure()
-	assert.Nil(t, s)
-	assert.Error(t, err)
-	os.Unsetenv(insecureEnvVar)
+	certificate, err := s.TLSConfig.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", certificateCommonName(t, certificate))
+}
+
+func TestConfigureServer_TLS_MissingKeyPair(t *testing.T) {
+	tbs := FizzBuzzServer{}
+
+	cfg := config.Default()
+	cfg.TLS.Enable = true
+	cfg.TLS.Cert = filepath.Join(t.TempDir(), "cert.pem")
+	cfg.TLS.Key = filepath.Join(t.TempDir(), "key.pem")
 
-	os.Setenv(clientAuthTypeEnvVar, "boh")
-	s, err = tbs.Configure()
-	assert.Nil(t, s)
+	_, err := tbs.Configure(cfg)
 	assert.Error(t, err)
-	os.Unsetenv(clientAuthTypeEnvVar)
+}
+
+func TestConfigureServer_TLS_Rotation(t *testing.T) {
+	tbs := FizzBuzzServer{}
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.TLS.Enable = true
+	cfg.TLS.ClientAuthType = "no_client_cert"
+	cfg.TLS.Cert, cfg.TLS.Key = writeKeyPair(t, dir, "before")
+
+	s, err := tbs.Configure(cfg)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(tls.NewListener(ln, s.TLSConfig))
+	defer s.Close()
+
+	served := func() string {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "before", served())
+
+	writeKeyPair(t, dir, "after")
+	_, err = tbs.Reload(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "after", served())
+}
+
+func TestConfigureAdmin(t *testing.T) {
+	tbs := FizzBuzzServer{
+		Metrics: metrics.New(),
+	}
+
+	s, err := tbs.ConfigureAdmin(config.Default())
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9090", s.Addr)
+
+	resp := httptest.NewRecorder()
+	s.Handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com/metrics", nil))
+	assert.Equal(t, http.StatusOK, resp.Result().StatusCode)
+}
+
+func TestConfigureServer_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	stats := mocks.NewFizzBuzzStats(t)
+	stats.On("Increment", mock.Anything, 2, 3, 7, "f", "b").Return(int64(1), nil)
+	tbs := FizzBuzzServer{
+		Stats: stats,
+	}
+
+	s, err := tbs.Configure(config.Default())
+	require.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	s.Handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/fizzbuzz/?int1=2&int2=3&limit=7&str1=f&str2=b", nil))
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+
+	names := []string{}
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+		spans[span.Name()] = span
+	}
+	assert.ElementsMatch(t, []string{
+		"ValidationMiddleware",
+		"ToStatisticsMiddleware",
+		"fizzbuzz.Fizzbuzz",
+		"json.Marshal",
+		"GetFizzBuzzHandler",
+		"GET /api/v1/fizzbuzz",
+	}, names)
+	// the spans of the middlewares are children of the span of the request, not of one another
+	request := spans["GET /api/v1/fizzbuzz"].SpanContext().SpanID()
+	assert.Equal(t, request, spans["ValidationMiddleware"].Parent().SpanID())
+	assert.Equal(t, request, spans["ToStatisticsMiddleware"].Parent().SpanID())
 }
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/fizzbuzz"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// Header key of the links to the related resources (RFC 8288)
+	LinkHeader = "Link"
+
+	// media type of the plain-text representation of the fizzbuzz sequences
+	plainTextMediaType = "text/plain"
+)
+
+// acceptsPlainText reports whether the client of r prefers the plain-text representation of the fizzbuzz sequences,
+// i.e. if its Accept header lists text/plain with a quality higher than application/json
+func acceptsPlainText(r *http.Request) bool {
+	qualities := acceptQualities(r)
+	return qualities[plainTextMediaType] > qualities[JSONContentType]
+}
+
+// fizzBuzzTextCacheKey is the canonical key of the plain-text response to input, paginated by paginationMax
+func fizzBuzzTextCacheKey(input model.FizzBuzzInput, paginationMax int) string {
+	return formatCacheKey(fizzBuzzTextFormatVersion, input, paginationMax)
+}
+
+// serveFizzBuzzText answers r with the plain-text representation of the page of the sequence of input, identified by
+// etag and cached by key: its elements, each followed by a line feed, the link to the next page being sent as a Link
+// header. As the length of any element is computed from its number, the offset of any byte of the page is known
+// without generating the page: a range of bytes is answered with 206 Partial Content, generating only the elements
+// it overlaps
+func (fbs *FizzBuzzServer) serveFizzBuzzText(ctx context.Context, rw http.ResponseWriter, r *http.Request, input model.FizzBuzzInput,
+	paginationMax int, key string, etag string, maxAge time.Duration) {
+	page, next := paginate(input, paginationMax)
+	if next != "" {
+		rw.Header().Set(LinkHeader, fmt.Sprintf(`<%s>; rel="next"`, next))
+	}
+	rw.Header().Set(AcceptRangesHeader, bytesUnit)
+
+	length := uint64(fizzbuzz.TextLength(page))
+	if value := r.Header.Get(RangeHeader); value != "" && ifRangeMatches(r, etag) {
+		if rng, ok, satisfiable := parseRange(value, bytesUnit, length); ok {
+			if !satisfiable {
+				rw.Header().Set(ContentRangeHeader, fmt.Sprintf("%s */%d", bytesUnit, length))
+				renderError(rw, r, rangeNotSatisfiable{unit: bytesUnit, length: length})
+				return
+			}
+			fbs.serveTextRange(ctx, rw, r, page, paginationMax, rng, length, etag, maxAge)
+			return
+		}
+	}
+
+	text, err := fbs.cachedGeneration(ctx, page, key, func() ([]byte, error) {
+		return fbs.generateText(ctx, page), nil
+	})
+	if err != nil {
+		renderGenerationError(rw, r, err)
+		return
+	}
+	fbs.setCacheHeaders(rw, r, maxAge, etag, time.Time{})
+	rw.Header().Set(ContentTypeHeader, PlainTextContentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(text)
+}
+
+// serveTextRange answers r with the bytes rng of the plain-text representation of page, of length bytes
+func (fbs *FizzBuzzServer) serveTextRange(ctx context.Context, rw http.ResponseWriter, r *http.Request, page model.FizzBuzzInput,
+	paginationMax int, rng indexRange, length uint64, etag string, maxAge time.Duration) {
+	elements := page
+	elements.Start, elements.Limit = elementAt(page, rng.first), elementAt(page, rng.last)
+	text, err := fbs.cachedGeneration(ctx, elements, fizzBuzzTextCacheKey(elements, paginationMax), func() ([]byte, error) {
+		return fbs.generateText(ctx, elements), nil
+	})
+	if err != nil {
+		renderGenerationError(rw, r, err)
+		return
+	}
+	offset := uint64(0)
+	if elements.Start > page.Start {
+		preceding := page
+		preceding.Limit = elements.Start - 1
+		offset = uint64(fizzbuzz.TextLength(preceding))
+	}
+
+	fbs.setCacheHeaders(rw, r, maxAge, etag, time.Time{})
+	rw.Header().Set(ContentRangeHeader, fmt.Sprintf("%s %d-%d/%d", bytesUnit, rng.first, rng.last, length))
+	rw.Header().Set(ContentTypeHeader, PlainTextContentType)
+	rw.WriteHeader(http.StatusPartialContent)
+	rw.Write(text[rng.first-offset : rng.last-offset+1])
+}
+
+// elementAt returns the number of the element of page whose line holds the byte at offset of the plain-text
+// representation of page
+func elementAt(page model.FizzBuzzInput, offset uint64) int {
+	return page.Start + sort.Search(page.Limit-page.Start+1, func(i int) bool {
+		prefix := page
+		prefix.Limit = page.Start + i
+		return uint64(fizzbuzz.TextLength(prefix)) > offset
+	})
+}
+
+// generateText returns the plain-text representation of the sequence of input
+func (fbs *FizzBuzzServer) generateText(ctx context.Context, input model.FizzBuzzInput) []byte {
+	_, span := tracing.Start(ctx, "fizzbuzz.Text")
+	text := fizzbuzz.Text(input)
+	span.SetAttributes(attribute.Int("fizzbuzz.text_length", len(text)))
+	span.End()
+	fbs.Metrics.ObserveSequence(fbs.pageElements(input))
+	return text
+}
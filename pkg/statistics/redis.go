@@ -5,24 +5,17 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
-	"os"
 	"strconv"
 
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
 	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/tracing"
 	"github.com/peano88/fizzbuzz-rest/pkg/utils"
 	"github.com/redis/go-redis/v9"
 )
 
 const (
-	fizzBuzzStatisticsSet           = "fizzbuzz:statistics"
-	redisDBAddressEnvVar            = "REDIS_DB_ADDRESS"
-	redisDBTLSEnvVar                = "REDIS_DB_TLS"
-	redisDBTLSInsecureEnvVar        = "REDIS_DB_TLS_INSECURE"
-	redisDBUsernameEnvVar           = "REDIS_DB_USERNAME"
-	redisDBPasswordEnvVar           = "REDIS_DB_PASSWORD"
-	redisDBIdEnvVar                 = "REDIS_DB_ID"
-	redisDBTLSCertificatePathEnvVar = "REDIS_DB_TLS_CERTIFICATE_PATH"
-	redisDBTLSKeyPathEnvVar         = "REDIS_DB_TLS_KEY_PATH"
+	fizzBuzzStatisticsSet = "fizzbuzz:statistics"
 )
 
 // FizzBuzzStatsRedis is a statistic component based on redis DB
@@ -31,35 +24,30 @@ type FizzBuzzStatsRedis struct {
 }
 
 // NewFizzBuzzStatsRedis instances a new FizzBuzzStatsRedis, which will automatically handles reconnection
-// to the redis DB. the address can be set using environment variable REDIS_DB_ADDRESS as well as user login
-// via variables REDIS_DB_USERNAME, REDIS_DB_PASSWORD. The db instance to use is not provide by default and can
-// be changed via REDIS_DB_ID. If the connection needs a TLS protection, than variable REDIS_DB_TLS needs to be set to
-// true. When using TLS the configuration can be tweaked using REDIS_DB_TLS_INSECURE, REDIS_DB_TLS_CERTIFICATE_PATH,
-// REDIS_DB_TLS_KEY_PATH which will allow for an insecure connection and specific client certificate+key.
+// to the redis DB at cfg.Address, using cfg.Username and cfg.Password as login and cfg.DB as db instance.
+// If the connection needs a TLS protection, than cfg.TLS needs to be set to true. When using TLS the configuration
+// can be tweaked using cfg.TLSInsecure, cfg.TLSCertificatePath and cfg.TLSKeyPath which will allow for an insecure
+// connection and specific client certificate+key.
 // Standard variable SSL_CERT_FILE and SSL_CERT_DIR can be used to change the default loading of system CAs.
-func NewFizzBuzzStatsRedis() (*FizzBuzzStatsRedis, error) {
+// Each redis command is traced using a tracing.RedisHook.
+func NewFizzBuzzStatsRedis(cfg config.RedisConfig) (*FizzBuzzStatsRedis, error) {
 	redisOptions := redis.Options{
-		Addr:     utils.GetEnv(redisDBAddressEnvVar, "localhost:6379"),
-		Username: utils.GetEnv(redisDBUsernameEnvVar, ""),
-		Password: utils.GetEnv(redisDBPasswordEnvVar, ""),
+		Addr:     cfg.Address,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
 	}
 
-	if id, err := strconv.Atoi(utils.GetEnv(redisDBIdEnvVar, "0")); err == nil {
-		redisOptions.DB = id
-	}
-
-	if utils.IsTLSEnabled(redisDBTLSEnvVar) {
+	if cfg.TLS {
 		certPool, err := x509.SystemCertPool()
 		if err != nil {
 			return nil, fmt.Errorf("error loading system certpool: %w", err)
 		}
 
-		certificatePath := os.Getenv(redisDBTLSCertificatePathEnvVar)
-		keyPath := os.Getenv(redisDBTLSKeyPathEnvVar)
 		tlsCertificates := []tls.Certificate{}
 
-		if certificatePath != "" && keyPath != "" {
-			certificate, err := tls.LoadX509KeyPair(certificatePath, keyPath)
+		if cfg.TLSCertificatePath != "" && cfg.TLSKeyPath != "" {
+			certificate, err := tls.LoadX509KeyPair(cfg.TLSCertificatePath, cfg.TLSKeyPath)
 			if err != nil {
 				return nil, fmt.Errorf("error loading tls certificate: %w", err)
 			}
@@ -69,30 +57,50 @@ func NewFizzBuzzStatsRedis() (*FizzBuzzStatsRedis, error) {
 		redisOptions.TLSConfig = &tls.Config{
 			Certificates:       tlsCertificates,
 			RootCAs:            certPool,
-			InsecureSkipVerify: false,
+			InsecureSkipVerify: cfg.TLSInsecure,
 			MinVersion:         tls.VersionTLS12,
 		}
 
 	}
 
+	rdb := redis.NewClient(&redisOptions)
+	rdb.AddHook(tracing.RedisHook{})
+
 	return &FizzBuzzStatsRedis{
-		rdb: redis.NewClient(&redisOptions),
+		rdb: rdb,
 	}, nil
 }
 
-// Increment uses redis ZINCRBY to increment the request count of the provided set of input parameters. The set identifier
-// is built by concatenation of each parameter using the model.Separator
-func (fs *FizzBuzzStatsRedis) Increment(ctx context.Context, n, m, top int, fizz, buzz string) error {
-
-	member := strconv.Itoa(n) + model.Separator + strconv.Itoa(m) + model.Separator + strconv.Itoa(top) + model.Separator + fizz + model.Separator + buzz
+// Client returns the redis client used by the component, so that it can be shared with other
+// components relying on the same redis DB
+func (fs *FizzBuzzStatsRedis) Client() *redis.Client {
+	return fs.rdb
+}
 
-	if err := fs.rdb.ZIncrBy(ctx, fizzBuzzStatisticsSet, 1.0, member).Err(); err != nil {
-		return fmt.Errorf("error in incrementing input parameters counter: %w", err)
+// HealthCheck uses redis PING to verify that the redis DB is reachable
+func (fs *FizzBuzzStatsRedis) HealthCheck(ctx context.Context) error {
+	if err := fs.rdb.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("error pinging redis: %w", err)
 	}
 
 	return nil
 }
 
+func member(n, m, top int, fizz, buzz string) string {
+	return strconv.Itoa(n) + model.Separator + strconv.Itoa(m) + model.Separator + strconv.Itoa(top) + model.Separator + fizz + model.Separator + buzz
+}
+
+// Increment uses redis ZINCRBY to increment the request count of the provided set of input parameters and returns the
+// incremented count. The set identifier is built by concatenation of each parameter using the model.Separator
+func (fs *FizzBuzzStatsRedis) Increment(ctx context.Context, n, m, top int, fizz, buzz string) (int64, error) {
+	score, err := fs.rdb.ZIncrBy(ctx, fizzBuzzStatisticsSet, 1.0, member(n, m, top, fizz, buzz)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("error in incrementing input parameters counter: %w", err)
+	}
+
+	return int64(score), nil
+}
+
 // Stats will return the most request set using ZREVRANGEBYSCORE of redis.Will return NoStatsAvailable error
 // if no statistic of previous requests is available
 func (fs *FizzBuzzStatsRedis) Stats(ctx context.Context) (model.FizzBuzzStatisticsOutput, error) {
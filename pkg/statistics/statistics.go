@@ -1,5 +1,14 @@
 package statistics
 
+import "context"
+
+// HealthChecker is an optional interface of the statistics components able to check their backend; the decorators
+// of a statistics component forward the check to it
+type HealthChecker interface {
+	// HealthCheck returns an error if the component can't serve requests
+	HealthCheck(ctx context.Context) error
+}
+
 // NoStatsAvailable indicates that no previous request is available
 type NoStatsAvailable struct{}
 
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RedisHook is a redis.Hook creating a client span for each redis command or pipeline
+type RedisHook struct{}
+
+// DialHook is the redis.Hook interface implementation; dialing is not traced
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+// ProcessHook is the redis.Hook interface implementation; the span is named after the command
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := Start(ctx, "redis."+cmd.Name(), trace.WithSpanKind(trace.SpanKindClient))
+		span.SetAttributes(
+			semconv.DBSystemRedis,
+			semconv.DBOperation(cmd.Name()),
+		)
+
+		err := next(ctx, cmd)
+		End(span, redisError(err))
+		return err
+	}
+}
+
+// ProcessPipelineHook is the redis.Hook interface implementation; a single span covers the whole pipeline
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := Start(ctx, "redis.pipeline", trace.WithSpanKind(trace.SpanKindClient))
+		span.SetAttributes(semconv.DBSystemRedis)
+
+		err := next(ctx, cmds)
+		End(span, redisError(err))
+		return err
+	}
+}
+
+// redisError filters out redis.Nil, which is a legit reply and not a failure
+func redisError(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func attributeValue(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := recordSpans(t)
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(Middleware)
+	r.Get("/items/{id}", func(rw http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "inner")
+		span.End()
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/items/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	inner, server := spans[0], spans[1]
+
+	assert.Equal(t, "GET /items/{id}", server.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.NotEmpty(t, attributeValue(server, AttributeRequestID).AsString())
+	assert.Equal(t, int64(http.StatusOK), attributeValue(server, "http.status_code").AsInt64())
+	assert.Equal(t, server.SpanContext().SpanID(), inner.Parent().SpanID())
+}
+
+func TestRedisHook(t *testing.T) {
+	recorder := recordSpans(t)
+
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	rdb.AddHook(RedisHook{})
+	defer rdb.Close()
+
+	assert.Error(t, rdb.ZIncrBy(context.TODO(), "key", 1, "member").Err())
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "redis.zincrby", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+}
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	cfg := config.Default().Tracing
+	shutdown, err := Setup(context.TODO(), cfg)
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.TODO()))
+
+	cfg.Exporter = ExporterStdout
+	shutdown, err = Setup(context.TODO(), cfg)
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.TODO()))
+
+	cfg.Exporter = "jaeger"
+	_, err = Setup(context.TODO(), cfg)
+	assert.Error(t, err)
+}
+
+func TestSetup_OTLP(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	// collector stand-in
+	var mu sync.Mutex
+	received := []*http.Request{}
+	collector := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r)
+	}))
+	defer collector.Close()
+
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+
+	shutdown, err := Setup(context.TODO(), config.TracingConfig{Exporter: ExporterOTLP, SampleRatio: 1})
+	require.NoError(t, err)
+
+	_, span := Start(context.TODO(), "test")
+	span.End()
+	require.NoError(t, shutdown(context.TODO()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 1)
+	assert.Equal(t, "/v1/traces", received[0].URL.Path)
+	assert.Equal(t, "application/x-protobuf", received[0].Header.Get("Content-Type"))
+}
@@ -15,6 +15,19 @@ func FizzBuzzInputFromContext(ctx context.Context) model.FizzBuzzInput {
 	return ctx.Value(model.InputKey).(model.FizzBuzzInput)
 }
 
+// IdentityFromContext returns the name of the client identity contained in the context.Context, empty if
+// not present
+func IdentityFromContext(ctx context.Context) string {
+	identity, _ := ctx.Value(model.IdentityKey).(string)
+	return identity
+}
+
+// PrincipalFromContext returns the authenticated model.Principal contained in the context.Context, if any
+func PrincipalFromContext(ctx context.Context) (model.Principal, bool) {
+	principal, ok := ctx.Value(model.PrincipalKey).(model.Principal)
+	return principal, ok
+}
+
 // FizzBuzzStatisticsOutputFromString splits the s string using the model.Separator and creates the model.FizzBuzzStatisticsOutput
 // using the separated tokens
 func FizzBuzzStatisticsOutputFromString(s string, hits int64) (model.FizzBuzzStatisticsOutput, error) {
@@ -0,0 +1,35 @@
+package validation
+
+import (
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+)
+
+var (
+	scopesConstraint = i18n.New("constraint.scopes", "scopes", model.ScopeFizzBuzzRead+", "+model.ScopeStatisticsRead+", "+model.ScopeAdmin)
+)
+
+// ValidateAPIKey checks the scopes of a model.APIKey and returns a ValidationError in case of issue
+func ValidateAPIKey(key model.APIKey) error {
+	if len(key.Scopes) == 0 {
+		return ValidationError{
+			err:        i18n.NewError("validation.missing", "param", "Scopes"),
+			parameter:  "Scopes",
+			constraint: scopesConstraint,
+		}
+	}
+
+	for _, scope := range key.Scopes {
+		switch scope {
+		case model.ScopeFizzBuzzRead, model.ScopeStatisticsRead, model.ScopeAdmin:
+		default:
+			return ValidationError{
+				err:        i18n.NewError("validation.invalid_scope", "value", scope),
+				parameter:  "Scopes",
+				constraint: scopesConstraint,
+			}
+		}
+	}
+
+	return nil
+}
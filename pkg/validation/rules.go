@@ -0,0 +1,179 @@
+package validation
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/fizzbuzz"
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+)
+
+// characterClasses maps the classes of config.CharacterClasses to their Unicode categories
+var characterClasses = map[string]*unicode.RangeTable{
+	"letter":  unicode.Letter,
+	"mark":    unicode.Mark,
+	"digit":   unicode.Digit,
+	"punct":   unicode.Punct,
+	"symbol":  unicode.Symbol,
+	"space":   unicode.White_Space,
+	"control": unicode.Cc,
+}
+
+// Rule is a check of the input parameters configured by the operators, run once the parameters it involves are
+// parsed. A violation is reported as a ValidationError on parameter, with the constraint of the rule
+type Rule struct {
+	parameter string
+	// parameters which must be parsed for the rule to run, parameter included
+	requires   []string
+	constraint i18n.Message
+	// returns the reason of the violation, nil if the input complies with the rule
+	check func(input model.FizzBuzzInput) error
+}
+
+// Rules returns the rules configured by cfg, which is expected to be validated
+func Rules(cfg config.ValidationConfig) []Rule {
+	var rules []Rule
+	if cfg.MaxInteger > 0 {
+		rules = append(rules, MaxInteger("int1", cfg.MaxInteger), MaxInteger("int2", cfg.MaxInteger))
+	}
+	if cfg.MaxSpan > 0 {
+		rules = append(rules, MaxSpan(cfg.MaxSpan))
+	}
+	if !cfg.AllowStartAfterLimit {
+		rules = append(rules, StartNotAfterLimit())
+	}
+	for _, param := range []string{"str1", "str2"} {
+		if cfg.MaxLength > 0 {
+			rules = append(rules, MaxLength(param, cfg.MaxLength))
+		}
+		if classes := cfg.Classes(); len(classes) > 0 {
+			rules = append(rules, Characters(param, classes))
+		}
+		if len(cfg.BannedWords) > 0 {
+			rules = append(rules, BannedWords(param, cfg.BannedWords))
+		}
+	}
+	return rules
+}
+
+// MaxInteger is the rule limiting int1 or int2, as param, to max
+func MaxInteger(param string, max int) Rule {
+	return Rule{
+		parameter:  param,
+		requires:   []string{param},
+		constraint: i18n.New("constraint.max_integer", "param", param, "max", max),
+		check: func(input model.FizzBuzzInput) error {
+			value := input.Int1
+			if param == "int2" {
+				value = input.Int2
+			}
+			if value > max {
+				return i18n.NewError("validation.integer_exceeded", "param", param, "value", value, "max", max)
+			}
+			return nil
+		},
+	}
+}
+
+// MaxSpan is the rule limiting the span between start and limit to max
+func MaxSpan(max int) Rule {
+	return Rule{
+		parameter:  "limit",
+		requires:   []string{"limit", "start"},
+		constraint: i18n.New("constraint.max_span", "max", max),
+		check: func(input model.FizzBuzzInput) error {
+			// the span is one less than the length
+			if fizzbuzz.Length(input) > uint64(max)+1 {
+				return i18n.NewError("validation.span_exceeded", "start", input.Start, "limit", input.Limit, "max", max)
+			}
+			return nil
+		},
+	}
+}
+
+// StartNotAfterLimit is the rule rejecting a start greater than limit
+func StartNotAfterLimit() Rule {
+	return Rule{
+		parameter:  "start",
+		requires:   []string{"limit", "start"},
+		constraint: i18n.New("constraint.start_not_after_limit"),
+		check: func(input model.FizzBuzzInput) error {
+			if input.Start > input.Limit {
+				return i18n.NewError("validation.start_after_limit", "start", input.Start, "limit", input.Limit)
+			}
+			return nil
+		},
+	}
+}
+
+// MaxLength is the rule limiting the number of characters of str1 or str2, as param, to max
+func MaxLength(param string, max int) Rule {
+	return Rule{
+		parameter:  param,
+		requires:   []string{param},
+		constraint: i18n.New("constraint.max_length", "param", param, "max", max),
+		check: func(input model.FizzBuzzInput) error {
+			if length := utf8.RuneCountInString(stringParameter(input, param)); length > max {
+				return i18n.NewError("validation.too_long", "param", param, "length", length, "max", max)
+			}
+			return nil
+		},
+	}
+}
+
+// Characters is the rule allowing in str1 or str2, as param, only the characters of classes, among
+// config.CharacterClasses
+func Characters(param string, classes []string) Rule {
+	tables := make([]*unicode.RangeTable, 0, len(classes))
+	for _, class := range classes {
+		if table, ok := characterClasses[class]; ok {
+			tables = append(tables, table)
+		}
+	}
+	return Rule{
+		parameter:  param,
+		requires:   []string{param},
+		constraint: i18n.New("constraint.characters", "param", param, "classes", strings.Join(classes, ", ")),
+		check: func(input model.FizzBuzzInput) error {
+			for _, r := range stringParameter(input, param) {
+				if !unicode.In(r, tables...) {
+					return i18n.NewError("validation.illegal_class", "param", param, "character", strconv.QuoteRune(r))
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// BannedWords is the rule rejecting str1 or str2, as param, if it includes one of words, whatever the case
+func BannedWords(param string, words []string) Rule {
+	lowered := make([]string, 0, len(words))
+	for _, word := range words {
+		lowered = append(lowered, strings.ToLower(strings.TrimSpace(word)))
+	}
+	return Rule{
+		parameter:  param,
+		requires:   []string{param},
+		constraint: i18n.New("constraint.banned_words", "param", param),
+		check: func(input model.FizzBuzzInput) error {
+			value := strings.ToLower(stringParameter(input, param))
+			for _, word := range lowered {
+				if strings.Contains(value, word) {
+					return i18n.NewError("validation.banned_word", "param", param)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func stringParameter(input model.FizzBuzzInput, param string) string {
+	if param == "str2" {
+		return input.Str2
+	}
+	return input.Str1
+}
@@ -3,31 +3,48 @@ package validation
 import (
 	"context"
 	"errors"
-	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
 	"github.com/peano88/fizzbuzz-rest/pkg/model"
 )
 
-const (
-	int1Constraint = "int1 should be a positive integer between 0 (excluding) and 9223372036854775807"
-	int2Constraint = "int2 should be a positive integer between 0 (excluding) and 9223372036854775807"
-	str1Constraint = "str1 can be any string not including character '-'"
-	str2Constraint = "str2 can be any string not including character '-'"
+var (
+	int1Constraint  = i18n.New("constraint.positive_integer", "param", "int1", "max", int64(math.MaxInt64))
+	int2Constraint  = i18n.New("constraint.positive_integer", "param", "int2", "max", int64(math.MaxInt64))
+	str1Constraint  = i18n.New("constraint.string", "param", "str1")
+	str2Constraint  = i18n.New("constraint.string", "param", "str2")
+	limitConstraint = i18n.New("constraint.integer", "param", "limit", "min", int64(math.MinInt64), "max", int64(math.MaxInt64))
+	startConstraint = i18n.New("constraint.optional_integer", "param", "start", "min", int64(math.MinInt64), "max", int64(math.MaxInt64))
 )
 
 // ValidationError is an error created in case of issue with the input parameters
 type ValidationError struct {
 	err        error
 	parameter  string
-	constraint string
+	constraint i18n.Message
+}
+
+// NewValidationError returns the ValidationError of parameter, not satisfying constraint because of err
+func NewValidationError(parameter string, err error, constraint i18n.Message) ValidationError {
+	return ValidationError{
+		err:        err,
+		parameter:  parameter,
+		constraint: constraint,
+	}
 }
 
 // Error is the error interface implementation
 func (ve ValidationError) Error() string {
-	return fmt.Sprintf("validation error on parameter: %s", ve.err.Error())
+	return ve.Message().String()
+}
+
+// Message returns the localizable message of the error
+func (ve ValidationError) Message() i18n.Message {
+	return i18n.New("validation.error", "error", ve.err)
 }
 
 // Unwrap allows correct use of errors.As and errors.Is
@@ -35,20 +52,76 @@ func (ve ValidationError) Unwrap() error {
 	return ve.err
 }
 
+// Parameter returns the name of the parameter failing the validation
+func (ve ValidationError) Parameter() string {
+	return ve.parameter
+}
+
 // Constraint returns the validation constraint triggering the validation error
 func (ve ValidationError) Constraint() string {
+	return ve.constraint.String()
+}
+
+// ConstraintMessage returns the localizable message of the validation constraint
+func (ve ValidationError) ConstraintMessage() i18n.Message {
 	return ve.constraint
 }
 
+// ValidationErrors collects the ValidationError of every invalid parameter of a request
+type ValidationErrors []ValidationError
+
+// Error is the error interface implementation
+func (ves ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(ves))
+	for _, ve := range ves {
+		msgs = append(msgs, ve.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap allows errors.As to find the first ValidationError
+func (ves ValidationErrors) Unwrap() []error {
+	errs := make([]error, 0, len(ves))
+	for _, ve := range ves {
+		errs = append(errs, ve)
+	}
+	return errs
+}
+
+// add appends to ves the ValidationError of param, not satisfying constraint because of err, if err is not nil
+func (ves *ValidationErrors) add(param string, constraint i18n.Message, err error) {
+	if err != nil {
+		*ves = append(*ves, ValidationError{
+			err:        err,
+			parameter:  param,
+			constraint: constraint,
+		})
+	}
+}
+
+// Errors returns every ValidationError of err, which is either a ValidationErrors or wraps a ValidationError;
+// it is empty if err is not a validation error
+func Errors(err error) []ValidationError {
+	var ves ValidationErrors
+	if errors.As(err, &ves) {
+		return ves
+	}
+	var ve ValidationError
+	if errors.As(err, &ve) {
+		return []ValidationError{ve}
+	}
+	return nil
+}
+
 func mandatoryPositiveInteger(r *http.Request, param string) (int, error) {
 	value := r.URL.Query().Get(param)
 	if value == "" {
-		return 0, errors.New("missing mandatory parameter: " + param)
+		return 0, i18n.NewError("validation.missing", "param", param)
 	}
 
 	valueInt, err := strconv.Atoi(value)
 	if err != nil || valueInt <= 0 {
-		return 0, errors.New(param + ": " + value + " is not a positive integer")
+		return 0, i18n.NewError("validation.not_positive_integer", "param", param, "value", value)
 	}
 
 	return valueInt, nil
@@ -57,10 +130,14 @@ func mandatoryPositiveInteger(r *http.Request, param string) (int, error) {
 func mandatoryInteger(r *http.Request, param string) (int, error) {
 	value := r.URL.Query().Get(param)
 	if value == "" {
-		return 0, errors.New("missing mandatory parameter: " + param)
+		return 0, i18n.NewError("validation.missing", "param", param)
 	}
 
-	return strconv.Atoi(value)
+	valueInt, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, i18n.NewError("validation.not_integer", "param", param, "value", value)
+	}
+	return valueInt, nil
 }
 
 func optionalInteger(r *http.Request, param string) (int, error, bool) {
@@ -70,83 +147,69 @@ func optionalInteger(r *http.Request, param string) (int, error, bool) {
 	}
 
 	intValue, err := strconv.Atoi(value)
-	return intValue, err, true
+	if err != nil {
+		return 0, i18n.NewError("validation.not_integer", "param", param, "value", value), true
+	}
+	return intValue, nil, true
 }
 
 func mandatoryString(r *http.Request, param string) (string, error) {
-	value := r.URL.Query().Get(param)
+	return checkString(param, r.URL.Query().Get(param))
+}
+
+func checkString(param, value string) (string, error) {
 	if value == "" {
-		return "", errors.New("missing mandatory parameter: " + param)
+		return "", i18n.NewError("validation.missing", "param", param)
 	}
 
 	if strings.Contains(value, "-") {
-		return "", errors.New("parameter " + param + "contains illegal character '-'")
+		return "", i18n.NewError("validation.illegal_character", "param", param)
 	}
 
 	return value, nil
 }
 
+func bodyPositiveInteger(param string, value *int) (int, error) {
+	if value == nil {
+		return 0, i18n.NewError("validation.missing", "param", param)
+	}
+	if *value <= 0 {
+		return 0, i18n.NewError("validation.not_positive_integer", "param", param, "value", *value)
+	}
+	return *value, nil
+}
+
+func bodyString(param string, value *string) (string, error) {
+	if value == nil {
+		return "", i18n.NewError("validation.missing", "param", param)
+	}
+	return checkString(param, *value)
+}
+
 // Validator runs the different validation
 type Validator struct {
+	rules []Rule
 }
 
-// RunValidations runs the different validations and returns a ValidationError in case of issue
-// if the validation is succesfull a modified context.Context is returned. This context is obtained
-// by adding a model.FizzBuzzInput in the r.Context()
+// RunValidations runs the different validations and returns a ValidationErrors, listing every invalid
+// parameter, in case of issue. The rules of the validator are checked once the parameters they involve are
+// parsed. If the validation is succesfull a modified context.Context is returned.
+// This context is obtained by adding a model.FizzBuzzInput in the r.Context()
 func (v *Validator) RunValidations(r *http.Request) (context.Context, error) {
-	newContext := r.Context()
+	var errs ValidationErrors
 
 	int1, err := mandatoryPositiveInteger(r, "int1")
-	if err != nil {
-		return nil, ValidationError{
-			err:        err,
-			parameter:  "int1",
-			constraint: int1Constraint,
-		}
-	}
-
+	errs.add("int1", int1Constraint, err)
 	int2, err := mandatoryPositiveInteger(r, "int2")
-	if err != nil {
-		return nil, ValidationError{
-			err:        err,
-			parameter:  "int2",
-			constraint: int2Constraint,
-		}
-	}
-
+	errs.add("int2", int2Constraint, err)
 	limit, err := mandatoryInteger(r, "limit")
-	if err != nil {
-		return nil, ValidationError{
-			err:       err,
-			parameter: "limit",
-		}
-	}
-
+	errs.add("limit", limitConstraint, err)
 	start, err, startProvided := optionalInteger(r, "start")
-	if err != nil {
-		return nil, ValidationError{
-			err:       err,
-			parameter: "start",
-		}
-	}
-
+	errs.add("start", startConstraint, err)
 	str1, err := mandatoryString(r, "str1")
-	if err != nil {
-		return nil, ValidationError{
-			err:        err,
-			parameter:  "str1",
-			constraint: str1Constraint,
-		}
-	}
-
+	errs.add("str1", str1Constraint, err)
 	str2, err := mandatoryString(r, "str2")
-	if err != nil {
-		return nil, ValidationError{
-			err:        err,
-			parameter:  "str2",
-			constraint: str2Constraint,
-		}
-	}
+	errs.add("str2", str2Constraint, err)
 
 	input := model.FizzBuzzInput{
 		FizzBuzzInputStats: model.FizzBuzzInputStats{
@@ -158,17 +221,86 @@ func (v *Validator) RunValidations(r *http.Request) (context.Context, error) {
 		},
 		Start: 1,
 	}
-
 	if startProvided {
 		input.Start = start
 	}
 
-	newContext = context.WithValue(newContext, model.InputKey, input)
+	return v.complete(r.Context(), input, errs)
+}
+
+// RunBodyValidations runs on req, the decoded body of a request, the same validations as RunValidations: the
+// fields of req are checked as the query parameters of the same name, start being defaulted to 1. If the
+// validation is succesfull, a context.Context obtained by adding a model.FizzBuzzInput in ctx is returned
+func (v *Validator) RunBodyValidations(ctx context.Context, req model.FizzBuzzRequest) (context.Context, error) {
+	var errs ValidationErrors
+
+	int1, err := bodyPositiveInteger("int1", req.Int1)
+	errs.add("int1", int1Constraint, err)
+	int2, err := bodyPositiveInteger("int2", req.Int2)
+	errs.add("int2", int2Constraint, err)
+	if req.Limit == nil {
+		errs.add("limit", limitConstraint, i18n.NewError("validation.missing", "param", "limit"))
+	}
+	str1, err := bodyString("str1", req.Str1)
+	errs.add("str1", str1Constraint, err)
+	str2, err := bodyString("str2", req.Str2)
+	errs.add("str2", str2Constraint, err)
+
+	input := model.FizzBuzzInput{
+		FizzBuzzInputStats: model.FizzBuzzInputStats{
+			Int1: int1,
+			Int2: int2,
+			Str1: str1,
+			Str2: str2,
+		},
+		Start: 1,
+	}
+	if req.Limit != nil {
+		input.Limit = *req.Limit
+	}
+	if req.Start != nil {
+		input.Start = *req.Start
+	}
+
+	return v.complete(ctx, input, errs)
+}
+
+// complete checks the rules of v on input, parsed with the errors errs, and returns the context.Context obtained
+// by adding input in ctx if there is no error at all
+func (v *Validator) complete(ctx context.Context, input model.FizzBuzzInput, errs ValidationErrors) (context.Context, error) {
+	errs = append(errs, v.checkRules(input, errs)...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
-	return newContext, nil
+	return context.WithValue(ctx, model.InputKey, input), nil
+}
+
+// checkRules returns the violations of the rules of v by input, skipping the rules involving a parameter of
+// parsingErrs
+func (v *Validator) checkRules(input model.FizzBuzzInput, parsingErrs ValidationErrors) ValidationErrors {
+	var errs ValidationErrors
+rules:
+	for _, rule := range v.rules {
+		for _, parsingErr := range parsingErrs {
+			for _, param := range rule.requires {
+				if parsingErr.parameter == param {
+					continue rules
+				}
+			}
+		}
+		if err := rule.check(input); err != nil {
+			errs = append(errs, ValidationError{
+				err:        err,
+				parameter:  rule.parameter,
+				constraint: rule.constraint,
+			})
+		}
+	}
+	return errs
 }
 
-// NewFizzBuzzValidator returns a new Validator
-func NewFizzBuzzValidator() *Validator {
-	return &Validator{}
+// NewFizzBuzzValidator returns a new Validator checking, in addition to the format of the parameters, rules
+func NewFizzBuzzValidator(rules ...Rule) *Validator {
+	return &Validator{rules: rules}
 }
@@ -1,11 +1,14 @@
 package validation
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
 	"github.com/peano88/fizzbuzz-rest/pkg/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -75,15 +78,183 @@ func TestFizzBuzzValidator_KO(t *testing.T) {
 	assert.Nil(t, ctx)
 }
 
+func TestFizzBuzzValidator_KO_AllErrors(t *testing.T) {
+	v := NewFizzBuzzValidator()
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com?int1=-1&int2=3&limit=seven&str2=bzz", nil)
+	ctx, err := v.RunValidations(r)
+	require.Error(t, err)
+	assert.Nil(t, ctx)
+
+	// every invalid parameter is reported
+	valErrs := Errors(err)
+	require.Len(t, valErrs, 3)
+	assert.Equal(t, "int1", valErrs[0].Parameter())
+	assert.Equal(t, "int1 should be a positive integer between 0 (excluding) and 9223372036854775807", valErrs[0].Constraint())
+	assert.Equal(t, "limit", valErrs[1].Parameter())
+	assert.Equal(t, "limit should be an integer between -9223372036854775808 and 9223372036854775807", valErrs[1].Constraint())
+	assert.Equal(t, "limit: seven is not an integer", errors.Unwrap(valErrs[1]).Error())
+	assert.Equal(t, "str1", valErrs[2].Parameter())
+
+	var valErr ValidationError
+	require.True(t, errors.As(err, &valErr))
+	assert.Equal(t, "int1", valErr.Parameter())
+
+	assert.Len(t, Errors(ValidateWebhook(model.Webhook{})), 1)
+	assert.Empty(t, Errors(errors.New("dummy")))
+}
+
+func TestFizzBuzzValidator_Rules(t *testing.T) {
+	v := NewFizzBuzzValidator(Rules(config.ValidationConfig{
+		MaxSpan:              100,
+		MaxInteger:           10,
+		MaxLength:            4,
+		Characters:           "letter, digit",
+		BannedWords:          []string{"Bad"},
+		AllowStartAfterLimit: true,
+	})...)
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com?int1=2&int2=3&limit=7&start=9&str1=fizz&str2=b%C3%A9%C3%A92", nil)
+	_, err := v.RunValidations(r)
+	require.NoError(t, err)
+
+	tests := []struct {
+		query      string
+		parameter  string
+		constraint string
+	}{
+		{"int1=11&int2=3&limit=7&str1=f&str2=b", "int1", "int1 should not be greater than 10"},
+		{"int1=2&int2=3&start=-5&limit=96&str1=f&str2=b", "limit", "limit should not exceed start by more than 100"},
+		{"int1=2&int2=3&limit=7&str1=fizzz&str2=b", "str1", "str1 should not be longer than 4 characters"},
+		{"int1=2&int2=3&limit=7&str1=f&str2=b%20z", "str2", "str2 can only include characters of the classes letter, digit"},
+		{"int1=2&int2=3&limit=7&str1=f&str2=aBAD", "str2", "str2 can't include a banned word"},
+	}
+	for _, tt := range tests {
+		_, err := v.RunValidations(httptest.NewRequest(http.MethodGet, "http://example.com?"+tt.query, nil))
+		valErrs := Errors(err)
+		require.Len(t, valErrs, 1, tt.query)
+		assert.Equal(t, tt.parameter, valErrs[0].Parameter(), tt.query)
+		assert.Equal(t, tt.constraint, valErrs[0].Constraint(), tt.query)
+	}
+
+	// the rules involving a parameter which can't be parsed are skipped, the others are checked
+	_, err = v.RunValidations(httptest.NewRequest(http.MethodGet, "http://example.com?int1=2&int2=30&limit=seven&start=-1000&str1=f&str2=b", nil))
+	valErrs := Errors(err)
+	require.Len(t, valErrs, 2)
+	assert.Equal(t, "limit", valErrs[0].Parameter())
+	assert.Equal(t, "int2", valErrs[1].Parameter())
+	assert.Equal(t, "int2: 30 is greater than 10", errors.Unwrap(valErrs[1]).Error())
+
+	// start after limit
+	r = httptest.NewRequest(http.MethodGet, "http://example.com?int1=2&int2=3&limit=7&start=9&str1=f&str2=b", nil)
+	_, err = NewFizzBuzzValidator(Rules(config.ValidationConfig{})...).RunValidations(r)
+	valErrs = Errors(err)
+	require.Len(t, valErrs, 1)
+	assert.Equal(t, "start: 9 is greater than limit 7", errors.Unwrap(valErrs[0]).Error())
+	_, err = NewFizzBuzzValidator(Rules(config.Default().Validation)...).RunValidations(r)
+	assert.NoError(t, err)
+}
+
+func TestFizzBuzzValidator_Body(t *testing.T) {
+	v := NewFizzBuzzValidator(Rules(config.ValidationConfig{MaxLength: 4, AllowStartAfterLimit: true})...)
+	intOf := func(i int) *int { return &i }
+	stringOf := func(s string) *string { return &s }
+
+	ctx, err := v.RunBodyValidations(context.Background(), model.FizzBuzzRequest{
+		Int1:  intOf(2),
+		Int2:  intOf(3),
+		Limit: intOf(7),
+		Str1:  stringOf("fizz"),
+		Str2:  stringOf("bézé"),
+	})
+	require.NoError(t, err)
+	input, ok := ctx.Value(model.InputKey).(model.FizzBuzzInput)
+	require.True(t, ok)
+	assert.Equal(t, model.FizzBuzzInput{
+		FizzBuzzInputStats: model.FizzBuzzInputStats{Int1: 2, Int2: 3, Limit: 7, Str1: "fizz", Str2: "bézé"},
+		Start:              1,
+	}, input)
+
+	// the fields are validated as the query parameters, rules included
+	_, err = v.RunBodyValidations(context.Background(), model.FizzBuzzRequest{
+		Int1:  intOf(0),
+		Start: intOf(3),
+		Str1:  stringOf("f-f"),
+		Str2:  stringOf("buzzz"),
+	})
+	valErrs := Errors(err)
+	require.Len(t, valErrs, 5)
+	for i, expected := range []string{
+		"int1: 0 is not a positive integer",
+		"missing mandatory parameter: int2",
+		"missing mandatory parameter: limit",
+		"parameter str1 contains illegal character '-'",
+		"str2: 5 characters are more than 4",
+	} {
+		assert.Equal(t, expected, errors.Unwrap(valErrs[i]).Error())
+	}
+}
+
 func TestValidationError(t *testing.T) {
 	errA := errors.New("error A")
 	valErr := ValidationError{
 		err:        errA,
 		parameter:  "donald",
-		constraint: "duck",
+		constraint: i18n.New("duck"),
 	}
 
 	assert.NotEmpty(t, valErr.Error())
 	assert.True(t, errors.Is(valErr, errA))
 	assert.Equal(t, "duck", valErr.Constraint())
 }
+
+func TestValidateWebhook(t *testing.T) {
+	tests := []struct {
+		label   string
+		webhook model.Webhook
+		wantErr bool
+	}{
+		{"threshold", model.Webhook{URL: "https://example.com/hook", Trigger: model.WebhookTriggerThreshold, Threshold: 10, Secret: "s"}, false},
+		{"top", model.Webhook{URL: "http://example.com/hook", Trigger: model.WebhookTriggerTop, Secret: "s"}, false},
+		{"relative url", model.Webhook{URL: "/hook", Trigger: model.WebhookTriggerTop, Secret: "s"}, true},
+		{"wrong scheme", model.Webhook{URL: "ftp://example.com/hook", Trigger: model.WebhookTriggerTop, Secret: "s"}, true},
+		{"unknown trigger", model.Webhook{URL: "http://example.com/hook", Trigger: "bottom", Secret: "s"}, true},
+		{"no threshold", model.Webhook{URL: "http://example.com/hook", Trigger: model.WebhookTriggerThreshold, Secret: "s"}, true},
+		{"no secret", model.Webhook{URL: "http://example.com/hook", Trigger: model.WebhookTriggerTop}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			err := ValidateWebhook(tt.webhook)
+			assert.Equal(t, tt.wantErr, err != nil, tt.label)
+			if err != nil {
+				var valErr ValidationError
+				assert.True(t, errors.As(err, &valErr))
+			}
+		})
+	}
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	tests := []struct {
+		label   string
+		key     model.APIKey
+		wantErr bool
+	}{
+		{"single scope", model.APIKey{Scopes: []string{model.ScopeFizzBuzzRead}}, false},
+		{"all scopes", model.APIKey{Scopes: []string{model.ScopeFizzBuzzRead, model.ScopeStatisticsRead, model.ScopeAdmin}}, false},
+		{"no scope", model.APIKey{}, true},
+		{"unknown scope", model.APIKey{Scopes: []string{model.ScopeFizzBuzzRead, "fizzbuzz:write"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			err := ValidateAPIKey(tt.key)
+			assert.Equal(t, tt.wantErr, err != nil, tt.label)
+			if err != nil {
+				var valErr ValidationError
+				assert.True(t, errors.As(err, &valErr))
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package validation
+
+import (
+	"net/url"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/i18n"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+)
+
+var (
+	urlConstraint       = i18n.New("constraint.url")
+	triggerConstraint   = i18n.New("constraint.trigger", "threshold", model.WebhookTriggerThreshold, "top", model.WebhookTriggerTop)
+	thresholdConstraint = i18n.New("constraint.threshold", "threshold", model.WebhookTriggerThreshold)
+	secretConstraint    = i18n.New("constraint.secret")
+)
+
+// ValidateWebhook checks the registration of a model.Webhook and returns a ValidationError in case of issue
+func ValidateWebhook(webhook model.Webhook) error {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ValidationError{
+			err:        i18n.NewError("validation.invalid_url", "value", webhook.URL),
+			parameter:  "URL",
+			constraint: urlConstraint,
+		}
+	}
+
+	switch webhook.Trigger {
+	case model.WebhookTriggerThreshold:
+		if webhook.Threshold <= 0 {
+			return ValidationError{
+				err:        i18n.NewError("validation.invalid_threshold"),
+				parameter:  "Threshold",
+				constraint: thresholdConstraint,
+			}
+		}
+	case model.WebhookTriggerTop:
+	default:
+		return ValidationError{
+			err:        i18n.NewError("validation.invalid_trigger", "value", webhook.Trigger),
+			parameter:  "Trigger",
+			constraint: triggerConstraint,
+		}
+	}
+
+	if webhook.Secret == "" {
+		return ValidationError{
+			err:        i18n.NewError("validation.missing", "param", "Secret"),
+			parameter:  "Secret",
+			constraint: secretConstraint,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	webhooksHash       = "fizzbuzz:webhooks"
+	topKey             = "fizzbuzz:webhooks:top"
+	deliveriesHash     = "fizzbuzz:webhooks:deliveries"
+	deliveriesSchedule = "fizzbuzz:webhooks:deliveries:schedule"
+)
+
+// RedisStore is a Store based on redis DB: webhooks and deliveries are JSON encoded in redis hashes,
+// while the deliveries schedule is kept in a sorted set scored by the next attempt instant
+type RedisStore struct {
+	rdb *redis.Client
+}
+
+// NewRedisStore returns a RedisStore using the provided client
+func NewRedisStore(rdb *redis.Client) *RedisStore {
+	return &RedisStore{
+		rdb: rdb,
+	}
+}
+
+// SaveWebhook is the Store interface implementation
+func (rs *RedisStore) SaveWebhook(ctx context.Context, webhook model.Webhook) error {
+	payload, err := json.Marshal(&webhook)
+	if err != nil {
+		return fmt.Errorf("error marshaling webhook: %w", err)
+	}
+
+	if err := rs.rdb.HSet(ctx, webhooksHash, webhook.ID, payload).Err(); err != nil {
+		return fmt.Errorf("error saving webhook: %w", err)
+	}
+
+	return nil
+}
+
+// Webhook is the Store interface implementation
+func (rs *RedisStore) Webhook(ctx context.Context, id string) (model.Webhook, error) {
+	payload, err := rs.rdb.HGet(ctx, webhooksHash, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return model.Webhook{}, WebhookNotFound{}
+	}
+	if err != nil {
+		return model.Webhook{}, fmt.Errorf("error retrieving webhook: %w", err)
+	}
+
+	var webhook model.Webhook
+	if err := json.Unmarshal(payload, &webhook); err != nil {
+		return model.Webhook{}, fmt.Errorf("error unmarshaling webhook: %w", err)
+	}
+
+	return webhook, nil
+}
+
+// Webhooks is the Store interface implementation; webhooks are sorted by identifier
+func (rs *RedisStore) Webhooks(ctx context.Context) ([]model.Webhook, error) {
+	res, err := rs.rdb.HGetAll(ctx, webhooksHash).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving webhooks: %w", err)
+	}
+
+	webhooks := make([]model.Webhook, 0, len(res))
+	for _, payload := range res {
+		var webhook model.Webhook
+		if err := json.Unmarshal([]byte(payload), &webhook); err != nil {
+			return nil, fmt.Errorf("error unmarshaling webhook: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+
+	return webhooks, nil
+}
+
+// DeleteWebhook is the Store interface implementation
+func (rs *RedisStore) DeleteWebhook(ctx context.Context, id string) error {
+	deleted, err := rs.rdb.HDel(ctx, webhooksHash, id).Result()
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+	if deleted == 0 {
+		return WebhookNotFound{}
+	}
+
+	return nil
+}
+
+// SwapTop is the Store interface implementation, based on redis GETSET
+func (rs *RedisStore) SwapTop(ctx context.Context, member string) (string, error) {
+	previous, err := rs.rdb.GetSet(ctx, topKey, member).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error swapping top member: %w", err)
+	}
+
+	return previous, nil
+}
+
+// SaveDelivery is the Store interface implementation
+func (rs *RedisStore) SaveDelivery(ctx context.Context, delivery Delivery) error {
+	payload, err := json.Marshal(&delivery)
+	if err != nil {
+		return fmt.Errorf("error marshaling delivery: %w", err)
+	}
+
+	_, err = rs.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, deliveriesHash, delivery.ID, payload)
+		pipe.ZAdd(ctx, deliveriesSchedule, redis.Z{
+			Score:  float64(delivery.NextAttempt.UnixMilli()),
+			Member: delivery.ID,
+		})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving delivery: %w", err)
+	}
+
+	return nil
+}
+
+// DueDeliveries is the Store interface implementation; deliveries are sorted by NextAttempt
+func (rs *RedisStore) DueDeliveries(ctx context.Context, now time.Time, count int) ([]Delivery, error) {
+	ids, err := rs.rdb.ZRangeByScore(ctx, deliveriesSchedule, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(count),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving due deliveries: %w", err)
+	}
+	if len(ids) == 0 {
+		return []Delivery{}, nil
+	}
+
+	payloads, err := rs.rdb.HMGet(ctx, deliveriesHash, ids...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving due deliveries: %w", err)
+	}
+
+	deliveries := make([]Delivery, 0, len(payloads))
+	for _, payload := range payloads {
+		s, ok := payload.(string)
+		if !ok {
+			// scheduled but already deleted
+			continue
+		}
+		var delivery Delivery
+		if err := json.Unmarshal([]byte(s), &delivery); err != nil {
+			return nil, fmt.Errorf("error unmarshaling delivery: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// DeleteDelivery is the Store interface implementation
+func (rs *RedisStore) DeleteDelivery(ctx context.Context, id string) error {
+	_, err := rs.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, deliveriesHash, id)
+		pipe.ZRem(ctx, deliveriesSchedule, id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting delivery: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package webhooks
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+)
+
+// WebhookNotFound indicates that no webhook is registered with the requested identifier
+type WebhookNotFound struct{}
+
+// Error is the error interface implementation
+func (w WebhookNotFound) Error() string {
+	return "webhook not found"
+}
+
+// Delivery is a pending notification of a model.WebhookEvent to a webhook receiver
+type Delivery struct {
+	// identifier of the delivery, same as the identifier of the delivered event
+	ID string
+	// identifier of the receiving webhook
+	WebhookID string
+	// JSON encoded model.WebhookEvent
+	Payload []byte
+	// number of delivery attempts already made
+	Attempts int
+	// instant after which the next attempt can be made
+	NextAttempt time.Time
+}
+
+// Store persists the registered webhooks and the pending deliveries
+type Store interface {
+	// SaveWebhook registers or replaces a webhook
+	SaveWebhook(ctx context.Context, webhook model.Webhook) error
+	// Webhook returns the webhook with the provided id, WebhookNotFound otherwise
+	Webhook(ctx context.Context, id string) (model.Webhook, error)
+	// Webhooks returns all registered webhooks
+	Webhooks(ctx context.Context) ([]model.Webhook, error)
+	// DeleteWebhook removes the webhook with the provided id, WebhookNotFound if not registered
+	DeleteWebhook(ctx context.Context, id string) error
+	// SwapTop stores the identifier of the most requested set and returns the previously stored one
+	SwapTop(ctx context.Context, member string) (string, error)
+	// SaveDelivery stores or reschedules a delivery
+	SaveDelivery(ctx context.Context, delivery Delivery) error
+	// DueDeliveries returns at most count deliveries whose NextAttempt is not after now
+	DueDeliveries(ctx context.Context, now time.Time, count int) ([]Delivery, error)
+	// DeleteDelivery removes a delivery, either completed or abandoned
+	DeleteDelivery(ctx context.Context, id string) error
+}
+
+// MemoryStore is a Store keeping everything in memory; useful for tests or a single, non-persistent, instance
+type MemoryStore struct {
+	mu         sync.Mutex
+	webhooks   map[string]model.Webhook
+	deliveries map[string]Delivery
+	top        string
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		webhooks:   map[string]model.Webhook{},
+		deliveries: map[string]Delivery{},
+	}
+}
+
+// SaveWebhook is the Store interface implementation
+func (ms *MemoryStore) SaveWebhook(ctx context.Context, webhook model.Webhook) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.webhooks[webhook.ID] = webhook
+	return nil
+}
+
+// Webhook is the Store interface implementation
+func (ms *MemoryStore) Webhook(ctx context.Context, id string) (model.Webhook, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	webhook, ok := ms.webhooks[id]
+	if !ok {
+		return model.Webhook{}, WebhookNotFound{}
+	}
+	return webhook, nil
+}
+
+// Webhooks is the Store interface implementation; webhooks are sorted by identifier
+func (ms *MemoryStore) Webhooks(ctx context.Context) ([]model.Webhook, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	webhooks := make([]model.Webhook, 0, len(ms.webhooks))
+	for _, webhook := range ms.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks, nil
+}
+
+// DeleteWebhook is the Store interface implementation
+func (ms *MemoryStore) DeleteWebhook(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.webhooks[id]; !ok {
+		return WebhookNotFound{}
+	}
+	delete(ms.webhooks, id)
+	return nil
+}
+
+// SwapTop is the Store interface implementation
+func (ms *MemoryStore) SwapTop(ctx context.Context, member string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	previous := ms.top
+	ms.top = member
+	return previous, nil
+}
+
+// SaveDelivery is the Store interface implementation
+func (ms *MemoryStore) SaveDelivery(ctx context.Context, delivery Delivery) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+// DueDeliveries is the Store interface implementation; deliveries are sorted by NextAttempt
+func (ms *MemoryStore) DueDeliveries(ctx context.Context, now time.Time, count int) ([]Delivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	due := []Delivery{}
+	for _, delivery := range ms.deliveries {
+		if !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+	if len(due) > count {
+		due = due[:count]
+	}
+	return due, nil
+}
+
+// DeleteDelivery is the Store interface implementation
+func (ms *MemoryStore) DeleteDelivery(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.deliveries, id)
+	return nil
+}
@@ -0,0 +1,405 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
+)
+
+const (
+	// Header carrying the HMAC-SHA256 signature of the event payload, formatted as sha256=<hex digest>
+	SignatureHeader = "X-Fizzbuzz-Signature"
+	// Header carrying the trigger of the webhook generating the event
+	EventHeader = "X-Fizzbuzz-Event"
+	// Header carrying the identifier of the delivery, stable across retries
+	DeliveryHeader = "X-Fizzbuzz-Delivery"
+
+	maxBackoff    = time.Hour
+	deliveryBatch = 64
+)
+
+// ObservedStats is the statistics component observed by the NotifyingStats
+type ObservedStats interface {
+	// Increment receives the input parameters so that they can be registered, and returns their request count
+	Increment(ctx context.Context, n, m, top int, fizz, buzz string) (int64, error)
+	// Stats returns the model.FizzBuzzStatisticsOutput representing the #1 hit
+	Stats(ctx context.Context) (model.FizzBuzzStatisticsOutput, error)
+}
+
+// Sign returns the value of the SignatureHeader for the provided payload
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Dispatcher registers webhooks and delivers their events. Events are persisted in the Store before
+// being delivered, so that they survive a restart; a failed delivery is retried with an exponential backoff
+// (Backoff, 2*Backoff, 4*Backoff... capped to one hour) until MaxAttempts is reached.
+// The registered webhooks are cached for PollInterval, so that a webhook registered by another instance sharing the
+// Store is evaluated after at most PollInterval.
+type Dispatcher struct {
+	store Store
+	// cache of the registered webhooks, reloaded from the Store when older than PollInterval
+	mu       sync.Mutex
+	webhooks []model.Webhook
+	loadedAt time.Time
+	// client used for the deliveries
+	Client *http.Client
+	// maximum number of delivery attempts of a single event
+	MaxAttempts int
+	// delay before the first retry
+	Backoff time.Duration
+	// interval between two scans of the due deliveries
+	PollInterval time.Duration
+}
+
+// ForbiddenDestination indicates that a webhook event is not delivered to an address which is not public
+type ForbiddenDestination struct {
+	address string
+}
+
+// Error is the error interface implementation
+func (fd ForbiddenDestination) Error() string {
+	return fmt.Sprintf("delivery to the non-public address %s is forbidden", fd.address)
+}
+
+// publicOnly is a net.Dialer control refusing the connections to the loopback, private, link-local, multicast and
+// unspecified addresses. It checks the resolved address of each connection, redirections included, so that a host
+// name resolving to an internal address is refused as well
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return ForbiddenDestination{address: host}
+	}
+	return nil
+}
+
+// NewDispatcher returns a Dispatcher using the provided Store, with the retry policy of cfg. The events are only
+// delivered to public addresses, unless cfg.AllowPrivate is set; the deliveries never go through a proxy
+func NewDispatcher(store Store, cfg config.WebhooksConfig) *Dispatcher {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !cfg.AllowPrivate {
+		dialer.Control = publicOnly
+	}
+	return &Dispatcher{
+		store: store,
+		Client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+		},
+		MaxAttempts:  cfg.MaxAttempts,
+		Backoff:      cfg.Backoff,
+		PollInterval: cfg.PollInterval,
+	}
+}
+
+// Register assigns an identifier to the webhook and stores it. The webhook is expected to be already validated
+func (d *Dispatcher) Register(ctx context.Context, webhook model.Webhook) (model.Webhook, error) {
+	id, err := newID()
+	if err != nil {
+		return model.Webhook{}, fmt.Errorf("error generating webhook id: %w", err)
+	}
+	webhook.ID = id
+
+	if err := d.store.SaveWebhook(ctx, webhook); err != nil {
+		return model.Webhook{}, err
+	}
+	d.invalidate()
+
+	return webhook, nil
+}
+
+// Webhooks returns the registered webhooks
+func (d *Dispatcher) Webhooks(ctx context.Context) ([]model.Webhook, error) {
+	return d.store.Webhooks(ctx)
+}
+
+// Unregister removes a webhook; WebhookNotFound is returned if no webhook has the provided id
+func (d *Dispatcher) Unregister(ctx context.Context, id string) error {
+	defer d.invalidate()
+	return d.store.DeleteWebhook(ctx, id)
+}
+
+// invalidate drops the cached webhooks, so that the next evaluation reloads them from the Store
+func (d *Dispatcher) invalidate() {
+	d.mu.Lock()
+	d.webhooks, d.loadedAt = nil, time.Time{}
+	d.mu.Unlock()
+}
+
+// cachedWebhooks returns the registered webhooks, from the cache if loaded less than PollInterval ago
+func (d *Dispatcher) cachedWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.loadedAt.IsZero() && time.Since(d.loadedAt) < d.PollInterval {
+		return d.webhooks, nil
+	}
+
+	webhooks, err := d.store.Webhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.webhooks, d.loadedAt = webhooks, time.Now()
+	return webhooks, nil
+}
+
+func topMember(params model.FizzBuzzInputStats) string {
+	return strconv.Itoa(params.Int1) + model.Separator + strconv.Itoa(params.Int2) + model.Separator + strconv.Itoa(params.Limit) + model.Separator + params.Str1 + model.Separator + params.Str2
+}
+
+// evaluate enqueues an event for every webhook triggered by the last increment of params, which brought their
+// request count to hits. A threshold webhook fires when hits equals its threshold: as each ZINCRBY returns the count it
+// produced, the exact count is seen by exactly one increment
+func (d *Dispatcher) evaluate(ctx context.Context, stats ObservedStats, params model.FizzBuzzInputStats, hits int64) error {
+	webhooks, err := d.cachedWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	topObserved := false
+	for _, webhook := range webhooks {
+		topObserved = topObserved || webhook.Trigger == model.WebhookTriggerTop
+	}
+
+	newTop := false
+	if topObserved {
+		res, err := stats.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		if res.Parameters == params {
+			previous, err := d.store.SwapTop(ctx, topMember(params))
+			if err != nil {
+				return err
+			}
+			newTop = previous != topMember(params)
+		}
+	}
+
+	for _, webhook := range webhooks {
+		switch {
+		case webhook.Trigger == model.WebhookTriggerThreshold && hits == webhook.Threshold:
+		case webhook.Trigger == model.WebhookTriggerTop && newTop:
+		default:
+			continue
+		}
+
+		if err := d.enqueue(ctx, webhook, params, hits); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) enqueue(ctx context.Context, webhook model.Webhook, params model.FizzBuzzInputStats, hits int64) error {
+	id, err := newID()
+	if err != nil {
+		return fmt.Errorf("error generating event id: %w", err)
+	}
+
+	event := model.WebhookEvent{
+		ID:         id,
+		WebhookID:  webhook.ID,
+		Trigger:    webhook.Trigger,
+		Parameters: params,
+		Hits:       hits,
+		Timestamp:  time.Now().UTC(),
+	}
+
+	payload, err := json.Marshal(&event)
+	if err != nil {
+		return fmt.Errorf("error marshaling event: %w", err)
+	}
+
+	return d.store.SaveDelivery(ctx, Delivery{
+		ID:          id,
+		WebhookID:   webhook.ID,
+		Payload:     payload,
+		NextAttempt: event.Timestamp,
+	})
+}
+
+// Run delivers the pending events every PollInterval, until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.DeliverPending(ctx); err != nil {
+				log.Printf("error delivering webhook events: %s", err.Error())
+			}
+		}
+	}
+}
+
+// DeliverPending makes an attempt for every due delivery: a successful delivery (2xx response) is removed
+// from the Store, a failed one is rescheduled or, if MaxAttempts is reached, abandoned
+func (d *Dispatcher) DeliverPending(ctx context.Context) error {
+	deliveries, err := d.store.DueDeliveries(ctx, time.Now(), deliveryBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		webhook, err := d.store.Webhook(ctx, delivery.WebhookID)
+		if err != nil {
+			if errors.Is(err, WebhookNotFound{}) {
+				// webhook unregistered in the meantime
+				if err := d.store.DeleteDelivery(ctx, delivery.ID); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+
+		if err := d.deliver(ctx, webhook, delivery); err != nil {
+			log.Printf("webhook %s delivery %s attempt %d failed: %s", webhook.ID, delivery.ID, delivery.Attempts+1, err.Error())
+		} else {
+			if err := d.store.DeleteDelivery(ctx, delivery.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		delivery.Attempts++
+		if delivery.Attempts >= d.MaxAttempts {
+			log.Printf("webhook %s delivery %s abandoned after %d attempts", webhook.ID, delivery.ID, delivery.Attempts)
+			if err := d.store.DeleteDelivery(ctx, delivery.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		delivery.NextAttempt = time.Now().Add(d.backoff(delivery.Attempts))
+		if err := d.store.SaveDelivery(ctx, delivery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.Backoff
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, webhook model.Webhook, delivery Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, delivery.Payload))
+	req.Header.Set(EventHeader, webhook.Trigger)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// NotifyingStats decorates a statistics component: every successful Increment is evaluated against the
+// registered webhooks of the Dispatcher
+type NotifyingStats struct {
+	stats      ObservedStats
+	dispatcher *Dispatcher
+}
+
+// NewNotifyingStats returns a NotifyingStats decorating stats
+func NewNotifyingStats(stats ObservedStats, dispatcher *Dispatcher) *NotifyingStats {
+	return &NotifyingStats{
+		stats:      stats,
+		dispatcher: dispatcher,
+	}
+}
+
+// Increment increments the request count of the provided set of input parameters and enqueues the events
+// of the triggered webhooks
+func (ns *NotifyingStats) Increment(ctx context.Context, n, m, top int, fizz, buzz string) (int64, error) {
+	hits, err := ns.stats.Increment(ctx, n, m, top, fizz, buzz)
+	if err != nil {
+		return 0, err
+	}
+
+	params := model.FizzBuzzInputStats{
+		Int1:  n,
+		Int2:  m,
+		Limit: top,
+		Str1:  fizz,
+		Str2:  buzz,
+	}
+
+	if err := ns.dispatcher.evaluate(ctx, ns.stats, params, hits); err != nil {
+		return hits, fmt.Errorf("error evaluating webhooks: %w", err)
+	}
+
+	return hits, nil
+}
+
+// Stats returns the statistics of the decorated component
+func (ns *NotifyingStats) Stats(ctx context.Context) (model.FizzBuzzStatisticsOutput, error) {
+	return ns.stats.Stats(ctx)
+}
+
+// HealthCheck forwards the check to the decorated component, if it supports health checks
+func (ns *NotifyingStats) HealthCheck(ctx context.Context) error {
+	if checker, ok := ns.stats.(statistics.HealthChecker); ok {
+		return checker.HealthCheck(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peano88/fizzbuzz-rest/pkg/config"
+	"github.com/peano88/fizzbuzz-rest/pkg/model"
+	"github.com/peano88/fizzbuzz-rest/pkg/statistics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStats is an in-memory statistics component
+type memoryStats struct {
+	mu   sync.Mutex
+	hits map[model.FizzBuzzInputStats]int64
+}
+
+func (ms *memoryStats) Increment(ctx context.Context, n, m, top int, fizz, buzz string) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	params := model.FizzBuzzInputStats{Int1: n, Int2: m, Limit: top, Str1: fizz, Str2: buzz}
+	ms.hits[params]++
+	return ms.hits[params], nil
+}
+
+func (ms *memoryStats) Stats(ctx context.Context) (model.FizzBuzzStatisticsOutput, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	res := model.FizzBuzzStatisticsOutput{}
+	for params, hits := range ms.hits {
+		if hits > res.Hits || (hits == res.Hits && topMember(params) > topMember(res.Parameters)) {
+			res = model.FizzBuzzStatisticsOutput{Parameters: params, Hits: hits}
+		}
+	}
+	if res.Hits == 0 {
+		return res, statistics.NoStatsAvailable{}
+	}
+	return res, nil
+}
+
+// receiver is a webhook receiver failing the first failures requests
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	events   []model.WebhookEvent
+	headers  []http.Header
+	payloads [][]byte
+}
+
+func (rc *receiver) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.calls++
+	if rc.calls <= rc.failures {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	payload, _ := io.ReadAll(r.Body)
+	var event model.WebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.events = append(rc.events, event)
+	rc.headers = append(rc.headers, r.Header.Clone())
+	rc.payloads = append(rc.payloads, payload)
+}
+
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	cfg := config.Default().Webhooks
+	// the receivers of the tests listen on the loopback
+	cfg.AllowPrivate = true
+	d := NewDispatcher(NewMemoryStore(), cfg)
+	d.Backoff = time.Millisecond
+	return d
+}
+
+func TestNotifyingStats_Threshold(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	d := newTestDispatcher(t)
+	webhook, err := d.Register(context.TODO(), model.Webhook{
+		URL:       srv.URL,
+		Trigger:   model.WebhookTriggerThreshold,
+		Threshold: 2,
+		Secret:    "s3cr3t",
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, webhook.ID)
+
+	stats := NewNotifyingStats(&memoryStats{hits: map[model.FizzBuzzInputStats]int64{}}, d)
+	for i := 0; i < 3; i++ {
+		_, err = stats.Increment(context.TODO(), 3, 5, 100, "fizz", "buzz")
+		require.NoError(t, err)
+		require.NoError(t, d.DeliverPending(context.TODO()))
+	}
+
+	require.Len(t, rc.events, 1)
+	assert.Equal(t, webhook.ID, rc.events[0].WebhookID)
+	assert.Equal(t, model.WebhookTriggerThreshold, rc.events[0].Trigger)
+	assert.Equal(t, int64(2), rc.events[0].Hits)
+	assert.Equal(t, model.FizzBuzzInputStats{Int1: 3, Int2: 5, Limit: 100, Str1: "fizz", Str2: "buzz"}, rc.events[0].Parameters)
+	assert.Equal(t, Sign("s3cr3t", rc.payloads[0]), rc.headers[0].Get(SignatureHeader))
+	assert.Equal(t, rc.events[0].ID, rc.headers[0].Get(DeliveryHeader))
+}
+
+func TestNotifyingStats_ConcurrentThreshold(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	d := newTestDispatcher(t)
+	_, err := d.Register(context.TODO(), model.Webhook{
+		URL:       srv.URL,
+		Trigger:   model.WebhookTriggerThreshold,
+		Threshold: 10,
+		Secret:    "s3cr3t",
+	})
+	require.NoError(t, err)
+
+	// every increment observes its own count, the threshold is crossed by exactly one of them
+	stats := NewNotifyingStats(&memoryStats{hits: map[model.FizzBuzzInputStats]int64{}}, d)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := stats.Increment(context.TODO(), 3, 5, 100, "fizz", "buzz")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, d.DeliverPending(context.TODO()))
+
+	require.Len(t, rc.events, 1)
+	assert.Equal(t, int64(10), rc.events[0].Hits)
+}
+
+func TestDispatcher_WebhooksCache(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	store := NewMemoryStore()
+	d := newTestDispatcher(t)
+	d.store = store
+	d.PollInterval = time.Hour
+
+	stats := NewNotifyingStats(&memoryStats{hits: map[model.FizzBuzzInputStats]int64{}}, d)
+	_, err := stats.Increment(context.TODO(), 3, 5, 100, "fizz", "buzz")
+	require.NoError(t, err)
+
+	// a webhook registered by another instance is not observed until the cache expires
+	require.NoError(t, store.SaveWebhook(context.TODO(), model.Webhook{
+		ID:        "other",
+		URL:       srv.URL,
+		Trigger:   model.WebhookTriggerThreshold,
+		Threshold: 2,
+	}))
+	_, err = stats.Increment(context.TODO(), 3, 5, 100, "fizz", "buzz")
+	require.NoError(t, err)
+	require.NoError(t, d.DeliverPending(context.TODO()))
+	assert.Empty(t, rc.events)
+
+	// a local registration reloads the webhooks
+	_, err = d.Register(context.TODO(), model.Webhook{
+		URL:       srv.URL,
+		Trigger:   model.WebhookTriggerThreshold,
+		Threshold: 3,
+	})
+	require.NoError(t, err)
+	_, err = stats.Increment(context.TODO(), 3, 5, 100, "fizz", "buzz")
+	require.NoError(t, err)
+	require.NoError(t, d.DeliverPending(context.TODO()))
+	require.Len(t, rc.events, 1)
+	assert.Equal(t, int64(3), rc.events[0].Hits)
+}
+
+func TestNotifyingStats_Top(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	d := newTestDispatcher(t)
+	_, err := d.Register(context.TODO(), model.Webhook{
+		URL:     srv.URL,
+		Trigger: model.WebhookTriggerTop,
+		Secret:  "s3cr3t",
+	})
+	require.NoError(t, err)
+
+	stats := NewNotifyingStats(&memoryStats{hits: map[model.FizzBuzzInputStats]int64{}}, d)
+	// 3-5 becomes #1, 2-5 takes over with its third hit, then 3-5 takes it back as soon as
+	// it ties (ties are won by reversed lexicographical order)
+	for _, int1 := range []int{3, 3, 2, 2, 2, 3, 3} {
+		_, err = stats.Increment(context.TODO(), int1, 5, 100, "fizz", "buzz")
+		require.NoError(t, err)
+	}
+	require.NoError(t, d.DeliverPending(context.TODO()))
+
+	require.Len(t, rc.events, 3)
+	assert.Equal(t, 3, rc.events[0].Parameters.Int1)
+	assert.Equal(t, 2, rc.events[1].Parameters.Int1)
+	assert.Equal(t, 3, rc.events[2].Parameters.Int1)
+}
+
+func TestDispatcher_Retry(t *testing.T) {
+	rc := &receiver{failures: 2}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	d := newTestDispatcher(t)
+	_, err := d.Register(context.TODO(), model.Webhook{
+		URL:       srv.URL,
+		Trigger:   model.WebhookTriggerThreshold,
+		Threshold: 1,
+		Secret:    "s3cr3t",
+	})
+	require.NoError(t, err)
+
+	stats := NewNotifyingStats(&memoryStats{hits: map[model.FizzBuzzInputStats]int64{}}, d)
+	_, err = stats.Increment(context.TODO(), 3, 5, 100, "fizz", "buzz")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	d.PollInterval = time.Millisecond
+	go d.Run(ctx)
+
+	assert.Eventually(t, func() bool {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		return len(rc.events) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	assert.Equal(t, 3, rc.calls)
+}
+
+func TestDispatcher_Abandon(t *testing.T) {
+	rc := &receiver{failures: 100}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	store := NewMemoryStore()
+	d := NewDispatcher(store, config.WebhooksConfig{MaxAttempts: 2, AllowPrivate: true})
+
+	_, err := d.Register(context.TODO(), model.Webhook{
+		URL:       srv.URL,
+		Trigger:   model.WebhookTriggerThreshold,
+		Threshold: 1,
+		Secret:    "s3cr3t",
+	})
+	require.NoError(t, err)
+
+	stats := NewNotifyingStats(&memoryStats{hits: map[model.FizzBuzzInputStats]int64{}}, d)
+	_, err = stats.Increment(context.TODO(), 3, 5, 100, "fizz", "buzz")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, d.DeliverPending(context.TODO()))
+	}
+	assert.Equal(t, 2, rc.calls)
+	pending, err := store.DueDeliveries(context.TODO(), time.Now(), deliveryBatch)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestDispatcher_Unregister(t *testing.T) {
+	d := newTestDispatcher(t)
+	webhook, err := d.Register(context.TODO(), model.Webhook{
+		URL:     "http://example.com",
+		Trigger: model.WebhookTriggerTop,
+		Secret:  "s3cr3t",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, d.Unregister(context.TODO(), webhook.ID))
+	assert.ErrorIs(t, d.Unregister(context.TODO(), webhook.ID), WebhookNotFound{})
+
+	webhooks, err := d.Webhooks(context.TODO())
+	require.NoError(t, err)
+	assert.Empty(t, webhooks)
+}
+
+func TestBackoff(t *testing.T) {
+	d := newTestDispatcher(t)
+	d.Backoff = time.Second
+
+	assert.Equal(t, time.Second, d.backoff(1))
+	assert.Equal(t, 4*time.Second, d.backoff(3))
+	assert.Equal(t, maxBackoff, d.backoff(100))
+}
+
+func TestDispatcher_PrivateDestination(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	store := NewMemoryStore()
+	d := NewDispatcher(store, config.Default().Webhooks)
+	for _, url := range []string{srv.URL, "http://169.254.169.254/latest/meta-data", "http://10.0.0.1/hook", "http://[::1]:8080/hook"} {
+		webhook := model.Webhook{URL: url, Trigger: model.WebhookTriggerThreshold, Threshold: 1, Secret: "s3cr3t"}
+		err := d.deliver(context.TODO(), webhook, Delivery{ID: "id", Payload: []byte("{}")})
+		assert.ErrorAs(t, err, &ForbiddenDestination{}, url)
+	}
+	assert.Zero(t, rc.calls)
+}
@@ -0,0 +1,5 @@
+package fizzbuzzrest
+
+// Version is the version of the server, stamped at build time with
+// -ldflags "-X github.com/peano88/fizzbuzz-rest.Version=<version>"
+var Version = "dev"